@@ -111,5 +111,19 @@ func main() {
 	fmt.Printf("After restoration, TEMPORARY_VAR: %s\n", env.GetEnv("TEMPORARY_VAR"))
 	fmt.Printf("Original USER preserved: %s\n", env.GetEnv("USER"))
 
+	// Test arrays
+	fmt.Println("\n7. Array Tests:")
+
+	env.SetArray("HOSTS", []string{"web1", "web2"})
+	env.AppendArray("HOSTS", "db1")
+	hosts, _ := env.GetArray("HOSTS")
+	fmt.Printf("Indexed array HOSTS: %v (indices %v)\n", hosts, env.GetArrayIndices("HOSTS"))
+
+	env.DeclareAssocArray("ROLES")
+	env.SetAssocElement("ROLES", "web1", "frontend")
+	env.SetAssocElement("ROLES", "db1", "database")
+	roles, _ := env.GetAssocArray("ROLES")
+	fmt.Printf("Associative array ROLES: %v (keys %v)\n", roles, env.GetAssocKeys("ROLES"))
+
 	fmt.Println("\nEnvironment manager testing completed successfully!")
 }
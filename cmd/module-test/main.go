@@ -105,16 +105,16 @@ internal_function() {
 	}
 
 	// Try to access exports directly from the module using the correct keys
-	if helloCmd, exists := testModule.Exports["hello()"]; exists {
+	if helloCmd, exists := testModule.Exports["hello"]; exists {
 		fmt.Printf("Direct access - Hello export: %s (args: %v)\n", helloCmd.Name, helloCmd.Args)
 	} else {
-		fmt.Println("Direct access - hello() export not found")
+		fmt.Println("Direct access - hello export not found")
 	}
 
-	if greetCmd, exists := testModule.Exports["greet()"]; exists {
+	if greetCmd, exists := testModule.Exports["greet"]; exists {
 		fmt.Printf("Direct access - Greet export: %s (args: %v)\n", greetCmd.Name, greetCmd.Args)
 	} else {
-		fmt.Println("Direct access - greet() export not found")
+		fmt.Println("Direct access - greet export not found")
 	}
 
 	fmt.Println("\n6. Testing export existence check:")
@@ -3,6 +3,9 @@ package main
 import (
 	"fmt"
 	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
 
 	"gitee.com/com_818cloud/shode/pkg/parser"
 	"gitee.com/com_818cloud/shode/pkg/sandbox"
@@ -27,6 +30,19 @@ func main() {
 		"echo 'safe command'",                 // Safe with quotes
 		"useradd testuser",                    // User management
 		"passwd --password secret",            // Password in command line
+		"/bin/rm -r build",                    // Dangerous command by path
+		"sudo -u root rm -r build",            // Dangerous command behind a wrapper
+		"timeout 5 nc example.com 80",         // Network command behind a wrapper
+		"sh -c 'rm -r build'",                 // Dangerous command in a shell script
+		"grep 'a|b' notes.txt",                // Quoted metacharacters are literal
+		"mkdir -p build/out",                  // -p is not a password
+		"mysql -psecret app",                  // Password attached to -p
+		"find . -name '*.tmp' -delete",        // Medium risk, allowed
+		"git -c core.sshCommand=./x fetch",    // Config that runs a program
+		"cat /etc/../etc/shadow",              // Sensitive file behind ..
+		"grep --file=/etc/shadow notes.txt",   // Sensitive file in an option value
+		"echo hello > /etc/passwd",            // Sensitive file as redirection target
+		"ls /rootless",                        // Not inside /root
 	}
 
 	for i, cmdText := range testCommands {
@@ -54,6 +70,7 @@ func main() {
 		fmt.Printf("    Dangerous: %t\n", report["is_dangerous_command"])
 		fmt.Printf("    Network: %t\n", report["is_network_command"])
 		fmt.Printf("    Sensitive files: %v\n", report["sensitive_files"])
+		fmt.Printf("    Risk: %d (%s)\n", report["risk_score"], report["risk_level"])
 
 		// Check security
 		err = security.CheckCommand(cmd)
@@ -90,5 +107,191 @@ func main() {
 		fmt.Printf("RM command allowed after removal from blacklist\n")
 	}
 
+	// Test policy profiles and rules
+	fmt.Println("\nTesting Security Policies:")
+	fmt.Println("--------------------------")
+
+	policy := &sandbox.Policy{
+		Extends: "ci",
+		Commands: sandbox.CommandPolicy{
+			Deny: []string{"curl"},
+			Rules: []sandbox.CommandRule{
+				{Command: "rm", AllowArgs: []string{"-rf build"}},
+			},
+		},
+		Paths: []sandbox.PathRule{
+			{Path: "/var/log/app/**", Access: "allow"},
+			{Path: "/srv/config/**", Access: "r"},
+		},
+	}
+	policyChecker, err := sandbox.NewSecurityCheckerWithPolicy(policy)
+	if err != nil {
+		log.Fatalf("Error applying policy: %v", err)
+	}
+
+	policyCommands := []string{
+		"rm -rf build",                  // Allowed by an allowArgs rule
+		"rm -rf src",                    // Other rm arguments stay blocked
+		"curl https://example.com",      // Denied by the policy
+		"chmod +x build.sh",             // Allowed by the ci profile
+		"git push origin main -f",       // Force push denied by the ci profile
+		"tail /var/log/app/out.log",     // Allowed path rule
+		"cat /srv/config/app.yml",       // Read-only path rule
+		"touch /srv/config/app.yml",     // Writes to a read-only path
+	}
+	for _, cmdText := range policyCommands {
+		script, err := parser.ParseString(cmdText)
+		if err != nil {
+			log.Printf("Error parsing command: %v", err)
+			continue
+		}
+		if err := policyChecker.CheckCommand(script.Nodes[0].(*types.CommandNode)); err != nil {
+			fmt.Printf("  ❌ %s: %s\n", cmdText, err)
+		} else {
+			fmt.Printf("  ✅ %s\n", cmdText)
+		}
+	}
+
+	// Test allowlist mode, where only listed binaries may run
+	fmt.Println("\nTesting Allowlist Mode:")
+	fmt.Println("-----------------------")
+
+	allowlistChecker, err := sandbox.NewSecurityCheckerWithPolicy(&sandbox.Policy{
+		Mode:     sandbox.ModeAllowlist,
+		Commands: sandbox.CommandPolicy{Allow: []string{"ls", "cat"}},
+	})
+	if err != nil {
+		log.Fatalf("Error applying policy: %v", err)
+	}
+
+	for _, name := range []string{"ls", "/bin/ls", "cat", "python3", "busybox"} {
+		path, err := exec.LookPath(name)
+		if err != nil {
+			fmt.Printf("  ⏭️  %s: not installed\n", name)
+			continue
+		}
+		if err := allowlistChecker.CheckExecutable(name, path); err != nil {
+			fmt.Printf("  ❌ %s: %s\n", name, err)
+		} else {
+			fmt.Printf("  ✅ %s (%s)\n", name, path)
+		}
+	}
+
+	// Test kernel enforcement: a binary is confined at runtime even when
+	// the static checks are bypassed
+	fmt.Println("\nTesting Kernel Enforcement:")
+	fmt.Println("---------------------------")
+
+	dir, err := os.MkdirTemp("", "shode-enforce")
+	if err != nil {
+		log.Fatalf("Error creating directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	secret := filepath.Join(dir, "secret.txt")
+	if err := os.WriteFile(secret, []byte("top secret\n"), 0644); err != nil {
+		log.Fatalf("Error writing file: %v", err)
+	}
+
+	enforcedChecker, err := sandbox.NewSecurityCheckerWithPolicy(&sandbox.Policy{
+		Paths:       []sandbox.PathRule{{Path: secret, Access: "deny"}},
+		Enforcement: &sandbox.EnforcementPolicy{Landlock: true, Seccomp: true},
+	})
+	if err != nil {
+		log.Fatalf("Error applying policy: %v", err)
+	}
+
+	for _, args := range [][]string{{"cat", secret}, {"ls", dir}, {"unshare", "-U", "true"}} {
+		command := exec.Command(args[0], args[1:]...)
+		release, err := enforcedChecker.Confine(command)
+		if err != nil {
+			fmt.Printf("  ⏭️  %v: %v\n", args, err)
+			continue
+		}
+		output, err := command.CombinedOutput()
+		release()
+		if err != nil {
+			fmt.Printf("  ❌ %v: %s", args, output)
+		} else {
+			fmt.Printf("  ✅ %v\n", args)
+		}
+	}
+
+	// Test network egress rules
+	fmt.Println("\nTesting Network Policy:")
+	fmt.Println("-----------------------")
+
+	networkChecker, err := sandbox.NewSecurityCheckerWithPolicy(&sandbox.Policy{
+		Network: &sandbox.NetworkPolicy{
+			Default: sandbox.NetworkDeny,
+			Allow:   []string{"github.com:443", "*.npmjs.org"},
+			Deny:    []string{"169.254.169.254"},
+		},
+	})
+	if err != nil {
+		log.Fatalf("Error applying policy: %v", err)
+	}
+
+	networkCommands := []string{
+		"git clone https://github.com/spf13/cobra",  // Allowed host and port
+		"curl https://registry.npmjs.org/cobra",     // Allowed subdomain
+		"curl http://github.com/",                   // Port 80 is not allowed
+		"wget https://example.com/install.sh",       // Not in network.allow
+		"curl http://169.254.169.254/latest/",       // Denied address
+	}
+	for _, cmdText := range networkCommands {
+		script, err := parser.ParseString(cmdText)
+		if err != nil {
+			log.Printf("Error parsing command: %v", err)
+			continue
+		}
+		if err := networkChecker.CheckCommand(script.Nodes[0].(*types.CommandNode)); err != nil {
+			fmt.Printf("  ❌ %s: %s\n", cmdText, err)
+		} else {
+			fmt.Printf("  ✅ %s\n", cmdText)
+		}
+	}
+
+	// Test context rules, which depend on where a command runs
+	fmt.Println("\nTesting Context Rules:")
+	fmt.Println("----------------------")
+
+	contextChecker, err := sandbox.NewSecurityCheckerWithPolicy(&sandbox.Policy{
+		Contexts: []sandbox.ContextRule{
+			{Modules: []string{"*"}, Deny: []string{"curl"}},
+			{Scripts: []string{"deploy.sh"}, Only: []string{"kubectl"}},
+		},
+	})
+	if err != nil {
+		log.Fatalf("Error applying policy: %v", err)
+	}
+
+	contextCommands := []struct {
+		command string
+		script  string
+	}{
+		{"curl https://example.com/", "/app/main.sh"},
+		{"curl https://example.com/", "/app/sh_models/netlib/index.sh"},
+		{"kubectl apply -f app.yaml", "/app/deploy.sh"},
+		{"kubectl apply -f app.yaml", "/app/main.sh"},
+	}
+	for _, test := range contextCommands {
+		script, err := parser.ParseString(test.command)
+		if err != nil {
+			log.Printf("Error parsing command: %v", err)
+			continue
+		}
+		ctx := &sandbox.CheckContext{
+			Dir:       "/app",
+			Script:    test.script,
+			Module:    sandbox.ModuleOf(test.script),
+			CallStack: []string{test.script},
+		}
+		if err := contextChecker.CheckCommandWithContext(script.Nodes[0].(*types.CommandNode), ctx); err != nil {
+			fmt.Printf("  ❌ %s in %s: %s\n", test.command, test.script, err)
+		} else {
+			fmt.Printf("  ✅ %s in %s\n", test.command, test.script)
+		}
+	}
+
 	fmt.Println("\nSecurity testing completed!")
 }
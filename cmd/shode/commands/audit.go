@@ -0,0 +1,171 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"gitee.com/com_818cloud/shode/pkg/parser"
+	"gitee.com/com_818cloud/shode/pkg/sandbox"
+	"github.com/spf13/cobra"
+)
+
+// Output formats of the audit command
+const (
+	auditFormatText  = "text"
+	auditFormatJSON  = "json"
+	auditFormatSARIF = "sarif"
+)
+
+// auditOutput is the JSON output of the audit command
+type auditOutput struct {
+	Reports  []*sandbox.ScriptReport `json:"reports"`
+	Errors   int                     `json:"errors"`
+	Warnings int                     `json:"warnings"`
+	Notes    int                     `json:"notes"`
+}
+
+// NewAuditCommand creates the 'audit' command for analyzing scripts
+// without running them
+func NewAuditCommand() *cobra.Command {
+	var policy string
+	var format string
+	var failOn string
+
+	cmd := &cobra.Command{
+		Use:   "audit [script-file...]",
+		Short: "Analyze shell scripts for security issues without running them",
+		Long: `Audit parses scripts without executing them and checks every command,
+including those in functions, loops, conditions, command substitutions and
+sh -c scripts, against the security policy.
+
+It reports dangerous commands, protected paths, network use, unquoted
+expansions, downloads piped into a shell (curl ... | sh), eval and risky
+arguments, as text, JSON or SARIF:
+
+  shode audit deploy.sh
+  shode audit --format sarif --policy ci scripts/*.sh > shode.sarif
+
+The exit status is 1 if an issue at or above --fail-on is found.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != auditFormatText && format != auditFormatJSON && format != auditFormatSARIF {
+				return fmt.Errorf("unknown format %q: use text, json or sarif", format)
+			}
+			if failOn != "none" && !sandbox.ValidSeverity(failOn) {
+				return fmt.Errorf("unknown severity %q: use error, warning, note or none", failOn)
+			}
+
+			security, err := newSecurityChecker(policyFlags{policy: policy})
+			if err != nil {
+				return err
+			}
+			wd, err := os.Getwd()
+			if err != nil {
+				return fmt.Errorf("failed to get working directory: %v", err)
+			}
+
+			reports := make([]*sandbox.ScriptReport, 0, len(args))
+			for _, file := range args {
+				report, err := auditScript(security, file, wd)
+				if err != nil {
+					return err
+				}
+				reports = append(reports, report)
+			}
+
+			out := cmd.OutOrStdout()
+			switch format {
+			case auditFormatJSON:
+				err = writeAuditJSON(out, reports)
+			case auditFormatSARIF:
+				err = writeSARIF(out, reports)
+			default:
+				writeAuditText(out, reports)
+			}
+			if err != nil {
+				return err
+			}
+
+			if failOn != "none" {
+				for _, report := range reports {
+					if report.Count(failOn) > 0 {
+						return &ExitError{Code: 1}
+					}
+				}
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&policy, "policy", "", "security policy file or profile (strict, ci, dev); defaults to "+sandbox.PolicyFileName+" or the security section of shode.json")
+	cmd.Flags().StringVar(&format, "format", auditFormatText, "output format: text, json or sarif")
+	cmd.Flags().StringVar(&failOn, "fail-on", sandbox.SeverityError, "exit with status 1 on issues of this severity or higher: error, warning, note or none")
+
+	// Issues are the output; the exit status only gates on them
+	cmd.SilenceErrors = true
+	cmd.SilenceUsage = true
+
+	return cmd
+}
+
+// auditScript parses and analyzes a script file. A script that cannot be
+// parsed is reported as a syntax error.
+func auditScript(security *sandbox.SecurityChecker, file, dir string) (*sandbox.ScriptReport, error) {
+	if _, err := os.Stat(file); err != nil {
+		return nil, fmt.Errorf("script file not found: %s", file)
+	}
+	script, err := parser.NewSimpleParser().ParseFile(file)
+	if err != nil {
+		return &sandbox.ScriptReport{
+			File: file,
+			Issues: []sandbox.Issue{{
+				Rule:     "syntax-error",
+				Severity: sandbox.SeverityError,
+				Message:  err.Error(),
+			}},
+		}, nil
+	}
+	report := security.AnalyzeScript(script, dir)
+	report.File = file
+	return report, nil
+}
+
+// writeAuditText prints issues one per line, like compiler diagnostics
+func writeAuditText(w io.Writer, reports []*sandbox.ScriptReport) {
+	for _, report := range reports {
+		for _, issue := range report.Issues {
+			location := report.File
+			if issue.Line > 0 {
+				location += fmt.Sprintf(":%d", issue.Line)
+				if issue.Column > 0 {
+					location += fmt.Sprintf(":%d", issue.Column)
+				}
+			}
+			fmt.Fprintf(w, "%s: %s: %s [%s]\n", location, issue.Severity, issue.Message, issue.Rule)
+			if issue.Context != "" {
+				fmt.Fprintf(w, "    in %s\n", issue.Context)
+			}
+		}
+		errors := report.Count(sandbox.SeverityError)
+		warnings := report.Count(sandbox.SeverityWarning) - errors
+		notes := len(report.Issues) - errors - warnings
+		fmt.Fprintf(w, "%s: %d commands, %d errors, %d warnings, %d notes\n", report.File, report.Commands, errors, warnings, notes)
+	}
+}
+
+// writeAuditJSON prints the reports with issue totals
+func writeAuditJSON(w io.Writer, reports []*sandbox.ScriptReport) error {
+	output := auditOutput{Reports: reports}
+	for _, report := range reports {
+		errors := report.Count(sandbox.SeverityError)
+		warnings := report.Count(sandbox.SeverityWarning) - errors
+		output.Errors += errors
+		output.Warnings += warnings
+		output.Notes += len(report.Issues) - errors - warnings
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(output)
+}
@@ -3,83 +3,168 @@ package commands
 import (
 	"context"
 	"fmt"
+	"io"
+	"os"
+	"strings"
 	"time"
 
 	"gitee.com/com_818cloud/shode/pkg/engine"
 	"gitee.com/com_818cloud/shode/pkg/environment"
 	"gitee.com/com_818cloud/shode/pkg/module"
 	"gitee.com/com_818cloud/shode/pkg/parser"
-	"gitee.com/com_818cloud/shode/pkg/sandbox"
 	"gitee.com/com_818cloud/shode/pkg/stdlib"
 	"github.com/spf13/cobra"
 )
 
+// syntaxErrorStatus is the exit status for scripts that fail to parse, as in sh
+const syntaxErrorStatus = 2
+
 // NewExecCommand creates the 'exec' command for executing inline commands
 func NewExecCommand() *cobra.Command {
+	var commandString bool
+	var timeout time.Duration
+	var policy policyFlags
+
 	cmd := &cobra.Command{
-		Use:   "exec [command]",
+		Use:   "exec [command...] | exec -c script [name [args...]] | exec - [args...]",
 		Short: "Execute an inline shell command",
-		Long: `Execute runs a single shell command with Shode's security features.
-The command will be parsed, analyzed for security risks, and executed in a sandboxed environment.`,
-		Args: cobra.MinimumNArgs(1),
+		Long: `Execute runs shell commands with Shode's security features.
+The commands will be parsed, analyzed for security risks, and executed in a sandboxed environment.
+
+It takes its arguments like sh -c:
+
+  shode exec -c 'echo "$0: $1"' name arg   # like sh -c script name args
+  curl -fsSL https://example.com/install.sh | shode exec - arg
+  shode exec "echo hello world"            # arguments are joined into one command
+
+With no arguments the script is read from stdin when it is not a terminal.
+The exit status of the last command becomes the exit status of shode.
+
+It is not a drop-in replacement for sh: && and || are not supported, nor
+are cd, export, test and $?, and output is printed when the script ends.`,
+		Args: cobra.ArbitraryArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			command := args[0]
-			if len(args) > 1 {
-				// Join all arguments to form the complete command
-				for _, arg := range args[1:] {
-					command += " " + arg
-				}
+			source, scriptName, scriptArgs, err := execScriptSource(cmd, commandString, args)
+			if err != nil {
+				return err
 			}
 
-			fmt.Printf("Executing command: %s\n", command)
-			
 			// Parse the command
 			parser := parser.NewSimpleParser()
-			script, err := parser.ParseString(command)
+			script, err := parser.ParseString(source)
 			if err != nil {
-				return fmt.Errorf("failed to parse command: %v", err)
+				fmt.Fprintf(cmd.ErrOrStderr(), "%s: syntax error: %v\n", scriptName, err)
+				return &ExitError{Code: syntaxErrorStatus}
 			}
-			
-			if len(script.Nodes) == 0 {
-				return fmt.Errorf("no command to execute")
-			}
-			
+
 			// Initialize execution engine components
 			envManager := environment.NewEnvironmentManager()
 			stdLib := stdlib.New()
 			moduleMgr := module.NewModuleManager()
-			security := sandbox.NewSecurityChecker()
-			
+			security, err := newSecurityChecker(policy)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "%s: %v\n", scriptName, err)
+				return &ExitError{Code: 1}
+			}
+
 			// Create execution engine
 			executionEngine := engine.NewExecutionEngine(envManager, stdLib, moduleMgr, security)
-			
-			// Execute the command with timeout
-			ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
-			defer cancel()
-			
+			executionEngine.SetScriptArgs(scriptName, scriptArgs)
+			closeLog, err := configureEngine(executionEngine, policy)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "%s: %v\n", scriptName, err)
+				return &ExitError{Code: 1}
+			}
+			defer closeLog()
+
+			// Like sh, run without a time limit unless one is requested
+			ctx := context.Background()
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+
 			result, err := executionEngine.Execute(ctx, script)
 			if err != nil {
-				return fmt.Errorf("execution error: %v", err)
+				fmt.Fprintf(cmd.ErrOrStderr(), "%s: %v\n", scriptName, err)
+				return &ExitError{Code: 1}
 			}
-			
+
 			// Display output directly
-			if result.Output != "" {
-				fmt.Print(result.Output)
-			}
-			
+			fmt.Fprint(cmd.OutOrStdout(), result.Output)
+
 			// Display errors to stderr
-			if result.Error != "" {
-				fmt.Fprint(cmd.ErrOrStderr(), result.Error)
-			}
-			
-			// Return error if command failed
-			if !result.Success {
-				return fmt.Errorf("command execution failed with exit code %d", result.ExitCode)
+			fmt.Fprint(cmd.ErrOrStderr(), result.Error)
+
+			// Pass the exit status through unchanged
+			if result.ExitCode != 0 {
+				return &ExitError{Code: result.ExitCode}
 			}
-			
+
 			return nil
 		},
 	}
 
+	cmd.Flags().BoolVarP(&commandString, "command", "c", false, "read commands from the first argument, like sh -c")
+	cmd.Flags().DurationVar(&timeout, "timeout", 0, "abort execution after this duration (0 means no limit)")
+	addPolicyFlags(cmd, &policy)
+
+	// Flags after the script belong to the script
+	cmd.Flags().SetInterspersed(false)
+
+	// Failures are reported through the script's own output and exit status
+	cmd.SilenceErrors = true
+	cmd.SilenceUsage = true
+
 	return cmd
 }
+
+// execScriptSource determines the script text, $0 and positional parameters
+// for an exec invocation
+func execScriptSource(cmd *cobra.Command, commandString bool, args []string) (string, string, []string, error) {
+	switch {
+	case commandString:
+		// sh -c script [name [args...]]
+		if len(args) == 0 {
+			return "", "", nil, fmt.Errorf("-c: option requires an argument")
+		}
+		name := "shode"
+		if len(args) > 1 {
+			name = args[1]
+		}
+		var scriptArgs []string
+		if len(args) > 2 {
+			scriptArgs = args[2:]
+		}
+		return args[0], name, scriptArgs, nil
+
+	case len(args) > 0 && args[0] == "-", len(args) == 0 && !isTerminal(os.Stdin):
+		// Script on stdin; remaining arguments are positional parameters
+		var scriptArgs []string
+		if len(args) > 1 {
+			scriptArgs = args[1:]
+		}
+		data, err := io.ReadAll(cmd.InOrStdin())
+		if err != nil {
+			return "", "", nil, fmt.Errorf("failed to read script from stdin: %v", err)
+		}
+		return string(data), "shode", scriptArgs, nil
+
+	case len(args) == 0:
+		return "", "", nil, fmt.Errorf("no command to execute")
+
+	default:
+		// Join all arguments to form the complete command
+		return strings.Join(args, " "), "shode", nil, nil
+	}
+}
+
+// isTerminal reports whether f is an interactive terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,13 @@
+package commands
+
+import "fmt"
+
+// ExitError makes shode exit with a script's exit status instead of
+// reporting an error
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
@@ -0,0 +1,113 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gitee.com/com_818cloud/shode/pkg/audit"
+	"gitee.com/com_818cloud/shode/pkg/engine"
+	"gitee.com/com_818cloud/shode/pkg/sandbox"
+	"github.com/spf13/cobra"
+)
+
+// policyFlags are the security flags shared by run, exec and repl
+type policyFlags struct {
+	policy   string
+	enforce  bool
+	auditLog string
+	confirm  bool
+}
+
+// addPolicyFlags registers the --policy, --enforce, --audit-log and
+// --confirm flags. The value of flags.confirm is the default of --confirm.
+func addPolicyFlags(cmd *cobra.Command, flags *policyFlags) {
+	cmd.Flags().StringVar(&flags.policy, "policy", "", "security policy file or profile (strict, ci, dev); defaults to "+sandbox.PolicyFileName+" or the security section of shode.json")
+	cmd.Flags().BoolVar(&flags.enforce, "enforce", false, "run external commands in a kernel sandbox (Landlock, seccomp, no_new_privs; Linux only)")
+	cmd.Flags().StringVar(&flags.auditLog, "audit-log", "", "append a JSON Lines record of every command to a file, '-' for stderr, 'syslog' or 'syslog://host:port'")
+	cmd.Flags().BoolVar(&flags.confirm, "confirm", flags.confirm, "ask on the terminal before running commands that violate the policy or are risky, instead of refusing them")
+}
+
+// engineOptions are the settings of an engine, or of the REPL's engine,
+// that the policy flags configure
+type engineOptions interface {
+	SetAuditLogger(logger audit.Logger)
+	SetApprover(approver engine.Approver)
+}
+
+// configureEngine applies the --audit-log and --confirm flags. It returns
+// the function that closes the audit log.
+func configureEngine(target engineOptions, flags policyFlags) (func(), error) {
+	closeLog := func() {}
+	if flags.auditLog != "" {
+		logger, err := audit.Open(flags.auditLog)
+		if err != nil {
+			return nil, err
+		}
+		target.SetAuditLogger(logger)
+		closeLog = func() { logger.Close() }
+	}
+	if flags.confirm {
+		target.SetApprover(engine.TerminalApprover())
+	}
+	return closeLog, nil
+}
+
+// newSecurityChecker creates the security checker for the policy flags,
+// discovering a policy file in the working directory if --policy is empty
+func newSecurityChecker(flags policyFlags) (*sandbox.SecurityChecker, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get working directory: %v", err)
+	}
+
+	policy, err := sandbox.ResolvePolicy(flags.policy, wd)
+	if err != nil {
+		return nil, err
+	}
+	security, err := sandbox.NewSecurityCheckerWithPolicy(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	// --enforce adds Landlock and seccomp to the policy's enforcement
+	if flags.enforce {
+		enforcement := sandbox.EnforcementPolicy{}
+		if current := security.Enforcement(); current != nil {
+			enforcement = *current
+		}
+		enforcement.Landlock = true
+		enforcement.Seccomp = true
+		if err := security.SetEnforcement(&enforcement); err != nil {
+			return nil, err
+		}
+	}
+	return security, nil
+}
+
+// watchPolicy reloads the checker when the policy file it was configured
+// from changes, reporting each reload on stderr. It returns the function
+// that stops watching; nothing is watched for profiles and inline policies.
+func watchPolicy(security *sandbox.SecurityChecker) func() {
+	policies := security.Policies()
+	if len(policies) == 0 {
+		return func() {}
+	}
+	source := policies[len(policies)-1]
+	if source == "inline" || strings.HasPrefix(source, "profile:") {
+		return func() {}
+	}
+
+	watcher, err := security.WatchPolicy(source, sandbox.DefaultWatchInterval, func(err error) {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "shode: policy %s not reloaded, keeping the previous rules: %v\n", source, err)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "shode: policy %s reloaded\n", source)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "shode: %v\n", err)
+		return func() {}
+	}
+	return watcher.Stop
+}
@@ -7,17 +7,36 @@ import (
 
 // NewReplCommand creates the 'repl' command for interactive shell
 func NewReplCommand() *cobra.Command {
+	// Interactive sessions ask about violations by default
+	policy := policyFlags{confirm: true}
+
 	cmd := &cobra.Command{
 		Use:   "repl",
 		Short: "Start an interactive shell session",
 		Long: `REPL starts an interactive Read-Eval-Print Loop session where you can
 execute shell commands in a safe, sandboxed environment with Shode's security features.`,
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
+			security, err := newSecurityChecker(policy)
+			if err != nil {
+				return err
+			}
+			// A long session picks up edits of its policy file
+			stopWatching := watchPolicy(security)
+			defer stopWatching()
+
 			// Create and start the REPL
-			shodeRepl := repl.NewREPL()
+			shodeRepl := repl.NewREPLWithSecurity(security)
+			closeLog, err := configureEngine(shodeRepl, policy)
+			if err != nil {
+				return err
+			}
+			defer closeLog()
 			shodeRepl.Start()
+			return nil
 		},
 	}
 
+	addPolicyFlags(cmd, &policy)
+
 	return cmd
 }
@@ -2,6 +2,7 @@ package commands
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"time"
@@ -17,14 +18,37 @@ import (
 
 // NewRunCommand creates the 'run' command for executing script files
 func NewRunCommand() *cobra.Command {
+	var policy policyFlags
+	var dryRun bool
+	var planFile string
+	var transactional string
+	var commit bool
+
 	cmd := &cobra.Command{
-		Use:   "run [script-file]",
+		Use:   "run [script-file] [args...]",
 		Short: "Run a shell script file",
 		Long: `Run executes a shell script file with Shode's security features enabled.
-The script will be parsed, analyzed for security risks, and executed in a sandboxed environment.`,
-		Args: cobra.ExactArgs(1),
+The script will be parsed, analyzed for security risks, and executed in a sandboxed environment.
+Arguments after the script file become its positional parameters $1..$n.
+
+With --dry-run the script is simulated: expansions, assignments and builtins
+run, but external commands, redirections, WriteFile and ChangeDir are only
+recorded. The plan of commands, files touched and environment changes is
+printed after the output, and written as JSON with --plan:
+
+  shode run --dry-run --plan plan.json deploy.sh
+
+With --transactional the script runs against a copy-on-write view of the
+working directory: overlayfs where the kernel allows it unprivileged, a
+shadow copy elsewhere (--transactional=overlay or =shadow picks one).
+Afterwards the files created, modified and deleted are listed and applied
+only once confirmed, or with --commit if the script succeeded.`,
+		Args: cobra.MinimumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			scriptFile := args[0]
+			if transactional != "" && (dryRun || planFile != "") {
+				return fmt.Errorf("--transactional cannot be combined with --dry-run")
+			}
 			
 			// Check if file exists
 			if _, err := os.Stat(scriptFile); os.IsNotExist(err) {
@@ -46,10 +70,31 @@ The script will be parsed, analyzed for security risks, and executed in a sandbo
 			envManager := environment.NewEnvironmentManager()
 			stdLib := stdlib.New()
 			moduleMgr := module.NewModuleManager()
-			security := sandbox.NewSecurityChecker()
+			security, err := newSecurityChecker(policy)
+			if err != nil {
+				return err
+			}
 			
 			// Create execution engine
 			executionEngine := engine.NewExecutionEngine(envManager, stdLib, moduleMgr, security)
+			executionEngine.SetScriptArgs(scriptFile, args[1:])
+			executionEngine.SetScriptFile(scriptFile)
+			closeLog, err := configureEngine(executionEngine, policy)
+			if err != nil {
+				return err
+			}
+			defer closeLog()
+			if dryRun || planFile != "" {
+				executionEngine.SetDryRun(true)
+			}
+			var tx *sandbox.Transaction
+			if transactional != "" {
+				tx, err = startTransaction(transactional)
+				if err != nil {
+					return err
+				}
+				executionEngine.SetTransaction(tx)
+			}
 			
 			// Execute the script with timeout
 			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
@@ -58,6 +103,9 @@ The script will be parsed, analyzed for security risks, and executed in a sandbo
 			fmt.Println("\n--- Execution Output ---")
 			result, err := executionEngine.Execute(ctx, script)
 			if err != nil {
+				if tx != nil {
+					tx.Discard()
+				}
 				return fmt.Errorf("execution error: %v", err)
 			}
 			
@@ -75,7 +123,23 @@ The script will be parsed, analyzed for security risks, and executed in a sandbo
 			if result.Error != "" {
 				fmt.Printf("\nErrors:\n%s\n", result.Error)
 			}
+
+			if plan := executionEngine.Plan(); plan != nil {
+				fmt.Println("\n--- Dry Run Plan ---")
+				fmt.Print(engine.FormatPlan(plan))
+				if planFile != "" {
+					if err := writePlan(planFile, plan); err != nil {
+						return err
+					}
+				}
+			}
 			
+			if tx != nil {
+				if err := finishTransaction(os.Stdout, tx, commit, result.Success); err != nil {
+					return err
+				}
+			}
+
 			// Return error if script failed
 			if !result.Success {
 				return fmt.Errorf("script execution failed with exit code %d", result.ExitCode)
@@ -85,5 +149,27 @@ The script will be parsed, analyzed for security risks, and executed in a sandbo
 		},
 	}
 
+	addPolicyFlags(cmd, &policy)
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "simulate the script and print what it would run, write and change instead of doing it")
+	cmd.Flags().StringVar(&planFile, "plan", "", "write the dry-run plan as JSON to this file; implies --dry-run")
+	cmd.Flags().StringVar(&transactional, "transactional", "", "run against a copy-on-write view of the working directory and apply the changes once confirmed: overlay, shadow or auto")
+	cmd.Flags().Lookup("transactional").NoOptDefVal = transactionAuto
+	cmd.Flags().BoolVar(&commit, "commit", false, "apply the changes of a transactional run without asking if the script succeeded")
+
+	// Flags after the script file belong to the script
+	cmd.Flags().SetInterspersed(false)
+
 	return cmd
 }
+
+// writePlan writes a dry-run plan as JSON
+func writePlan(path string, plan *engine.Plan) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write plan %s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package commands
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+
+	"gitee.com/com_818cloud/shode/pkg/sandbox"
+)
+
+// SARIF 2.1.0 output, the format code scanning services import
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+)
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name    string      `json:"name"`
+	Version string      `json:"version"`
+	Rules   []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifact `json:"artifactLocation"`
+	Region           *sarifRegion  `json:"region,omitempty"`
+}
+
+type sarifArtifact struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
+
+// writeSARIF prints the reports as a SARIF log with one run
+func writeSARIF(w io.Writer, reports []*sandbox.ScriptReport) error {
+	run := sarifRun{
+		Tool:    sarifTool{Driver: sarifDriver{Name: "shode", Version: "0.1.0", Rules: []sarifRule{}}},
+		Results: []sarifResult{},
+	}
+
+	rules := map[string]bool{}
+	for _, report := range reports {
+		for _, issue := range report.Issues {
+			rules[issue.Rule] = true
+
+			message := issue.Message
+			if issue.Context != "" {
+				message += " (in " + issue.Context + ")"
+			}
+			location := sarifPhysicalLocation{ArtifactLocation: sarifArtifact{URI: report.File}}
+			if issue.Line > 0 {
+				location.Region = &sarifRegion{StartLine: issue.Line, StartColumn: issue.Column}
+			}
+			run.Results = append(run.Results, sarifResult{
+				RuleID:    issue.Rule,
+				Level:     issue.Severity,
+				Message:   sarifMessage{Text: message},
+				Locations: []sarifLocation{{PhysicalLocation: location}},
+			})
+		}
+	}
+
+	ids := make([]string, 0, len(rules))
+	for id := range rules {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{
+			ID:               id,
+			ShortDescription: sarifMessage{Text: sandbox.RuleDescription(id)},
+		})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(sarifLog{Version: sarifVersion, Schema: sarifSchema, Runs: []sarifRun{run}})
+}
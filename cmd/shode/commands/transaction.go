@@ -0,0 +1,100 @@
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gitee.com/com_818cloud/shode/pkg/sandbox"
+)
+
+// transactionAuto picks the transaction backend the system supports
+const transactionAuto = "auto"
+
+// startTransaction starts a transaction on the working directory
+func startTransaction(mode string) (*sandbox.Transaction, error) {
+	if mode == transactionAuto {
+		mode = ""
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get working directory: %v", err)
+	}
+	return sandbox.NewTransaction(wd, mode)
+}
+
+// finishTransaction shows the changes of a transactional run and applies
+// them if the user confirms, or without asking if commit is set and the
+// script succeeded. Otherwise they are discarded.
+func finishTransaction(out io.Writer, tx *sandbox.Transaction, commit, succeeded bool) error {
+	defer tx.Discard()
+
+	changes, err := tx.Changes()
+	if err != nil {
+		return fmt.Errorf("failed to compare transaction: %v", err)
+	}
+	fmt.Fprintf(out, "\n--- Transaction (%s) ---\n", tx.Mode())
+	if len(changes) == 0 {
+		fmt.Fprintln(out, "No changes.")
+		return nil
+	}
+	writeChanges(out, tx.Root(), changes)
+
+	apply := commit && succeeded
+	if !commit {
+		apply = confirmCommit(len(changes), tx.Root())
+	}
+	if !apply {
+		fmt.Fprintln(out, "Changes discarded.")
+		return nil
+	}
+	if _, err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	fmt.Fprintf(out, "Applied %d changes.\n", len(changes))
+	return nil
+}
+
+// writeChanges lists changes relative to the transaction root: + created,
+// ~ modified, - deleted
+func writeChanges(out io.Writer, root string, changes []sandbox.Change) {
+	for _, change := range changes {
+		marker := "~"
+		switch change.Kind {
+		case sandbox.ChangeCreated:
+			marker = "+"
+		case sandbox.ChangeDeleted:
+			marker = "-"
+		}
+		path, err := filepath.Rel(root, change.Path)
+		if err != nil {
+			path = change.Path
+		}
+		if change.Dir {
+			path += "/"
+		}
+		fmt.Fprintf(out, "  %s %s\n", marker, path)
+	}
+}
+
+// confirmCommit asks on the terminal whether to apply the changes. Without
+// a terminal they are not applied.
+func confirmCommit(count int, root string) bool {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "shode: no terminal to confirm the changes; use --commit to apply them")
+		return false
+	}
+	defer tty.Close()
+
+	fmt.Fprintf(tty, "Apply %d changes to %s? [y/N]: ", count, root)
+	answer, _ := bufio.NewReader(tty).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
@@ -22,10 +23,16 @@ for shell scripting.`,
 	rootCmd.AddCommand(commands.NewRunCommand())
 	rootCmd.AddCommand(commands.NewExecCommand())
 	rootCmd.AddCommand(commands.NewReplCommand())
+	rootCmd.AddCommand(commands.NewAuditCommand())
 	rootCmd.AddCommand(commands.NewPkgCommand())
 	rootCmd.AddCommand(commands.NewVersionCommand())
 
 	if err := rootCmd.Execute(); err != nil {
+		// Scripts run by exec report their own failures; only pass the status on
+		var exitErr *commands.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.Code)
+		}
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"log"
+	"path/filepath"
+	"strings"
 
 	"gitee.com/com_818cloud/shode/pkg/stdlib"
 )
@@ -67,5 +69,111 @@ func main() {
 	stdlib.Println("This is a test message printed via stdlib.Println")
 	stdlib.Error("This is an error message printed via stdlib.Error\n")
 
+	// Test JSON functions
+	fmt.Println("\n5. JSON Functions:")
+
+	doc := `{"name": "shode", "version": "0.1", "hosts": [{"name": "a", "up": true}, {"name": "b", "up": false}]}`
+	version, err := stdlib.JSONGet(doc, ".version")
+	if err != nil {
+		log.Printf("Error querying JSON: %v", err)
+	} else {
+		fmt.Printf("Version: %v\n", version)
+	}
+
+	up, err := stdlib.JSONQuery(doc, `.hosts[] | select(.up) | .name`)
+	if err != nil {
+		log.Printf("Error querying JSON: %v", err)
+	} else {
+		fmt.Printf("Hosts up: %v\n", up)
+	}
+
+	updated, err := stdlib.JSONSetString(doc, ".version", "0.2")
+	if err == nil {
+		updated, err = stdlib.JSONDelete(updated, ".hosts")
+	}
+	if err == nil {
+		updated, err = stdlib.JSONMerge(updated, `{"license": "MIT"}`)
+	}
+	if err != nil {
+		log.Printf("Error updating JSON: %v", err)
+	} else {
+		fmt.Printf("Updated: %s\n", updated)
+	}
+
+	names, err := stdlib.JSONToArray(`["x", 1, {"y": null}]`)
+	if err != nil {
+		log.Printf("Error converting JSON: %v", err)
+	} else {
+		fmt.Printf("As shell array: %q\n", names)
+		fmt.Printf("Back to JSON: %s\n", stdlib.JSONFromArray(names))
+	}
+
+	// Test regex and text functions
+	fmt.Println("\n6. Regex and Text Functions:")
+
+	groups, err := stdlib.RegexMatch("release v1.12", `v(\d+)\.(\d+)`)
+	if err != nil {
+		log.Printf("Error matching: %v", err)
+	} else {
+		fmt.Printf("Match and groups: %q\n", groups)
+	}
+
+	replacedRegex, err := stdlib.RegexReplace("me@host", `(\w+)@(\w+)`, `\2 at $1`)
+	if err != nil {
+		log.Printf("Error replacing: %v", err)
+	} else {
+		fmt.Printf("Regex replaced: %s\n", replacedRegex)
+	}
+
+	accessLog := "10.0.0.1 GET /\n10.0.0.2 GET /a\n10.0.0.1 POST /b\n"
+	addresses, err := stdlib.Fields(accessLog, "", "1")
+	if err == nil {
+		fmt.Printf("Requests per address: %q\n", countLines(stdlib, addresses))
+	}
+	posts, _ := stdlib.FilterLines(accessLog, `\bPOST\b`)
+	fmt.Printf("POST lines: %q, lines: %d, words: %d\n", posts, stdlib.CountLines(accessLog), stdlib.CountWords(accessLog))
+
+	upper, err := stdlib.Translate("shode", "a-z", "A-Z")
+	if err == nil {
+		fmt.Printf("Translated: %s\n", upper)
+	}
+
+	// Test file functions
+	fmt.Println("\n7. File Functions:")
+
+	dir, err := stdlib.TempDir("stdlib-test-*")
+	if err != nil {
+		log.Printf("Error creating directory: %v", err)
+	} else {
+		defer stdlib.Remove(dir, true)
+		config := filepath.Join(dir, "config", "app.json")
+		err = stdlib.MkdirAll(filepath.Dir(config), 0755)
+		if err == nil {
+			err = stdlib.AtomicWrite(config, `{"env": "dev"}`)
+		}
+		if err == nil {
+			err = stdlib.CopyDir(filepath.Join(dir, "config"), filepath.Join(dir, "backup"))
+		}
+		if err == nil {
+			err = stdlib.Chmod(config, "go-rwx")
+		}
+		if err != nil {
+			log.Printf("Error writing files: %v", err)
+		} else {
+			info, _ := stdlib.Stat(config)
+			fmt.Printf("Config mode: %s, size: %d\n", info.Mode, info.Size)
+			copies, _ := stdlib.Glob(filepath.Join(dir, "**", "*.json"))
+			fmt.Printf("JSON files: %d\n", len(copies))
+		}
+	}
+
 	fmt.Println("Standard library test completed successfully!")
 }
+
+// countLines counts the distinct lines, most frequent first, like
+// sort | uniq -c | sort -rn
+func countLines(lib *stdlib.StdLib, lines []string) []string {
+	sorted := lib.SortLines(strings.Join(lines, "\n"), stdlib.SortOptions{})
+	counted := lib.UniqueLines(strings.Join(sorted, "\n"), true)
+	return lib.SortLines(strings.Join(counted, "\n"), stdlib.SortOptions{Numeric: true, Reverse: true})
+}
@@ -0,0 +1,141 @@
+// Package audit records what a Shode run executed as JSON Lines, one
+// record per command, so runs can be reviewed after the fact.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Record events
+const (
+	EventCommand = "command" // a command was run or blocked
+	EventNetwork = "network" // a command connected through the egress proxy
+)
+
+// Policy decisions
+const (
+	DecisionAllow = "allow"
+	DecisionDeny  = "deny"
+)
+
+// Record is one audit log entry
+type Record struct {
+	Event    string    `json:"event"` // EventCommand or EventNetwork
+	RunID    string    `json:"runId"` // identifies the engine that ran the command
+	Seq      int64     `json:"seq"`   // position in the run, starting at 1
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Duration float64   `json:"durationMs"`
+	Script   string    `json:"script,omitempty"` // script file being executed
+	Line     int       `json:"line,omitempty"`   // line of the command in the script
+
+	Command    string             `json:"command"`
+	Argv       []string           `json:"argv"`                 // arguments after expansion, including the command
+	Kind       string             `json:"kind,omitempty"`       // "builtin", "stdlib" or "external"
+	Executable string             `json:"executable,omitempty"` // binary an external command resolved to
+	Dir        string             `json:"dir"`                  // working directory
+	Env        map[string]*string `json:"env,omitempty"`        // variables changed since the run started; null if unset
+	Redirects  []Redirect         `json:"redirects,omitempty"`
+
+	Decision string   `json:"decision"`           // DecisionAllow or DecisionDeny
+	Reason   string   `json:"reason,omitempty"`   // violation that blocked the command, or that was approved
+	Approval string   `json:"approval,omitempty"` // "once", "session" or "always" if the user approved the command
+	Policies []string `json:"policies,omitempty"`
+
+	ExitCode  int    `json:"exitCode"`
+	PID       int    `json:"pid,omitempty"` // process of an external command
+	ParentPID int    `json:"ppid"`          // process of the shode run
+	Cached    bool   `json:"cached,omitempty"`
+	Simulated bool   `json:"simulated,omitempty"` // recorded by a dry run, not run
+	Error     string `json:"error,omitempty"`     // why the command could not run, if not a policy decision
+
+	// Destination of a network event
+	Host    string `json:"host,omitempty"`
+	Port    int    `json:"port,omitempty"`
+	Address string `json:"address,omitempty"`
+}
+
+// Redirect is a file a command's input or output was redirected to
+type Redirect struct {
+	Op   string `json:"op"`
+	File string `json:"file"` // absolute path
+}
+
+// Logger receives audit records. Implementations are safe for concurrent
+// use.
+type Logger interface {
+	Log(record *Record) error
+	Close() error
+}
+
+// JSONLogger writes records as JSON Lines
+type JSONLogger struct {
+	mu     sync.Mutex
+	writer io.Writer
+	closer io.Closer
+}
+
+// NewJSONLogger writes records to w, one JSON object per line
+func NewJSONLogger(w io.Writer) *JSONLogger {
+	return &JSONLogger{writer: w}
+}
+
+// NewFileLogger appends records to a file, creating it if needed
+func NewFileLogger(path string) (*JSONLogger, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %v", err)
+	}
+	return &JSONLogger{writer: file, closer: file}, nil
+}
+
+// Log writes a record as a single line
+func (l *JSONLogger) Log(record *Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// One write per record keeps lines whole in files shared by several runs
+	_, err = l.writer.Write(data)
+	return err
+}
+
+// Close closes the underlying file, if the logger opened one
+func (l *JSONLogger) Close() error {
+	if l.closer == nil {
+		return nil
+	}
+	return l.closer.Close()
+}
+
+// Open opens the audit log named by spec: "-" for stderr, "syslog" for the
+// local syslog daemon, "syslog://host:port" for a remote one over UDP, or a
+// file path
+func Open(spec string) (Logger, error) {
+	var logger Logger
+	var err error
+	switch {
+	case spec == "-":
+		logger = NewJSONLogger(os.Stderr)
+	case spec == "syslog":
+		logger, err = NewSyslogLogger("", "")
+	case strings.HasPrefix(spec, "syslog://"):
+		logger, err = NewSyslogLogger("udp", strings.TrimPrefix(spec, "syslog://"))
+	default:
+		logger, err = NewFileLogger(spec)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return logger, nil
+}
@@ -0,0 +1,41 @@
+//go:build !windows && !plan9
+
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+)
+
+// SyslogLogger sends each record to syslog as a JSON message
+type SyslogLogger struct {
+	writer *syslog.Writer
+}
+
+// NewSyslogLogger connects to a syslog daemon; an empty network and address
+// select the local one. Records are logged with the tag "shode".
+func NewSyslogLogger(network, address string) (*SyslogLogger, error) {
+	writer, err := syslog.Dial(network, address, syslog.LOG_INFO|syslog.LOG_USER, "shode")
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to syslog: %v", err)
+	}
+	return &SyslogLogger{writer: writer}, nil
+}
+
+// Log sends a record; denied commands and connections are warnings
+func (l *SyslogLogger) Log(record *Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if record.Decision == DecisionDeny {
+		return l.writer.Warning(string(data))
+	}
+	return l.writer.Info(string(data))
+}
+
+// Close closes the connection to syslog
+func (l *SyslogLogger) Close() error {
+	return l.writer.Close()
+}
@@ -0,0 +1,10 @@
+//go:build windows || plan9
+
+package audit
+
+import "fmt"
+
+// NewSyslogLogger fails: syslog is not available on this platform
+func NewSyslogLogger(network, address string) (Logger, error) {
+	return nil, fmt.Errorf("syslog is not supported on this platform")
+}
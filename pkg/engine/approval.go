@@ -0,0 +1,217 @@
+package engine
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"gitee.com/com_818cloud/shode/pkg/sandbox"
+	"gitee.com/com_818cloud/shode/pkg/types"
+)
+
+// confirmRiskScore is the risk score from which commands the policy allows
+// are still confirmed
+const confirmRiskScore = 40
+
+// Approval is the user's answer to an approval request
+type Approval int
+
+const (
+	ApprovalDeny    Approval = iota // do not run the command
+	ApprovalOnce                    // run the command this time
+	ApprovalSession                 // run the command line for the rest of the session
+	ApprovalAlways                  // also save the command line to the policy file
+)
+
+// String names an approval in the audit log
+func (a Approval) String() string {
+	switch a {
+	case ApprovalOnce:
+		return "once"
+	case ApprovalSession:
+		return "session"
+	case ApprovalAlways:
+		return "always"
+	}
+	return "deny"
+}
+
+// ApprovalRequest is a command waiting for the user's approval
+type ApprovalRequest struct {
+	Command    *types.CommandNode
+	Line       string                  // command line with the values of secret variables masked
+	Location   string                  // script and line, if known
+	Violation  string                  // rule the command violates; empty for a risky command the policy allows
+	Assessment *sandbox.RiskAssessment // risk of the command's arguments
+}
+
+// Approver decides whether a command may run
+type Approver func(request *ApprovalRequest) Approval
+
+// SetApprover asks approver about commands that violate the policy or
+// whose risk score is at least 40, instead of refusing them. A nil
+// approver restores refusing violations.
+func (ee *ExecutionEngine) SetApprover(approver Approver) {
+	ee.approver = approver
+}
+
+// Authorize checks whether a command may run, asking the approver in
+// confirm mode, for callers that run the command in several steps. A
+// command approved once may then be executed once without asking again.
+func (ee *ExecutionEngine) Authorize(cmd *types.CommandNode) error {
+	// An earlier approval that was not used does not carry over
+	ee.approvedOnce = ""
+	denied, approval, _ := ee.authorize(cmd, time.Now())
+	if denied != nil {
+		return fmt.Errorf("%s", denied.Violation)
+	}
+	if approval == ApprovalOnce.String() {
+		ee.approvedOnce = sandbox.CommandLine(cmd)
+	}
+	return nil
+}
+
+// authorize runs the security check of a command. It returns a result if
+// the command may not run, and the approval the user gave, if asked.
+func (ee *ExecutionEngine) authorize(cmd *types.CommandNode, startTime time.Time) (*CommandResult, string, string) {
+	if ee.approvedOnce != "" {
+		line := ee.approvedOnce
+		ee.approvedOnce = ""
+		if line == sandbox.CommandLine(cmd) {
+			return nil, "", ""
+		}
+	}
+	err := ee.security.CheckCommandWithContext(cmd, ee.checkContext())
+	// A dry run records violations in its plan instead of asking
+	if ee.approver == nil || ee.plan != nil {
+		if err != nil {
+			return ee.violation(cmd, err, startTime), "", ""
+		}
+		return nil, "", ""
+	}
+
+	// The request is shown on the terminal, so it keeps secrets masked
+	redact := ee.redactor()
+	request := &ApprovalRequest{
+		Command:    cmd,
+		Line:       redact(sandbox.CommandLine(cmd)),
+		Assessment: ee.security.AssessCommand(cmd),
+	}
+	for i := range request.Assessment.Findings {
+		request.Assessment.Findings[i].Message = redact(request.Assessment.Findings[i].Message)
+	}
+	if err != nil {
+		request.Violation = redact(err.Error())
+	} else if request.Assessment.Score < confirmRiskScore || ee.security.IsApproved(cmd) {
+		return nil, "", ""
+	}
+	if len(ee.callStack) > 0 {
+		request.Location = fmt.Sprintf("%s: line %d", ee.callStack[len(ee.callStack)-1].file, cmd.Pos.Line)
+	}
+
+	approval := ee.approver(request)
+	switch approval {
+	case ApprovalDeny:
+		if err == nil {
+			err = fmt.Errorf("security violation: risky command was not approved")
+		}
+		return ee.violation(cmd, err, startTime), "", ""
+	case ApprovalSession:
+		ee.security.ApproveCommand(cmd)
+	case ApprovalAlways:
+		ee.security.ApproveCommand(cmd)
+		if _, saveErr := ee.security.SaveApproval(cmd, ee.envManager.GetWorkingDir()); saveErr != nil {
+			fmt.Fprintf(os.Stderr, "shode: approval: %v\n", saveErr)
+		}
+	}
+	return nil, approval.String(), request.Violation
+}
+
+// violation returns the result of a command the security checker refused
+func (ee *ExecutionEngine) violation(cmd *types.CommandNode, err error, startTime time.Time) *CommandResult {
+	return &CommandResult{
+		Command:   cmd,
+		Success:   false,
+		ExitCode:  1,
+		Error:     ee.diagnostic(cmd, err.Error()),
+		Duration:  time.Since(startTime),
+		Violation: err.Error(),
+	}
+}
+
+// checkContext describes where the next command runs to the security
+// checker
+func (ee *ExecutionEngine) checkContext() *sandbox.CheckContext {
+	ctx := &sandbox.CheckContext{
+		Dir:       ee.envManager.GetWorkingDir(),
+		Env:       ee.envManager.GetAllEnv(),
+		PipeIndex: ee.pipe.index,
+		PipeSize:  ee.pipe.size,
+	}
+	// Scripts passed with -c or on stdin are not files and match no rule
+	for _, frame := range ee.callStack {
+		if frame.path != "" {
+			ctx.CallStack = append(ctx.CallStack, frame.path)
+		}
+	}
+	if len(ee.callStack) > 0 {
+		ctx.Script = ee.callStack[len(ee.callStack)-1].path
+		ctx.Module = sandbox.ModuleOf(ctx.Script)
+	}
+	return ctx
+}
+
+// TerminalApprover asks for approvals on the terminal. Requests are
+// denied if there is no terminal to ask on, e.g. in CI.
+func TerminalApprover() Approver {
+	return func(request *ApprovalRequest) Approval {
+		tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "shode: cannot confirm '%s': no terminal\n", request.Line)
+			return ApprovalDeny
+		}
+		defer tty.Close()
+		return promptApproval(tty, tty, request)
+	}
+}
+
+// promptApproval shows a request and reads the answer, asking again until
+// the answer is understood
+func promptApproval(in io.Reader, out io.Writer, request *ApprovalRequest) Approval {
+	fmt.Fprintln(out)
+	if request.Location != "" {
+		fmt.Fprintf(out, "%s\n", request.Location)
+	}
+	fmt.Fprintf(out, "  command: %s\n", request.Line)
+	if request.Violation != "" {
+		fmt.Fprintf(out, "  blocked: %s\n", request.Violation)
+	}
+	if assessment := request.Assessment; assessment != nil && len(assessment.Findings) > 0 {
+		fmt.Fprintf(out, "  risk:    %d (%s)\n", assessment.Score, assessment.Level)
+		for _, finding := range assessment.Findings {
+			fmt.Fprintf(out, "           - %s [%s, %d]\n", finding.Message, finding.Rule, finding.Score)
+		}
+	}
+
+	reader := bufio.NewReader(in)
+	for {
+		fmt.Fprint(out, "Run it? [o]nce, for this [s]ession, [a]lways (save to policy), [N]o: ")
+		answer, err := reader.ReadString('\n')
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "o", "once", "y", "yes":
+			return ApprovalOnce
+		case "s", "session":
+			return ApprovalSession
+		case "a", "always":
+			return ApprovalAlways
+		case "", "n", "no":
+			return ApprovalDeny
+		}
+		if err != nil {
+			return ApprovalDeny
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package engine
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"gitee.com/com_818cloud/shode/pkg/audit"
+	"gitee.com/com_818cloud/shode/pkg/sandbox"
+	"gitee.com/com_818cloud/shode/pkg/types"
+)
+
+// auditState is the audit log of an engine
+type auditState struct {
+	logger   audit.Logger
+	runID    string
+	seq      atomic.Int64
+	baseline map[string]string // environment when the log was set
+}
+
+// SetAuditLogger records every command the engine runs, and every
+// connection through the egress proxy, to logger. The environment at this
+// point is the baseline that records show changes against. A nil logger
+// disables the audit log.
+func (ee *ExecutionEngine) SetAuditLogger(logger audit.Logger) {
+	if logger == nil {
+		ee.audit = nil
+		return
+	}
+	state := &auditState{
+		logger:   logger,
+		runID:    newRunID(),
+		baseline: ee.envManager.GetAllEnv(),
+	}
+	ee.audit = state
+
+	previous := ee.security.NetworkLogger()
+	ee.security.SetNetworkLogger(func(event sandbox.NetworkEvent) {
+		if previous != nil {
+			previous(event)
+		}
+		ee.auditNetwork(state, event)
+	})
+}
+
+// newRunID returns a random identifier for the records of one engine
+func newRunID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Sprintf("%d-%d", os.Getpid(), time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id)
+}
+
+// auditCommand logs a command the engine ran or refused to run
+func (ee *ExecutionEngine) auditCommand(cmd *types.CommandNode, result *CommandResult, err error, start time.Time) {
+	state := ee.audit
+	if state == nil {
+		return
+	}
+	end := time.Now()
+
+	// The result holds the command as it was after expansion
+	if result != nil && result.Command != nil {
+		cmd = result.Command
+	}
+	redact := ee.redactor()
+	record := &audit.Record{
+		Event:     audit.EventCommand,
+		RunID:     state.runID,
+		Start:     start,
+		End:       end,
+		Duration:  float64(end.Sub(start).Microseconds()) / 1000,
+		Line:      cmd.Pos.Line,
+		Command:   cmd.Name,
+		Argv:      redactAll(redact, append([]string{cmd.Name}, cmd.Args...)),
+		Dir:       ee.envManager.GetWorkingDir(),
+		Env:       ee.maskEnv(ee.envDiff(state.baseline), redact),
+		Decision:  audit.DecisionAllow,
+		Policies:  ee.security.Policies(),
+		ParentPID: os.Getpid(),
+	}
+	if len(ee.callStack) > 0 {
+		record.Script = ee.callStack[len(ee.callStack)-1].file
+	}
+
+	switch {
+	case ee.isBuiltin(cmd.Name) || ee.isDeclarationBuiltin(cmd.Name):
+		record.Kind = "builtin"
+	case ee.isStdLibFunction(cmd.Name):
+		record.Kind = "stdlib"
+	default:
+		record.Kind = "external"
+		if path, ok := ee.executablePath(cmd.Name); ok {
+			record.Executable = path
+		}
+	}
+
+	if redirect := cmd.Redirect; redirect != nil && redirect.File != "" {
+		record.Redirects = []audit.Redirect{{Op: redirect.Op, File: redact(ee.resolvePath(redirect.File))}}
+	}
+
+	switch {
+	case err != nil:
+		record.ExitCode = 1
+		record.Error = redact(err.Error())
+	case result != nil:
+		record.ExitCode = result.ExitCode
+		record.PID = result.PID
+		record.Cached = result.Cached
+		record.Simulated = result.Simulated
+		record.Reason = redact(result.Violation)
+		record.Approval = result.Approval
+		if result.Violation != "" && result.Approval == "" {
+			record.Decision = audit.DecisionDeny
+		}
+	}
+	ee.writeAudit(state, record)
+}
+
+// auditNetwork logs a connection made through the egress proxy
+func (ee *ExecutionEngine) auditNetwork(state *auditState, event sandbox.NetworkEvent) {
+	record := &audit.Record{
+		Event:     audit.EventNetwork,
+		RunID:     state.runID,
+		Start:     event.Time,
+		End:       event.Time,
+		Command:   event.Command,
+		Decision:  audit.DecisionAllow,
+		ParentPID: os.Getpid(),
+		Host:      event.Host,
+		Port:      event.Port,
+		Address:   event.Address,
+	}
+	if !event.Allowed {
+		record.Decision = audit.DecisionDeny
+		record.Reason = event.Reason
+	}
+	record.Error = event.Error
+	ee.writeAudit(state, record)
+}
+
+// writeAudit numbers and writes a record. A run does not fail because its
+// audit log cannot be written, but the failure is reported.
+func (ee *ExecutionEngine) writeAudit(state *auditState, record *audit.Record) {
+	record.Seq = state.seq.Add(1)
+	if record.Argv == nil {
+		record.Argv = []string{}
+	}
+	if err := state.logger.Log(record); err != nil {
+		fmt.Fprintf(os.Stderr, "shode: audit: %v\n", err)
+	}
+}
+
+// envDiff returns the environment variables that differ from baseline,
+// with nil for those that were unset
+func (ee *ExecutionEngine) envDiff(baseline map[string]string) map[string]*string {
+	current := ee.envManager.GetAllEnv()
+	diff := make(map[string]*string)
+	for name, value := range current {
+		if old, ok := baseline[name]; !ok || old != value {
+			value := value
+			diff[name] = &value
+		}
+	}
+	for name := range baseline {
+		if _, ok := current[name]; !ok {
+			diff[name] = nil
+		}
+	}
+	if len(diff) == 0 {
+		return nil
+	}
+	return diff
+}
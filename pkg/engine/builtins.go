@@ -0,0 +1,286 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gitee.com/com_818cloud/shode/pkg/parser"
+	"gitee.com/com_818cloud/shode/pkg/sandbox"
+	"gitee.com/com_818cloud/shode/pkg/types"
+)
+
+// builtinFunc implements a shell builtin operating on expanded arguments
+type builtinFunc func(ee *ExecutionEngine, ctx context.Context, cmd *types.CommandNode) (*CommandResult, error)
+
+// builtins maps shell builtin names to their implementations
+var builtins = map[string]builtinFunc{
+	"echo":   (*ExecutionEngine).builtinEcho,
+	"exit":   (*ExecutionEngine).builtinExit,
+	"printf": (*ExecutionEngine).builtinPrintf,
+	"read":   (*ExecutionEngine).builtinRead,
+	"set":    (*ExecutionEngine).builtinSet,
+	"shift":  (*ExecutionEngine).builtinShift,
+	"shopt":  (*ExecutionEngine).builtinShopt,
+	"unset":  (*ExecutionEngine).builtinUnset,
+}
+
+// shellOptions lists the options supported by shopt
+var shellOptions = []string{"dotglob", "failglob", "globstar", "nullglob"}
+
+// isBuiltin checks if a command is a shell builtin
+func (ee *ExecutionEngine) isBuiltin(name string) bool {
+	_, exists := builtins[name]
+	return exists
+}
+
+// isDeclarationBuiltin checks if a command declares variables. Like
+// assignments, declarations only touch shell state and take their
+// assignment arguments unexpanded.
+func (ee *ExecutionEngine) isDeclarationBuiltin(name string) bool {
+	return name == "declare" || name == "typeset"
+}
+
+// builtinResult builds the result of a builtin command
+func builtinResult(cmd *types.CommandNode, output string, err error) *CommandResult {
+	if err != nil {
+		return &CommandResult{
+			Command:  cmd,
+			Success:  false,
+			ExitCode: 1,
+			Output:   output,
+			Error:    fmt.Sprintf("%s: %v", cmd.Name, err),
+		}
+	}
+	return &CommandResult{
+		Command:  cmd,
+		Success:  true,
+		ExitCode: 0,
+		Output:   output,
+	}
+}
+
+// executeDeclaration implements declare/typeset [-aApS] [name[=value] ...].
+// -S marks the variables secret.
+func (ee *ExecutionEngine) executeDeclaration(cmd *types.CommandNode) *CommandResult {
+	words := cmd.RawArgs
+	if len(words) != len(cmd.Args) {
+		words = cmd.Args
+	}
+
+	indexed, assoc, print, secret := false, false, false, false
+	var output strings.Builder
+
+	for i, word := range words {
+		if strings.HasPrefix(cmd.Args[i], "-") {
+			for _, flag := range cmd.Args[i][1:] {
+				switch flag {
+				case 'a':
+					indexed = true
+				case 'A':
+					assoc = true
+				case 'p':
+					print = true
+				case 'S':
+					secret = true
+				default:
+					return builtinResult(cmd, "", fmt.Errorf("-%c: invalid option", flag))
+				}
+			}
+			continue
+		}
+
+		if assign, ok := parser.ParseAssignment(word); ok {
+			if secret {
+				ee.MarkSecret(assign.Name)
+			}
+			ee.declareVariable(assign.Name, indexed, assoc)
+			if err := ee.executeAssignment(assign); err != nil {
+				return builtinResult(cmd, output.String(), err)
+			}
+			continue
+		}
+
+		name, err := ee.expandString(word)
+		if err != nil {
+			return builtinResult(cmd, output.String(), err)
+		}
+		if secret {
+			ee.MarkSecret(name)
+		}
+		if print {
+			declaration, ok := ee.describeVariable(name)
+			if !ok {
+				return builtinResult(cmd, output.String(), fmt.Errorf("%s: not found", name))
+			}
+			output.WriteString(declaration + "\n")
+			continue
+		}
+		ee.declareVariable(name, indexed, assoc)
+	}
+
+	return builtinResult(cmd, output.String(), nil)
+}
+
+// declareVariable gives a variable the array type requested by declare
+func (ee *ExecutionEngine) declareVariable(name string, indexed, assoc bool) {
+	switch {
+	case assoc:
+		ee.envManager.DeclareAssocArray(name)
+	case indexed:
+		ee.envManager.DeclareArray(name)
+	}
+}
+
+// describeVariable formats a variable the way declare -p prints it, with
+// the values of secret variables masked
+func (ee *ExecutionEngine) describeVariable(name string) (string, bool) {
+	mask := func(value string) string { return value }
+	if ee.IsSecret(name) {
+		mask = func(string) string { return sandbox.SecretMask }
+	}
+	if assoc, ok := ee.envManager.GetAssocArray(name); ok {
+		keys := make([]string, 0, len(assoc))
+		for key := range assoc {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		parts := make([]string, len(keys))
+		for i, key := range keys {
+			parts[i] = fmt.Sprintf("[%s]=%q", key, mask(assoc[key]))
+		}
+		return fmt.Sprintf("declare -A %s=(%s)", name, strings.Join(parts, " ")), true
+	}
+
+	if ee.envManager.IsArray(name) {
+		indices := ee.envManager.GetArrayIndices(name)
+		parts := make([]string, len(indices))
+		for i, index := range indices {
+			value, _ := ee.envManager.GetArrayElement(name, index)
+			parts[i] = fmt.Sprintf("[%d]=%q", index, mask(value))
+		}
+		return fmt.Sprintf("declare -a %s=(%s)", name, strings.Join(parts, " ")), true
+	}
+
+	if value, ok := ee.envManager.GetAllEnv()[name]; ok {
+		return fmt.Sprintf("declare -- %s=%q", name, mask(value)), true
+	}
+	return "", false
+}
+
+// builtinUnset implements unset name... and unset 'arr[index]'
+func (ee *ExecutionEngine) builtinUnset(ctx context.Context, cmd *types.CommandNode) (*CommandResult, error) {
+	for _, arg := range cmd.Args {
+		if arg == "-v" {
+			continue
+		}
+
+		name, subscript, _ := splitSubscript(arg)
+		if name == "" {
+			return builtinResult(cmd, "", fmt.Errorf("`%s': not a valid identifier", arg)), nil
+		}
+		if subscript == "" {
+			ee.envManager.UnsetEnv(name)
+			continue
+		}
+
+		if ee.envManager.IsAssocArray(name) {
+			ee.envManager.UnsetAssocElement(name, subscript)
+			continue
+		}
+		index, err := strconv.Atoi(subscript)
+		if err != nil {
+			index, err = ee.evaluateIndex(subscript)
+			if err != nil {
+				return builtinResult(cmd, "", err), nil
+			}
+		}
+		ee.envManager.UnsetArrayElement(name, index)
+	}
+
+	return builtinResult(cmd, "", nil), nil
+}
+
+// ShellOption reports whether a shopt option is enabled
+func (ee *ExecutionEngine) ShellOption(name string) bool {
+	return ee.options[name]
+}
+
+// SetShellOption enables or disables a shopt option
+func (ee *ExecutionEngine) SetShellOption(name string, enabled bool) error {
+	if !ee.isShellOption(name) {
+		return fmt.Errorf("%s: invalid shell option name", name)
+	}
+	ee.options[name] = enabled
+	return nil
+}
+
+// builtinShopt implements shopt [-s|-u|-q] [optname ...]
+func (ee *ExecutionEngine) builtinShopt(ctx context.Context, cmd *types.CommandNode) (*CommandResult, error) {
+	set, unset, quiet := false, false, false
+	var names []string
+
+	for _, arg := range cmd.Args {
+		switch arg {
+		case "-s":
+			set = true
+		case "-u":
+			unset = true
+		case "-q":
+			quiet = true
+		default:
+			names = append(names, arg)
+		}
+	}
+
+	if set || unset {
+		for _, name := range names {
+			if err := ee.SetShellOption(name, set); err != nil {
+				return builtinResult(cmd, "", err), nil
+			}
+		}
+		return builtinResult(cmd, "", nil), nil
+	}
+
+	explicit := len(names) > 0
+	if !explicit {
+		names = shellOptions
+	}
+
+	var output strings.Builder
+	allEnabled := true
+	for _, name := range names {
+		if !ee.isShellOption(name) {
+			return builtinResult(cmd, output.String(), fmt.Errorf("%s: invalid shell option name", name)), nil
+		}
+		state := "off"
+		if ee.ShellOption(name) {
+			state = "on"
+		} else {
+			allEnabled = false
+		}
+		if !quiet {
+			fmt.Fprintf(&output, "%s\t%s\n", name, state)
+		}
+	}
+
+	result := builtinResult(cmd, output.String(), nil)
+	if explicit && !allEnabled {
+		result.Success = false
+		result.ExitCode = 1
+	}
+	return result, nil
+}
+
+// isShellOption checks if name is a supported shopt option
+func (ee *ExecutionEngine) isShellOption(name string) bool {
+	for _, option := range shellOptions {
+		if option == name {
+			return true
+		}
+	}
+	return false
+}
@@ -2,7 +2,9 @@ package engine
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"strings"
@@ -32,6 +34,29 @@ type ExecutionEngine struct {
 	security    *sandbox.SecurityChecker
 	processPool *ProcessPool
 	cache       *CommandCache
+	options     map[string]bool // shell options set with shopt
+	stdin       io.Reader       // stdin of the running command; os.Stdin unless piped or redirected
+	pendingRead *pendingRead    // input still being read by a timed-out read -t
+	scriptName  string          // $0
+	positional  []string        // positional parameters $1..$n
+	callStack   []sourceFrame   // scripts being executed, outermost first
+	audit       *auditState     // audit log, nil if disabled
+	approver    Approver        // asks about violations in confirm mode, nil to refuse them
+	approvedOnce string         // command line Authorize approved once, for its next execution
+	plan        *Plan           // what a dry run recorded, nil when commands really run
+	transaction *sandbox.Transaction // copy-on-write view commands run against, nil for the real files
+	depth       int             // nesting of Execute calls; results are masked at depth 1
+	pipe        pipePosition    // position of the running command in its pipeline
+	tempFiles   []string        // created by TempFile and TempDir, removed when the script ends
+	status      int             // exit status of the last command, used by exit without an argument
+	exiting     bool            // exit ran; the script stops after the current command
+	secrets     map[string]bool // variables marked secret by declare -S, upper case
+}
+
+// pipePosition is the position of a command in a pipeline
+type pipePosition struct {
+	index int // from 0
+	size  int // number of commands; 0 outside pipelines
 }
 
 // ExecutionResult represents the result of executing an AST
@@ -53,6 +78,11 @@ type CommandResult struct {
 	Error     string
 	Duration  time.Duration
 	Mode      ExecutionMode
+	Violation string // security violation that blocked the command, or that the user approved
+	Approval  string // how the user approved the command in confirm mode: "once", "session" or "always"
+	PID       int    // process of an external command
+	Cached    bool   // output came from the command cache
+	Simulated bool   // recorded by a dry run instead of run
 }
 
 // PipelineResult represents the result of pipeline execution
@@ -78,13 +108,31 @@ func NewExecutionEngine(
 		security:   security,
 		processPool: NewProcessPool(10, 30*time.Second),
 		cache:       NewCommandCache(1000),
+		options:     make(map[string]bool),
+		stdin:       os.Stdin,
+		secrets:     make(map[string]bool),
 	}
 }
 
-// Execute executes a complete script
+// Execute executes a complete script. The values of secret variables are
+// masked in its output and errors, and the temporary files it created are
+// removed when it ends.
 func (ee *ExecutionEngine) Execute(ctx context.Context, script *types.ScriptNode) (*ExecutionResult, error) {
+	ee.depth++
+	result, err := ee.execute(ctx, script)
+	ee.depth--
+	if ee.depth > 0 {
+		return result, err
+	}
+	ee.exiting = false
+	ee.RemoveTempFiles()
+	return ee.redactResult(result, err)
+}
+
+// execute executes the nodes of a script
+func (ee *ExecutionEngine) execute(ctx context.Context, script *types.ScriptNode) (*ExecutionResult, error) {
 	startTime := time.Now()
-	
+
 	result := &ExecutionResult{
 		Commands: make([]*CommandResult, 0, len(script.Nodes)),
 	}
@@ -96,13 +144,7 @@ func (ee *ExecutionEngine) Execute(ctx context.Context, script *types.ScriptNode
 			if err != nil {
 				return nil, err
 			}
-			result.Commands = append(result.Commands, cmdResult)
-			
-			if !cmdResult.Success {
-				result.Success = false
-				result.ExitCode = cmdResult.ExitCode
-				break
-			}
+			result.addCommand(cmdResult)
 
 		case *types.PipeNode:
 			// Execute pipeline
@@ -111,12 +153,9 @@ func (ee *ExecutionEngine) Execute(ctx context.Context, script *types.ScriptNode
 				return nil, err
 			}
 			result.Commands = append(result.Commands, pipeResult.Results...)
-			
-			if !pipeResult.Success {
-				result.Success = false
-				result.ExitCode = pipeResult.ExitCode
-				break
-			}
+			result.Output += pipeResult.Output
+			result.Error += terminateLine(pipeResult.Error)
+			result.ExitCode = pipeResult.ExitCode
 
 		case *types.IfNode:
 			// Execute if-then-else
@@ -124,13 +163,7 @@ func (ee *ExecutionEngine) Execute(ctx context.Context, script *types.ScriptNode
 			if err != nil {
 				return nil, err
 			}
-			result.Commands = append(result.Commands, ifResult.Commands...)
-			
-			if !ifResult.Success {
-				result.Success = false
-				result.ExitCode = ifResult.ExitCode
-				break
-			}
+			result.merge(ifResult)
 
 		case *types.ForNode:
 			// Execute for loop
@@ -138,13 +171,7 @@ func (ee *ExecutionEngine) Execute(ctx context.Context, script *types.ScriptNode
 			if err != nil {
 				return nil, err
 			}
-			result.Commands = append(result.Commands, forResult.Commands...)
-			
-			if !forResult.Success {
-				result.Success = false
-				result.ExitCode = forResult.ExitCode
-				break
-			}
+			result.merge(forResult)
 
 		case *types.WhileNode:
 			// Execute while loop
@@ -152,17 +179,23 @@ func (ee *ExecutionEngine) Execute(ctx context.Context, script *types.ScriptNode
 			if err != nil {
 				return nil, err
 			}
-			result.Commands = append(result.Commands, whileResult.Commands...)
-			
-			if !whileResult.Success {
-				result.Success = false
-				result.ExitCode = whileResult.ExitCode
-				break
+			result.merge(whileResult)
+
+		case *types.ScriptNode:
+			// Execute nested command list
+			listResult, err := ee.Execute(ctx, n)
+			if err != nil {
+				return nil, err
 			}
+			result.merge(listResult)
 
 		case *types.AssignmentNode:
 			// Execute variable assignment
-			ee.envManager.SetEnv(n.Name, n.Value)
+			if err := ee.executeAssignment(n); err != nil {
+				return nil, err
+			}
+			result.ExitCode = 0
+			ee.status = 0
 
 		case *types.FunctionNode:
 			// Store function definition (not executing it)
@@ -171,33 +204,87 @@ func (ee *ExecutionEngine) Execute(ctx context.Context, script *types.ScriptNode
 		default:
 			return nil, fmt.Errorf("unsupported node type: %T", n)
 		}
+
+		// exit stops the script, and every script that encloses it
+		if ee.exiting {
+			break
+		}
 	}
 
+	// Like a shell, the script's status is that of the last command
 	result.Duration = time.Since(startTime)
-	result.Success = true
+	result.Success = result.ExitCode == 0
 	return result, nil
 }
 
+// addCommand records a command result and its output
+func (r *ExecutionResult) addCommand(cmdResult *CommandResult) {
+	r.Commands = append(r.Commands, cmdResult)
+	r.Output += cmdResult.Output
+	r.Error += terminateLine(cmdResult.Error)
+	r.ExitCode = cmdResult.ExitCode
+}
+
+// merge records the results and output of a nested execution
+func (r *ExecutionResult) merge(other *ExecutionResult) {
+	r.Commands = append(r.Commands, other.Commands...)
+	r.Output += other.Output
+	r.Error += terminateLine(other.Error)
+	r.ExitCode = other.ExitCode
+}
+
 // ExecuteCommand executes a single command
 func (ee *ExecutionEngine) ExecuteCommand(ctx context.Context, cmd *types.CommandNode) (*CommandResult, error) {
 	startTime := time.Now()
+	result, err := ee.executeCommand(ctx, cmd)
+	ee.planViolation(cmd, result)
+	ee.auditCommand(cmd, result, err, startTime)
+	if result != nil {
+		ee.status = result.ExitCode
+	}
+	return result, err
+}
+
+// executeCommand executes a single command without auditing it
+func (ee *ExecutionEngine) executeCommand(ctx context.Context, cmd *types.CommandNode) (*CommandResult, error) {
+	startTime := time.Now()
+
+	// Declarations only assign shell variables, so like assignments they
+	// bypass the security checker
+	if ee.isDeclarationBuiltin(cmd.Name) {
+		result := ee.executeDeclaration(cmd)
+		result.Duration = time.Since(startTime)
+		result.Mode = ModeInterpreted
+		return result, nil
+	}
 
-	// Security check
-	if err := ee.security.CheckCommand(cmd); err != nil {
+	// Expand words before the security check so it sees the real arguments
+	// and file list
+	expanded, err := ee.expandCommand(cmd)
+	if err != nil {
 		return &CommandResult{
 			Command:  cmd,
 			Success:  false,
 			ExitCode: 1,
-			Error:    fmt.Sprintf("Security violation: %v", err),
+			Error:    ee.diagnostic(cmd, err.Error()),
 			Duration: time.Since(startTime),
 		}, nil
 	}
+	cmd = expanded
+	if cmd.Name == "" {
+		return &CommandResult{Command: cmd, Success: true, Mode: ModeInterpreted}, nil
+	}
+
+	// Security check, asking the user in confirm mode
+	denied, approval, approved := ee.authorize(cmd, startTime)
+	if denied != nil {
+		return denied, nil
+	}
 
 	// Decide execution mode
 	mode := ee.decideExecutionMode(cmd)
 
 	var result *CommandResult
-	var err error
 
 	switch mode {
 	case ModeInterpreted:
@@ -216,6 +303,10 @@ func (ee *ExecutionEngine) ExecuteCommand(ctx context.Context, cmd *types.Comman
 
 	result.Duration = time.Since(startTime)
 	result.Mode = mode
+	if approval != "" {
+		result.Approval = approval
+		result.Violation = approved
+	}
 	return result, nil
 }
 
@@ -226,10 +317,13 @@ func (ee *ExecutionEngine) ExecutePipeline(ctx context.Context, pipeline *types.
 	results := make([]*CommandResult, 0, len(commands))
 	
 	// Execute commands with piped data flow
+	outer := ee.pipe
+	defer func() { ee.pipe = outer }()
 	var previousOutput string
 	for i, cmd := range commands {
 		var result *CommandResult
 		var err error
+		ee.pipe = pipePosition{index: i, size: len(commands)}
 		
 		if i == 0 {
 			// First command - execute normally
@@ -291,33 +385,71 @@ func (ee *ExecutionEngine) collectPipelineCommands(node types.Node) []*types.Com
 // ExecuteCommandWithInput executes a command with input data
 func (ee *ExecutionEngine) ExecuteCommandWithInput(ctx context.Context, cmd *types.CommandNode, input string) (*CommandResult, error) {
 	startTime := time.Now()
-	
-	// Security check
-	if err := ee.security.CheckCommand(cmd); err != nil {
+	result, err := ee.executeCommandWithInput(ctx, cmd, input)
+	ee.planViolation(cmd, result)
+	ee.auditCommand(cmd, result, err, startTime)
+	return result, err
+}
+
+// executeCommandWithInput executes a command with input data without
+// auditing it
+func (ee *ExecutionEngine) executeCommandWithInput(ctx context.Context, cmd *types.CommandNode, input string) (*CommandResult, error) {
+	startTime := time.Now()
+
+	expanded, err := ee.expandCommand(cmd)
+	if err != nil {
 		return &CommandResult{
 			Command:  cmd,
 			Success:  false,
 			ExitCode: 1,
-			Error:    fmt.Sprintf("Security violation: %v", err),
+			Error:    ee.diagnostic(cmd, err.Error()),
 			Duration: time.Since(startTime),
 		}, nil
 	}
+	cmd = expanded
+
+	// Security check, asking the user in confirm mode
+	denied, approval, approved := ee.authorize(cmd, startTime)
+	if denied != nil {
+		return denied, nil
+	}
 	
-	// Execute process with input
-	result, err := ee.executeProcessWithInput(ctx, cmd, input)
+	// Builtins read the piped input from their stdin stream
+	mode := ModeProcess
+	var result *CommandResult
+	if ee.decideExecutionMode(cmd) == ModeInterpreted {
+		mode = ModeInterpreted
+		restore := ee.setStdin(strings.NewReader(input))
+		result, err = ee.executeInterpreted(ctx, cmd)
+		restore()
+	} else {
+		// Execute process with input
+		result, err = ee.executeProcessWithInput(ctx, cmd, input)
+	}
 	if err != nil {
 		return nil, err
 	}
 	
 	result.Duration = time.Since(startTime)
-	result.Mode = ModeProcess
+	result.Mode = mode
+	if approval != "" {
+		result.Approval = approval
+		result.Violation = approved
+	}
 	return result, nil
 }
 
 // executeProcessWithInput executes a command with stdin input
 func (ee *ExecutionEngine) executeProcessWithInput(ctx context.Context, cmd *types.CommandNode, input string) (*CommandResult, error) {
+	if denied := ee.checkExecutable(cmd); denied != nil {
+		return denied, nil
+	}
+	if ee.plan != nil {
+		return ee.simulateProcess(cmd, true), nil
+	}
+
 	// Create command with context
-	command := exec.CommandContext(ctx, cmd.Name, cmd.Args...)
+	command := ee.newProcess(ctx, cmd)
 	
 	// Set environment
 	envVars := make([]string, 0, len(ee.envManager.GetAllEnv()))
@@ -325,7 +457,12 @@ func (ee *ExecutionEngine) executeProcessWithInput(ctx context.Context, cmd *typ
 		envVars = append(envVars, key+"="+value)
 	}
 	command.Env = envVars
-	command.Dir = ee.envManager.GetWorkingDir()
+	command.Dir = ee.processDir()
+	release, denied := ee.confine(cmd, command)
+	if denied != nil {
+		return denied, nil
+	}
+	defer release()
 	
 	// Set up pipes
 	stdin, err := command.StdinPipe()
@@ -370,13 +507,14 @@ func (ee *ExecutionEngine) executeProcessWithInput(ctx context.Context, cmd *typ
 		ExitCode: exitCode,
 		Output:   stdout.String(),
 		Error:    stderr.String(),
+		PID:      processID(command),
 	}, nil
 }
 
 // decideExecutionMode determines the best execution mode for a command
 func (ee *ExecutionEngine) decideExecutionMode(cmd *types.CommandNode) ExecutionMode {
-	// Check if it's a standard library function
-	if ee.isStdLibFunction(cmd.Name) {
+	// Check if it's a shell builtin or standard library function
+	if ee.isBuiltin(cmd.Name) || ee.isStdLibFunction(cmd.Name) {
 		return ModeInterpreted
 	}
 
@@ -416,13 +554,63 @@ func (ee *ExecutionEngine) isStdLibFunction(funcName string) bool {
 		"WorkingDir": true,
 		"ChangeDir":  true,
 	}
-	return stdlibFunctions[funcName]
+	return stdlibFunctions[funcName] || jsonFunctions[funcName] || textFunctions[funcName] ||
+		httpFunctions[funcName] || fileFunctions[funcName]
 }
 
 // executeInterpreted executes a command using the interpreter (built-in functions)
 func (ee *ExecutionEngine) executeInterpreted(ctx context.Context, cmd *types.CommandNode) (*CommandResult, error) {
-	// Execute using standard library
-	result, err := ee.executeStdLibFunction(cmd.Name, cmd.Args)
+	if cmd.Redirect != nil && cmd.Redirect.Op == "<" {
+		file, err := ee.openInput(cmd.Redirect)
+		if err != nil {
+			return &CommandResult{
+				Command:  cmd,
+				Success:  false,
+				ExitCode: 1,
+				Error:    fmt.Sprintf("redirect error: %v", err),
+			}, nil
+		}
+		defer file.Close()
+		defer ee.setStdin(file)()
+	}
+
+	result, err := ee.interpret(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	if !result.Success && cmd.Name != "source" && cmd.Name != "." {
+		// Errors of a sourced script already carry their own locations,
+		// and what a command that succeeded wrote to stderr is not an error
+		result.Error = ee.diagnostic(cmd, result.Error)
+	}
+
+	if cmd.Redirect != nil {
+		if err := ee.redirectOutput(cmd.Redirect, &result.Output, &result.Error); err != nil {
+			result.Success = false
+			result.ExitCode = 1
+			result.Error += fmt.Sprintf("redirect error: %v", err)
+		}
+	}
+	return result, nil
+}
+
+// interpret runs a builtin or standard library function
+func (ee *ExecutionEngine) interpret(ctx context.Context, cmd *types.CommandNode) (*CommandResult, error) {
+	if builtin, exists := builtins[cmd.Name]; exists {
+		return builtin(ee, ctx, cmd)
+	}
+	if ee.plan != nil {
+		if result, ok := ee.simulateStdLib(cmd); ok {
+			return result, nil
+		}
+	}
+	// Execute using standard library, with what it writes to stderr kept
+	// apart from its output
+	var stderr strings.Builder
+	result, err := ee.executeStdLibFunction(cmd.Name, cmd.Args, &stderr)
+	if err == errNoMatch {
+		return &CommandResult{Command: cmd, Success: false, ExitCode: 1}, nil
+	}
 	if err != nil {
 		return &CommandResult{
 			Command:  cmd,
@@ -437,58 +625,52 @@ func (ee *ExecutionEngine) executeInterpreted(ctx context.Context, cmd *types.Co
 		Success:  true,
 		ExitCode: 0,
 		Output:   result,
+		Error:    stderr.String(),
 	}, nil
 }
 
-// executeStdLibFunction executes a standard library function
-func (ee *ExecutionEngine) executeStdLibFunction(funcName string, args []string) (string, error) {
+// executeStdLibFunction executes a standard library function in the
+// context of the script. What it writes to stderr goes to stderr.
+func (ee *ExecutionEngine) executeStdLibFunction(funcName string, args []string, stderr io.Writer) (string, error) {
+	var stdout strings.Builder
+	scope := ee.scriptContext()
+	scope.Stdout, scope.Stderr = &stdout, stderr
+	lib := ee.stdlib.WithContext(scope)
+
 	switch funcName {
-	case "Print":
-		if len(args) > 0 {
-			ee.stdlib.Print(args[0])
-			return args[0], nil
-		}
-		return "", nil
-	case "Println":
-		if len(args) > 0 {
-			ee.stdlib.Println(args[0])
-			return args[0], nil
-		}
-		ee.stdlib.Println("")
-		return "", nil
-	case "Error":
+	case "Print", "Println", "Error", "Errorln":
+		text := ""
 		if len(args) > 0 {
-			ee.stdlib.Error(args[0])
-			return args[0], nil
+			text = args[0]
 		}
-		return "", nil
-	case "Errorln":
-		if len(args) > 0 {
-			ee.stdlib.Errorln(args[0])
-			return args[0], nil
+		switch funcName {
+		case "Print":
+			lib.Print(text)
+		case "Println":
+			lib.Println(text)
+		case "Error":
+			lib.Error(text)
+		case "Errorln":
+			lib.Errorln(text)
 		}
-		ee.stdlib.Errorln("")
-		return "", nil
+		return stdout.String(), nil
 	case "ReadFile":
 		if len(args) == 0 {
 			return "", fmt.Errorf("ReadFile requires filename argument")
 		}
-		return ee.stdlib.ReadFile(args[0])
+		return lib.ReadFile(args[0])
 	case "WriteFile":
 		if len(args) < 2 {
 			return "", fmt.Errorf("WriteFile requires filename and content arguments")
 		}
-		err := ee.stdlib.WriteFile(args[0], args[1])
+		err := lib.WriteFile(args[0], args[1])
 		return "File written", err
 	case "ListFiles":
-		if len(args) == 0 {
-			files, err := ee.stdlib.ListFiles(".")
-			if err != nil {
-				return "", err
-			}
-			return strings.Join(files, "\n"), nil
+		dir := "."
+		if len(args) > 0 {
+			dir = args[0]
 		}
-		files, err := ee.stdlib.ListFiles(args[0])
+		files, err := lib.ListFiles(dir)
 		if err != nil {
 			return "", err
 		}
@@ -497,7 +679,7 @@ func (ee *ExecutionEngine) executeStdLibFunction(funcName string, args []string)
 		if len(args) == 0 {
 			return "", fmt.Errorf("FileExists requires filename argument")
 		}
-		exists := ee.stdlib.FileExists(args[0])
+		exists := lib.FileExists(args[0])
 		return fmt.Sprintf("%v", exists), nil
 	case "Contains":
 		if len(args) < 2 {
@@ -529,15 +711,15 @@ func (ee *ExecutionEngine) executeStdLibFunction(funcName string, args []string)
 		if len(args) == 0 {
 			return "", fmt.Errorf("GetEnv requires environment variable name")
 		}
-		return ee.stdlib.GetEnv(args[0]), nil
+		return lib.GetEnv(args[0]), nil
 	case "SetEnv":
 		if len(args) < 2 {
 			return "", fmt.Errorf("SetEnv requires key and value arguments")
 		}
-		err := ee.stdlib.SetEnv(args[0], args[1])
+		err := lib.SetEnv(args[0], args[1])
 		return "Environment variable set", err
 	case "WorkingDir":
-		wd, err := ee.stdlib.WorkingDir()
+		wd, err := lib.WorkingDir()
 		if err != nil {
 			return "", err
 		}
@@ -546,24 +728,50 @@ func (ee *ExecutionEngine) executeStdLibFunction(funcName string, args []string)
 		if len(args) == 0 {
 			return "", fmt.Errorf("ChangeDir requires directory path")
 		}
-		err := ee.stdlib.ChangeDir(args[0])
+		err := lib.ChangeDir(args[0])
 		return "Directory changed", err
 	default:
+		if jsonFunctions[funcName] {
+			return ee.executeJSONFunction(funcName, args)
+		}
+		if textFunctions[funcName] {
+			return ee.executeTextFunction(funcName, args)
+		}
+		if httpFunctions[funcName] {
+			return ee.executeHTTPFunction(funcName, args)
+		}
+		if fileFunctions[funcName] {
+			return ee.executeFileFunction(funcName, args)
+		}
 		return "", fmt.Errorf("unknown standard library function: %s", funcName)
 	}
 }
 
 // executeProcess executes a command as an external process
 func (ee *ExecutionEngine) executeProcess(ctx context.Context, cmd *types.CommandNode) (*CommandResult, error) {
-	// Check cache first (only if no redirects)
-	if cmd.Redirect == nil {
+	// Check the binary itself before anything runs, cached or not
+	if denied := ee.checkExecutable(cmd); denied != nil {
+		return denied, nil
+	}
+	if ee.plan != nil {
+		return ee.simulateProcess(cmd, ee.hasRedirectedStdin()), nil
+	}
+
+	// Check cache first (only if no redirects and stdin is not piped in)
+	cacheable := cmd.Redirect == nil && !ee.hasRedirectedStdin()
+	if cacheable {
 		if cached, ok := ee.cache.Get(cmd.Name, cmd.Args); ok {
-			return cached, nil
+			// A copy, so callers do not mark the cached entry
+			result := *cached
+			result.Command = cmd
+			result.PID = 0
+			result.Cached = true
+			return &result, nil
 		}
 	}
 
 	// Create command with context
-	command := exec.CommandContext(ctx, cmd.Name, cmd.Args...)
+	command := ee.newProcess(ctx, cmd)
 
 	// Set environment - convert map[string]string to []string
 	envVars := make([]string, 0, len(ee.envManager.GetAllEnv()))
@@ -573,12 +781,24 @@ func (ee *ExecutionEngine) executeProcess(ctx context.Context, cmd *types.Comman
 	command.Env = envVars
 
 	// Set working directory
-	command.Dir = ee.envManager.GetWorkingDir()
+	command.Dir = ee.processDir()
+
+	// Run in the kernel sandbox when the policy enforces one
+	release, denied := ee.confine(cmd, command)
+	if denied != nil {
+		return denied, nil
+	}
+	defer release()
+
+	// Like a shell's children, the process inherits the current stdin:
+	// shode's own, or that of a redirected loop or pipeline
+	command.Stdin = ee.stdin
 
 	// Handle redirections
 	var stdout, stderr strings.Builder
 	if cmd.Redirect != nil {
-		if err := ee.setupRedirect(command, cmd.Redirect, &stdout, &stderr); err != nil {
+		file, err := ee.setupRedirect(command, cmd.Redirect, &stdout, &stderr)
+		if err != nil {
 			return &CommandResult{
 				Command:  cmd,
 				Success:  false,
@@ -586,6 +806,9 @@ func (ee *ExecutionEngine) executeProcess(ctx context.Context, cmd *types.Comman
 				Error:    fmt.Sprintf("redirect error: %v", err),
 			}, nil
 		}
+		if file != nil {
+			defer file.Close()
+		}
 	} else {
 		// No redirect - capture output
 		command.Stdout = &stdout
@@ -602,8 +825,12 @@ func (ee *ExecutionEngine) executeProcess(ctx context.Context, cmd *types.Comman
 	if err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
 			exitCode = exitErr.ExitCode()
+		} else if errors.Is(err, exec.ErrNotFound) {
+			exitCode = 127
+			stderr.WriteString(ee.diagnostic(cmd, cmd.Name+": command not found"))
 		} else {
 			exitCode = 1
+			stderr.WriteString(ee.diagnostic(cmd, err.Error()))
 		}
 	}
 
@@ -614,72 +841,17 @@ func (ee *ExecutionEngine) executeProcess(ctx context.Context, cmd *types.Comman
 		Output:   stdout.String(),
 		Error:    stderr.String(),
 		Duration: duration,
+		PID:      processID(command),
 	}
 
 	// Cache successful results (only if no redirects)
-	if err == nil && cmd.Redirect == nil {
+	if err == nil && cacheable {
 		ee.cache.Put(cmd.Name, cmd.Args, result)
 	}
 
 	return result, nil
 }
 
-// setupRedirect sets up input/output redirection for a command
-func (ee *ExecutionEngine) setupRedirect(cmd *exec.Cmd, redirect *types.RedirectNode, stdout, stderr *strings.Builder) error {
-	switch redirect.Op {
-	case ">": // Output redirection (overwrite)
-		file, err := os.Create(redirect.File)
-		if err != nil {
-			return fmt.Errorf("failed to create file %s: %v", redirect.File, err)
-		}
-		defer file.Close()
-		
-		if redirect.Fd == 1 || redirect.Fd == 0 { // stdout
-			cmd.Stdout = file
-		} else if redirect.Fd == 2 { // stderr
-			cmd.Stderr = file
-		}
-		
-	case ">>": // Output redirection (append)
-		file, err := os.OpenFile(redirect.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-		if err != nil {
-			return fmt.Errorf("failed to open file %s: %v", redirect.File, err)
-		}
-		defer file.Close()
-		
-		if redirect.Fd == 1 || redirect.Fd == 0 {
-			cmd.Stdout = file
-		} else if redirect.Fd == 2 {
-			cmd.Stderr = file
-		}
-		
-	case "<": // Input redirection
-		file, err := os.Open(redirect.File)
-		if err != nil {
-			return fmt.Errorf("failed to open file %s: %v", redirect.File, err)
-		}
-		defer file.Close()
-		cmd.Stdin = file
-		
-	case "2>&1": // Redirect stderr to stdout
-		cmd.Stderr = cmd.Stdout
-		
-	case "&>": // Redirect both stdout and stderr to file
-		file, err := os.Create(redirect.File)
-		if err != nil {
-			return fmt.Errorf("failed to create file %s: %v", redirect.File, err)
-		}
-		defer file.Close()
-		cmd.Stdout = file
-		cmd.Stderr = file
-		
-	default:
-		return fmt.Errorf("unsupported redirect operator: %s", redirect.Op)
-	}
-	
-	return nil
-}
-
 // executeHybrid executes a command using hybrid approach (future enhancement)
 func (ee *ExecutionEngine) executeHybrid(ctx context.Context, cmd *types.CommandNode) (*CommandResult, error) {
 	// For now, default to process execution
@@ -687,10 +859,77 @@ func (ee *ExecutionEngine) executeHybrid(ctx context.Context, cmd *types.Command
 	return ee.executeProcess(ctx, cmd)
 }
 
+// checkExecutable applies the allowlist and hash pins to the binary an
+// external command resolves to. It returns nil if the command may run.
+func (ee *ExecutionEngine) checkExecutable(cmd *types.CommandNode) *CommandResult {
+	path, ok := ee.executablePath(cmd.Name)
+	if !ok {
+		// Not found; executeProcess reports it
+		return nil
+	}
+
+	if err := ee.security.CheckExecutable(cmd.Name, path); err != nil {
+		return &CommandResult{
+			Command:   cmd,
+			Success:   false,
+			ExitCode:  1,
+			Error:     ee.diagnostic(cmd, err.Error()),
+			Violation: err.Error(),
+		}
+	}
+	return nil
+}
+
+// executablePath returns the binary an external command resolves to
+func (ee *ExecutionEngine) executablePath(name string) (string, bool) {
+	if strings.Contains(name, "/") {
+		return ee.resolvePath(name), true
+	}
+	// The script's PATH, as the security checker sees it
+	resolved, err := sandbox.LookPath(name, ee.envManager.GetEnv("PATH"), ee.envManager.GetWorkingDir())
+	return resolved, err == nil
+}
+
+// newProcess creates the process of an external command, running the binary
+// executablePath resolves it to
+func (ee *ExecutionEngine) newProcess(ctx context.Context, cmd *types.CommandNode) *exec.Cmd {
+	command := exec.CommandContext(ctx, cmd.Name, cmd.Args...)
+	if path, ok := ee.executablePath(cmd.Name); ok {
+		command.Path = path
+		command.Err = nil
+	}
+	return command
+}
+
+// processID returns the process ID of a command that was started, or 0
+func processID(command *exec.Cmd) int {
+	if command.Process == nil {
+		return 0
+	}
+	return command.Process.Pid
+}
+
+// confine sets up the kernel sandbox and network policy for a process. It
+// returns the function to call once the process has finished, and a result
+// if the process may not start.
+func (ee *ExecutionEngine) confine(cmd *types.CommandNode, command *exec.Cmd) (func(), *CommandResult) {
+	release, err := ee.security.Confine(command)
+	if err != nil {
+		return release, &CommandResult{
+			Command:   cmd,
+			Success:   false,
+			ExitCode:  126,
+			Error:     ee.diagnostic(cmd, fmt.Sprintf("Sandbox error: %v", err)),
+			Violation: fmt.Sprintf("sandbox: %v", err),
+		}
+	}
+	return release, nil
+}
+
 // isExternalCommandAvailable checks if an external command exists
 func (ee *ExecutionEngine) isExternalCommandAvailable(cmd string) bool {
-	_, err := exec.LookPath(cmd)
-	return err == nil
+	_, ok := ee.executablePath(cmd)
+	return ok
 }
 
 // ExecuteIf executes an if-then-else statement
@@ -700,116 +939,146 @@ func (ee *ExecutionEngine) ExecuteIf(ctx context.Context, ifNode *types.IfNode)
 	if err != nil {
 		return nil, err
 	}
-	
-	// Execute appropriate branch
-	if conditionResult {
-		return ee.Execute(ctx, ifNode.Then)
-	} else if ifNode.Else != nil {
-		return ee.Execute(ctx, ifNode.Else)
+
+	result := &ExecutionResult{Commands: make([]*CommandResult, 0)}
+	result.merge(conditionResult)
+	if ee.exiting {
+		result.Success = result.ExitCode == 0
+		return result, nil
 	}
-	
-	// No else branch and condition was false
-	return &ExecutionResult{
-		Success:  true,
-		ExitCode: 0,
-		Commands: []*CommandResult{},
-	}, nil
-}
+	result.ExitCode = 0
 
-// ExecuteFor executes a for loop
-func (ee *ExecutionEngine) ExecuteFor(ctx context.Context, forNode *types.ForNode) (*ExecutionResult, error) {
-	result := &ExecutionResult{
-		Commands: make([]*CommandResult, 0),
+	// Execute appropriate branch
+	var branch *types.ScriptNode
+	if conditionResult.Success {
+		branch = ifNode.Then
+	} else {
+		branch = ifNode.Else
 	}
-	
-	// Iterate over the list
-	for _, item := range forNode.List {
-		// Set loop variable
-		ee.envManager.SetEnv(forNode.Variable, item)
-		
-		// Execute loop body
-		loopResult, err := ee.Execute(ctx, forNode.Body)
+
+	if branch != nil {
+		branchResult, err := ee.Execute(ctx, branch)
 		if err != nil {
 			return nil, err
 		}
-		
-		result.Commands = append(result.Commands, loopResult.Commands...)
-		
-		// Check for break/continue (TODO: implement break/continue support)
-		if !loopResult.Success {
-			result.Success = false
-			result.ExitCode = loopResult.ExitCode
-			return result, nil
-		}
+		result.merge(branchResult)
 	}
-	
-	result.Success = true
-	result.ExitCode = 0
+
+	result.Success = result.ExitCode == 0
 	return result, nil
 }
 
-// ExecuteWhile executes a while loop
-func (ee *ExecutionEngine) ExecuteWhile(ctx context.Context, whileNode *types.WhileNode) (*ExecutionResult, error) {
-	result := &ExecutionResult{
-		Commands: make([]*CommandResult, 0),
-	}
-	
-	maxIterations := 10000 // Safety limit to prevent infinite loops
-	iterations := 0
-	
-	for {
-		// Check iteration limit
-		if iterations >= maxIterations {
-			return nil, fmt.Errorf("while loop exceeded maximum iterations (%d)", maxIterations)
+// ExecuteFor executes a for loop
+func (ee *ExecutionEngine) ExecuteFor(ctx context.Context, forNode *types.ForNode) (*ExecutionResult, error) {
+	return ee.withLoopRedirect(forNode.Redirect, func() (*ExecutionResult, error) {
+		result := &ExecutionResult{
+			Commands: make([]*CommandResult, 0),
 		}
-		iterations++
-		
-		// Evaluate condition
-		conditionResult, err := ee.evaluateCondition(ctx, whileNode.Condition)
+
+		items, err := ee.expandWords(forNode.List)
 		if err != nil {
-			return nil, err
+			result.Success = false
+			result.ExitCode = 1
+			result.Error = err.Error()
+			return result, nil
 		}
-		
-		// Exit loop if condition is false
-		if !conditionResult {
-			break
+
+		// Iterate over the expanded list
+		for _, item := range items {
+			// Set loop variable
+			ee.envManager.SetEnv(forNode.Variable, item)
+
+			// Execute loop body
+			loopResult, err := ee.Execute(ctx, forNode.Body)
+			if err != nil {
+				return nil, err
+			}
+
+			// Check for break/continue (TODO: implement break/continue support)
+			result.merge(loopResult)
+			if ee.exiting {
+				break
+			}
 		}
-		
-		// Execute loop body
-		loopResult, err := ee.Execute(ctx, whileNode.Body)
-		if err != nil {
-			return nil, err
+
+		result.Success = result.ExitCode == 0
+		return result, nil
+	})
+}
+
+// ExecuteWhile executes a while loop
+func (ee *ExecutionEngine) ExecuteWhile(ctx context.Context, whileNode *types.WhileNode) (*ExecutionResult, error) {
+	return ee.withLoopRedirect(whileNode.Redirect, func() (*ExecutionResult, error) {
+		result := &ExecutionResult{
+			Commands: make([]*CommandResult, 0),
 		}
-		
-		result.Commands = append(result.Commands, loopResult.Commands...)
-		
-		// Check for errors
-		if !loopResult.Success {
-			result.Success = false
-			result.ExitCode = loopResult.ExitCode
-			return result, nil
+
+		maxIterations := 10000 // Safety limit to prevent infinite loops
+		iterations := 0
+		exitCode := 0
+
+		for {
+			// Check iteration limit
+			if iterations >= maxIterations {
+				return nil, fmt.Errorf("while loop exceeded maximum iterations (%d)", maxIterations)
+			}
+			iterations++
+
+			// Evaluate condition
+			conditionResult, err := ee.evaluateCondition(ctx, whileNode.Condition)
+			if err != nil {
+				return nil, err
+			}
+			result.merge(conditionResult)
+			if ee.exiting {
+				exitCode = conditionResult.ExitCode
+				break
+			}
+
+			// Exit loop if condition is false
+			if !conditionResult.Success {
+				break
+			}
+
+			// Execute loop body
+			loopResult, err := ee.Execute(ctx, whileNode.Body)
+			if err != nil {
+				return nil, err
+			}
+			result.merge(loopResult)
+			exitCode = loopResult.ExitCode
+			if ee.exiting {
+				break
+			}
 		}
-	}
-	
-	result.Success = true
-	result.ExitCode = 0
-	return result, nil
+
+		// The loop's status is that of the last body command, not the condition
+		result.ExitCode = exitCode
+		result.Success = exitCode == 0
+		return result, nil
+	})
 }
 
-// evaluateCondition evaluates a condition node and returns true/false
-func (ee *ExecutionEngine) evaluateCondition(ctx context.Context, condition types.Node) (bool, error) {
+// evaluateCondition evaluates a condition node; it holds when the
+// condition's exit status is zero
+func (ee *ExecutionEngine) evaluateCondition(ctx context.Context, condition types.Node) (*ExecutionResult, error) {
 	switch n := condition.(type) {
-	case *types.CommandNode:
-		// Execute command and check exit code
-		cmdResult, err := ee.ExecuteCommand(ctx, n)
-		if err != nil {
-			return false, err
-		}
-		return cmdResult.Success && cmdResult.ExitCode == 0, nil
-		
+	case *types.ScriptNode:
+		return ee.Execute(ctx, n)
+	case *types.CommandNode, *types.PipeNode, *types.IfNode, *types.ForNode, *types.WhileNode:
+		return ee.Execute(ctx, &types.ScriptNode{Pos: n.Position(), Nodes: []types.Node{n}})
 	default:
-		return false, fmt.Errorf("unsupported condition node type: %T", n)
+		return nil, fmt.Errorf("unsupported condition node type: %T", n)
+	}
+}
+
+// terminateLine ends non-empty diagnostic text with a newline, so messages
+// from consecutive commands stay on separate lines
+func terminateLine(s string) string {
+	if s == "" || strings.HasSuffix(s, "\n") {
+		return s
 	}
+	return s + "\n"
 }
 
 // Helper function to convert error to string
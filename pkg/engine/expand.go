@@ -0,0 +1,688 @@
+package engine
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gitee.com/com_818cloud/shode/pkg/types"
+)
+
+// arrayElement matches a [key]=value element of a compound assignment
+var arrayElement = regexp.MustCompile(`^\[([^\]]*)\]=(.*)$`)
+
+// parameterName matches the leading variable name of a ${...} expansion
+var parameterName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*`)
+
+// expandedField is a field produced by word expansion. pattern holds the
+// field with quoted glob characters escaped, for pathname expansion.
+type expandedField struct {
+	value   string
+	pattern string
+	glob    bool // contains unquoted glob characters
+}
+
+// fieldBuilder accumulates the fields produced by expanding a word
+type fieldBuilder struct {
+	fields  []expandedField
+	current strings.Builder
+	pattern strings.Builder
+	glob    bool
+	valid   bool // current field exists even if empty (e.g. "")
+	sawList bool // an array list expansion occurred inside the current quotes
+	split   bool // unquoted expansions are split into fields
+	ifs     string
+	err     error // first expansion that failed, e.g. a bad substitution
+}
+
+// fail records an expansion error; the first one is reported
+func (fb *fieldBuilder) fail(err error) {
+	if fb.err == nil {
+		fb.err = err
+	}
+}
+
+// appendText appends text to the current field; unquoted text keeps its
+// glob characters active
+func (fb *fieldBuilder) appendText(text string, quoted bool) {
+	fb.current.WriteString(text)
+	if quoted {
+		fb.pattern.WriteString(escapeGlob(text))
+	} else {
+		fb.pattern.WriteString(text)
+		fb.glob = fb.glob || strings.ContainsAny(text, "*?[")
+	}
+	fb.valid = true
+}
+
+// appendExpansion appends the result of an expansion, splitting it on IFS
+// when it appeared unquoted
+func (fb *fieldBuilder) appendExpansion(value string, quoted bool) {
+	if quoted || !fb.split {
+		fb.appendText(value, quoted)
+		return
+	}
+	if value == "" {
+		return
+	}
+
+	isSep := func(r rune) bool { return strings.ContainsRune(fb.ifs, r) }
+	if isSep(rune(value[0])) {
+		fb.breakField()
+	}
+	words := strings.FieldsFunc(value, isSep)
+	for i, word := range words {
+		if i > 0 {
+			fb.breakField()
+		}
+		fb.appendText(word, false)
+	}
+	if isSep(rune(value[len(value)-1])) {
+		fb.breakField()
+	}
+}
+
+// appendList appends the elements of an array expansion such as ${arr[@]};
+// quoted, each element becomes its own field
+func (fb *fieldBuilder) appendList(values []string, quoted bool) {
+	fb.sawList = fb.sawList || quoted
+	if !fb.split {
+		fb.appendText(strings.Join(values, " "), quoted)
+		return
+	}
+	for i, value := range values {
+		if i > 0 {
+			if quoted {
+				fb.breakField()
+			} else {
+				fb.appendExpansion(" ", false)
+			}
+		}
+		fb.appendExpansion(value, quoted)
+	}
+}
+
+// breakField terminates the current field
+func (fb *fieldBuilder) breakField() {
+	if fb.valid {
+		fb.fields = append(fb.fields, expandedField{
+			value:   fb.current.String(),
+			pattern: fb.pattern.String(),
+			glob:    fb.glob,
+		})
+	}
+	fb.current.Reset()
+	fb.pattern.Reset()
+	fb.glob = false
+	fb.valid = false
+}
+
+// expandCommand returns a copy of cmd with its name, arguments and
+// redirection target expanded
+func (ee *ExecutionEngine) expandCommand(cmd *types.CommandNode) (*types.CommandNode, error) {
+	words := cmd.RawArgs
+	if len(words) != len(cmd.Args) {
+		words = cmd.Args
+	}
+
+	fields, err := ee.expandWords(append([]string{cmd.Name}, words...))
+	if err != nil {
+		return nil, err
+	}
+
+	expanded := &types.CommandNode{Pos: cmd.Pos, Origin: cmd}
+	if len(fields) > 0 {
+		expanded.Name = fields[0]
+		expanded.Args = fields[1:]
+	}
+	if cmd.Redirect != nil {
+		redirect := *cmd.Redirect
+		if redirect.File, err = ee.expandString(redirect.File); err != nil {
+			return nil, err
+		}
+		expanded.Redirect = &redirect
+	}
+	return expanded, nil
+}
+
+// expandWords expands raw shell words into fields
+func (ee *ExecutionEngine) expandWords(words []string) ([]string, error) {
+	fields := []string{}
+	for _, word := range words {
+		expanded, err := ee.expandWord(word)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, expanded...)
+	}
+	return fields, nil
+}
+
+// expandWord performs brace expansion, parameter expansion, word splitting,
+// pathname expansion and quote removal on a single raw word
+func (ee *ExecutionEngine) expandWord(word string) ([]string, error) {
+	fields := []string{}
+	for _, braced := range expandBraces(word) {
+		fb := &fieldBuilder{split: true, ifs: ee.ifs()}
+		ee.expandInto(fb, braced)
+		fb.breakField()
+		if fb.err != nil {
+			return nil, fb.err
+		}
+
+		for _, field := range fb.fields {
+			if !field.glob {
+				fields = append(fields, field.value)
+				continue
+			}
+			matches, err := ee.expandPathname(field)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, matches...)
+		}
+	}
+	return fields, nil
+}
+
+// expandString expands a raw word without field splitting, as for the
+// right-hand side of an assignment
+func (ee *ExecutionEngine) expandString(word string) (string, error) {
+	fb := &fieldBuilder{split: false}
+	ee.expandInto(fb, word)
+	return fb.current.String(), fb.err
+}
+
+// ifs returns the field separators used for word splitting
+func (ee *ExecutionEngine) ifs() string {
+	if ifs, ok := ee.envManager.GetAllEnv()["IFS"]; ok {
+		return ifs
+	}
+	return " \t\n"
+}
+
+// expandInto scans a raw word and feeds literals and expansions to fb
+func (ee *ExecutionEngine) expandInto(fb *fieldBuilder, word string) {
+	inDouble := false
+
+	for i := 0; i < len(word); i++ {
+		c := word[i]
+		switch {
+		case c == '\'' && !inDouble:
+			end := strings.IndexByte(word[i+1:], '\'')
+			if end < 0 {
+				end = len(word) - i - 1
+			}
+			fb.appendText(word[i+1:i+1+end], true)
+			i += end + 1
+
+		case c == '"':
+			if !inDouble {
+				fb.sawList = false
+			} else if !fb.sawList {
+				// "" produces an empty field, "${empty[@]}" produces none
+				fb.valid = true
+			}
+			inDouble = !inDouble
+
+		case c == '\\' && i+1 < len(word):
+			if inDouble && strings.IndexByte("$`\"\\\n", word[i+1]) < 0 {
+				fb.appendText("\\", true)
+			}
+			i++
+			fb.appendText(word[i:i+1], true)
+
+		case c == '$' && i+1 < len(word):
+			consumed := ee.expandParameter(fb, word[i+1:], inDouble)
+			if consumed == 0 {
+				fb.appendText("$", inDouble)
+			}
+			i += consumed
+
+		default:
+			fb.appendText(word[i:i+1], inDouble)
+		}
+	}
+}
+
+// expandParameter expands the parameter following a '$' and returns the
+// number of bytes consumed
+func (ee *ExecutionEngine) expandParameter(fb *fieldBuilder, rest string, quoted bool) int {
+	switch {
+	case rest[0] == '{':
+		end := matchingBrace(rest)
+		if end < 0 {
+			return 0
+		}
+		ee.expandBraced(fb, rest[1:end], quoted)
+		return end + 1
+
+	case rest[0] == '(':
+		// Command and arithmetic substitution are left as written
+		return 0
+
+	case rest[0] >= '0' && rest[0] <= '9':
+		// $0..$9; longer positions need braces
+		fb.appendExpansion(ee.positionalParam(int(rest[0]-'0')), quoted)
+		return 1
+
+	case rest[0] == '#':
+		fb.appendExpansion(strconv.Itoa(len(ee.positional)), quoted)
+		return 1
+
+	case rest[0] == '@' || rest[0] == '*':
+		ee.appendArray(fb, ee.positional, rest[:1], quoted)
+		return 1
+
+	default:
+		name := parameterName.FindString(rest)
+		if name == "" {
+			return 0
+		}
+		fb.appendExpansion(ee.lookupScalar(name), quoted)
+		return len(name)
+	}
+}
+
+// matchingBrace returns the index of the '}' closing the '{' at s[0]
+func matchingBrace(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// expandBraced expands the body of a ${...} expansion. Operators it does
+// not implement, e.g. ${x:-default} or ${x%.sh}, are reported as a bad
+// substitution rather than expanded to the wrong value.
+func (ee *ExecutionEngine) expandBraced(fb *fieldBuilder, expr string, quoted bool) {
+	badSubstitution := func() {
+		fb.fail(fmt.Errorf("${%s}: bad substitution", expr))
+	}
+	// slice applies an offset[:length] suffix to a list
+	slice := func(values []string, suffix string) []string {
+		spec, err := ee.expandString(suffix[1:])
+		if err != nil {
+			fb.fail(err)
+		}
+		return sliceValues(values, spec)
+	}
+
+	switch {
+	case expr == "#" || expr == "#@" || expr == "#*":
+		// ${#} and ${#@} count the positional parameters
+		fb.appendExpansion(strconv.Itoa(len(ee.positional)), quoted)
+
+	case expr != "" && expr[0] >= '0' && expr[0] <= '9':
+		// ${10} and beyond
+		n, err := strconv.Atoi(expr)
+		if err != nil {
+			badSubstitution()
+			return
+		}
+		fb.appendExpansion(ee.positionalParam(n), quoted)
+
+	case strings.HasPrefix(expr, "@") || strings.HasPrefix(expr, "*"):
+		// ${@:offset:length} slices count $0 as position 0
+		values := ee.positional
+		switch suffix := expr[1:]; {
+		case strings.HasPrefix(suffix, ":"):
+			all := append([]string{ee.scriptName}, ee.positional...)
+			values = slice(all, suffix)
+		case suffix != "":
+			badSubstitution()
+			return
+		}
+		ee.appendArray(fb, values, expr[:1], quoted)
+
+	case strings.HasPrefix(expr, "#") && len(expr) > 1:
+		// ${#name}, ${#arr[@]}, ${#arr[i]}
+		name, subscript, suffix := splitSubscript(expr[1:])
+		if name == "" || suffix != "" {
+			badSubstitution()
+			return
+		}
+		if subscript == "@" || subscript == "*" {
+			fb.appendExpansion(strconv.Itoa(len(ee.arrayValues(name))), quoted)
+			return
+		}
+		value, err := ee.lookupElement(name, subscript)
+		if err != nil {
+			fb.fail(err)
+			return
+		}
+		fb.appendExpansion(strconv.Itoa(len(value)), quoted)
+
+	case strings.HasPrefix(expr, "!"):
+		// ${!arr[@]} lists the indices or keys of an array
+		name, subscript, suffix := splitSubscript(expr[1:])
+		if name == "" || suffix != "" {
+			badSubstitution()
+			return
+		}
+		if subscript != "@" && subscript != "*" {
+			fb.appendExpansion(ee.lookupScalar(ee.lookupScalar(name)), quoted)
+			return
+		}
+		ee.appendArray(fb, ee.arrayKeys(name), subscript, quoted)
+
+	default:
+		name, subscript, suffix := splitSubscript(expr)
+		if name == "" {
+			badSubstitution()
+			return
+		}
+		if subscript == "@" || subscript == "*" {
+			values := ee.arrayValues(name)
+			switch {
+			case strings.HasPrefix(suffix, ":"):
+				values = slice(values, suffix)
+			case suffix != "":
+				badSubstitution()
+				return
+			}
+			ee.appendArray(fb, values, subscript, quoted)
+			return
+		}
+		if suffix != "" {
+			badSubstitution()
+			return
+		}
+		value, err := ee.lookupElement(name, subscript)
+		if err != nil {
+			fb.fail(err)
+			return
+		}
+		fb.appendExpansion(value, quoted)
+	}
+}
+
+// appendArray appends a list, joining it into one field for [*] in quotes
+func (ee *ExecutionEngine) appendArray(fb *fieldBuilder, values []string, subscript string, quoted bool) {
+	if subscript == "*" && quoted {
+		sep := ""
+		if ifs := ee.ifs(); ifs != "" {
+			sep = ifs[:1]
+		}
+		fb.appendText(strings.Join(values, sep), true)
+		return
+	}
+	fb.appendList(values, quoted)
+}
+
+// splitSubscript splits "name[sub]rest" into its parts
+func splitSubscript(expr string) (name, subscript, rest string) {
+	name = parameterName.FindString(expr)
+	rest = expr[len(name):]
+	if strings.HasPrefix(rest, "[") {
+		if end := strings.IndexByte(rest, ']'); end > 0 {
+			return name, rest[1:end], rest[end+1:]
+		}
+	}
+	return name, "", rest
+}
+
+// sliceValues applies an offset[:length] slice to a list of values
+func sliceValues(values []string, spec string) []string {
+	parts := strings.SplitN(spec, ":", 2)
+
+	offset, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		offset = 0
+	}
+	if offset < 0 {
+		offset += len(values)
+	}
+	if offset < 0 || offset > len(values) {
+		return nil
+	}
+	values = values[offset:]
+
+	if len(parts) == 2 {
+		length, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil
+		}
+		if length < 0 {
+			length += len(values)
+		}
+		if length < 0 {
+			return nil
+		}
+		if length < len(values) {
+			values = values[:length]
+		}
+	}
+	return values
+}
+
+// lookupScalar returns the value of a variable; for arrays this is element 0
+func (ee *ExecutionEngine) lookupScalar(name string) string {
+	if ee.envManager.IsAssocArray(name) {
+		value, _ := ee.envManager.GetAssocElement(name, "0")
+		return value
+	}
+	if ee.envManager.IsArray(name) {
+		value, _ := ee.envManager.GetArrayElement(name, 0)
+		return value
+	}
+	return ee.envManager.GetEnv(name)
+}
+
+// lookupElement returns a single array element by subscript
+func (ee *ExecutionEngine) lookupElement(name, subscript string) (string, error) {
+	if subscript == "" {
+		return ee.lookupScalar(name), nil
+	}
+	if ee.envManager.IsAssocArray(name) {
+		key, err := ee.expandString(subscript)
+		if err != nil {
+			return "", err
+		}
+		value, _ := ee.envManager.GetAssocElement(name, key)
+		return value, nil
+	}
+
+	index, err := ee.evaluateIndex(subscript)
+	if err != nil {
+		return "", err
+	}
+	if index < 0 {
+		indices := ee.envManager.GetArrayIndices(name)
+		if len(indices) == 0 {
+			return "", nil
+		}
+		index += indices[len(indices)-1] + 1
+	}
+	if !ee.envManager.IsArray(name) {
+		if index == 0 {
+			return ee.envManager.GetEnv(name), nil
+		}
+		return "", nil
+	}
+	value, _ := ee.envManager.GetArrayElement(name, index)
+	return value, nil
+}
+
+// arrayValues returns all values of an array, or the scalar value as a
+// one-element list
+func (ee *ExecutionEngine) arrayValues(name string) []string {
+	if assoc, ok := ee.envManager.GetAssocArray(name); ok {
+		values := make([]string, 0, len(assoc))
+		for _, key := range ee.envManager.GetAssocKeys(name) {
+			values = append(values, assoc[key])
+		}
+		return values
+	}
+	if values, ok := ee.envManager.GetArray(name); ok {
+		return values
+	}
+	if value, ok := ee.envManager.GetAllEnv()[name]; ok {
+		return []string{value}
+	}
+	return nil
+}
+
+// arrayKeys returns the indices or keys of an array
+func (ee *ExecutionEngine) arrayKeys(name string) []string {
+	if ee.envManager.IsAssocArray(name) {
+		return ee.envManager.GetAssocKeys(name)
+	}
+	if ee.envManager.IsArray(name) {
+		indices := ee.envManager.GetArrayIndices(name)
+		keys := make([]string, len(indices))
+		for i, index := range indices {
+			keys[i] = strconv.Itoa(index)
+		}
+		return keys
+	}
+	if _, ok := ee.envManager.GetAllEnv()[name]; ok {
+		return []string{"0"}
+	}
+	return nil
+}
+
+// evaluateIndex evaluates an indexed array subscript: an integer, $var or
+// a bare variable name
+func (ee *ExecutionEngine) evaluateIndex(subscript string) (int, error) {
+	value, err := ee.expandString(subscript)
+	if err != nil {
+		return 0, err
+	}
+	value = strings.TrimSpace(value)
+	if identifierPattern.MatchString(value) {
+		value = strings.TrimSpace(ee.lookupScalar(value))
+	}
+	if value == "" {
+		return 0, nil
+	}
+	index, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid array index: %s", subscript)
+	}
+	return index, nil
+}
+
+// identifierPattern matches a valid shell variable name
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// executeAssignment performs a scalar, element or compound assignment
+func (ee *ExecutionEngine) executeAssignment(assign *types.AssignmentNode) error {
+	name := assign.Name
+
+	if assign.IsArray {
+		return ee.assignCompound(assign)
+	}
+
+	value, err := ee.expandString(assign.Value)
+	if err != nil {
+		return err
+	}
+
+	if assign.Index != "" {
+		if ee.envManager.IsAssocArray(name) {
+			key, err := ee.expandString(assign.Index)
+			if err != nil {
+				return err
+			}
+			if assign.Append {
+				current, _ := ee.envManager.GetAssocElement(name, key)
+				value = current + value
+			}
+			ee.envManager.SetAssocElement(name, key, value)
+			return nil
+		}
+
+		index, err := ee.evaluateIndex(assign.Index)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		if assign.Append {
+			current, _ := ee.envManager.GetArrayElement(name, index)
+			value = current + value
+		}
+		ee.envManager.SetArrayElement(name, index, value)
+		return nil
+	}
+
+	if assign.Append {
+		value = ee.lookupScalar(name) + value
+	}
+
+	ee.assignScalar(name, value)
+	return nil
+}
+
+// assignScalar sets a variable; for arrays it sets element 0, as bash does
+func (ee *ExecutionEngine) assignScalar(name, value string) {
+	switch {
+	case ee.envManager.IsAssocArray(name):
+		ee.envManager.SetAssocElement(name, "0", value)
+	case ee.envManager.IsArray(name):
+		ee.envManager.SetArrayElement(name, 0, value)
+	default:
+		ee.envManager.SetEnv(name, value)
+	}
+}
+
+// assignCompound performs name=(...) and name+=(...) assignments
+func (ee *ExecutionEngine) assignCompound(assign *types.AssignmentNode) error {
+	name := assign.Name
+
+	if ee.envManager.IsAssocArray(name) {
+		if !assign.Append {
+			ee.envManager.UnsetEnv(name)
+			ee.envManager.DeclareAssocArray(name)
+		}
+		for _, element := range assign.Elements {
+			match := arrayElement.FindStringSubmatch(element)
+			if match == nil {
+				return fmt.Errorf("%s: %s: must use subscript when assigning associative array", name, element)
+			}
+			key, err := ee.expandString(match[1])
+			if err != nil {
+				return err
+			}
+			value, err := ee.expandString(match[2])
+			if err != nil {
+				return err
+			}
+			ee.envManager.SetAssocElement(name, key, value)
+		}
+		return nil
+	}
+
+	if !assign.Append {
+		ee.envManager.SetArray(name, nil)
+	}
+	for _, element := range assign.Elements {
+		if match := arrayElement.FindStringSubmatch(element); match != nil {
+			index, err := ee.evaluateIndex(match[1])
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			value, err := ee.expandString(match[2])
+			if err != nil {
+				return err
+			}
+			ee.envManager.SetArrayElement(name, index, value)
+			continue
+		}
+		values, err := ee.expandWord(element)
+		if err != nil {
+			return err
+		}
+		ee.envManager.AppendArray(name, values...)
+	}
+	return nil
+}
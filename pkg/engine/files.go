@@ -0,0 +1,340 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gitee.com/com_818cloud/shode/pkg/sandbox"
+	"gitee.com/com_818cloud/shode/pkg/stdlib"
+)
+
+// fileFunctions are the file functions of the standard library beyond
+// ReadFile, WriteFile, ListFiles and FileExists
+var fileFunctions = map[string]bool{
+	"AppendFile":  true,
+	"AtomicWrite": true,
+	"CopyFile":    true,
+	"CopyDir":     true,
+	"Move":        true,
+	"Remove":      true,
+	"MkdirAll":    true,
+	"Chmod":       true,
+	"Stat":        true,
+	"Glob":        true,
+	"Walk":        true,
+	"TempFile":    true,
+	"TempDir":     true,
+	"Symlink":     true,
+	"ReadLink":    true,
+}
+
+// fileChanges are the file functions that change files, which a dry run
+// records instead of running
+var fileChanges = map[string]bool{
+	"AppendFile":  true,
+	"AtomicWrite": true,
+	"CopyFile":    true,
+	"CopyDir":     true,
+	"Move":        true,
+	"Remove":      true,
+	"MkdirAll":    true,
+	"Chmod":       true,
+	"Symlink":     true,
+}
+
+// scriptFileSystem is the file system as the script sees it: relative
+// paths resolve against its working directory, files go through its
+// transaction, and every path is checked against the security policy, as
+// file functions may reach files their arguments do not name
+type scriptFileSystem struct {
+	ee *ExecutionEngine
+}
+
+// check resolves a path and checks that it may be accessed with need
+func (fs scriptFileSystem) check(name string, need sandbox.Permission) (string, error) {
+	if err := fs.ee.security.CheckPath(name, fs.ee.envManager.GetWorkingDir(), need); err != nil {
+		return "", err
+	}
+	return fs.ee.resolvePath(name), nil
+}
+
+func (fs scriptFileSystem) OpenFile(name string, flag int, perm os.FileMode) (*os.File, error) {
+	need := sandbox.PermRead
+	switch {
+	case flag&os.O_RDWR != 0:
+		need |= sandbox.PermWrite
+	case flag&os.O_WRONLY != 0:
+		need = sandbox.PermWrite
+	}
+	path, err := fs.check(name, need)
+	if err != nil {
+		return nil, err
+	}
+	return fs.ee.openFile(path, flag, perm)
+}
+
+// Stat and Lstat need read access, as whether a file exists, its size and
+// its modification time are read from it
+func (fs scriptFileSystem) Stat(name string) (os.FileInfo, error) {
+	path, err := fs.check(name, sandbox.PermRead)
+	if err != nil {
+		return nil, err
+	}
+	return fs.ee.stat(path)
+}
+
+func (fs scriptFileSystem) Lstat(name string) (os.FileInfo, error) {
+	path, err := fs.check(name, sandbox.PermRead)
+	if err != nil {
+		return nil, err
+	}
+	return fs.ee.lstat(path)
+}
+
+func (fs scriptFileSystem) ReadDir(name string) ([]os.DirEntry, error) {
+	path, err := fs.check(name, sandbox.PermRead)
+	if err != nil {
+		return nil, err
+	}
+	return fs.ee.readDir(path)
+}
+
+func (fs scriptFileSystem) Mkdir(name string, perm os.FileMode) error {
+	path, err := fs.check(name, sandbox.PermWrite)
+	if err != nil {
+		return err
+	}
+	if fs.ee.transaction != nil {
+		return fs.ee.transaction.Mkdir(path, perm)
+	}
+	return os.Mkdir(path, perm)
+}
+
+func (fs scriptFileSystem) RemoveAll(name string) error {
+	path, err := fs.check(name, sandbox.PermWrite)
+	if err != nil {
+		return err
+	}
+	if fs.ee.transaction != nil {
+		return fs.ee.transaction.RemoveAll(path)
+	}
+	return os.RemoveAll(path)
+}
+
+func (fs scriptFileSystem) Rename(oldpath, newpath string) error {
+	from, err := fs.check(oldpath, sandbox.PermWrite)
+	if err != nil {
+		return err
+	}
+	to, err := fs.check(newpath, sandbox.PermWrite)
+	if err != nil {
+		return err
+	}
+	if fs.ee.transaction != nil {
+		return fs.ee.transaction.Rename(from, to)
+	}
+	return os.Rename(from, to)
+}
+
+func (fs scriptFileSystem) Symlink(target, name string) error {
+	path, err := fs.check(name, sandbox.PermWrite)
+	if err != nil {
+		return err
+	}
+	if fs.ee.transaction != nil {
+		return fs.ee.transaction.Symlink(target, path)
+	}
+	return os.Symlink(target, path)
+}
+
+func (fs scriptFileSystem) Readlink(name string) (string, error) {
+	if fs.ee.transaction != nil {
+		return fs.ee.transaction.Readlink(fs.ee.resolvePath(name))
+	}
+	return os.Readlink(fs.ee.resolvePath(name))
+}
+
+func (fs scriptFileSystem) Chmod(name string, mode os.FileMode) error {
+	path, err := fs.check(name, sandbox.PermWrite)
+	if err != nil {
+		return err
+	}
+	if fs.ee.transaction != nil {
+		return fs.ee.transaction.Chmod(path, mode)
+	}
+	return os.Chmod(path, mode)
+}
+
+// executeFileFunction runs a file function of the standard library on the
+// file system as the script sees it. Functions that change files print
+// nothing.
+func (ee *ExecutionEngine) executeFileFunction(funcName string, args []string) (string, error) {
+	// Number of arguments required
+	required := map[string]int{
+		"AppendFile": 1, "AtomicWrite": 1, "CopyFile": 2, "CopyDir": 2, "Move": 2, "Remove": 1,
+		"MkdirAll": 1, "Chmod": 2, "Stat": 1, "Glob": 1, "Walk": 1, "Symlink": 2, "ReadLink": 1,
+	}[funcName]
+	if len(args) < required {
+		return "", fmt.Errorf("%s requires %d arguments", funcName, required)
+	}
+	lib := ee.library()
+
+	switch funcName {
+	case "AppendFile", "AtomicWrite":
+		content, err := ee.stdlibInput(funcName, args, 1)
+		if err != nil {
+			return "", err
+		}
+		if funcName == "AppendFile" {
+			return "", lib.AppendFile(args[0], content)
+		}
+		return "", lib.AtomicWrite(args[0], content)
+	case "CopyFile":
+		return "", lib.CopyFile(args[0], args[1])
+	case "CopyDir":
+		return "", lib.CopyDir(args[0], args[1])
+	case "Move":
+		return "", lib.Move(args[0], args[1])
+	case "Symlink":
+		return "", lib.Symlink(args[0], args[1])
+	case "Remove":
+		return "", ee.removeFiles(lib, args)
+	case "MkdirAll":
+		for _, dir := range args {
+			if err := lib.MkdirAll(dir, 0755); err != nil {
+				return "", err
+			}
+		}
+		return "", nil
+	case "Chmod":
+		for _, path := range args[1:] {
+			if err := lib.Chmod(path, args[0]); err != nil {
+				return "", err
+			}
+		}
+		return "", nil
+	case "Stat":
+		info, err := lib.Stat(args[0])
+		if err != nil {
+			return "", err
+		}
+		return outputLines(info.String()), nil
+	case "ReadLink":
+		target, err := lib.ReadLink(args[0])
+		return outputLines(target), err
+	case "Glob":
+		var matches []string
+		for _, pattern := range args {
+			found, err := lib.Glob(pattern)
+			if err != nil {
+				return "", err
+			}
+			matches = append(matches, found...)
+		}
+		if len(matches) == 0 {
+			return "", errNoMatch
+		}
+		return outputLines(strings.Join(matches, "\n")), nil
+	case "Walk":
+		if strings.HasPrefix(args[0], "-") {
+			return "", fmt.Errorf("Walk requires the root directory before its options")
+		}
+		options, err := parseWalkArgs(args[1:])
+		if err != nil {
+			return "", err
+		}
+		paths, err := lib.Walk(args[0], options)
+		return outputLines(strings.Join(paths, "\n")), err
+	case "TempFile", "TempDir":
+		pattern := "shode-*"
+		if len(args) > 0 {
+			pattern = args[0]
+		}
+		create := lib.TempFile
+		if funcName == "TempDir" {
+			create = lib.TempDir
+		}
+		path, err := create(pattern)
+		if err != nil {
+			return "", err
+		}
+		ee.tempFiles = append(ee.tempFiles, path)
+		return outputLines(path), nil
+	}
+	return "", fmt.Errorf("unknown standard library function: %s", funcName)
+}
+
+// removeFiles runs Remove: -r removes directories with their content, -f
+// ignores missing paths
+func (ee *ExecutionEngine) removeFiles(lib *stdlib.StdLib, args []string) error {
+	recursive, force := false, false
+	for len(args) > 0 && len(args[0]) > 1 && strings.HasPrefix(args[0], "-") {
+		arg := args[0]
+		args = args[1:]
+		if arg == "--" {
+			break
+		}
+		for _, flag := range arg[1:] {
+			switch flag {
+			case 'r', 'R':
+				recursive = true
+			case 'f':
+				force = true
+			default:
+				return fmt.Errorf("unknown option -%c", flag)
+			}
+		}
+	}
+	if len(args) == 0 && !force {
+		return fmt.Errorf("Remove requires a path")
+	}
+	for _, path := range args {
+		if _, err := ee.lstat(ee.resolvePath(path)); err != nil && force {
+			continue
+		}
+		if err := lib.Remove(path, recursive); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseWalkArgs parses the filters of Walk: -name pattern, -type f, d or l
+// and -maxdepth levels
+func parseWalkArgs(args []string) (stdlib.WalkOptions, error) {
+	var options stdlib.WalkOptions
+	for i := 0; i < len(args); i += 2 {
+		option := args[i]
+		if option != "-name" && option != "-type" && option != "-maxdepth" {
+			return options, fmt.Errorf("unknown option %s, expected -name, -type or -maxdepth after the root", option)
+		}
+		if i+1 >= len(args) {
+			return options, fmt.Errorf("option %s requires a value", option)
+		}
+		value := args[i+1]
+		switch option {
+		case "-name":
+			options.Name = value
+		case "-type":
+			options.Type = value
+		case "-maxdepth":
+			depth, err := strconv.Atoi(value)
+			if err != nil || depth < 1 {
+				return options, fmt.Errorf("invalid depth %q", value)
+			}
+			options.MaxDepth = depth
+		}
+	}
+	return options, nil
+}
+
+// RemoveTempFiles removes the files and directories TempFile and TempDir
+// created. The outermost Execute calls it when its script ends.
+func (ee *ExecutionEngine) RemoveTempFiles() {
+	for _, path := range ee.tempFiles {
+		os.RemoveAll(path)
+	}
+	ee.tempFiles = nil
+}
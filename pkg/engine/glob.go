@@ -0,0 +1,376 @@
+package engine
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// numericSequence matches the body of a {start..end[..step]} integer sequence
+var numericSequence = regexp.MustCompile(`^(-?\d+)\.\.(-?\d+)(?:\.\.(-?\d+))?$`)
+
+// letterSequence matches the body of a {a..z[..step]} character sequence
+var letterSequence = regexp.MustCompile(`^([A-Za-z])\.\.([A-Za-z])(?:\.\.(-?\d+))?$`)
+
+// expandBraces performs brace expansion on a raw word: {a,b} alternatives
+// and {1..10..2} / {a..z} sequences. Quoted braces and ${...} are left alone.
+func expandBraces(word string) []string {
+	open, close, alternatives, ok := findBraceExpression(word)
+	if !ok {
+		return []string{word}
+	}
+
+	prefix, suffix := word[:open], word[close+1:]
+	var results []string
+	for _, alternative := range alternatives {
+		results = append(results, expandBraces(prefix+alternative+suffix)...)
+	}
+	return results
+}
+
+// findBraceExpression locates the first expandable brace expression in word
+func findBraceExpression(word string) (int, int, []string, bool) {
+	for i := 0; i < len(word); i++ {
+		switch word[i] {
+		case '\\':
+			i++
+		case '\'':
+			if end := strings.IndexByte(word[i+1:], '\''); end >= 0 {
+				i += end + 1
+			}
+		case '"':
+			i = skipDoubleQuotes(word, i)
+		case '$':
+			if i+1 < len(word) && word[i+1] == '{' {
+				if end := matchingBrace(word[i+1:]); end >= 0 {
+					i += end + 1
+				}
+			}
+		case '{':
+			close, commas := scanBraceBody(word, i)
+			if close < 0 {
+				continue
+			}
+			body := word[i+1 : close]
+			if len(commas) > 0 {
+				var alternatives []string
+				start := i + 1
+				for _, comma := range commas {
+					alternatives = append(alternatives, word[start:comma])
+					start = comma + 1
+				}
+				alternatives = append(alternatives, word[start:close])
+				return i, close, alternatives, true
+			}
+			if sequence, ok := expandSequence(body); ok {
+				return i, close, sequence, true
+			}
+		}
+	}
+	return 0, 0, nil, false
+}
+
+// skipDoubleQuotes returns the index of the '"' closing the one at word[start]
+func skipDoubleQuotes(word string, start int) int {
+	for i := start + 1; i < len(word); i++ {
+		switch word[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return len(word)
+}
+
+// scanBraceBody finds the '}' matching the '{' at word[start] and the
+// positions of its top-level commas
+func scanBraceBody(word string, start int) (int, []int) {
+	depth := 0
+	var commas []int
+	for i := start; i < len(word); i++ {
+		switch word[i] {
+		case '\\':
+			i++
+		case '\'':
+			if end := strings.IndexByte(word[i+1:], '\''); end >= 0 {
+				i += end + 1
+			}
+		case '"':
+			i = skipDoubleQuotes(word, i)
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i, commas
+			}
+		case ',':
+			if depth == 1 {
+				commas = append(commas, i)
+			}
+		}
+	}
+	return -1, nil
+}
+
+// expandSequence expands the body of a {start..end[..step]} expression
+func expandSequence(body string) ([]string, bool) {
+	if match := numericSequence.FindStringSubmatch(body); match != nil {
+		start, _ := strconv.Atoi(match[1])
+		end, _ := strconv.Atoi(match[2])
+		step := sequenceStep(match[3])
+
+		width := 0
+		if isZeroPadded(match[1]) || isZeroPadded(match[2]) {
+			width = len(strings.TrimPrefix(match[1], "-"))
+			if w := len(strings.TrimPrefix(match[2], "-")); w > width {
+				width = w
+			}
+		}
+
+		var values []string
+		for _, n := range sequence(start, end, step) {
+			if width > 0 {
+				values = append(values, fmt.Sprintf("%0*d", width, n))
+			} else {
+				values = append(values, strconv.Itoa(n))
+			}
+		}
+		return values, true
+	}
+
+	if match := letterSequence.FindStringSubmatch(body); match != nil {
+		var values []string
+		for _, c := range sequence(int(match[1][0]), int(match[2][0]), sequenceStep(match[3])) {
+			values = append(values, string(rune(c)))
+		}
+		return values, true
+	}
+
+	return nil, false
+}
+
+// sequenceStep parses the optional step of a sequence expression
+func sequenceStep(s string) int {
+	step, err := strconv.Atoi(s)
+	if err != nil || step == 0 {
+		return 1
+	}
+	if step < 0 {
+		return -step
+	}
+	return step
+}
+
+// sequence returns the integers from start to end inclusive, counting down
+// when end is below start
+func sequence(start, end, step int) []int {
+	var values []int
+	if start <= end {
+		for n := start; n <= end; n += step {
+			values = append(values, n)
+		}
+	} else {
+		for n := start; n >= end; n -= step {
+			values = append(values, n)
+		}
+	}
+	return values
+}
+
+// isZeroPadded reports whether a sequence bound requests zero padding
+func isZeroPadded(s string) bool {
+	s = strings.TrimPrefix(s, "-")
+	return len(s) > 1 && s[0] == '0'
+}
+
+// escapeGlob escapes glob characters so they match literally
+func escapeGlob(s string) string {
+	if !strings.ContainsAny(s, "*?[]\\") {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte("*?[]\\", s[i]) >= 0 {
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+// unescapeGlob removes glob escapes from a pattern segment without metacharacters
+func unescapeGlob(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+// hasGlobMeta reports whether a pattern contains unescaped glob characters
+func hasGlobMeta(pattern string) bool {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '*', '?', '[':
+			return true
+		}
+	}
+	return false
+}
+
+// toGoPattern converts shell bracket negation [!...] to Go's [^...]
+func toGoPattern(pattern string) string {
+	var sb strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case pattern[i] == '\\' && i+1 < len(pattern):
+			sb.WriteString(pattern[i : i+2])
+			i++
+		case pattern[i] == '[' && i+1 < len(pattern) && pattern[i+1] == '!':
+			sb.WriteString("[^")
+			i++
+		default:
+			sb.WriteByte(pattern[i])
+		}
+	}
+	return sb.String()
+}
+
+// expandPathname performs pathname expansion of a field relative to the
+// engine working directory, honouring nullglob, failglob, dotglob and globstar
+func (ee *ExecutionEngine) expandPathname(field expandedField) ([]string, error) {
+	pattern := toGoPattern(field.pattern)
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		// Not a valid pattern, e.g. a lone '[': keep the word as written
+		return []string{field.value}, nil
+	}
+
+	base, prefix := ee.envManager.GetWorkingDir(), ""
+	if strings.HasPrefix(pattern, "/") {
+		base, prefix = "/", "/"
+		pattern = strings.TrimLeft(pattern, "/")
+	}
+
+	var matches []string
+	ee.globSegments(base, prefix, strings.Split(pattern, "/"), &matches)
+	sort.Strings(matches)
+
+	if len(matches) > 0 {
+		return matches, nil
+	}
+	switch {
+	case ee.ShellOption("failglob"):
+		return nil, fmt.Errorf("no match: %s", field.value)
+	case ee.ShellOption("nullglob"):
+		return nil, nil
+	default:
+		return []string{field.value}, nil
+	}
+}
+
+// globSegments matches the remaining pattern segments below dir, appending
+// matches as paths relative to the original pattern
+func (ee *ExecutionEngine) globSegments(dir, prefix string, segments []string, matches *[]string) {
+	if len(segments) == 0 {
+		return
+	}
+	segment, rest := segments[0], segments[1:]
+
+	switch {
+	case segment == "":
+		// Trailing or repeated slash
+		if len(rest) == 0 {
+			*matches = append(*matches, joinGlobPath(prefix, ""))
+			return
+		}
+		ee.globSegments(dir, prefix, rest, matches)
+
+	case segment == "**" && ee.ShellOption("globstar"):
+		// ** matches zero or more directories
+		if len(rest) > 0 {
+			ee.globSegments(dir, prefix, rest, matches)
+		}
+		entries, err := ee.readDir(dir)
+		if err != nil {
+			return
+		}
+		for _, entry := range entries {
+			if ee.isHiddenFromGlob(entry.Name(), segment) {
+				continue
+			}
+			path := joinGlobPath(prefix, entry.Name())
+			if len(rest) == 0 {
+				*matches = append(*matches, path)
+			}
+			// Symlinked directories are not followed, as in bash
+			if entry.IsDir() {
+				ee.globSegments(filepath.Join(dir, entry.Name()), path, segments, matches)
+			}
+		}
+
+	case !hasGlobMeta(segment):
+		name := unescapeGlob(segment)
+		full := filepath.Join(dir, name)
+		if len(rest) == 0 {
+			if _, err := ee.lstat(full); err == nil {
+				*matches = append(*matches, joinGlobPath(prefix, name))
+			}
+			return
+		}
+		if info, err := ee.stat(full); err == nil && info.IsDir() {
+			ee.globSegments(full, joinGlobPath(prefix, name), rest, matches)
+		}
+
+	default:
+		entries, err := ee.readDir(dir)
+		if err != nil {
+			return
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if ee.isHiddenFromGlob(name, segment) {
+				continue
+			}
+			if matched, _ := filepath.Match(segment, name); !matched {
+				continue
+			}
+			if len(rest) == 0 {
+				*matches = append(*matches, joinGlobPath(prefix, name))
+				continue
+			}
+			full := filepath.Join(dir, name)
+			if info, err := ee.stat(full); err == nil && info.IsDir() {
+				ee.globSegments(full, joinGlobPath(prefix, name), rest, matches)
+			}
+		}
+	}
+}
+
+// isHiddenFromGlob reports whether a dotfile must be skipped for a segment
+func (ee *ExecutionEngine) isHiddenFromGlob(name, segment string) bool {
+	if !strings.HasPrefix(name, ".") {
+		return false
+	}
+	if strings.HasPrefix(segment, ".") || strings.HasPrefix(segment, "\\.") {
+		return false
+	}
+	return !ee.ShellOption("dotglob")
+}
+
+// joinGlobPath appends name to a glob result prefix
+func joinGlobPath(prefix, name string) string {
+	if prefix == "" || strings.HasSuffix(prefix, "/") {
+		return prefix + name
+	}
+	return prefix + "/" + name
+}
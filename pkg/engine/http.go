@@ -0,0 +1,186 @@
+package engine
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitee.com/com_818cloud/shode/pkg/sandbox"
+	"gitee.com/com_818cloud/shode/pkg/stdlib"
+	"gitee.com/com_818cloud/shode/pkg/types"
+)
+
+// httpFunctions are the HTTP functions of the standard library
+var httpFunctions = map[string]bool{
+	"HttpGet":     true,
+	"HttpPost":    true,
+	"HttpRequest": true,
+	"Download":    true,
+}
+
+// httpCall is an HTTP function call with its options parsed
+type httpCall struct {
+	args    []string // arguments that are not options
+	options stdlib.HttpOptions
+	output  string // file the body is written to
+}
+
+// parseHTTPArgs separates the options of an HTTP function from its other
+// arguments: -H 'Name: value' (repeatable), -t timeout in seconds or as a
+// duration such as 500ms, -r retries and -o file
+func parseHTTPArgs(funcName string, args []string) (*httpCall, error) {
+	call := &httpCall{options: stdlib.HttpOptions{Headers: http.Header{}}}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			call.args = append(call.args, args[i+1:]...)
+			break
+		}
+		if arg != "-H" && arg != "-t" && arg != "-r" && arg != "-o" {
+			call.args = append(call.args, arg)
+			continue
+		}
+		if i+1 >= len(args) {
+			return nil, fmt.Errorf("option %s requires a value", arg)
+		}
+		i++
+		value := args[i]
+		switch arg {
+		case "-H":
+			name, headerValue, ok := strings.Cut(value, ":")
+			if !ok || strings.TrimSpace(name) == "" {
+				return nil, fmt.Errorf("invalid header %q, expected 'Name: value'", sandbox.MaskAuthorization(value))
+			}
+			call.options.Headers.Add(strings.TrimSpace(name), strings.TrimSpace(headerValue))
+		case "-t":
+			timeout, err := parseTimeout(value)
+			if err != nil {
+				return nil, err
+			}
+			call.options.Timeout = timeout
+		case "-r":
+			retries, err := strconv.Atoi(value)
+			if err != nil || retries < 0 {
+				return nil, fmt.Errorf("invalid number of retries %q", value)
+			}
+			call.options.Retries = retries
+		case "-o":
+			call.output = value
+		}
+	}
+
+	required := map[string]int{"HttpGet": 1, "HttpPost": 1, "HttpRequest": 2, "Download": 2}[funcName]
+	if len(call.args) < required {
+		usage := map[string]string{
+			"HttpGet":     "url",
+			"HttpPost":    "url",
+			"HttpRequest": "method and url",
+			"Download":    "url and filename",
+		}[funcName]
+		return nil, fmt.Errorf("%s requires %s arguments", funcName, usage)
+	}
+	if funcName == "Download" {
+		call.output = call.args[1]
+	}
+	return call, nil
+}
+
+// bodyMethods are the methods whose body is read from the piped input when
+// no body argument is given
+var bodyMethods = map[string]bool{
+	http.MethodPost:  true,
+	http.MethodPut:   true,
+	http.MethodPatch: true,
+}
+
+// parseTimeout parses seconds, e.g. 10 or 0.5, or a duration such as 1m30s
+func parseTimeout(value string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds >= 0 {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid timeout %q", value)
+	}
+	return timeout, nil
+}
+
+// executeHTTPFunction runs an HTTP function of the standard library. It
+// prints the body, or writes it to a file, and sets HTTP_STATUS and the
+// HTTP_HEADERS associative array. Connections go through the network policy
+// and statuses from 400 on make it fail.
+func (ee *ExecutionEngine) executeHTTPFunction(funcName string, args []string) (string, error) {
+	call, err := parseHTTPArgs(funcName, args)
+	if err != nil {
+		return "", err
+	}
+	method, url := http.MethodGet, call.args[0]
+	body := ""
+	switch funcName {
+	case "HttpPost", "HttpRequest":
+		method = http.MethodPost
+		if funcName == "HttpRequest" {
+			method, url = strings.ToUpper(call.args[0]), call.args[1]
+		}
+		// The body is the argument after the URL, stdin for "-", or the
+		// piped input when the argument is missing and the method carries
+		// a body
+		bodyIndex := map[string]int{"HttpPost": 1, "HttpRequest": 2}[funcName]
+		if bodyIndex < len(call.args) || (bodyMethods[method] && ee.hasRedirectedStdin()) {
+			if body, err = ee.stdlibInput(funcName, call.args, bodyIndex); err != nil {
+				return "", err
+			}
+		}
+	}
+	call.options.Body = body
+
+	// The network logger sees the call with credentials masked
+	line := ee.Redact(sandbox.CommandLine(&types.CommandNode{Name: funcName, Args: args}))
+
+	var file *os.File
+	if call.output != "" {
+		// Checked like a redirection, not only by the command-level scan
+		if err := ee.security.CheckPath(call.output, ee.envManager.GetWorkingDir(), sandbox.PermWrite); err != nil {
+			return "", fmt.Errorf("%s: %v", funcName, err)
+		}
+		file, err = ee.openFile(ee.resolvePath(call.output), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			return "", fmt.Errorf("%s: failed to open %s: %v", funcName, call.output, err)
+		}
+		call.options.Output = file
+	}
+
+	scope := ee.scriptContext()
+	scope.Dial = ee.security.Dialer(line)
+	response, err := ee.stdlib.WithContext(scope).HttpRequest(method, url, call.options)
+	if file != nil {
+		if closeErr := file.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("failed to write %s: %v", call.output, closeErr)
+		}
+		// A failed download leaves no partial file
+		if response == nil || err != nil || response.Status >= 400 {
+			os.Remove(file.Name())
+		}
+	}
+
+	ee.envManager.UnsetEnv("HTTP_HEADERS")
+	if response == nil {
+		ee.envManager.SetEnv("HTTP_STATUS", "0")
+		return "", fmt.Errorf("%s: %v", funcName, err)
+	}
+	ee.envManager.SetEnv("HTTP_STATUS", strconv.Itoa(response.Status))
+	ee.envManager.DeclareAssocArray("HTTP_HEADERS")
+	for name, values := range response.Headers {
+		ee.envManager.SetAssocElement("HTTP_HEADERS", name, strings.Join(values, ", "))
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", funcName, err)
+	}
+	if response.Status >= 400 {
+		return "", fmt.Errorf("%s: %s %s: %s", funcName, method, url, response.Reason)
+	}
+	return response.Body, nil
+}
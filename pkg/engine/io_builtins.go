@@ -0,0 +1,733 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"gitee.com/com_818cloud/shode/pkg/types"
+)
+
+// readTimeoutStatus is the exit status of read -t when it times out
+const readTimeoutStatus = 142
+
+// errReadTimeout reports that read -t gave up waiting for input
+var errReadTimeout = errors.New("read timed out")
+
+// readRecordResult is one record read from stdin by the read builtin
+type readRecordResult struct {
+	data    []byte
+	literal []bool // bytes quoted with a backslash, never field separators
+	eof     bool
+	err     error
+}
+
+// pendingRead is a read left running by a timed-out read -t. The next read
+// from the same stream collects its result so no input is lost.
+type pendingRead struct {
+	reader io.Reader
+	result chan readRecordResult
+}
+
+// builtinEcho implements echo [-neE] [arg ...]
+func (ee *ExecutionEngine) builtinEcho(ctx context.Context, cmd *types.CommandNode) (*CommandResult, error) {
+	newline, escapes := true, false
+	args := cmd.Args
+	for len(args) > 0 && isEchoOption(args[0]) {
+		for _, flag := range args[0][1:] {
+			switch flag {
+			case 'n':
+				newline = false
+			case 'e':
+				escapes = true
+			case 'E':
+				escapes = false
+			}
+		}
+		args = args[1:]
+	}
+
+	output := strings.Join(args, " ")
+	if escapes {
+		var stop bool
+		output, stop = expandEscapes(output, true)
+		if stop {
+			newline = false
+		}
+	}
+	if newline {
+		output += "\n"
+	}
+	return builtinResult(cmd, output, nil), nil
+}
+
+// isEchoOption reports whether arg is a cluster of echo flags such as -ne;
+// anything else, including a lone "-", is printed
+func isEchoOption(arg string) bool {
+	if len(arg) < 2 || arg[0] != '-' {
+		return false
+	}
+	for _, flag := range arg[1:] {
+		if flag != 'n' && flag != 'e' && flag != 'E' {
+			return false
+		}
+	}
+	return true
+}
+
+// expandEscapes expands backslash escapes. zeroOctal selects the \0NNN
+// octal form of echo -e and %b instead of the \NNN form of printf formats.
+// It reports whether a \c escape asked to stop all further output.
+func expandEscapes(s string, zeroOctal bool) (string, bool) {
+	if !strings.Contains(s, "\\") {
+		return s, false
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); {
+		if s[i] != '\\' {
+			sb.WriteByte(s[i])
+			i++
+			continue
+		}
+		text, next, stop := decodeEscape(s, i, zeroOctal)
+		if stop {
+			return sb.String(), true
+		}
+		sb.WriteString(text)
+		i = next
+	}
+	return sb.String(), false
+}
+
+// decodeEscape decodes the backslash escape at s[i], returning its text and
+// the index following it
+func decodeEscape(s string, i int, zeroOctal bool) (string, int, bool) {
+	if i+1 >= len(s) {
+		return "\\", i + 1, false
+	}
+	c := s[i+1]
+	switch c {
+	case 'a':
+		return "\a", i + 2, false
+	case 'b':
+		return "\b", i + 2, false
+	case 'c':
+		return "", i + 2, true
+	case 'e', 'E':
+		return "\x1b", i + 2, false
+	case 'f':
+		return "\f", i + 2, false
+	case 'n':
+		return "\n", i + 2, false
+	case 'r':
+		return "\r", i + 2, false
+	case 't':
+		return "\t", i + 2, false
+	case 'v':
+		return "\v", i + 2, false
+	case '\\':
+		return "\\", i + 2, false
+	case '"', '\'', '?':
+		if !zeroOctal {
+			return string(c), i + 2, false
+		}
+	case 'x':
+		if value, next, ok := parseEscapeNumber(s, i+2, 16, 2); ok {
+			return string([]byte{byte(value)}), next, false
+		}
+	case 'u', 'U':
+		digits := 4
+		if c == 'U' {
+			digits = 8
+		}
+		if value, next, ok := parseEscapeNumber(s, i+2, 16, digits); ok {
+			return string(rune(value)), next, false
+		}
+	}
+
+	if c >= '0' && c <= '7' {
+		start := i + 1
+		if zeroOctal {
+			if c != '0' {
+				return s[i : i+2], i + 2, false
+			}
+			start = i + 2
+		}
+		value, next, ok := parseEscapeNumber(s, start, 8, 3)
+		if !ok {
+			// A lone \0 in echo -e is NUL
+			return "\x00", start, false
+		}
+		return string([]byte{byte(value)}), next, false
+	}
+
+	return s[i : i+2], i + 2, false
+}
+
+// parseEscapeNumber parses up to maxDigits digits in the given base at s[start]
+func parseEscapeNumber(s string, start, base, maxDigits int) (int, int, bool) {
+	end := start
+	for end < len(s) && end-start < maxDigits && isDigitInBase(s[end], base) {
+		end++
+	}
+	if end == start {
+		return 0, start, false
+	}
+	value, err := strconv.ParseInt(s[start:end], base, 64)
+	if err != nil {
+		return 0, start, false
+	}
+	return int(value), end, true
+}
+
+// isDigitInBase reports whether c is a digit in base 8 or 16
+func isDigitInBase(c byte, base int) bool {
+	if base == 8 {
+		return c >= '0' && c <= '7'
+	}
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// builtinPrintf implements printf [-v var] format [arguments]
+func (ee *ExecutionEngine) builtinPrintf(ctx context.Context, cmd *types.CommandNode) (*CommandResult, error) {
+	args := cmd.Args
+	variable := ""
+	if len(args) > 0 && args[0] == "-v" {
+		if len(args) < 2 {
+			return builtinResult(cmd, "", fmt.Errorf("-v: option requires an argument")), nil
+		}
+		variable = args[1]
+		if !identifierPattern.MatchString(variable) {
+			return builtinResult(cmd, "", fmt.Errorf("`%s': not a valid identifier", variable)), nil
+		}
+		args = args[2:]
+	}
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return builtinResult(cmd, "", fmt.Errorf("usage: printf [-v var] format [arguments]")), nil
+	}
+
+	pf := &printfFormatter{args: args[1:]}
+	output := pf.format(args[0])
+	if variable != "" {
+		ee.assignScalar(variable, output)
+		output = ""
+	}
+	return builtinResult(cmd, output, pf.err), nil
+}
+
+// printfFormatter expands a printf format, consuming arguments as
+// conversions need them
+type printfFormatter struct {
+	args []string
+	next int
+	err  error // first conversion error; output continues regardless
+}
+
+// format applies the format, reusing it until all arguments are consumed
+func (pf *printfFormatter) format(format string) string {
+	var out strings.Builder
+	for {
+		start := pf.next
+		if stop := pf.formatOnce(format, &out); stop {
+			break
+		}
+		if pf.next >= len(pf.args) || pf.next == start {
+			break
+		}
+	}
+	return out.String()
+}
+
+// formatOnce applies the format a single time. It reports whether output
+// must stop, after \c or an invalid conversion.
+func (pf *printfFormatter) formatOnce(format string, out *strings.Builder) bool {
+	for i := 0; i < len(format); {
+		if format[i] == '\\' {
+			text, next, stop := decodeEscape(format, i, false)
+			if stop {
+				return true
+			}
+			out.WriteString(text)
+			i = next
+			continue
+		}
+		if format[i] != '%' {
+			out.WriteByte(format[i])
+			i++
+			continue
+		}
+
+		if i+1 < len(format) && format[i+1] == '%' {
+			out.WriteByte('%')
+			i += 2
+			continue
+		}
+
+		// %[flags][width][.precision]conversion
+		j := i + 1
+		var spec strings.Builder
+		spec.WriteByte('%')
+		for j < len(format) && strings.IndexByte("-+ #0", format[j]) >= 0 {
+			spec.WriteByte(format[j])
+			j++
+		}
+		j = pf.formatNumber(format, j, &spec)
+		hasPrecision := false
+		if j < len(format) && format[j] == '.' {
+			hasPrecision = true
+			spec.WriteByte('.')
+			j = pf.formatNumber(format, j+1, &spec)
+		}
+		if j >= len(format) {
+			pf.fail(fmt.Errorf("`%s': missing format character", format[i:]))
+			return true
+		}
+
+		verb := format[j]
+		i = j + 1
+		if stop := pf.convert(out, spec.String(), verb, hasPrecision); stop {
+			return true
+		}
+	}
+	return false
+}
+
+// formatNumber copies a width or precision at format[j] into spec; '*'
+// takes its value from the next argument
+func (pf *printfFormatter) formatNumber(format string, j int, spec *strings.Builder) int {
+	if j < len(format) && format[j] == '*' {
+		spec.WriteString(strconv.FormatInt(pf.intArg(), 10))
+		return j + 1
+	}
+	for j < len(format) && format[j] >= '0' && format[j] <= '9' {
+		spec.WriteByte(format[j])
+		j++
+	}
+	return j
+}
+
+// convert formats the next argument with one conversion
+func (pf *printfFormatter) convert(out *strings.Builder, spec string, verb byte, hasPrecision bool) bool {
+	switch verb {
+	case 's':
+		fmt.Fprintf(out, spec+"s", pf.arg())
+	case 'b':
+		text, stop := expandEscapes(pf.arg(), true)
+		fmt.Fprintf(out, spec+"s", text)
+		return stop
+	case 'q':
+		fmt.Fprintf(out, spec+"s", shellQuote(pf.arg()))
+	case 'c':
+		arg := pf.arg()
+		if arg != "" {
+			r, _ := utf8.DecodeRuneInString(arg)
+			arg = string(r)
+		}
+		fmt.Fprintf(out, spec+"s", arg)
+	case 'd', 'i':
+		fmt.Fprintf(out, spec+"d", pf.intArg())
+	case 'u':
+		fmt.Fprintf(out, spec+"d", uint64(pf.intArg()))
+	case 'o', 'x', 'X':
+		fmt.Fprintf(out, spec+string(verb), uint64(pf.intArg()))
+	case 'f', 'F', 'e', 'E':
+		fmt.Fprintf(out, spec+string(verb), pf.floatArg())
+	case 'g', 'G':
+		// C defaults %g to six significant digits; Go uses as many as needed
+		if !hasPrecision {
+			spec += ".6"
+		}
+		fmt.Fprintf(out, spec+string(verb), pf.floatArg())
+	default:
+		pf.fail(fmt.Errorf("`%c': invalid format character", verb))
+		return true
+	}
+	return false
+}
+
+// arg returns the next argument, or "" once they are exhausted
+func (pf *printfFormatter) arg() string {
+	if pf.next >= len(pf.args) {
+		return ""
+	}
+	pf.next++
+	return pf.args[pf.next-1]
+}
+
+// intArg returns the next argument as an integer
+func (pf *printfFormatter) intArg() int64 {
+	arg := pf.arg()
+	value, err := parseShellInt(arg)
+	if err != nil {
+		pf.fail(err)
+	}
+	return value
+}
+
+// floatArg returns the next argument as a floating point number
+func (pf *printfFormatter) floatArg() float64 {
+	arg := strings.TrimSpace(pf.arg())
+	if arg == "" {
+		return 0
+	}
+	if arg[0] == '\'' || arg[0] == '"' {
+		value, _ := parseShellInt(arg)
+		return float64(value)
+	}
+	value, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		intValue, intErr := parseShellInt(arg)
+		if intErr != nil {
+			pf.fail(fmt.Errorf("%s: invalid number", arg))
+		}
+		return float64(intValue)
+	}
+	return value
+}
+
+// fail records the first conversion error
+func (pf *printfFormatter) fail(err error) {
+	if pf.err == nil {
+		pf.err = err
+	}
+}
+
+// parseShellInt parses a printf numeric argument: decimal, 0x hex, 0 octal,
+// or 'c to take the character code of c
+func parseShellInt(s string) (int64, error) {
+	s = strings.TrimLeft(s, " \t\n")
+	if s == "" {
+		return 0, nil
+	}
+	if s[0] == '\'' || s[0] == '"' {
+		if len(s) == 1 {
+			return 0, nil
+		}
+		r, _ := utf8.DecodeRuneInString(s[1:])
+		return int64(r), nil
+	}
+	value, err := strconv.ParseInt(s, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid number", s)
+	}
+	return value, nil
+}
+
+// shellQuote quotes s so the shell reads it back as a single word, the way
+// printf %q does
+func shellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	for _, r := range s {
+		if !unicode.IsPrint(r) {
+			return ansiCQuote(s)
+		}
+	}
+
+	var sb strings.Builder
+	for i, r := range s {
+		safe := unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_-./:@%+,=", r)
+		if !safe && !(r == '~' && i > 0) {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// ansiCQuote quotes s as $'...', escaping control characters
+func ansiCQuote(s string) string {
+	var sb strings.Builder
+	sb.WriteString("$'")
+	for _, r := range s {
+		switch r {
+		case '\a':
+			sb.WriteString(`\a`)
+		case '\b':
+			sb.WriteString(`\b`)
+		case '\x1b':
+			sb.WriteString(`\E`)
+		case '\f':
+			sb.WriteString(`\f`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\v':
+			sb.WriteString(`\v`)
+		case '\\', '\'':
+			sb.WriteByte('\\')
+			sb.WriteRune(r)
+		default:
+			if unicode.IsPrint(r) {
+				sb.WriteRune(r)
+			} else {
+				fmt.Fprintf(&sb, "\\%03o", r)
+			}
+		}
+	}
+	sb.WriteByte('\'')
+	return sb.String()
+}
+
+// builtinRead implements read [-r] [-a array] [-d delim] [-p prompt]
+// [-t timeout] [name ...]
+func (ee *ExecutionEngine) builtinRead(ctx context.Context, cmd *types.CommandNode) (*CommandResult, error) {
+	raw := false
+	array, prompt := "", ""
+	delim := byte('\n')
+	timeout := time.Duration(-1)
+	var names []string
+
+	args := cmd.Args
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if len(names) > 0 || !strings.HasPrefix(arg, "-") || arg == "-" {
+			names = append(names, arg)
+			continue
+		}
+		if arg == "--" {
+			names = append(names, args[i+1:]...)
+			break
+		}
+
+		flags := arg[1:]
+		for j := 0; j < len(flags); j++ {
+			flag := flags[j]
+			if flag == 'r' {
+				raw = true
+				continue
+			}
+			if strings.IndexByte("adpt", flag) < 0 {
+				return builtinResult(cmd, "", fmt.Errorf("-%c: invalid option", flag)), nil
+			}
+
+			// Options with a value take the rest of the word or the next argument
+			value := flags[j+1:]
+			if value == "" {
+				i++
+				if i >= len(args) {
+					return builtinResult(cmd, "", fmt.Errorf("-%c: option requires an argument", flag)), nil
+				}
+				value = args[i]
+			}
+			j = len(flags)
+
+			switch flag {
+			case 'a':
+				array = value
+			case 'd':
+				delim = 0
+				if value != "" {
+					delim = value[0]
+				}
+			case 'p':
+				prompt = value
+			case 't':
+				seconds, err := strconv.ParseFloat(value, 64)
+				if err != nil || seconds < 0 {
+					return builtinResult(cmd, "", fmt.Errorf("%s: invalid timeout specification", value)), nil
+				}
+				timeout = time.Duration(seconds * float64(time.Second))
+			}
+		}
+	}
+
+	if array != "" {
+		names = append([]string{array}, names...)
+	}
+	for _, name := range names {
+		if !identifierPattern.MatchString(name) {
+			return builtinResult(cmd, "", fmt.Errorf("`%s': not a valid identifier", name)), nil
+		}
+	}
+
+	// -t 0 only reports whether input is available, without reading it
+	if timeout == 0 {
+		if ee.hasRedirectedStdin() || ee.pendingRead != nil {
+			return builtinResult(cmd, "", nil), nil
+		}
+		return &CommandResult{Command: cmd, Success: false, ExitCode: 1}, nil
+	}
+
+	// Like bash, only prompt when reading from the terminal
+	if prompt != "" && !ee.hasRedirectedStdin() {
+		fmt.Fprint(os.Stderr, prompt)
+	}
+
+	record := ee.readInput(delim, raw, timeout)
+	if record.err == errReadTimeout {
+		return &CommandResult{Command: cmd, Success: false, ExitCode: readTimeoutStatus}, nil
+	}
+	if record.err != nil {
+		return builtinResult(cmd, "", record.err), nil
+	}
+
+	ifs := ee.ifs()
+	switch {
+	case array != "":
+		ee.envManager.UnsetEnv(array)
+		ee.envManager.SetArray(array, splitReadFields(record.data, record.literal, ifs, -1))
+	case len(names) == 0:
+		ee.envManager.SetEnv("REPLY", string(record.data))
+	default:
+		fields := splitReadFields(record.data, record.literal, ifs, len(names))
+		for i, name := range names {
+			value := ""
+			if i < len(fields) {
+				value = fields[i]
+			}
+			ee.assignScalar(name, value)
+		}
+	}
+
+	if record.eof {
+		return &CommandResult{Command: cmd, Success: false, ExitCode: 1}, nil
+	}
+	return builtinResult(cmd, "", nil), nil
+}
+
+// readInput reads one record from stdin, giving up after timeout unless it
+// is negative
+func (ee *ExecutionEngine) readInput(delim byte, raw bool, timeout time.Duration) readRecordResult {
+	pending := ee.pendingRead
+	ee.pendingRead = nil
+	if pending != nil && pending.reader != ee.stdin {
+		pending = nil
+	}
+
+	if timeout < 0 && pending == nil {
+		return readRecord(ee.stdin, delim, raw)
+	}
+
+	if pending == nil {
+		pending = &pendingRead{reader: ee.stdin, result: make(chan readRecordResult, 1)}
+		go func(p *pendingRead) {
+			p.result <- readRecord(p.reader, delim, raw)
+		}(pending)
+	}
+	if timeout < 0 {
+		return <-pending.result
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case record := <-pending.result:
+		return record
+	case <-timer.C:
+		ee.pendingRead = pending
+		return readRecordResult{err: errReadTimeout}
+	}
+}
+
+// readRecord reads up to delim one byte at a time, so input after the
+// record stays available to the next command. Unless raw, a backslash quotes
+// the following byte and backslash-newline continues the record.
+func readRecord(r io.Reader, delim byte, raw bool) readRecordResult {
+	var record readRecordResult
+	escaped := false
+	for {
+		c, err := readByte(r)
+		if err == io.EOF {
+			record.eof = true
+			return record
+		}
+		if err != nil {
+			record.err = err
+			return record
+		}
+
+		switch {
+		case escaped:
+			escaped = false
+			if c == '\n' {
+				continue
+			}
+			record.data = append(record.data, c)
+			record.literal = append(record.literal, true)
+		case c == '\\' && !raw:
+			escaped = true
+		case c == delim:
+			return record
+		default:
+			record.data = append(record.data, c)
+			record.literal = append(record.literal, false)
+		}
+	}
+}
+
+// readByte reads a single byte, using the reader's ReadByte when it has one
+func readByte(r io.Reader) (byte, error) {
+	if br, ok := r.(io.ByteReader); ok {
+		return br.ReadByte()
+	}
+	var buf [1]byte
+	for {
+		n, err := r.Read(buf[:])
+		if n == 1 {
+			return buf[0], nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// splitReadFields splits a record on IFS the way read does. With n > 0 the
+// last field takes the rest of the record; n < 0 splits every field.
+func splitReadFields(data []byte, literal []bool, ifs string, n int) []string {
+	isSep := func(i int) bool {
+		return !literal[i] && strings.IndexByte(ifs, data[i]) >= 0
+	}
+	isSpace := func(i int) bool {
+		return isSep(i) && strings.IndexByte(" \t\n", data[i]) >= 0
+	}
+
+	i := 0
+	for i < len(data) && isSpace(i) {
+		i++
+	}
+
+	var fields []string
+	for i < len(data) {
+		if n > 0 && len(fields) == n-1 {
+			end := len(data)
+			for end > i && isSpace(end-1) {
+				end--
+			}
+			return append(fields, string(data[i:end]))
+		}
+
+		start := i
+		for i < len(data) && !isSep(i) {
+			i++
+		}
+		fields = append(fields, string(data[start:i]))
+
+		// A separator is IFS whitespace around at most one other IFS character
+		for i < len(data) && isSpace(i) {
+			i++
+		}
+		if i < len(data) && isSep(i) {
+			i++
+			for i < len(data) && isSpace(i) {
+				i++
+			}
+		}
+	}
+	return fields
+}
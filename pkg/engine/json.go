@@ -0,0 +1,154 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+)
+
+// jsonFunctions are the JSON functions of the standard library
+var jsonFunctions = map[string]bool{
+	"JSONParse":     true,
+	"JSONLoad":      true,
+	"JSONPretty":    true,
+	"JSONCompact":   true,
+	"JSONQuery":     true,
+	"JSONGet":       true,
+	"JSONSet":       true,
+	"JSONSetString": true,
+	"JSONDelete":    true,
+	"JSONMerge":     true,
+	"JSONToArray":   true,
+	"JSONToMap":     true,
+	"JSONFromArray": true,
+}
+
+// executeJSONFunction runs a JSON function of the standard library. The
+// document is the last argument; when it is missing or "-" it is read from
+// the piped input, so the functions work in the middle of a pipeline.
+func (ee *ExecutionEngine) executeJSONFunction(funcName string, args []string) (string, error) {
+	output, err := ee.runJSONFunction(funcName, args)
+	if err != nil && !strings.HasPrefix(err.Error(), funcName) {
+		err = fmt.Errorf("%s: %v", funcName, err)
+	}
+	return output, err
+}
+
+// runJSONFunction is executeJSONFunction without the function name in errors
+func (ee *ExecutionEngine) runJSONFunction(funcName string, args []string) (string, error) {
+	switch funcName {
+	case "JSONParse", "JSONPretty", "JSONCompact":
+		doc, err := ee.stdlibInput(funcName, args, 0)
+		if err != nil {
+			return "", err
+		}
+		format := map[string]func(string) (string, error){
+			"JSONParse":   ee.stdlib.JSONParse,
+			"JSONPretty":  ee.stdlib.JSONPretty,
+			"JSONCompact": ee.stdlib.JSONCompact,
+		}[funcName]
+		output, err := format(doc)
+		return outputLines(output), err
+	case "JSONLoad":
+		if len(args) == 0 {
+			return "", fmt.Errorf("JSONLoad requires filename argument")
+		}
+		output, err := ee.library().JSONLoad(args[0])
+		return outputLines(output), err
+	case "JSONQuery", "JSONGet":
+		if len(args) == 0 {
+			return "", fmt.Errorf("%s requires filter argument", funcName)
+		}
+		doc, err := ee.stdlibInput(funcName, args, 1)
+		if err != nil {
+			return "", err
+		}
+		query := ee.stdlib.JSONQuery
+		if funcName == "JSONGet" {
+			query = ee.stdlib.JSONGet
+		}
+		results, err := query(doc, args[0])
+		return outputLines(strings.Join(results, "\n")), err
+	case "JSONSet", "JSONSetString":
+		if len(args) < 2 {
+			return "", fmt.Errorf("%s requires path and value arguments", funcName)
+		}
+		doc, err := ee.stdlibInput(funcName, args, 2)
+		if err != nil {
+			return "", err
+		}
+		set := ee.stdlib.JSONSet
+		if funcName == "JSONSetString" {
+			set = ee.stdlib.JSONSetString
+		}
+		output, err := set(doc, args[0], args[1])
+		return outputLines(output), err
+	case "JSONDelete":
+		if len(args) == 0 {
+			return "", fmt.Errorf("JSONDelete requires path argument")
+		}
+		doc, err := ee.stdlibInput(funcName, args, 1)
+		if err != nil {
+			return "", err
+		}
+		output, err := ee.stdlib.JSONDelete(doc, args[0])
+		return outputLines(output), err
+	case "JSONMerge":
+		// Without arguments the piped input is merged; "-" stands for
+		// stdin among the arguments
+		var docs []string
+		if len(args) == 0 {
+			doc, err := ee.stdlibInput(funcName, nil, 0)
+			if err != nil {
+				return "", err
+			}
+			docs = append(docs, doc)
+		}
+		for i := range args {
+			doc, err := ee.stdlibInput(funcName, args, i)
+			if err != nil {
+				return "", err
+			}
+			docs = append(docs, doc)
+		}
+		output, err := ee.stdlib.JSONMerge(docs...)
+		return outputLines(output), err
+	case "JSONToArray", "JSONToMap":
+		if len(args) == 0 {
+			return "", fmt.Errorf("%s requires variable name argument", funcName)
+		}
+		doc, err := ee.stdlibInput(funcName, args, 1)
+		if err != nil {
+			return "", err
+		}
+		if funcName == "JSONToArray" {
+			words, err := ee.stdlib.JSONToArray(doc)
+			if err != nil {
+				return "", err
+			}
+			ee.envManager.SetArray(args[0], words)
+			return "", nil
+		}
+		words, err := ee.stdlib.JSONToMap(doc)
+		if err != nil {
+			return "", err
+		}
+		ee.envManager.UnsetEnv(args[0])
+		ee.envManager.DeclareAssocArray(args[0])
+		for key, word := range words {
+			ee.envManager.SetAssocElement(args[0], key, word)
+		}
+		return "", nil
+	case "JSONFromArray":
+		if len(args) == 0 {
+			return "", fmt.Errorf("JSONFromArray requires variable name argument")
+		}
+		if words, ok := ee.envManager.GetAssocArray(args[0]); ok {
+			return outputLines(ee.stdlib.JSONFromMap(words)), nil
+		}
+		if words, ok := ee.envManager.GetArray(args[0]); ok {
+			return outputLines(ee.stdlib.JSONFromArray(words)), nil
+		}
+		return "", fmt.Errorf("%s is not an array", args[0])
+	}
+	return "", fmt.Errorf("unknown standard library function: %s", funcName)
+}
@@ -0,0 +1,43 @@
+package engine
+
+import (
+	"io"
+
+	"gitee.com/com_818cloud/shode/pkg/stdlib"
+)
+
+// scriptEnvironment is the environment of the script as the standard
+// library sees it. The library checks the directory ChangeDir is given on
+// the script's file system, so a directory that only exists in its
+// transaction is accepted.
+type scriptEnvironment struct {
+	ee *ExecutionEngine
+}
+
+func (env scriptEnvironment) GetEnv(key string) string { return env.ee.envManager.GetEnv(key) }
+func (env scriptEnvironment) SetEnv(key, value string) { env.ee.envManager.SetEnv(key, value) }
+func (env scriptEnvironment) GetWorkingDir() string    { return env.ee.envManager.GetWorkingDir() }
+
+func (env scriptEnvironment) ChangeDir(dir string) error {
+	env.ee.envManager.SetWorkingDir(dir)
+	return nil
+}
+
+// scriptContext returns the context standard library functions run in:
+// the variables and working directory of the script, its file system as
+// scriptFileSystem sees it, and its stdin. Output is discarded unless the
+// caller captures it.
+func (ee *ExecutionEngine) scriptContext() stdlib.Context {
+	return stdlib.Context{
+		Env:    scriptEnvironment{ee: ee},
+		Files:  scriptFileSystem{ee: ee},
+		Stdin:  ee.stdin,
+		Stdout: io.Discard,
+		Stderr: io.Discard,
+	}
+}
+
+// library returns the standard library bound to the script
+func (ee *ExecutionEngine) library() *stdlib.StdLib {
+	return ee.stdlib.WithContext(ee.scriptContext())
+}
@@ -0,0 +1,331 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gitee.com/com_818cloud/shode/pkg/sandbox"
+	"gitee.com/com_818cloud/shode/pkg/types"
+)
+
+// maxPlanSteps is the number of commands a dry run simulates before
+// simulated commands start failing, which ends loops waiting on them
+const maxPlanSteps = 1000
+
+// Actions of plan steps
+const (
+	PlanRun     = "run"     // an external command
+	PlanWrite   = "write"   // files changed by WriteFile or a file function such as CopyFile
+	PlanChdir   = "cd"      // a ChangeDir
+	PlanFetch   = "fetch"   // an HTTP request of HttpGet, HttpPost, HttpRequest or Download
+	PlanBlocked = "blocked" // a command the security policy refuses
+)
+
+// Plan is what a script would do, recorded by a dry run
+type Plan struct {
+	Steps     []*PlanStep        `json:"steps"`
+	Files     []*PlanFile        `json:"files"`
+	Env       map[string]*string `json:"env,omitempty"` // variables the script changes; null if unset
+	Dir       string             `json:"dir"`           // working directory at the end
+	Truncated bool               `json:"truncated,omitempty"`
+
+	baseline map[string]string // environment when the dry run started
+}
+
+// PlanStep is a command a dry run recorded instead of performing
+type PlanStep struct {
+	Script  string   `json:"script,omitempty"`
+	Line    int      `json:"line,omitempty"`
+	Action  string   `json:"action"`
+	Command string   `json:"command"`
+	Argv    []string `json:"argv"`
+	Dir     string   `json:"dir"`              // working directory
+	Stdin   bool     `json:"stdin,omitempty"`  // reads the output of a pipeline
+	Reason  string   `json:"reason,omitempty"` // violation of a blocked command
+}
+
+// PlanFile is a file the script would read or write
+type PlanFile struct {
+	Path    string `json:"path"`
+	Access  string `json:"access"` // "read", "write", "append" or "read/write"
+	Script  string `json:"script,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Command string `json:"command"`
+}
+
+// SetDryRun switches the engine to simulating scripts. Expansions,
+// assignments and builtins run as usual, but external commands succeed
+// without output, and redirections, WriteFile, ChangeDir and the file
+// functions that change files are recorded in the plan instead of performed.
+func (ee *ExecutionEngine) SetDryRun(enabled bool) {
+	if !enabled {
+		ee.plan = nil
+		return
+	}
+	ee.plan = &Plan{
+		Steps:    []*PlanStep{},
+		Files:    []*PlanFile{},
+		baseline: ee.envManager.GetAllEnv(),
+	}
+}
+
+// Plan returns what the dry run recorded so far, with the values of secret
+// variables masked, or nil if the engine is not in dry-run mode
+func (ee *ExecutionEngine) Plan() *Plan {
+	if ee.plan == nil {
+		return nil
+	}
+	redact := ee.redactor()
+	for _, step := range ee.plan.Steps {
+		step.Command = redact(step.Command)
+		step.Argv = redactAll(redact, step.Argv)
+		step.Reason = redact(step.Reason)
+	}
+	for _, file := range ee.plan.Files {
+		file.Path = redact(file.Path)
+	}
+	ee.plan.Env = ee.maskEnv(ee.envDiff(ee.plan.baseline), redact)
+	ee.plan.Dir = ee.envManager.GetWorkingDir()
+	return ee.plan
+}
+
+// addStep records a step of the plan at the position of cmd
+func (ee *ExecutionEngine) addStep(action string, cmd *types.CommandNode) *PlanStep {
+	step := &PlanStep{
+		Line:    cmd.Pos.Line,
+		Action:  action,
+		Command: sandbox.CommandLine(cmd),
+		Argv:    append([]string{cmd.Name}, cmd.Args...),
+		Dir:     ee.envManager.GetWorkingDir(),
+	}
+	if len(ee.callStack) > 0 {
+		step.Script = ee.callStack[len(ee.callStack)-1].file
+	}
+	ee.plan.Steps = append(ee.plan.Steps, step)
+	return step
+}
+
+// addFile records a file the command at pos would read or write, each
+// once; a loop touching it again adds nothing
+func (ee *ExecutionEngine) addFile(path, access, command string, pos types.Position) {
+	file := &PlanFile{Path: path, Access: access, Line: pos.Line, Command: command}
+	if len(ee.callStack) > 0 {
+		file.Script = ee.callStack[len(ee.callStack)-1].file
+	}
+	for _, existing := range ee.plan.Files {
+		if *existing == *file {
+			return
+		}
+	}
+	ee.plan.Files = append(ee.plan.Files, file)
+}
+
+// writesPath reports whether an earlier step writes path or a file below it
+func (p *Plan) writesPath(path string) bool {
+	for _, file := range p.Files {
+		if file.Access != "read" && (file.Path == path || strings.HasPrefix(file.Path, path+string(filepath.Separator))) {
+			return true
+		}
+	}
+	return false
+}
+
+// planRedirect records the file of a redirection
+func (ee *ExecutionEngine) planRedirect(redirect *types.RedirectNode, command string) {
+	access := ""
+	switch redirect.Op {
+	case "<":
+		access = "read"
+	case ">", "&>":
+		access = "write"
+	case ">>":
+		access = "append"
+	default:
+		return
+	}
+	ee.addFile(ee.resolvePath(redirect.File), access, command, redirect.Pos)
+}
+
+// simulateProcess records an external command in the plan instead of
+// running it. It succeeds without output until the plan is full.
+func (ee *ExecutionEngine) simulateProcess(cmd *types.CommandNode, piped bool) *CommandResult {
+	if len(ee.plan.Steps) >= maxPlanSteps {
+		ee.plan.Truncated = true
+		return &CommandResult{
+			Command:   cmd,
+			Success:   false,
+			ExitCode:  1,
+			Error:     ee.diagnostic(cmd, fmt.Sprintf("dry run: stopped simulating after %d commands", maxPlanSteps)),
+			Simulated: true,
+		}
+	}
+
+	// A command that cannot be found fails as it would in a real run
+	if _, ok := ee.executablePath(cmd.Name); !ok {
+		return &CommandResult{
+			Command:   cmd,
+			Success:   false,
+			ExitCode:  127,
+			Error:     ee.diagnostic(cmd, cmd.Name+": command not found"),
+			Simulated: true,
+		}
+	}
+
+	step := ee.addStep(PlanRun, cmd)
+	step.Stdin = piped
+	ee.planFiles(cmd, step.Dir)
+	if cmd.Redirect != nil {
+		ee.planRedirect(cmd.Redirect, cmd.Name)
+	}
+	return &CommandResult{Command: cmd, Success: true, Simulated: true}
+}
+
+// planFiles records the files the arguments of a command read and write
+func (ee *ExecutionEngine) planFiles(cmd *types.CommandNode, dir string) {
+	for _, file := range ee.security.CommandFiles(cmd, dir) {
+		access := "read"
+		switch file.Access {
+		case sandbox.PermWrite:
+			access = "write"
+		case sandbox.PermRead | sandbox.PermWrite:
+			access = "read/write"
+		}
+		ee.addFile(file.Path, access, cmd.Name, cmd.Pos)
+	}
+}
+
+// planViolation records a command the security policy refuses
+func (ee *ExecutionEngine) planViolation(cmd *types.CommandNode, result *CommandResult) {
+	if ee.plan == nil || result == nil || result.Violation == "" || result.Approval != "" {
+		return
+	}
+	ee.addStep(PlanBlocked, cmd).Reason = result.Violation
+}
+
+// planWriteFile records a WriteFile call
+func (ee *ExecutionEngine) planWriteFile(cmd *types.CommandNode, path string) {
+	ee.addStep(PlanWrite, cmd)
+	ee.addFile(ee.resolvePath(path), "write", cmd.Name, cmd.Pos)
+}
+
+// planChangeDir records a ChangeDir call and moves the engine's working
+// directory, so later steps resolve paths as they would. The process
+// itself stays where it is.
+func (ee *ExecutionEngine) planChangeDir(cmd *types.CommandNode, dir string) {
+	step := ee.addStep(PlanChdir, cmd)
+	err := ee.envManager.ChangeDir(dir)
+	if err == nil {
+		return
+	}
+	// The directory may be created by a step that was only recorded
+	if target := ee.resolvePath(dir); ee.plan.writesPath(target) {
+		ee.envManager.SetWorkingDir(target)
+		return
+	}
+	step.Reason = err.Error()
+}
+
+// planFetch records an HTTP request and the file it would write
+func (ee *ExecutionEngine) planFetch(cmd *types.CommandNode) {
+	step := ee.addStep(PlanFetch, cmd)
+	step.Stdin = ee.hasRedirectedStdin()
+	if call, err := parseHTTPArgs(cmd.Name, cmd.Args); err == nil && call.output != "" {
+		ee.addFile(ee.resolvePath(call.output), "write", cmd.Name, cmd.Pos)
+	}
+}
+
+// simulateStdLib records the standard library functions that change the
+// file system, working directory or process environment, or use the
+// network. It reports whether the function was handled.
+func (ee *ExecutionEngine) simulateStdLib(cmd *types.CommandNode) (*CommandResult, bool) {
+	var output string
+	switch {
+	case cmd.Name == "WriteFile" && len(cmd.Args) >= 2:
+		ee.planWriteFile(cmd, cmd.Args[0])
+		output = "File written"
+	case cmd.Name == "ChangeDir" && len(cmd.Args) >= 1:
+		ee.planChangeDir(cmd, cmd.Args[0])
+		output = "Directory changed"
+	case httpFunctions[cmd.Name]:
+		ee.planFetch(cmd)
+	case fileChanges[cmd.Name]:
+		step := ee.addStep(PlanWrite, cmd)
+		step.Stdin = ee.hasRedirectedStdin()
+		ee.planFiles(cmd, step.Dir)
+	case cmd.Name == "SetEnv" && len(cmd.Args) >= 2:
+		// Shown with the environment changes of the plan
+		ee.envManager.SetEnv(cmd.Args[0], cmd.Args[1])
+		output = "Environment variable set"
+	default:
+		return nil, false
+	}
+	return &CommandResult{Command: cmd, Success: true, Output: output, Simulated: true}, true
+}
+
+// openDryRunOutput records an output redirection and returns a file that
+// discards what is written to it
+func (ee *ExecutionEngine) openDryRunOutput(redirect *types.RedirectNode) (*os.File, error) {
+	ee.planRedirect(redirect, "")
+	file, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", os.DevNull, err)
+	}
+	return file, nil
+}
+
+// FormatPlan renders a plan as text for review
+func FormatPlan(plan *Plan) string {
+	var b strings.Builder
+	location := func(script string, line int) string {
+		switch {
+		case script == "":
+			return fmt.Sprintf("line %d", line)
+		case line == 0:
+			return script
+		}
+		return fmt.Sprintf("%s:%d", script, line)
+	}
+
+	fmt.Fprintf(&b, "Commands (%d):\n", len(plan.Steps))
+	for _, step := range plan.Steps {
+		fmt.Fprintf(&b, "  %-8s %s", step.Action, step.Command)
+		if step.Stdin {
+			b.WriteString(" < pipe")
+		}
+		fmt.Fprintf(&b, "\n           at %s in %s\n", location(step.Script, step.Line), step.Dir)
+		if step.Reason != "" {
+			fmt.Fprintf(&b, "           %s\n", step.Reason)
+		}
+	}
+	if plan.Truncated {
+		fmt.Fprintf(&b, "  ... stopped simulating after %d commands\n", maxPlanSteps)
+	}
+
+	fmt.Fprintf(&b, "Files (%d):\n", len(plan.Files))
+	for _, file := range plan.Files {
+		command := file.Command
+		if command == "" {
+			command = "redirection"
+		}
+		fmt.Fprintf(&b, "  %-10s %s (%s, %s)\n", file.Access, file.Path, command, location(file.Script, file.Line))
+	}
+
+	names := make([]string, 0, len(plan.Env))
+	for name := range plan.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(&b, "Environment (%d):\n", len(names))
+	for _, name := range names {
+		if value := plan.Env[name]; value != nil {
+			fmt.Fprintf(&b, "  %s=%s\n", name, *value)
+		} else {
+			fmt.Fprintf(&b, "  unset %s\n", name)
+		}
+	}
+	fmt.Fprintf(&b, "Working directory: %s\n", plan.Dir)
+	return b.String()
+}
@@ -0,0 +1,215 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"gitee.com/com_818cloud/shode/pkg/types"
+)
+
+// setStdin replaces the stdin stream seen by builtins and child processes,
+// returning a function that restores the previous stream
+func (ee *ExecutionEngine) setStdin(r io.Reader) func() {
+	previous := ee.stdin
+	ee.stdin = r
+	return func() { ee.stdin = previous }
+}
+
+// hasRedirectedStdin reports whether the script piped or redirected the
+// input of the running command, e.g. with a pipe or a `< file` redirection.
+// shode's own stdin does not count: under CI, cron or </dev/null it is not
+// input meant for the command.
+func (ee *ExecutionEngine) hasRedirectedStdin() bool {
+	return ee.stdin != os.Stdin
+}
+
+// stdlibInput returns the argument at index i of a standard library
+// function. "-" reads stdin, shode's own included; a missing argument reads
+// the input the script piped or redirected.
+func (ee *ExecutionEngine) stdlibInput(funcName string, args []string, i int) (string, error) {
+	if i < len(args) && args[i] != "-" {
+		return args[i], nil
+	}
+	if i >= len(args) && !ee.hasRedirectedStdin() {
+		return "", fmt.Errorf("%s requires an input argument or piped input", funcName)
+	}
+	return ee.library().ReadInput()
+}
+
+// outputLines ends non-empty output with a newline, as functions print
+// lines
+func outputLines(output string) string {
+	if output == "" {
+		return ""
+	}
+	return output + "\n"
+}
+
+// resolvePath resolves a redirection target against the working directory
+func (ee *ExecutionEngine) resolvePath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(ee.envManager.GetWorkingDir(), path)
+}
+
+// openInput opens the source of an input redirection
+func (ee *ExecutionEngine) openInput(redirect *types.RedirectNode) (*os.File, error) {
+	if ee.plan != nil {
+		ee.planRedirect(redirect, "")
+	}
+	file, err := ee.openFile(ee.resolvePath(redirect.File), os.O_RDONLY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s: %v", redirect.File, err)
+	}
+	return file, nil
+}
+
+// openOutput opens the target of an output redirection
+func (ee *ExecutionEngine) openOutput(redirect *types.RedirectNode) (*os.File, error) {
+	if ee.plan != nil {
+		return ee.openDryRunOutput(redirect)
+	}
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if redirect.Op == ">>" {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	file, err := ee.openFile(ee.resolvePath(redirect.File), flags, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s: %v", redirect.File, err)
+	}
+	return file, nil
+}
+
+// redirectOutput routes the captured output of an interpreted command or
+// loop according to redirect: into a file, or between stdout and stderr
+func (ee *ExecutionEngine) redirectOutput(redirect *types.RedirectNode, output, errOutput *string) error {
+	switch redirect.Op {
+	case "<":
+		return nil
+	case "2>&1":
+		*output += *errOutput
+		*errOutput = ""
+		return nil
+	case ">&2":
+		*errOutput += *output
+		*output = ""
+		return nil
+	}
+
+	file, err := ee.openOutput(redirect)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var data string
+	switch {
+	case redirect.Op == "&>":
+		data = *output + *errOutput
+		*output, *errOutput = "", ""
+	case redirect.Fd == 2:
+		data = *errOutput
+		*errOutput = ""
+	default:
+		data = *output
+		*output = ""
+	}
+	_, err = file.WriteString(data)
+	return err
+}
+
+// setupRedirect sets up input/output redirection for a command. Streams that
+// are not redirected are captured in stdout and stderr. The returned file, if
+// any, must be closed once the command has finished.
+func (ee *ExecutionEngine) setupRedirect(cmd *exec.Cmd, redirect *types.RedirectNode, stdout, stderr *strings.Builder) (*os.File, error) {
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	switch redirect.Op {
+	case ">", ">>": // Output redirection (overwrite or append)
+		file, err := ee.openOutput(redirect)
+		if err != nil {
+			return nil, err
+		}
+		if redirect.Fd == 2 {
+			cmd.Stderr = file
+		} else {
+			cmd.Stdout = file
+		}
+		return file, nil
+
+	case "<": // Input redirection
+		file, err := ee.openInput(redirect)
+		if err != nil {
+			return nil, err
+		}
+		cmd.Stdin = file
+		return file, nil
+
+	case "2>&1": // Redirect stderr to stdout
+		cmd.Stderr = stdout
+
+	case ">&2": // Redirect stdout to stderr
+		cmd.Stdout = stderr
+
+	case "&>": // Redirect both stdout and stderr to file
+		file, err := ee.openOutput(redirect)
+		if err != nil {
+			return nil, err
+		}
+		cmd.Stdout = file
+		cmd.Stderr = file
+		return file, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported redirect operator: %s", redirect.Op)
+	}
+
+	return nil, nil
+}
+
+// withLoopRedirect runs a loop with the redirection written after its done:
+// `< file` becomes the stdin of the whole loop, output redirections apply to
+// everything the loop printed. The file is shared unbuffered, so read and
+// child processes in the body see the same offset.
+func (ee *ExecutionEngine) withLoopRedirect(redirect *types.RedirectNode, run func() (*ExecutionResult, error)) (*ExecutionResult, error) {
+	if redirect == nil {
+		return run()
+	}
+
+	target := *redirect
+	file, err := ee.expandString(redirect.File)
+	if err != nil {
+		return &ExecutionResult{ExitCode: 1, Error: terminateLine(err.Error())}, nil
+	}
+	target.File = file
+	if err := ee.security.CheckRedirect(&target, ee.envManager.GetWorkingDir()); err != nil {
+		return &ExecutionResult{ExitCode: 1, Error: terminateLine(err.Error())}, nil
+	}
+
+	if target.Op == "<" {
+		file, err := ee.openInput(&target)
+		if err != nil {
+			return &ExecutionResult{ExitCode: 1, Error: fmt.Sprintf("redirect error: %v", err)}, nil
+		}
+		defer file.Close()
+		defer ee.setStdin(file)()
+		return run()
+	}
+
+	result, err := run()
+	if err != nil {
+		return nil, err
+	}
+	if err := ee.redirectOutput(&target, &result.Output, &result.Error); err != nil {
+		result.Error += fmt.Sprintf("redirect error: %v", err)
+		result.ExitCode = 1
+		result.Success = false
+	}
+	return result, nil
+}
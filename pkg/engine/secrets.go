@@ -0,0 +1,87 @@
+package engine
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"gitee.com/com_818cloud/shode/pkg/sandbox"
+)
+
+// MarkSecret makes a variable secret: its value is masked in the output
+// and errors of the engine, audit records and dry-run plans. The mark
+// belongs to the engine, so it ends with the run or REPL session and does
+// not reach other engines sharing the security checker.
+func (ee *ExecutionEngine) MarkSecret(name string) {
+	ee.secrets[strings.ToUpper(name)] = true
+}
+
+// IsSecret reports whether a variable holds a secret: the security
+// checker considers it one, or it was marked by MarkSecret
+func (ee *ExecutionEngine) IsSecret(name string) bool {
+	return ee.secrets[strings.ToUpper(name)] || ee.security.IsSecret(name)
+}
+
+// Redact replaces the values of secret variables in s with ***
+func (ee *ExecutionEngine) Redact(s string) string {
+	return ee.redactor()(s)
+}
+
+// redactor returns a function masking the current values of the secret
+// variables, including those set in the process environment by SetEnv
+func (ee *ExecutionEngine) redactor() func(string) string {
+	env := ee.envManager.GetAllEnv()
+	for _, entry := range os.Environ() {
+		if name, value, ok := strings.Cut(entry, "="); ok {
+			if _, exists := env[name]; !exists {
+				env[name] = value
+			}
+		}
+	}
+	return ee.security.SecretMasker(env, ee.secrets)
+}
+
+// redactResult masks secrets in the output and errors of a script result
+func (ee *ExecutionEngine) redactResult(result *ExecutionResult, err error) (*ExecutionResult, error) {
+	redact := ee.redactor()
+	if err != nil {
+		if message := redact(err.Error()); message != err.Error() {
+			err = errors.New(message)
+		}
+	}
+	if result == nil {
+		return nil, err
+	}
+	result.Output = redact(result.Output)
+	result.Error = redact(result.Error)
+	for _, cmdResult := range result.Commands {
+		cmdResult.Output = redact(cmdResult.Output)
+		cmdResult.Error = redact(cmdResult.Error)
+		cmdResult.Violation = redact(cmdResult.Violation)
+	}
+	return result, err
+}
+
+// maskEnv masks the values of secret variables in an environment diff
+func (ee *ExecutionEngine) maskEnv(diff map[string]*string, redact func(string) string) map[string]*string {
+	for name, value := range diff {
+		if value == nil {
+			continue
+		}
+		masked := redact(*value)
+		if ee.IsSecret(name) {
+			masked = sandbox.SecretMask
+		}
+		diff[name] = &masked
+	}
+	return diff
+}
+
+// redactAll masks secrets in each of a list of words
+func redactAll(redact func(string) string, words []string) []string {
+	masked := make([]string, len(words))
+	for i, word := range words {
+		masked[i] = redact(word)
+	}
+	return masked
+}
@@ -0,0 +1,265 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gitee.com/com_818cloud/shode/pkg/parser"
+	"gitee.com/com_818cloud/shode/pkg/sandbox"
+	"gitee.com/com_818cloud/shode/pkg/types"
+)
+
+// maxSourceDepth bounds nested source calls, catching scripts that source
+// themselves
+const maxSourceDepth = 100
+
+// sourceFrame is an entry of the script call stack
+type sourceFrame struct {
+	file     string // script being executed
+	callLine int    // line of the source command in the calling script
+	path     string // absolute path of the script; empty if it is not a file
+}
+
+func init() {
+	// source executes scripts, which dispatch builtins again, so it is
+	// registered here rather than in the builtins literal to avoid an
+	// initialization cycle
+	builtins["source"] = (*ExecutionEngine).builtinSource
+	builtins["."] = (*ExecutionEngine).builtinSource
+}
+
+// SetScriptArgs sets $0 and the positional parameters of the script being
+// run, and makes it the bottom of the call stack
+func (ee *ExecutionEngine) SetScriptArgs(scriptName string, args []string) {
+	ee.scriptName = scriptName
+	ee.positional = append([]string(nil), args...)
+	ee.callStack = []sourceFrame{{file: scriptName}}
+	ee.updateCallStackVars()
+}
+
+// SetScriptFile records the file of the script being run, which context
+// rules of the security policy match against
+func (ee *ExecutionEngine) SetScriptFile(path string) {
+	if len(ee.callStack) == 0 {
+		ee.callStack = []sourceFrame{{file: path}}
+	}
+	ee.callStack[0].path = ee.resolvePath(path)
+}
+
+// PositionalArgs returns the current positional parameters $1..$n
+func (ee *ExecutionEngine) PositionalArgs() []string {
+	return append([]string(nil), ee.positional...)
+}
+
+// CallStack returns the files of the script call stack, innermost first,
+// like BASH_SOURCE
+func (ee *ExecutionEngine) CallStack() []string {
+	files := make([]string, len(ee.callStack))
+	for i, frame := range ee.callStack {
+		files[len(files)-1-i] = frame.file
+	}
+	return files
+}
+
+// positionalParam returns $n; $0 is the script name
+func (ee *ExecutionEngine) positionalParam(n int) string {
+	if n == 0 {
+		return ee.scriptName
+	}
+	if n > len(ee.positional) {
+		return ""
+	}
+	return ee.positional[n-1]
+}
+
+// updateCallStackVars mirrors the call stack into BASH_SOURCE and BASH_LINENO
+func (ee *ExecutionEngine) updateCallStackVars() {
+	ee.envManager.UnsetEnv("BASH_SOURCE")
+	ee.envManager.UnsetEnv("BASH_LINENO")
+	if len(ee.callStack) == 0 {
+		return
+	}
+
+	sources := make([]string, len(ee.callStack))
+	lines := make([]string, len(ee.callStack))
+	for i, frame := range ee.callStack {
+		sources[len(sources)-1-i] = frame.file
+		// BASH_LINENO[i] is the line in BASH_SOURCE[i+1] that called frame i
+		lines[len(lines)-1-i] = strconv.Itoa(frame.callLine)
+	}
+	ee.envManager.SetArray("BASH_SOURCE", sources)
+	ee.envManager.SetArray("BASH_LINENO", lines)
+}
+
+// diagnostic prefixes an error message produced by the engine with the
+// script location, the way bash reports "script.sh: line 3: message"
+func (ee *ExecutionEngine) diagnostic(cmd *types.CommandNode, msg string) string {
+	if msg == "" || len(ee.callStack) == 0 {
+		return msg
+	}
+	file := ee.callStack[len(ee.callStack)-1].file
+	return fmt.Sprintf("%s: line %d: %s", file, cmd.Pos.Line, msg)
+}
+
+// builtinSource implements source file [args] and . file [args]
+func (ee *ExecutionEngine) builtinSource(ctx context.Context, cmd *types.CommandNode) (*CommandResult, error) {
+	if len(cmd.Args) == 0 {
+		return ee.sourceFailure(cmd, fmt.Errorf("filename argument required")), nil
+	}
+	if len(ee.callStack) >= maxSourceDepth {
+		return ee.sourceFailure(cmd, fmt.Errorf("maximum source nesting level exceeded (%d)", maxSourceDepth)), nil
+	}
+
+	file := cmd.Args[0]
+	path, err := ee.findSourceFile(file)
+	if err != nil {
+		return ee.sourceFailure(cmd, err), nil
+	}
+
+	script, err := parser.NewSimpleParser().ParseFile(path)
+	if err != nil {
+		return ee.sourceFailure(cmd, fmt.Errorf("%s: %v", file, err)), nil
+	}
+
+	// Arguments replace the positional parameters while the file runs;
+	// without them the file shares the caller's
+	if len(cmd.Args) > 1 {
+		saved := ee.positional
+		ee.positional = append([]string(nil), cmd.Args[1:]...)
+		defer func() { ee.positional = saved }()
+	}
+
+	ee.callStack = append(ee.callStack, sourceFrame{file: file, callLine: cmd.Pos.Line, path: ee.resolvePath(path)})
+	ee.updateCallStackVars()
+	defer func() {
+		ee.callStack = ee.callStack[:len(ee.callStack)-1]
+		ee.updateCallStackVars()
+	}()
+
+	result, err := ee.Execute(ctx, script)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+
+	return &CommandResult{
+		Command:  cmd,
+		Success:  result.Success,
+		ExitCode: result.ExitCode,
+		Output:   result.Output,
+		Error:    result.Error,
+	}, nil
+}
+
+// sourceFailure reports an error of the source builtin itself. The engine
+// leaves source results unprefixed, since a sourced script's errors carry
+// their own locations, so the caller's location is added here.
+func (ee *ExecutionEngine) sourceFailure(cmd *types.CommandNode, err error) *CommandResult {
+	result := builtinResult(cmd, "", err)
+	result.Error = ee.diagnostic(cmd, result.Error)
+	return result
+}
+
+// findSourceFile resolves a sourced file: paths containing a slash are
+// relative to the working directory, bare names are searched in PATH and
+// then the working directory
+func (ee *ExecutionEngine) findSourceFile(file string) (string, error) {
+	var candidates []string
+	if strings.Contains(file, "/") {
+		candidates = append(candidates, ee.resolvePath(file))
+	} else {
+		for _, dir := range filepath.SplitList(ee.envManager.GetEnv("PATH")) {
+			if dir != "" {
+				candidates = append(candidates, filepath.Join(dir, file))
+			}
+		}
+		candidates = append(candidates, ee.resolvePath(file))
+	}
+
+	for _, candidate := range candidates {
+		if info, err := ee.stat(candidate); err == nil && !info.IsDir() {
+			return ee.readablePath(candidate), nil
+		}
+	}
+	return "", fmt.Errorf("%s: No such file or directory", file)
+}
+
+// builtinShift implements shift [n]
+func (ee *ExecutionEngine) builtinShift(ctx context.Context, cmd *types.CommandNode) (*CommandResult, error) {
+	n := 1
+	if len(cmd.Args) > 0 {
+		value, err := strconv.Atoi(cmd.Args[0])
+		if err != nil || value < 0 {
+			return builtinResult(cmd, "", fmt.Errorf("%s: numeric argument required", cmd.Args[0])), nil
+		}
+		n = value
+	}
+
+	if n > len(ee.positional) {
+		return &CommandResult{Command: cmd, Success: false, ExitCode: 1}, nil
+	}
+	ee.positional = ee.positional[n:]
+	return builtinResult(cmd, "", nil), nil
+}
+
+// builtinExit implements exit [n], stopping the script with status n, or
+// with the status of the last command when n is missing. Like bash, exit
+// in a pipeline only ends its own part of the pipeline.
+func (ee *ExecutionEngine) builtinExit(ctx context.Context, cmd *types.CommandNode) (*CommandResult, error) {
+	status := ee.status
+	if len(cmd.Args) > 0 {
+		value, err := strconv.Atoi(cmd.Args[0])
+		if err != nil {
+			ee.exiting = ee.pipe.size == 0
+			result := builtinResult(cmd, "", fmt.Errorf("%s: numeric argument required", cmd.Args[0]))
+			result.ExitCode = 2
+			return result, nil
+		}
+		if len(cmd.Args) > 1 {
+			return builtinResult(cmd, "", fmt.Errorf("too many arguments")), nil
+		}
+		// The status is taken modulo 256, as the process exit status is
+		status = value & 0xff
+	}
+
+	ee.exiting = ee.pipe.size == 0
+	return &CommandResult{Command: cmd, Success: status == 0, ExitCode: status}, nil
+}
+
+// builtinSet implements set [--] [arg ...], replacing the positional
+// parameters
+func (ee *ExecutionEngine) builtinSet(ctx context.Context, cmd *types.CommandNode) (*CommandResult, error) {
+	args := cmd.Args
+	if len(args) == 0 {
+		// Without arguments set lists the shell variables
+		env := ee.envManager.GetAllEnv()
+		names := make([]string, 0, len(env))
+		for name := range env {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		var output strings.Builder
+		for _, name := range names {
+			value := shellQuote(env[name])
+			if ee.IsSecret(name) {
+				value = sandbox.SecretMask
+			}
+			fmt.Fprintf(&output, "%s=%s\n", name, value)
+		}
+		return builtinResult(cmd, output.String(), nil), nil
+	}
+
+	switch {
+	case args[0] == "--":
+		args = args[1:]
+	case strings.HasPrefix(args[0], "-") || strings.HasPrefix(args[0], "+"):
+		return builtinResult(cmd, "", fmt.Errorf("%s: invalid option", args[0])), nil
+	}
+
+	ee.positional = append([]string(nil), args...)
+	return builtinResult(cmd, "", nil), nil
+}
@@ -0,0 +1,189 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gitee.com/com_818cloud/shode/pkg/stdlib"
+)
+
+// errNoMatch makes a standard library function fail without a message,
+// as grep does when nothing matches
+var errNoMatch = errors.New("no match")
+
+// textFunctions are the regex and text functions of the standard library
+var textFunctions = map[string]bool{
+	"RegexMatch":   true,
+	"RegexFindAll": true,
+	"RegexReplace": true,
+	"FilterLines":  true,
+	"RejectLines":  true,
+	"Fields":       true,
+	"SortLines":    true,
+	"UniqueLines":  true,
+	"CountLines":   true,
+	"CountWords":   true,
+	"CountChars":   true,
+	"HeadLines":    true,
+	"TailLines":    true,
+	"Translate":    true,
+	"DeleteChars":  true,
+}
+
+// executeTextFunction runs a regex or text function of the standard
+// library. The text is the last argument; when it is missing or "-" it is
+// read from the piped input.
+func (ee *ExecutionEngine) executeTextFunction(funcName string, args []string) (string, error) {
+	output, err := ee.runTextFunction(funcName, args)
+	if err != nil && err != errNoMatch && !strings.HasPrefix(err.Error(), funcName) {
+		err = fmt.Errorf("%s: %v", funcName, err)
+	}
+	return output, err
+}
+
+// runTextFunction is executeTextFunction without the function name in errors
+func (ee *ExecutionEngine) runTextFunction(funcName string, args []string) (string, error) {
+	// Number of arguments before the text
+	required := map[string]int{
+		"RegexMatch": 1, "RegexFindAll": 1, "RegexReplace": 2,
+		"FilterLines": 1, "RejectLines": 1, "Fields": 2,
+		"HeadLines": 1, "TailLines": 1, "Translate": 2, "DeleteChars": 1,
+	}[funcName]
+	if len(args) < required {
+		return "", fmt.Errorf("%s requires %d arguments before the text", funcName, required)
+	}
+
+	switch funcName {
+	case "SortLines":
+		options, rest, err := parseSortArgs(args)
+		if err != nil {
+			return "", err
+		}
+		text, err := ee.stdlibInput(funcName, rest, 0)
+		if err != nil {
+			return "", err
+		}
+		return outputLines(strings.Join(ee.stdlib.SortLines(text, options), "\n")), nil
+	case "UniqueLines":
+		count := len(args) > 0 && args[0] == "-c"
+		if count {
+			args = args[1:]
+		}
+		text, err := ee.stdlibInput(funcName, args, 0)
+		if err != nil {
+			return "", err
+		}
+		return outputLines(strings.Join(ee.stdlib.UniqueLines(text, count), "\n")), nil
+	}
+
+	text, err := ee.stdlibInput(funcName, args, required)
+	if err != nil {
+		return "", err
+	}
+	switch funcName {
+	case "RegexMatch":
+		groups, err := ee.stdlib.RegexMatch(text, args[0])
+		if err != nil {
+			return "", err
+		}
+		// The groups are kept in MATCH, as bash keeps them in BASH_REMATCH
+		ee.envManager.SetArray("MATCH", groups)
+		if groups == nil {
+			return "", errNoMatch
+		}
+		return outputLines(groups[0]), nil
+	case "RegexFindAll", "FilterLines", "RejectLines":
+		lines, err := ee.textLines(funcName, text, args)
+		if err != nil {
+			return "", err
+		}
+		if len(lines) == 0 {
+			return "", errNoMatch
+		}
+		return outputLines(strings.Join(lines, "\n")), nil
+	case "Fields", "HeadLines", "TailLines":
+		lines, err := ee.textLines(funcName, text, args)
+		return outputLines(strings.Join(lines, "\n")), err
+	case "RegexReplace":
+		return ee.stdlib.RegexReplace(text, args[0], args[1])
+	case "Translate":
+		return ee.stdlib.Translate(text, args[0], args[1])
+	case "DeleteChars":
+		return ee.stdlib.DeleteChars(text, args[0])
+	case "CountLines":
+		return outputLines(strconv.Itoa(ee.stdlib.CountLines(text))), nil
+	case "CountWords":
+		return outputLines(strconv.Itoa(ee.stdlib.CountWords(text))), nil
+	case "CountChars":
+		return outputLines(strconv.Itoa(ee.stdlib.CountChars(text))), nil
+	}
+	return "", fmt.Errorf("unknown standard library function: %s", funcName)
+}
+
+// textLines runs the text functions that return lines
+func (ee *ExecutionEngine) textLines(funcName, text string, args []string) ([]string, error) {
+	switch funcName {
+	case "RegexFindAll":
+		return ee.stdlib.RegexFindAll(text, args[0])
+	case "FilterLines":
+		return ee.stdlib.FilterLines(text, args[0])
+	case "RejectLines":
+		return ee.stdlib.RejectLines(text, args[0])
+	case "Fields":
+		return ee.stdlib.Fields(text, args[0], args[1])
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid number of lines %q", args[0])
+	}
+	if funcName == "HeadLines" {
+		return ee.stdlib.HeadLines(text, n), nil
+	}
+	return ee.stdlib.TailLines(text, n), nil
+}
+
+// parseSortArgs parses the options of SortLines: -n, -r and -u, which may
+// be combined, -k field and -t delimiter, ended by the first other
+// argument or --
+func parseSortArgs(args []string) (stdlib.SortOptions, []string, error) {
+	var options stdlib.SortOptions
+	for len(args) > 0 && len(args[0]) > 1 && strings.HasPrefix(args[0], "-") {
+		arg := args[0]
+		args = args[1:]
+		if arg == "--" {
+			break
+		}
+		for i := 1; i < len(arg); i++ {
+			switch flag := arg[i]; flag {
+			case 'n':
+				options.Numeric = true
+			case 'r':
+				options.Reverse = true
+			case 'u':
+				options.Unique = true
+			case 'k', 't':
+				// The value is the rest of the argument or the next one
+				value := arg[i+1:]
+				if value == "" {
+					if len(args) == 0 {
+						return options, nil, fmt.Errorf("option -%c requires a value", flag)
+					}
+					value, args = args[0], args[1:]
+				}
+				if flag == 't' {
+					options.Delimiter = value
+				} else if key, err := strconv.Atoi(value); err != nil || key < 1 {
+					return options, nil, fmt.Errorf("invalid sort key %q", value)
+				} else {
+					options.Key = key
+				}
+				i = len(arg)
+			default:
+				return options, nil, fmt.Errorf("unknown option -%c", flag)
+			}
+		}
+	}
+	return options, args, nil
+}
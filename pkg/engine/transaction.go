@@ -0,0 +1,69 @@
+package engine
+
+import (
+	"os"
+
+	"gitee.com/com_818cloud/shode/pkg/sandbox"
+)
+
+// SetTransaction runs commands against the copy-on-write view of tx:
+// external commands through the sandbox, and redirections, pathname
+// expansion, source and the standard library functions that use files
+// through tx itself. Paths keep their real names. A nil tx runs commands against
+// the real files again.
+func (ee *ExecutionEngine) SetTransaction(tx *sandbox.Transaction) {
+	ee.transaction = tx
+	ee.security.SetTransaction(tx)
+}
+
+// processDir returns the directory external commands start in
+func (ee *ExecutionEngine) processDir() string {
+	dir := ee.envManager.GetWorkingDir()
+	if ee.transaction != nil {
+		return ee.transaction.Dir(dir)
+	}
+	return dir
+}
+
+// openFile opens a file as the script sees it
+func (ee *ExecutionEngine) openFile(path string, flag int, perm os.FileMode) (*os.File, error) {
+	if ee.transaction != nil {
+		return ee.transaction.OpenFile(path, flag, perm)
+	}
+	return os.OpenFile(path, flag, perm)
+}
+
+// stat returns the file info of a path as the script sees it
+func (ee *ExecutionEngine) stat(path string) (os.FileInfo, error) {
+	if ee.transaction != nil {
+		return ee.transaction.Stat(path)
+	}
+	return os.Stat(path)
+}
+
+// lstat is stat without following a final symlink
+func (ee *ExecutionEngine) lstat(path string) (os.FileInfo, error) {
+	if ee.transaction != nil {
+		return ee.transaction.Lstat(path)
+	}
+	return os.Lstat(path)
+}
+
+// readDir lists a directory as the script sees it
+func (ee *ExecutionEngine) readDir(dir string) ([]os.DirEntry, error) {
+	if ee.transaction != nil {
+		return ee.transaction.ReadDir(dir)
+	}
+	return os.ReadDir(dir)
+}
+
+// readablePath returns the file holding the content of a path as the
+// script sees it
+func (ee *ExecutionEngine) readablePath(path string) string {
+	if ee.transaction != nil {
+		if resolved, err := ee.transaction.Resolve(path); err == nil {
+			return resolved
+		}
+	}
+	return path
+}
@@ -0,0 +1,227 @@
+package environment
+
+import "sort"
+
+// Arrays are kept apart from the scalar environment: they are never exported
+// to child processes, mirroring bash where arrays cannot be exported.
+
+// DeclareArray declares an empty indexed array, replacing any scalar value
+func (em *EnvironmentManager) DeclareArray(name string) {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	if _, exists := em.indexedArrays[name]; exists {
+		return
+	}
+	em.convertScalarLocked(name)
+	if em.indexedArrays[name] == nil {
+		em.indexedArrays[name] = make(map[int]string)
+	}
+}
+
+// DeclareAssocArray declares an empty associative array
+func (em *EnvironmentManager) DeclareAssocArray(name string) {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	if _, exists := em.assocArrays[name]; exists {
+		return
+	}
+	delete(em.environment, name)
+	delete(em.indexedArrays, name)
+	em.assocArrays[name] = make(map[string]string)
+}
+
+// convertScalarLocked turns a scalar variable into element 0 of an indexed
+// array, as bash does when a scalar is first used as an array
+func (em *EnvironmentManager) convertScalarLocked(name string) {
+	value, exists := em.environment[name]
+	if !exists {
+		return
+	}
+	delete(em.environment, name)
+	em.indexedArrays[name] = map[int]string{0: value}
+}
+
+// SetArray replaces the contents of an indexed array
+func (em *EnvironmentManager) SetArray(name string, values []string) {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	delete(em.environment, name)
+	delete(em.assocArrays, name)
+
+	elements := make(map[int]string, len(values))
+	for i, value := range values {
+		elements[i] = value
+	}
+	em.indexedArrays[name] = elements
+}
+
+// AppendArray appends values after the highest index of an indexed array,
+// creating the array if needed
+func (em *EnvironmentManager) AppendArray(name string, values ...string) {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	em.convertScalarLocked(name)
+	elements := em.indexedArrays[name]
+	if elements == nil {
+		elements = make(map[int]string)
+		em.indexedArrays[name] = elements
+	}
+
+	next := 0
+	for index := range elements {
+		if index >= next {
+			next = index + 1
+		}
+	}
+	for _, value := range values {
+		elements[next] = value
+		next++
+	}
+}
+
+// SetArrayElement sets a single element of an indexed array
+func (em *EnvironmentManager) SetArrayElement(name string, index int, value string) {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	em.convertScalarLocked(name)
+	if em.indexedArrays[name] == nil {
+		em.indexedArrays[name] = make(map[int]string)
+	}
+	em.indexedArrays[name][index] = value
+}
+
+// UnsetArrayElement removes a single element of an indexed array
+func (em *EnvironmentManager) UnsetArrayElement(name string, index int) {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	if elements, exists := em.indexedArrays[name]; exists {
+		delete(elements, index)
+	}
+}
+
+// GetArray returns the values of an indexed array in index order
+func (em *EnvironmentManager) GetArray(name string) ([]string, bool) {
+	em.mu.RLock()
+	defer em.mu.RUnlock()
+
+	elements, exists := em.indexedArrays[name]
+	if !exists {
+		return nil, false
+	}
+
+	values := make([]string, 0, len(elements))
+	for _, index := range sortedIndices(elements) {
+		values = append(values, elements[index])
+	}
+	return values, true
+}
+
+// GetArrayElement returns a single element of an indexed array
+func (em *EnvironmentManager) GetArrayElement(name string, index int) (string, bool) {
+	em.mu.RLock()
+	defer em.mu.RUnlock()
+
+	value, exists := em.indexedArrays[name][index]
+	return value, exists
+}
+
+// GetArrayIndices returns the set indices of an indexed array in ascending order
+func (em *EnvironmentManager) GetArrayIndices(name string) []int {
+	em.mu.RLock()
+	defer em.mu.RUnlock()
+	return sortedIndices(em.indexedArrays[name])
+}
+
+// SetAssocElement sets a key of an associative array, declaring it if needed
+func (em *EnvironmentManager) SetAssocElement(name, key, value string) {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	if em.assocArrays[name] == nil {
+		delete(em.environment, name)
+		delete(em.indexedArrays, name)
+		em.assocArrays[name] = make(map[string]string)
+	}
+	em.assocArrays[name][key] = value
+}
+
+// UnsetAssocElement removes a key from an associative array
+func (em *EnvironmentManager) UnsetAssocElement(name, key string) {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	if elements, exists := em.assocArrays[name]; exists {
+		delete(elements, key)
+	}
+}
+
+// GetAssocElement returns a single value of an associative array
+func (em *EnvironmentManager) GetAssocElement(name, key string) (string, bool) {
+	em.mu.RLock()
+	defer em.mu.RUnlock()
+
+	value, exists := em.assocArrays[name][key]
+	return value, exists
+}
+
+// GetAssocArray returns a copy of an associative array
+func (em *EnvironmentManager) GetAssocArray(name string) (map[string]string, bool) {
+	em.mu.RLock()
+	defer em.mu.RUnlock()
+
+	elements, exists := em.assocArrays[name]
+	if !exists {
+		return nil, false
+	}
+
+	assocCopy := make(map[string]string, len(elements))
+	for k, v := range elements {
+		assocCopy[k] = v
+	}
+	return assocCopy, true
+}
+
+// GetAssocKeys returns the keys of an associative array in sorted order
+func (em *EnvironmentManager) GetAssocKeys(name string) []string {
+	em.mu.RLock()
+	defer em.mu.RUnlock()
+
+	keys := make([]string, 0, len(em.assocArrays[name]))
+	for key := range em.assocArrays[name] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// IsArray reports whether name is an indexed array
+func (em *EnvironmentManager) IsArray(name string) bool {
+	em.mu.RLock()
+	defer em.mu.RUnlock()
+	_, exists := em.indexedArrays[name]
+	return exists
+}
+
+// IsAssocArray reports whether name is an associative array
+func (em *EnvironmentManager) IsAssocArray(name string) bool {
+	em.mu.RLock()
+	defer em.mu.RUnlock()
+	_, exists := em.assocArrays[name]
+	return exists
+}
+
+// sortedIndices returns the indices of an indexed array in ascending order
+func sortedIndices(elements map[int]string) []int {
+	indices := make([]int, 0, len(elements))
+	for index := range elements {
+		indices = append(indices, index)
+	}
+	sort.Ints(indices)
+	return indices
+}
@@ -13,13 +13,17 @@ type EnvironmentManager struct {
 	workingDir    string
 	environment   map[string]string
 	originalEnv   map[string]string // Original environment for restoration
+	indexedArrays map[string]map[int]string
+	assocArrays   map[string]map[string]string
 }
 
 // NewEnvironmentManager creates a new environment manager
 func NewEnvironmentManager() *EnvironmentManager {
 	em := &EnvironmentManager{
-		environment: make(map[string]string),
-		originalEnv: make(map[string]string),
+		environment:   make(map[string]string),
+		originalEnv:   make(map[string]string),
+		indexedArrays: make(map[string]map[int]string),
+		assocArrays:   make(map[string]map[string]string),
 	}
 
 	// Store original environment
@@ -80,6 +84,13 @@ func (em *EnvironmentManager) ChangeDir(dir string) error {
 	return nil
 }
 
+// SetWorkingDir sets the working directory without checking that it exists
+func (em *EnvironmentManager) SetWorkingDir(dir string) {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+	em.workingDir = filepath.Clean(dir)
+}
+
 // GetEnv gets an environment variable
 func (em *EnvironmentManager) GetEnv(key string) string {
 	em.mu.RLock()
@@ -94,11 +105,13 @@ func (em *EnvironmentManager) SetEnv(key, value string) {
 	em.environment[key] = value
 }
 
-// UnsetEnv removes an environment variable
+// UnsetEnv removes an environment variable or array
 func (em *EnvironmentManager) UnsetEnv(key string) {
 	em.mu.Lock()
 	defer em.mu.Unlock()
 	delete(em.environment, key)
+	delete(em.indexedArrays, key)
+	delete(em.assocArrays, key)
 }
 
 // GetAllEnv returns all environment variables
@@ -147,10 +147,13 @@ func (mm *ModuleManager) loadScriptExports(module *Module, scriptPath string) er
 
 	// Extract exports (functions starting with export_)
 	for _, node := range script.Nodes {
-		if cmdNode, ok := node.(*types.CommandNode); ok {
-			if strings.HasPrefix(cmdNode.Name, "export_") {
-				exportName := strings.TrimPrefix(cmdNode.Name, "export_")
-				module.Exports[exportName] = cmdNode
+		if fnNode, ok := node.(*types.FunctionNode); ok {
+			if strings.HasPrefix(fnNode.Name, "export_") {
+				exportName := strings.TrimPrefix(fnNode.Name, "export_")
+				module.Exports[exportName] = &types.CommandNode{
+					Pos:  fnNode.Pos,
+					Name: fnNode.Name,
+				}
 			}
 		}
 	}
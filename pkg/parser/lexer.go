@@ -0,0 +1,321 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gitee.com/com_818cloud/shode/pkg/types"
+)
+
+// tokenKind identifies the kind of a lexical token
+type tokenKind int
+
+const (
+	tokenWord     tokenKind = iota // A shell word, kept exactly as written
+	tokenOperator                  // Control or redirection operator
+	tokenNewline                   // End of line
+	tokenEOF                       // End of input
+)
+
+// token is a single lexical token of a shell script
+type token struct {
+	kind tokenKind
+	text string
+	pos  types.Position
+}
+
+// operators lists control and redirection operators, longest first
+var operators = []string{
+	"2>&1", "2>>", ">&2", "&>", ">>", "2>", "1>", "&&", "||", ";;",
+	"<", ">", "|", "&", ";", "(", ")",
+}
+
+// assignmentPrefix matches the start of a word that opens a compound
+// assignment such as arr=( or arr+=(
+var assignmentPrefix = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*\+?=$`)
+
+// lexer splits shell source into tokens
+type lexer struct {
+	src  string
+	pos  int
+	line int
+	col  int
+}
+
+// newLexer creates a lexer for the given source
+func newLexer(src string) *lexer {
+	return &lexer{src: src, line: 1, col: 1}
+}
+
+// tokenize returns all tokens of the source, ending with tokenEOF
+func (l *lexer) tokenize() ([]token, error) {
+	var tokens []token
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, tok)
+		if tok.kind == tokenEOF {
+			return tokens, nil
+		}
+	}
+}
+
+// position returns the current source position
+func (l *lexer) position() types.Position {
+	return types.Position{Line: l.line, Column: l.col, Offset: l.pos}
+}
+
+// advance consumes n bytes, keeping line and column up to date
+func (l *lexer) advance(n int) {
+	for i := 0; i < n && l.pos < len(l.src); i++ {
+		if l.src[l.pos] == '\n' {
+			l.line++
+			l.col = 1
+		} else {
+			l.col++
+		}
+		l.pos++
+	}
+}
+
+// next returns the next token
+func (l *lexer) next() (token, error) {
+	l.skipBlanks()
+
+	pos := l.position()
+	if l.pos >= len(l.src) {
+		return token{kind: tokenEOF, pos: pos}, nil
+	}
+
+	if l.src[l.pos] == '\n' {
+		l.advance(1)
+		return token{kind: tokenNewline, text: "\n", pos: pos}, nil
+	}
+
+	if l.src[l.pos] == '#' {
+		// Comment runs to the end of the line
+		for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+			l.advance(1)
+		}
+		return l.next()
+	}
+
+	for _, op := range operators {
+		if strings.HasPrefix(l.src[l.pos:], op) {
+			l.advance(len(op))
+			return token{kind: tokenOperator, text: op, pos: pos}, nil
+		}
+	}
+
+	word, err := l.readWord()
+	if err != nil {
+		return token{}, err
+	}
+	return token{kind: tokenWord, text: word, pos: pos}, nil
+}
+
+// skipBlanks skips spaces, tabs and escaped newlines
+func (l *lexer) skipBlanks() {
+	for l.pos < len(l.src) {
+		switch {
+		case l.src[l.pos] == ' ' || l.src[l.pos] == '\t' || l.src[l.pos] == '\r':
+			l.advance(1)
+		case strings.HasPrefix(l.src[l.pos:], "\\\n"):
+			l.advance(2)
+		default:
+			return
+		}
+	}
+}
+
+// isWordBreak reports whether c ends an unquoted word
+func isWordBreak(c byte) bool {
+	return strings.IndexByte(" \t\r\n;|&<>()", c) >= 0
+}
+
+// readWord reads a single word, keeping quotes and expansions intact
+func (l *lexer) readWord() (string, error) {
+	start := l.pos
+	startPos := l.position()
+
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+
+		if c == '(' && assignmentPrefix.MatchString(l.src[start:l.pos]) {
+			// Compound assignment: arr=(a b c)
+			if err := l.skipBalanced('(', ')'); err != nil {
+				return "", err
+			}
+			continue
+		}
+
+		if isWordBreak(c) {
+			break
+		}
+
+		switch c {
+		case '\\':
+			l.advance(2)
+		case '\'':
+			if err := l.skipSingleQuoted(); err != nil {
+				return "", err
+			}
+		case '"':
+			if err := l.skipDoubleQuoted(); err != nil {
+				return "", err
+			}
+		case '`':
+			if err := l.skipUntil('`'); err != nil {
+				return "", err
+			}
+		case '$':
+			if err := l.skipDollar(); err != nil {
+				return "", err
+			}
+		default:
+			l.advance(1)
+		}
+	}
+
+	if l.pos == start {
+		return "", fmt.Errorf("line %d: unexpected character %q", startPos.Line, l.src[l.pos])
+	}
+	return l.src[start:l.pos], nil
+}
+
+// skipSingleQuoted skips a '...' string
+func (l *lexer) skipSingleQuoted() error {
+	line := l.line
+	l.advance(1)
+	end := strings.IndexByte(l.src[l.pos:], '\'')
+	if end < 0 {
+		return fmt.Errorf("line %d: unterminated single quote", line)
+	}
+	l.advance(end + 1)
+	return nil
+}
+
+// skipDoubleQuoted skips a "..." string, including nested expansions
+func (l *lexer) skipDoubleQuoted() error {
+	line := l.line
+	l.advance(1)
+	for l.pos < len(l.src) {
+		switch l.src[l.pos] {
+		case '\\':
+			l.advance(2)
+		case '"':
+			l.advance(1)
+			return nil
+		case '`':
+			if err := l.skipUntil('`'); err != nil {
+				return err
+			}
+		case '$':
+			if err := l.skipDollar(); err != nil {
+				return err
+			}
+		default:
+			l.advance(1)
+		}
+	}
+	return fmt.Errorf("line %d: unterminated double quote", line)
+}
+
+// skipUntil skips from the current delimiter to its matching closing delimiter
+func (l *lexer) skipUntil(delim byte) error {
+	line := l.line
+	l.advance(1)
+	for l.pos < len(l.src) {
+		switch l.src[l.pos] {
+		case '\\':
+			l.advance(2)
+		case delim:
+			l.advance(1)
+			return nil
+		default:
+			l.advance(1)
+		}
+	}
+	return fmt.Errorf("line %d: unterminated %q", line, delim)
+}
+
+// skipDollar skips a $-expansion: $name, ${...}, $(...) or $((...))
+func (l *lexer) skipDollar() error {
+	if l.pos+1 < len(l.src) {
+		switch l.src[l.pos+1] {
+		case '{':
+			l.advance(1)
+			return l.skipBalanced('{', '}')
+		case '(':
+			l.advance(1)
+			return l.skipBalanced('(', ')')
+		}
+	}
+	l.advance(1)
+	return nil
+}
+
+// skipBalanced skips a bracketed region, honouring quotes and nesting
+func (l *lexer) skipBalanced(open, close byte) error {
+	line := l.line
+	depth := 0
+	for l.pos < len(l.src) {
+		switch c := l.src[l.pos]; c {
+		case '\\':
+			l.advance(2)
+		case '\'':
+			if err := l.skipSingleQuoted(); err != nil {
+				return err
+			}
+		case '"':
+			if err := l.skipDoubleQuoted(); err != nil {
+				return err
+			}
+		case open:
+			depth++
+			l.advance(1)
+		case close:
+			depth--
+			l.advance(1)
+			if depth == 0 {
+				return nil
+			}
+		default:
+			l.advance(1)
+		}
+	}
+	return fmt.Errorf("line %d: missing closing %q", line, close)
+}
+
+// removeQuotes strips quoting from a raw word without performing expansion
+func removeQuotes(word string) string {
+	var sb strings.Builder
+	inDouble := false
+
+	for i := 0; i < len(word); i++ {
+		c := word[i]
+		switch {
+		case c == '\'' && !inDouble:
+			end := strings.IndexByte(word[i+1:], '\'')
+			if end < 0 {
+				sb.WriteString(word[i+1:])
+				return sb.String()
+			}
+			sb.WriteString(word[i+1 : i+1+end])
+			i += end + 1
+		case c == '"':
+			inDouble = !inDouble
+		case c == '\\' && i+1 < len(word):
+			if !inDouble || strings.IndexByte("$`\"\\\n", word[i+1]) >= 0 {
+				i++
+			}
+			sb.WriteByte(word[i])
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,527 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gitee.com/com_818cloud/shode/pkg/types"
+)
+
+// assignmentWord matches name=value, name+=value and name[index]=value words
+var assignmentWord = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)(\[[^\]]*\])?(\+?)=`)
+
+// identifier matches a valid shell variable name
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// functionName matches a valid function name
+var functionName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.:-]*$`)
+
+// redirectOps maps redirection operators to their node operator and descriptor
+var redirectOps = map[string]struct {
+	op string
+	fd int
+}{
+	">":    {">", 1},
+	"1>":   {">", 1},
+	">>":   {">>", 1},
+	"2>":   {">", 2},
+	"2>>":  {">>", 2},
+	"<":    {"<", 0},
+	"&>":   {"&>", 1},
+	"2>&1": {"2>&1", 2},
+	">&2":  {">&2", 1},
+}
+
+// scriptParser builds AST nodes from a token stream
+type scriptParser struct {
+	tokens []token
+	pos    int
+}
+
+// peek returns the current token without consuming it
+func (sp *scriptParser) peek() token {
+	return sp.tokens[sp.pos]
+}
+
+// peekAt returns the token n positions ahead without consuming anything
+func (sp *scriptParser) peekAt(n int) token {
+	if sp.pos+n >= len(sp.tokens) {
+		return sp.tokens[len(sp.tokens)-1]
+	}
+	return sp.tokens[sp.pos+n]
+}
+
+// next consumes and returns the current token
+func (sp *scriptParser) next() token {
+	tok := sp.tokens[sp.pos]
+	if tok.kind != tokenEOF {
+		sp.pos++
+	}
+	return tok
+}
+
+// isKeyword reports whether tok is the given reserved word
+func isKeyword(tok token, word string) bool {
+	return tok.kind == tokenWord && tok.text == word
+}
+
+// skipSeparators skips newlines and semicolons
+func (sp *scriptParser) skipSeparators() {
+	for {
+		tok := sp.peek()
+		if tok.kind == tokenNewline || (tok.kind == tokenOperator && tok.text == ";") {
+			sp.next()
+			continue
+		}
+		return
+	}
+}
+
+// parseStatements parses statements until EOF or one of the terminator
+// keywords appears in command position. The terminator is not consumed.
+func (sp *scriptParser) parseStatements(terminators []string) ([]types.Node, string, error) {
+	var nodes []types.Node
+
+	for {
+		sp.skipSeparators()
+
+		tok := sp.peek()
+		if tok.kind == tokenEOF {
+			if len(terminators) > 0 {
+				return nil, "", fmt.Errorf("line %d: unexpected end of input, expected %q", tok.pos.Line, terminators[0])
+			}
+			return nodes, "", nil
+		}
+		for _, term := range terminators {
+			if isKeyword(tok, term) {
+				return nodes, term, nil
+			}
+		}
+
+		stmt, err := sp.parseStatement()
+		if err != nil {
+			return nil, "", err
+		}
+		nodes = append(nodes, stmt...)
+
+		tok = sp.peek()
+		switch {
+		case tok.kind == tokenEOF, tok.kind == tokenNewline:
+		case tok.kind == tokenOperator && tok.text == ";":
+		default:
+			return nil, "", fmt.Errorf("line %d: unsupported syntax near %q", tok.pos.Line, tok.text)
+		}
+	}
+}
+
+// parseStatement parses a compound command or a pipeline
+func (sp *scriptParser) parseStatement() ([]types.Node, error) {
+	var node types.Node
+	var err error
+
+	tok := sp.peek()
+	switch {
+	case isKeyword(tok, "for"):
+		node, err = sp.parseFor()
+	case isKeyword(tok, "while"):
+		node, err = sp.parseWhile()
+	case isKeyword(tok, "if"):
+		node, err = sp.parseIf()
+	case isKeyword(tok, "function"):
+		sp.next()
+		node, err = sp.parseFunction(sp.next())
+	case tok.kind == tokenWord && isOperator(sp.peekAt(1), "(") && isOperator(sp.peekAt(2), ")"):
+		node, err = sp.parseFunction(sp.next())
+	default:
+		return sp.parsePipeline()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return []types.Node{node}, nil
+}
+
+// isOperator reports whether tok is the given operator
+func isOperator(tok token, op string) bool {
+	return tok.kind == tokenOperator && tok.text == op
+}
+
+// parseFunction parses the rest of: [function] name [()] { body }
+func (sp *scriptParser) parseFunction(nameTok token) (types.Node, error) {
+	if nameTok.kind != tokenWord || !functionName.MatchString(nameTok.text) {
+		return nil, fmt.Errorf("line %d: invalid function name %q", nameTok.pos.Line, nameTok.text)
+	}
+
+	if isOperator(sp.peek(), "(") {
+		sp.next()
+		if !isOperator(sp.next(), ")") {
+			return nil, fmt.Errorf("line %d: expected ')' after function name %q", nameTok.pos.Line, nameTok.text)
+		}
+	}
+
+	for sp.peek().kind == tokenNewline {
+		sp.next()
+	}
+	open := sp.next()
+	if !isKeyword(open, "{") {
+		return nil, fmt.Errorf("line %d: expected '{' to start body of function %q", open.pos.Line, nameTok.text)
+	}
+
+	nodes, _, err := sp.parseStatements([]string{"}"})
+	if err != nil {
+		return nil, err
+	}
+	sp.next()
+
+	return &types.FunctionNode{
+		Pos:  nameTok.pos,
+		Name: nameTok.text,
+		Body: &types.ScriptNode{Pos: open.pos, Nodes: nodes},
+	}, nil
+}
+
+// parsePipeline parses commands joined by |
+func (sp *scriptParser) parsePipeline() ([]types.Node, error) {
+	nodes, err := sp.parseSimpleCommand()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		tok := sp.peek()
+		if tok.kind != tokenOperator || tok.text != "|" {
+			return nodes, nil
+		}
+		sp.next()
+		for sp.peek().kind == tokenNewline {
+			sp.next()
+		}
+
+		left, ok := pipelineCommand(nodes)
+		if !ok {
+			return nil, fmt.Errorf("line %d: pipeline requires a command before '|'", tok.pos.Line)
+		}
+		rightNodes, err := sp.parseSimpleCommand()
+		if err != nil {
+			return nil, err
+		}
+		right, ok := pipelineCommand(rightNodes)
+		if !ok {
+			return nil, fmt.Errorf("line %d: pipeline requires a command after '|'", tok.pos.Line)
+		}
+
+		nodes = []types.Node{&types.PipeNode{Pos: tok.pos, Left: left, Right: right}}
+	}
+}
+
+// pipelineCommand returns the single command or pipe node of a pipeline stage
+func pipelineCommand(nodes []types.Node) (types.Node, bool) {
+	if len(nodes) != 1 {
+		return nil, false
+	}
+	switch nodes[0].(type) {
+	case *types.CommandNode, *types.PipeNode:
+		return nodes[0], true
+	}
+	return nil, false
+}
+
+// parseSimpleCommand parses leading assignments, a command and its redirections
+func (sp *scriptParser) parseSimpleCommand() ([]types.Node, error) {
+	var nodes []types.Node
+	var cmd *types.CommandNode
+
+	for {
+		tok := sp.peek()
+
+		if tok.kind == tokenOperator {
+			if _, ok := redirectOps[tok.text]; !ok {
+				break
+			}
+			if cmd == nil {
+				cmd = &types.CommandNode{Pos: tok.pos}
+			}
+			if err := sp.parseRedirect(cmd); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if tok.kind != tokenWord {
+			break
+		}
+		sp.next()
+
+		if cmd == nil {
+			if assign, ok := ParseAssignment(tok.text); ok {
+				assign.Pos = tok.pos
+				nodes = append(nodes, assign)
+				continue
+			}
+			cmd = &types.CommandNode{Pos: tok.pos}
+		}
+
+		if cmd.Name == "" {
+			cmd.Pos = tok.pos
+			cmd.Name = removeQuotes(tok.text)
+			continue
+		}
+		cmd.Args = append(cmd.Args, removeQuotes(tok.text))
+		cmd.RawArgs = append(cmd.RawArgs, tok.text)
+	}
+
+	if cmd != nil {
+		if cmd.Name == "" {
+			return nil, fmt.Errorf("line %d: redirection without a command", cmd.Pos.Line)
+		}
+		nodes = append(nodes, cmd)
+	}
+
+	if len(nodes) == 0 {
+		tok := sp.peek()
+		return nil, fmt.Errorf("line %d: syntax error near %q", tok.pos.Line, tok.text)
+	}
+	return nodes, nil
+}
+
+// parseRedirect parses a redirection operator and its target into cmd
+func (sp *scriptParser) parseRedirect(cmd *types.CommandNode) error {
+	opTok := sp.next()
+	spec := redirectOps[opTok.text]
+	redirect := &types.RedirectNode{Pos: opTok.pos, Op: spec.op, Fd: spec.fd}
+
+	if spec.op != "2>&1" && spec.op != ">&2" {
+		target := sp.next()
+		if target.kind != tokenWord {
+			return fmt.Errorf("line %d: missing target for redirection %q", opTok.pos.Line, opTok.text)
+		}
+		redirect.File = target.text
+	}
+
+	switch {
+	case cmd.Redirect == nil:
+		cmd.Redirect = redirect
+	case cmd.Redirect.Op == ">" && cmd.Redirect.Fd == 1 && redirect.Op == "2>&1":
+		// cmd > file 2>&1 sends both streams to the file
+		cmd.Redirect.Op = "&>"
+	default:
+		return fmt.Errorf("line %d: multiple redirections are not supported", opTok.pos.Line)
+	}
+	return nil
+}
+
+// parseFor parses: for name [in words]; do body; done
+func (sp *scriptParser) parseFor() (types.Node, error) {
+	forTok := sp.next()
+
+	nameTok := sp.next()
+	if nameTok.kind != tokenWord || !identifier.MatchString(nameTok.text) {
+		return nil, fmt.Errorf("line %d: invalid for loop variable %q", forTok.pos.Line, nameTok.text)
+	}
+
+	forNode := &types.ForNode{Pos: forTok.pos, Variable: nameTok.text}
+
+	for sp.peek().kind == tokenNewline {
+		sp.next()
+	}
+
+	if isKeyword(sp.peek(), "in") {
+		sp.next()
+		forNode.List = []string{}
+		for sp.peek().kind == tokenWord {
+			forNode.List = append(forNode.List, sp.next().text)
+		}
+	} else {
+		// for name; do ... iterates over the positional parameters
+		forNode.List = []string{`"$@"`}
+	}
+
+	body, err := sp.parseDoGroup(forTok)
+	if err != nil {
+		return nil, err
+	}
+	forNode.Body = body
+
+	forNode.Redirect, err = sp.parseCompoundRedirect()
+	if err != nil {
+		return nil, err
+	}
+	return forNode, nil
+}
+
+// parseWhile parses: while condition; do body; done [redirection]
+func (sp *scriptParser) parseWhile() (types.Node, error) {
+	whileTok := sp.next()
+
+	condition, err := sp.parseCondition(whileTok, "do")
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := sp.parseDoGroup(whileTok)
+	if err != nil {
+		return nil, err
+	}
+
+	redirect, err := sp.parseCompoundRedirect()
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.WhileNode{
+		Pos:       whileTok.pos,
+		Condition: condition,
+		Body:      body,
+		Redirect:  redirect,
+	}, nil
+}
+
+// parseIf parses: if condition; then body; [elif ...;] [else body;] fi
+func (sp *scriptParser) parseIf() (types.Node, error) {
+	ifTok := sp.next()
+
+	condition, err := sp.parseCondition(ifTok, "then")
+	if err != nil {
+		return nil, err
+	}
+	sp.next()
+
+	thenNodes, term, err := sp.parseStatements([]string{"elif", "else", "fi"})
+	if err != nil {
+		return nil, err
+	}
+
+	ifNode := &types.IfNode{
+		Pos:       ifTok.pos,
+		Condition: condition,
+		Then:      &types.ScriptNode{Pos: ifTok.pos, Nodes: thenNodes},
+	}
+
+	switch term {
+	case "elif":
+		// elif continues as a nested if in the else branch, sharing its fi
+		elifNode, err := sp.parseIf()
+		if err != nil {
+			return nil, err
+		}
+		ifNode.Else = &types.ScriptNode{Pos: elifNode.Position(), Nodes: []types.Node{elifNode}}
+		return ifNode, nil
+	case "else":
+		elseTok := sp.next()
+		elseNodes, _, err := sp.parseStatements([]string{"fi"})
+		if err != nil {
+			return nil, err
+		}
+		ifNode.Else = &types.ScriptNode{Pos: elseTok.pos, Nodes: elseNodes}
+	}
+
+	sp.next()
+	return ifNode, nil
+}
+
+// parseCondition parses the condition list of an if or while up to the
+// given keyword, which is left unconsumed
+func (sp *scriptParser) parseCondition(start token, keyword string) (types.Node, error) {
+	nodes, _, err := sp.parseStatements([]string{keyword})
+	if err != nil {
+		return nil, err
+	}
+	switch len(nodes) {
+	case 0:
+		return nil, fmt.Errorf("line %d: missing condition for %q", start.pos.Line, start.text)
+	case 1:
+		return nodes[0], nil
+	default:
+		// The exit status of the last command decides
+		return &types.ScriptNode{Pos: start.pos, Nodes: nodes}, nil
+	}
+}
+
+// parseCompoundRedirect parses an optional redirection following a loop
+func (sp *scriptParser) parseCompoundRedirect() (*types.RedirectNode, error) {
+	if sp.peek().kind != tokenOperator {
+		return nil, nil
+	}
+	if _, ok := redirectOps[sp.peek().text]; !ok {
+		return nil, nil
+	}
+
+	holder := &types.CommandNode{}
+	for sp.peek().kind == tokenOperator {
+		if _, ok := redirectOps[sp.peek().text]; !ok {
+			break
+		}
+		if err := sp.parseRedirect(holder); err != nil {
+			return nil, err
+		}
+	}
+	return holder.Redirect, nil
+}
+
+// parseDoGroup parses: do body done
+func (sp *scriptParser) parseDoGroup(start token) (*types.ScriptNode, error) {
+	sp.skipSeparators()
+	doTok := sp.next()
+	if !isKeyword(doTok, "do") {
+		return nil, fmt.Errorf("line %d: expected 'do' for loop starting at line %d", doTok.pos.Line, start.pos.Line)
+	}
+
+	nodes, _, err := sp.parseStatements([]string{"done"})
+	if err != nil {
+		return nil, err
+	}
+	sp.next()
+
+	return &types.ScriptNode{Pos: doTok.pos, Nodes: nodes}, nil
+}
+
+// ParseAssignment parses a raw assignment word such as name=value,
+// name+=value, name[index]=value or name=(a b c)
+func ParseAssignment(word string) (*types.AssignmentNode, bool) {
+	match := assignmentWord.FindStringSubmatchIndex(word)
+	if match == nil {
+		return nil, false
+	}
+
+	assign := &types.AssignmentNode{
+		Name:   word[match[2]:match[3]],
+		Append: match[7] > match[6],
+		Value:  word[match[1]:],
+	}
+	if match[4] >= 0 {
+		assign.Index = word[match[4]+1 : match[5]-1]
+	}
+
+	if assign.Index == "" && strings.HasPrefix(assign.Value, "(") && strings.HasSuffix(assign.Value, ")") {
+		elements, err := splitWords(assign.Value[1 : len(assign.Value)-1])
+		if err != nil {
+			return nil, false
+		}
+		assign.IsArray = true
+		assign.Elements = elements
+		assign.Value = ""
+	}
+
+	return assign, true
+}
+
+// splitWords splits source into raw words, ignoring newlines and comments
+func splitWords(source string) ([]string, error) {
+	tokens, err := newLexer(source).tokenize()
+	if err != nil {
+		return nil, err
+	}
+
+	words := []string{}
+	for _, tok := range tokens {
+		switch tok.kind {
+		case tokenWord:
+			words = append(words, tok.text)
+		case tokenOperator:
+			return nil, fmt.Errorf("unexpected %q in compound assignment", tok.text)
+		}
+	}
+	return words, nil
+}
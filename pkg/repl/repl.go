@@ -2,11 +2,15 @@ package repl
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
 	"strings"
 
+	"gitee.com/com_818cloud/shode/pkg/audit"
+	"gitee.com/com_818cloud/shode/pkg/engine"
 	"gitee.com/com_818cloud/shode/pkg/environment"
+	"gitee.com/com_818cloud/shode/pkg/module"
 	"gitee.com/com_818cloud/shode/pkg/parser"
 	"gitee.com/com_818cloud/shode/pkg/sandbox"
 	"gitee.com/com_818cloud/shode/pkg/stdlib"
@@ -19,25 +23,47 @@ type REPL struct {
 	security     *sandbox.SecurityChecker
 	parser       *parser.SimpleParser
 	stdlib       *stdlib.StdLib
+	engine       *engine.ExecutionEngine
 	history      []string
 	running      bool
 }
 
 // NewREPL creates a new interactive REPL environment
 func NewREPL() *REPL {
+	return NewREPLWithSecurity(sandbox.NewSecurityChecker())
+}
+
+// NewREPLWithSecurity creates a REPL that checks commands with the given
+// security checker, e.g. one configured from a policy file
+func NewREPLWithSecurity(security *sandbox.SecurityChecker) *REPL {
+	envManager := environment.NewEnvironmentManager()
+	stdLib := stdlib.New()
 	return &REPL{
-		envManager: environment.NewEnvironmentManager(),
-		security:   sandbox.NewSecurityChecker(),
+		envManager: envManager,
+		security:   security,
 		parser:     parser.NewSimpleParser(),
-		stdlib:     stdlib.New(),
+		stdlib:     stdLib.WithContext(stdlib.Context{Env: envManager}),
+		engine:     engine.NewExecutionEngine(envManager, stdLib, module.NewModuleManager(), security),
 		history:    make([]string, 0),
 		running:    false,
 	}
 }
 
+// SetAuditLogger records the commands of the session to an audit log
+func (r *REPL) SetAuditLogger(logger audit.Logger) {
+	r.engine.SetAuditLogger(logger)
+}
+
+// SetApprover asks approver before running commands that violate the
+// policy or are risky
+func (r *REPL) SetApprover(approver engine.Approver) {
+	r.engine.SetApprover(approver)
+}
+
 // Start begins the REPL interactive session
 func (r *REPL) Start() {
 	r.running = true
+	defer r.engine.RemoveTempFiles()
 	fmt.Println("Shode REPL - Interactive Shell Environment")
 	fmt.Println("Type 'exit' or 'quit' to exit, 'help' for help")
 	fmt.Printf("Working directory: %s\n", r.envManager.GetWorkingDir())
@@ -135,11 +161,15 @@ func (r *REPL) processCommand(input string) {
 		return
 	}
 
-	cmd := script.Nodes[0].(*types.CommandNode)
+	cmd, ok := script.Nodes[0].(*types.CommandNode)
+	if !ok {
+		fmt.Printf("Unsupported statement in REPL: %s\n", script.Nodes[0].String())
+		return
+	}
 
-	// Check security
-	if err := r.security.CheckCommand(cmd); err != nil {
-		fmt.Printf("Security error: %v\n", err)
+	// Check security, asking in confirm mode
+	if err := r.engine.Authorize(cmd); err != nil {
+		fmt.Printf("Security error: %s\n", r.engine.Redact(err.Error()))
 		return
 	}
 
@@ -153,10 +183,8 @@ func (r *REPL) executeCommand(cmd *types.CommandNode) {
 
 	// Handle built-in commands
 	switch commandName {
-	case "echo":
-		if len(cmd.Args) > 0 {
-			fmt.Println(strings.Join(cmd.Args, " "))
-		}
+	case "echo", "printf", "read":
+		r.executeBuiltin(cmd)
 		return
 	case "ls":
 		r.handleLsCommand(cmd.Args)
@@ -169,10 +197,24 @@ func (r *REPL) executeCommand(cmd *types.CommandNode) {
 	}
 
 	// For other commands, just show what would be executed
-	fmt.Printf("Would execute: %s %s\n", cmd.Name, strings.Join(cmd.Args, " "))
+	fmt.Printf("Would execute: %s\n", r.engine.Redact(sandbox.CommandLine(cmd)))
 	fmt.Println("(Execution engine will handle this in future versions)")
 }
 
+// executeBuiltin runs a shell builtin through the execution engine,
+// masking the values of secret variables in what it prints
+func (r *REPL) executeBuiltin(cmd *types.CommandNode) {
+	result, err := r.engine.ExecuteCommand(context.Background(), cmd)
+	if err != nil {
+		fmt.Printf("Error: %s\n", r.engine.Redact(err.Error()))
+		return
+	}
+	fmt.Print(r.engine.Redact(result.Output))
+	if result.Error != "" {
+		fmt.Fprintln(os.Stderr, r.engine.Redact(strings.TrimSuffix(result.Error, "\n")))
+	}
+}
+
 // handleLsCommand handles the ls command
 func (r *REPL) handleLsCommand(args []string) {
 	dir := "."
@@ -198,7 +240,7 @@ func (r *REPL) handleCatCommand(filename string) {
 		fmt.Printf("cat: %v\n", err)
 		return
 	}
-	fmt.Print(content)
+	fmt.Print(r.engine.Redact(content))
 }
 
 // showHelp displays REPL help information
@@ -214,13 +256,18 @@ func (r *REPL) showHelp() {
 	fmt.Println("  ls [dir]      - List files")
 	fmt.Println("  cat <file>    - Show file content")
 	fmt.Println("  echo <text>   - Echo text")
+	fmt.Println("  printf, read  - Format output, read a line into variables")
 	fmt.Println("  Other shell commands will be processed by Shode")
 }
 
-// showEnvironment displays current environment variables
+// showEnvironment displays current environment variables, with the values
+// of secret variables masked
 func (r *REPL) showEnvironment() {
 	env := r.envManager.GetAllEnv()
 	for key, value := range env {
+		if r.engine.IsSecret(key) {
+			value = sandbox.SecretMask
+		}
 		fmt.Printf("%s=%s\n", key, value)
 	}
 }
@@ -0,0 +1,196 @@
+package sandbox
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Policy modes
+const (
+	ModeDenylist  = "denylist"  // everything not denied may run (the default)
+	ModeAllowlist = "allowlist" // only commands in commands.allow may run
+)
+
+// pinEntry is the expected sha256 of a command's binary
+type pinEntry struct {
+	command string
+	sum     string
+	source  string
+}
+
+// hashEntry caches the hash of a binary until it changes
+type hashEntry struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+// mode returns the policy mode, defaulting to denylist
+func (p *Policy) mode() string {
+	if p.Mode == "" {
+		return ModeDenylist
+	}
+	return p.Mode
+}
+
+// validateAllowlist checks the mode and pins of a policy
+func (p *Policy) validateAllowlist() error {
+	if p.Mode != "" && p.Mode != ModeDenylist && p.Mode != ModeAllowlist {
+		return fmt.Errorf("mode must be %q or %q, got %q", ModeDenylist, ModeAllowlist, p.Mode)
+	}
+	for command, sum := range p.Commands.Pins {
+		if len(sum) != sha256.Size*2 {
+			return fmt.Errorf("pin for %s: expected a hex sha256, got %q", command, sum)
+		}
+		if _, err := hex.DecodeString(sum); err != nil {
+			return fmt.Errorf("pin for %s: expected a hex sha256, got %q", command, sum)
+		}
+	}
+	return nil
+}
+
+// applyAllowlist records the mode, allowed commands and pins of a policy
+func (sc *SecurityChecker) applyAllowlist(policy *Policy) {
+	if policy.Mode != "" {
+		sc.mode = policy.mode()
+		sc.modeSource = policy.name()
+	}
+	for _, command := range policy.Commands.Allow {
+		sc.allowed = append(sc.allowed, command)
+	}
+	for command, sum := range policy.Commands.Pins {
+		sc.pins = append(sc.pins, pinEntry{command: command, sum: strings.ToLower(sum), source: policy.name()})
+	}
+}
+
+// Mode returns the checker's mode, ModeDenylist or ModeAllowlist
+func (sc *SecurityChecker) Mode() string {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return sc.mode
+}
+
+// CheckExecutable validates the binary an external command resolves to.
+// name is the command as written and path the file that will run. In
+// allowlist mode the binary must be one a commands.allow entry resolves to;
+// in every mode a pinned binary must match its sha256.
+func (sc *SecurityChecker) CheckExecutable(name, path string) error {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return sc.checkExecutable(name, path)
+}
+
+// checkExecutable is CheckExecutable for callers holding the lock
+func (sc *SecurityChecker) checkExecutable(name, path string) error {
+	if sc.mode != ModeAllowlist && len(sc.pins) == 0 {
+		return nil
+	}
+
+	canonical, err := canonicalExecutable(path)
+	if err != nil {
+		// Nothing runs; the engine reports the missing command
+		return nil
+	}
+
+	if sc.mode == ModeAllowlist && !sc.isAllowedExecutable(canonical) {
+		return fmt.Errorf("security violation: '%s' (%s) is not allowed: policy %s is in allowlist mode and no commands.allow entry matches it",
+			name, canonical, sc.modeSource)
+	}
+
+	for _, pin := range sc.pins {
+		if !sameExecutable(pin.command, canonical) {
+			continue
+		}
+		sum, err := sc.hashExecutable(canonical)
+		if err != nil {
+			return fmt.Errorf("security violation: cannot verify pinned binary '%s' of policy %s: %v", canonical, pin.source, err)
+		}
+		if sum != pin.sum {
+			return fmt.Errorf("security violation: '%s' (%s) does not match commands.pins[%q] of policy %s (sha256 %s)",
+				name, canonical, pin.command, pin.source, sum)
+		}
+	}
+	return nil
+}
+
+// isAllowedExecutable reports whether a commands.allow entry resolves to
+// the canonical path
+func (sc *SecurityChecker) isAllowedExecutable(canonical string) bool {
+	for _, entry := range sc.allowed {
+		if sameExecutable(entry, canonical) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameExecutable reports whether a policy entry, a command name looked up
+// in PATH or a path to a binary, resolves to the canonical path
+func sameExecutable(entry, canonical string) bool {
+	path := entry
+	if !strings.Contains(entry, "/") {
+		resolved, err := exec.LookPath(entry)
+		if err != nil {
+			return false
+		}
+		path = resolved
+	}
+	resolved, err := canonicalExecutable(expandTilde(path))
+	return err == nil && resolved == canonical
+}
+
+// canonicalExecutable returns the absolute path of a binary with symlinks
+// resolved, so /bin/rm and /usr/bin/rm are the same command
+func canonicalExecutable(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
+
+// hashExecutable returns the hex sha256 of a binary, cached until the file
+// changes
+func (sc *SecurityChecker) hashExecutable(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	sc.hashMu.Lock()
+	cached, ok := sc.hashes[path]
+	sc.hashMu.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.sum, nil
+	}
+
+	sum, err := HashFile(path)
+	if err != nil {
+		return "", err
+	}
+	sc.hashMu.Lock()
+	sc.hashes[path] = hashEntry{size: info.Size(), modTime: info.ModTime(), sum: sum}
+	sc.hashMu.Unlock()
+	return sum, nil
+}
+
+// HashFile returns the hex sha256 of a file, the value commands.pins expects
+func HashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
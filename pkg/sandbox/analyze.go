@@ -0,0 +1,604 @@
+package sandbox
+
+import (
+	"fmt"
+	"strings"
+
+	"gitee.com/com_818cloud/shode/pkg/parser"
+	"gitee.com/com_818cloud/shode/pkg/types"
+)
+
+// Severities of analysis issues, named like SARIF result levels
+const (
+	SeverityError   = "error"   // blocked at runtime, or runs downloaded code
+	SeverityWarning = "warning" // likely a bug or a risk to review
+	SeverityNote    = "note"    // worth knowing, e.g. network use
+)
+
+// severityRanks orders the severities
+var severityRanks = map[string]int{SeverityNote: 1, SeverityWarning: 2, SeverityError: 3}
+
+// Issue is a problem found in a script without running it
+type Issue struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column,omitempty"`
+	Command  string `json:"command"`
+	Message  string `json:"message"`
+	Context  string `json:"context,omitempty"` // where the command is, e.g. "function deploy"
+}
+
+// ScriptReport is the result of analyzing a script
+type ScriptReport struct {
+	File     string  `json:"file,omitempty"`
+	Commands int     `json:"commands"` // commands analyzed, including nested ones
+	Issues   []Issue `json:"issues"`
+}
+
+// Count returns the number of issues of at least the given severity
+func (r *ScriptReport) Count(severity string) int {
+	count := 0
+	for _, issue := range r.Issues {
+		if severityRanks[issue.Severity] >= severityRanks[severity] {
+			count++
+		}
+	}
+	return count
+}
+
+// ValidSeverity reports whether s is one of the issue severities
+func ValidSeverity(s string) bool {
+	return severityRanks[s] > 0
+}
+
+// analysisRules describe the rules of the analyzer; argument risk rules
+// are described by their findings
+var analysisRules = map[string]string{
+	"dangerous-command":    "Command is on the dangerous command list",
+	"network-command":      "Command changes or inspects the network configuration",
+	"policy-rule":          "Arguments are denied by a policy rule",
+	"allowlist":            "Executable is not allowed by the policy",
+	"sensitive-path":       "Command accesses a protected path",
+	"network-denied":       "Destination is denied by the network policy",
+	"network-access":       "Command uses the network",
+	"pipe-to-shell":        "Downloaded code is run by a shell or interpreter",
+	"eval":                 "eval runs text as shell code",
+	"unquoted-expansion":   "Unquoted expansion is split into words and globbed",
+	"command-substitution": "Command substitution is not run by shode",
+	"unparsable-script":    "Nested script cannot be parsed",
+	"syntax-error":         "Script cannot be parsed",
+}
+
+// RuleDescription returns a one-line description of an analysis rule
+func RuleDescription(rule string) string {
+	if description, ok := analysisRules[rule]; ok {
+		return description
+	}
+	return "Risky command arguments (" + rule + ")"
+}
+
+// downloaders fetch remote content to stdout
+var downloaders = map[string]bool{"curl": true, "wget": true, "fetch": true, "aria2c": true}
+
+// interpreters run code they read from stdin, besides the shells
+var interpreters = map[string]bool{
+	"python": true, "python2": true, "python3": true, "perl": true, "ruby": true, "node": true, "php": true,
+}
+
+// networkClients use the network even without a URL argument
+var networkClients = map[string]bool{
+	"curl": true, "wget": true, "ssh": true, "scp": true, "sftp": true, "rsync": true,
+	"ncat": true, "telnet": true, "ftp": true,
+}
+
+// declarationCommands assign their arguments without word splitting
+var declarationCommands = map[string]bool{
+	"export": true, "local": true, "declare": true, "readonly": true, "typeset": true,
+}
+
+// analysisScope is where the analyzer is in a script
+type analysisScope struct {
+	line    int    // line of the enclosing command for nested scripts, 0 at the top
+	context string // description of the enclosing construct
+	depth   int    // nesting of substitutions and wrapped commands
+}
+
+// nested returns the scope of a script nested in a command at line
+func (s analysisScope) nested(line int, context string) analysisScope {
+	if s.context != "" {
+		context = context + " in " + s.context
+	}
+	return analysisScope{line: line, context: context, depth: s.depth + 1}
+}
+
+// scriptAnalyzer collects the issues of a script
+type scriptAnalyzer struct {
+	checker *SecurityChecker
+	dir     string
+	report  *ScriptReport
+}
+
+// AnalyzeScript checks every command of a script without running it,
+// including commands in functions, loops, conditions, command
+// substitutions and the scripts of sh -c and eval. Relative paths are
+// resolved against dir. Expansions are not performed, so paths and URLs
+// built from variables are not seen.
+func (sc *SecurityChecker) AnalyzeScript(script *types.ScriptNode, dir string) *ScriptReport {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	analyzer := &scriptAnalyzer{checker: sc, dir: dir, report: &ScriptReport{Issues: []Issue{}}}
+	analyzer.walk(script, analysisScope{})
+	return analyzer.report
+}
+
+// walk analyzes a node and its children
+func (a *scriptAnalyzer) walk(node types.Node, scope analysisScope) {
+	switch n := node.(type) {
+	case *types.ScriptNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			a.walk(child, scope)
+		}
+	case *types.CommandNode:
+		a.command(n, scope)
+	case *types.PipeNode:
+		var commands []*types.CommandNode
+		collectCommands(n, &commands)
+		a.pipeline(commands, scope)
+		for _, cmd := range commands {
+			a.command(cmd, scope)
+		}
+	case *types.IfNode:
+		a.walk(n.Condition, scope)
+		a.walk(n.Then, scope)
+		if n.Else != nil {
+			a.walk(n.Else, scope)
+		}
+	case *types.ForNode:
+		a.substitutions(n.List, n.Pos, nil, scope)
+		a.walk(n.Body, scope)
+	case *types.WhileNode:
+		a.walk(n.Condition, scope)
+		a.walk(n.Body, scope)
+	case *types.FunctionNode:
+		context := "function " + n.Name
+		if scope.context != "" {
+			context += " in " + scope.context
+		}
+		a.walk(n.Body, analysisScope{line: scope.line, context: context, depth: scope.depth})
+	case *types.AssignmentNode:
+		a.substitutions(append([]string{n.Value}, n.Elements...), n.Pos, nil, scope)
+	}
+}
+
+// position maps a position in a possibly nested script to the analyzed file
+func (a *scriptAnalyzer) position(pos types.Position, scope analysisScope) (int, int) {
+	if scope.line == 0 {
+		return pos.Line, pos.Column
+	}
+	// Nested scripts are parsed on their own; only the line is kept
+	line := pos.Line
+	if line < 1 {
+		line = 1
+	}
+	return scope.line + line - 1, 0
+}
+
+// add records an issue about a command
+func (a *scriptAnalyzer) add(cmd *types.CommandNode, scope analysisScope, rule, severity, message string) {
+	line, column := a.position(cmd.Pos, scope)
+	a.report.Issues = append(a.report.Issues, Issue{
+		Rule:     rule,
+		Severity: severity,
+		Line:     line,
+		Column:   column,
+		Command:  cmd.Name,
+		Message:  message,
+		Context:  scope.context,
+	})
+}
+
+// command analyzes a single command and the scripts nested in it
+func (a *scriptAnalyzer) command(cmd *types.CommandNode, scope analysisScope) {
+	sc := a.checker
+	a.report.Commands++
+	names := identities(cmd.Name, &CheckContext{Dir: a.dir})
+
+	dangerous, network := false, false
+	for _, name := range names {
+		allowedByRule, err := sc.checkCommandRules(name, cmd.Args)
+		if err != nil {
+			a.add(cmd, scope, "policy-rule", SeverityError, violationMessage(err))
+			continue
+		}
+		if sc.isDenied(cmd, name) && !allowedByRule && !dangerous {
+			dangerous = true
+			message := fmt.Sprintf("dangerous command '%s' is not allowed", name)
+			if source, ok := sc.denySources[name]; ok {
+				message = fmt.Sprintf("command '%s' is denied by commands.deny of policy %s", name, source)
+			}
+			a.add(cmd, scope, "dangerous-command", SeverityError, message)
+		}
+		if sc.networkBlacklist[name] && !allowedByRule && !network {
+			network = true
+			a.add(cmd, scope, "network-command", SeverityError, fmt.Sprintf("network command '%s' is not allowed", name))
+		}
+	}
+
+	// The allowlist and hash pins apply to binaries, not shell builtins
+	executable := commandPath(cmd.Name, &CheckContext{Dir: a.dir})
+	if executable != "" && !shellBuiltins[names[0]] {
+		if err := sc.checkExecutable(cmd.Name, executable); err != nil {
+			a.add(cmd, scope, "allowlist", SeverityError, violationMessage(err))
+		}
+	}
+
+	a.paths(cmd, names, executable, scope)
+	a.network(cmd, names, scope)
+
+	if names[0] == "eval" {
+		message := "eval runs its arguments as shell code"
+		for _, arg := range cmd.Args {
+			if strings.Contains(arg, "$") || strings.Contains(arg, "`") {
+				message = "eval runs text that is only known at runtime as shell code"
+				break
+			}
+		}
+		a.add(cmd, scope, "eval", SeverityWarning, message)
+	}
+
+	if !declarationCommands[names[0]] {
+		for _, word := range rawWords(cmd) {
+			if expansion := unquotedExpansion(word); expansion != "" {
+				a.add(cmd, scope, "unquoted-expansion", SeverityWarning,
+					fmt.Sprintf("unquoted %s in '%s' is split into words and expanded as a glob; quote it", expansion, word))
+			}
+		}
+	}
+
+	for _, finding := range commandFindings(cmd, names) {
+		severity := SeverityNote
+		switch {
+		case finding.Score >= sc.riskThreshold:
+			severity = SeverityError
+		case finding.Score >= 40:
+			severity = SeverityWarning
+		}
+		a.add(cmd, scope, finding.Rule, severity, finding.Message)
+	}
+
+	a.substitutions(rawWords(cmd), cmd.Pos, cmd, scope)
+	a.wrapped(cmd, names, scope)
+}
+
+// paths reports the protected paths a command runs, reads or writes
+func (a *scriptAnalyzer) paths(cmd *types.CommandNode, names []string, executable string, scope analysisScope) {
+	sc := a.checker
+	if executable != "" {
+		if err := sc.checkPath(executable, a.dir, PermExecute); err != nil {
+			a.add(cmd, scope, "sensitive-path", SeverityError, violationMessage(err))
+		}
+	}
+	for _, operand := range commandPaths(cmd, names, a.dir) {
+		if err := sc.checkPath(operand.path, a.dir, operand.need); err != nil {
+			a.add(cmd, scope, "sensitive-path", SeverityError, violationMessage(err))
+		}
+	}
+	if cmd.Redirect != nil {
+		if err := sc.checkRedirect(cmd.Redirect, a.dir); err != nil {
+			a.add(cmd, scope, "sensitive-path", SeverityError, violationMessage(err))
+		}
+	}
+}
+
+// network reports the destinations a command connects to
+func (a *scriptAnalyzer) network(cmd *types.CommandNode, names []string, scope analysisScope) {
+	found := false
+	for _, arg := range cmd.Args {
+		// URLs in substitutions belong to the commands there
+		if strings.Contains(arg, "$(") || strings.Contains(arg, "`") {
+			continue
+		}
+		host, port, ok := urlDestination(arg)
+		if !ok {
+			continue
+		}
+		found = true
+		if err := a.checker.checkNetworkArguments([]string{arg}); err != nil {
+			a.add(cmd, scope, "network-denied", SeverityError, violationMessage(err))
+			continue
+		}
+		a.add(cmd, scope, "network-access", SeverityNote, fmt.Sprintf("'%s' connects to %s:%d", cmd.Name, host, port))
+	}
+	if found {
+		return
+	}
+	for _, name := range names {
+		if networkClients[name] {
+			a.add(cmd, scope, "network-access", SeverityNote, fmt.Sprintf("'%s' uses the network", cmd.Name))
+			return
+		}
+	}
+}
+
+// pipeline reports downloaded content piped into a shell or interpreter,
+// as in curl https://example.com/install.sh | sh
+func (a *scriptAnalyzer) pipeline(commands []*types.CommandNode, scope analysisScope) {
+	downloader := ""
+	for _, cmd := range commands {
+		names := identities(cmd.Name, &CheckContext{Dir: a.dir})
+		if downloader != "" && runsStdin(cmd, names, 0) {
+			a.add(cmd, scope, "pipe-to-shell", SeverityError,
+				fmt.Sprintf("'%s' runs code downloaded by '%s'", cmd.Name, downloader))
+		}
+		if isDownloader(names) {
+			downloader = cmd.Name
+		}
+	}
+}
+
+// substitutions analyzes the command, backquote and process substitutions
+// in words. For a command that runs code, substitutions that download code
+// are reported, as in sh -c "$(curl -fsSL https://example.com/install.sh)".
+func (a *scriptAnalyzer) substitutions(words []string, pos types.Position, cmd *types.CommandNode, scope analysisScope) {
+	if scope.depth >= maxWrapperDepth {
+		return
+	}
+	line, _ := a.position(pos, scope)
+	runsCode := false
+	if cmd != nil {
+		for _, name := range identities(cmd.Name, &CheckContext{Dir: a.dir}) {
+			if shells[name] || interpreters[name] || name == "eval" || name == "source" || name == "." {
+				runsCode = true
+			}
+		}
+	}
+
+	for _, word := range words {
+		for _, body := range substitutionBodies(word) {
+			parsed, err := parser.NewSimpleParser().ParseString(body)
+			if err != nil {
+				if cmd != nil {
+					a.add(cmd, scope, "unparsable-script", SeverityWarning, fmt.Sprintf("cannot analyze substitution '%s': %v", body, err))
+				}
+				continue
+			}
+			a.walk(parsed, scope.nested(line, "command substitution"))
+
+			if !runsCode {
+				continue
+			}
+			var inner []*types.CommandNode
+			collectCommands(parsed, &inner)
+			for _, downloaded := range inner {
+				if isDownloader(identities(downloaded.Name, &CheckContext{Dir: a.dir})) {
+					a.add(cmd, scope, "pipe-to-shell", SeverityError,
+						fmt.Sprintf("'%s' runs code downloaded by '%s'", cmd.Name, downloaded.Name))
+					break
+				}
+			}
+		}
+	}
+}
+
+// wrapped analyzes the commands run by a wrapper such as sudo, or the
+// script of sh -c and eval
+func (a *scriptAnalyzer) wrapped(cmd *types.CommandNode, names []string, scope analysisScope) {
+	if scope.depth >= maxWrapperDepth {
+		return
+	}
+	line, _ := a.position(cmd.Pos, scope)
+	context := fmt.Sprintf("run by '%s'", cmd.Name)
+
+	for _, name := range names {
+		if shells[name] || name == "eval" {
+			script, ok := shellScript(name, cmd.Args)
+			if !ok {
+				return
+			}
+			parsed, err := parser.NewSimpleParser().ParseString(script)
+			if err != nil {
+				a.add(cmd, scope, "unparsable-script", SeverityWarning, fmt.Sprintf("cannot analyze script of '%s': %v", name, err))
+				return
+			}
+			a.walk(parsed, scope.nested(line, context))
+			return
+		}
+	}
+
+	wrapped, _, err := wrappedCommands(names, cmd.Args)
+	if err != nil {
+		a.add(cmd, scope, "unparsable-script", SeverityWarning, violationMessage(err))
+		return
+	}
+	for _, inner := range wrapped {
+		a.command(inner, scope.nested(line, context))
+	}
+}
+
+// isDownloader reports whether a command fetches remote content
+func isDownloader(names []string) bool {
+	for _, name := range names {
+		if downloaders[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// runsStdin reports whether a command runs the code it reads from stdin:
+// a shell or interpreter without a script argument, possibly behind a
+// wrapper such as sudo
+func runsStdin(cmd *types.CommandNode, names []string, depth int) bool {
+	for _, name := range names {
+		if shells[name] || interpreters[name] {
+			return readsScriptFromStdin(cmd.Args, shells[name])
+		}
+	}
+	if depth >= maxWrapperDepth {
+		return false
+	}
+	for _, name := range names {
+		if spec, ok := wrappers[name]; ok {
+			for _, inner := range spec.unwrap(name, cmd.Args) {
+				if runsStdin(inner, identities(inner.Name, &CheckContext{}), depth+1) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return false
+}
+
+// readsScriptFromStdin reports whether a shell or interpreter with args
+// reads its script from stdin rather than from -c, -e or a file
+func readsScriptFromStdin(args []string, shell bool) bool {
+	for i, arg := range args {
+		switch {
+		case arg == "-" || arg == "-s":
+			return true
+		case arg == "--":
+			// A script file may follow
+			return i+1 >= len(args)
+		case strings.HasPrefix(arg, "--"):
+			continue
+		case !strings.HasPrefix(arg, "-"):
+			// A script file
+			return false
+		case strings.Contains(arg, "c"), !shell && strings.Contains(arg, "e"):
+			return false
+		}
+	}
+	return true
+}
+
+// substitutionBodies returns the scripts of the command substitutions,
+// backquotes and process substitutions in a raw word, outside single
+// quotes. Arithmetic $((...)) is skipped.
+func substitutionBodies(word string) []string {
+	var bodies []string
+	inSingle, inDouble := false, false
+	for i := 0; i < len(word); i++ {
+		c := word[i]
+		next := byte(0)
+		if i+1 < len(word) {
+			next = word[i+1]
+		}
+		switch {
+		case inSingle:
+			if c == '\'' {
+				inSingle = false
+			}
+		case c == '\\':
+			i++
+		case c == '\'' && !inDouble:
+			inSingle = true
+		case c == '"':
+			inDouble = !inDouble
+		case c == '`':
+			end := strings.IndexByte(word[i+1:], '`')
+			if end < 0 {
+				return bodies
+			}
+			bodies = append(bodies, word[i+1:i+1+end])
+			i += end + 1
+		case c == '$' && next == '(' && strings.HasPrefix(word[i:], "$(("):
+			i += closingParen(word, i+1)
+		case c == '$' && next == '(', (c == '<' || c == '>') && next == '(' && !inDouble:
+			length := closingParen(word, i+1)
+			if i+1+length >= len(word) {
+				// Unterminated; the parser reports it
+				return bodies
+			}
+			bodies = append(bodies, word[i+2:i+1+length])
+			i += length + 1
+		}
+	}
+	return bodies
+}
+
+// closingParen returns the offset from open of the parenthesis that closes
+// the one at open, or the rest of s if it is not closed
+func closingParen(s string, open int) int {
+	depth := 0
+	inSingle, inDouble := false, false
+	for i := open; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case inSingle:
+			if c == '\'' {
+				inSingle = false
+			}
+		case c == '\\':
+			i++
+		case c == '\'' && !inDouble:
+			inSingle = true
+		case c == '"':
+			inDouble = !inDouble
+		case inDouble:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return i - open
+			}
+		}
+	}
+	return len(s) - open
+}
+
+// unquotedExpansion returns the first parameter expansion in a raw word
+// outside double quotes, such as $dir or ${files[@]}, or "" if there is
+// none. $#, $? and the like expand to single words and are not reported.
+func unquotedExpansion(word string) string {
+	inSingle, inDouble := false, false
+	for i := 0; i < len(word); i++ {
+		c := word[i]
+		switch {
+		case inSingle:
+			if c == '\'' {
+				inSingle = false
+			}
+		case c == '\\':
+			i++
+		case c == '\'' && !inDouble:
+			inSingle = true
+		case c == '"':
+			inDouble = !inDouble
+		case c == '$' && !inDouble && i+1 < len(word):
+			next := word[i+1]
+			switch {
+			case next == '{':
+				end := strings.IndexByte(word[i:], '}')
+				if end < 0 {
+					return word[i:]
+				}
+				return word[i : i+end+1]
+			case next == '_' || next >= 'a' && next <= 'z' || next >= 'A' && next <= 'Z':
+				end := i + 2
+				for end < len(word) && isNameChar(word[end]) {
+					end++
+				}
+				return word[i:end]
+			case next == '@' || next == '*' || next >= '0' && next <= '9':
+				return word[i : i+2]
+			}
+		}
+	}
+	return ""
+}
+
+// isNameChar reports whether c may appear in a variable name
+func isNameChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// violationMessage returns the text of a security error without its prefix
+func violationMessage(err error) string {
+	return strings.TrimPrefix(err.Error(), "security violation: ")
+}
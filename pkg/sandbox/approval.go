@@ -0,0 +1,121 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gitee.com/com_818cloud/shode/pkg/types"
+)
+
+// approvalFileMu serializes the edits of policy files by SaveApproval, so
+// concurrent approvals do not overwrite each other
+var approvalFileMu sync.Mutex
+
+// CommandLine returns the command line that approvals are matched against:
+// the command and its expanded arguments joined by spaces
+func CommandLine(cmd *types.CommandNode) string {
+	return strings.Join(append([]string{cmd.Name}, cmd.Args...), " ")
+}
+
+// ApproveCommand lets a command line run despite violations for the rest
+// of the session
+func (sc *SecurityChecker) ApproveCommand(cmd *types.CommandNode) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.approved[CommandLine(cmd)] = "session"
+}
+
+// IsApproved reports whether a command line was approved, by a policy or
+// for the session
+func (sc *SecurityChecker) IsApproved(cmd *types.CommandNode) bool {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return sc.isApproved(cmd)
+}
+
+// isApproved is IsApproved for callers holding the lock
+func (sc *SecurityChecker) isApproved(cmd *types.CommandNode) bool {
+	_, ok := sc.approved[CommandLine(cmd)]
+	return ok
+}
+
+// applyApprovals records the approved command lines of a policy
+func (sc *SecurityChecker) applyApprovals(policy *Policy) {
+	for _, line := range policy.Approved {
+		sc.approved[line] = policy.name()
+	}
+}
+
+// SaveApproval adds a command line to the approved list of the policy file
+// the checker was configured from and returns the file written. A profile,
+// a shode.json security section or no policy at all get a new policy file
+// in dir that extends them.
+func (sc *SecurityChecker) SaveApproval(cmd *types.CommandNode, dir string) (string, error) {
+	source := ""
+	if policies := sc.Policies(); len(policies) > 0 {
+		source = policies[len(policies)-1]
+	}
+
+	path := source
+	extends := ""
+	switch {
+	case source == "" || source == "inline":
+		path = filepath.Join(dir, PolicyFileName)
+	case strings.HasPrefix(source, "profile:"):
+		path = filepath.Join(dir, PolicyFileName)
+		extends = strings.TrimPrefix(source, "profile:")
+	case filepath.Base(source) == "shode.json":
+		path = filepath.Join(filepath.Dir(source), PolicyFileName)
+		extends = "shode.json"
+	}
+
+	approvalFileMu.Lock()
+	defer approvalFileMu.Unlock()
+
+	// Edit the file as raw JSON so fields this version does not know survive
+	fields := map[string]json.RawMessage{}
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return path, fmt.Errorf("failed to parse policy file %s: %v", path, err)
+		}
+	case os.IsNotExist(err):
+		if extends != "" {
+			fields["extends"], _ = json.Marshal(extends)
+		}
+	default:
+		return path, fmt.Errorf("failed to read policy file %s: %v", path, err)
+	}
+
+	var approved []string
+	if raw, ok := fields["approved"]; ok {
+		if err := json.Unmarshal(raw, &approved); err != nil {
+			return path, fmt.Errorf("failed to parse approved of %s: %v", path, err)
+		}
+	}
+	line := CommandLine(cmd)
+	for _, existing := range approved {
+		if existing == line {
+			return path, nil
+		}
+	}
+	fields["approved"], _ = json.Marshal(append(approved, line))
+
+	data, err = json.MarshalIndent(fields, "", "  ")
+	if err != nil {
+		return path, err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return path, fmt.Errorf("failed to write policy file %s: %v", path, err)
+	}
+	// Kept across reloads, even of a policy that no longer has the file
+	sc.override(func(sc *SecurityChecker) {
+		sc.approved[line] = path
+	})
+	return path, nil
+}
@@ -0,0 +1,207 @@
+package sandbox
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// CheckContext is where a command runs. Context rules of policies allow or
+// deny commands depending on it.
+type CheckContext struct {
+	Dir       string            // working directory; relative paths are resolved against it
+	Script    string            // script the command is in; empty for commands passed with -c or typed
+	Module    string            // package in sh_models the script belongs to, if any
+	CallStack []string          // scripts being executed, outermost first, ending with Script
+	Env       map[string]string // shell variables when the command runs
+	PipeIndex int               // position of the command in its pipeline, from 0
+	PipeSize  int               // number of commands in the pipeline; 0 if not in one
+}
+
+// ContextRule restricts commands by the scripts, modules and directories
+// they run in. A context matches if every selector given matches. Scripts
+// and modules match any script on the call stack, so scripts sourced by a
+// matching script match too.
+type ContextRule struct {
+	Scripts []string `json:"scripts,omitempty"` // script base names or paths, relative to the policy file; globs allowed
+	Modules []string `json:"modules,omitempty"` // sh_models package names; '*' matches any module
+	Dirs    []string `json:"dirs,omitempty"`    // working directories; "**" spans directories
+	Deny    []string `json:"deny,omitempty"`    // commands that may not run in a matching context
+	Only    []string `json:"only,omitempty"`    // commands that may run only in a matching context
+}
+
+// contextRuleEntry is a context rule with its paths made absolute
+type contextRuleEntry struct {
+	rule    ContextRule
+	scripts []string
+	dirs    []string
+	source  string
+}
+
+// validate checks that a context rule selects a context and commands
+func (r *ContextRule) validate() error {
+	if len(r.Scripts) == 0 && len(r.Modules) == 0 && len(r.Dirs) == 0 {
+		return fmt.Errorf("context rule without scripts, modules or dirs")
+	}
+	if len(r.Deny) == 0 && len(r.Only) == 0 {
+		return fmt.Errorf("context rule without deny or only commands")
+	}
+	return nil
+}
+
+// applyContextRules records the context rules of a policy
+func (sc *SecurityChecker) applyContextRules(policy *Policy) {
+	base := ""
+	if policy.source != "" && !strings.HasPrefix(policy.source, "profile:") {
+		base, _ = filepath.Abs(filepath.Dir(policy.source))
+	}
+	resolve := func(pattern string) string {
+		pattern = expandTilde(pattern)
+		if !filepath.IsAbs(pattern) && base != "" {
+			pattern = filepath.Join(base, pattern)
+		}
+		return pattern
+	}
+
+	for _, rule := range policy.Contexts {
+		entry := contextRuleEntry{rule: rule, source: policy.name()}
+		for _, script := range rule.Scripts {
+			// A bare name matches the script in any directory
+			if !strings.Contains(script, "/") {
+				entry.scripts = append(entry.scripts, script)
+				continue
+			}
+			entry.scripts = append(entry.scripts, resolve(script))
+		}
+		for _, dir := range rule.Dirs {
+			entry.dirs = append(entry.dirs, resolve(dir))
+		}
+		sc.contextRules = append(sc.contextRules, entry)
+	}
+}
+
+// ModuleOf returns the sh_models package a script belongs to, or "" if it
+// is not part of an installed module
+func ModuleOf(script string) string {
+	parts := strings.Split(filepath.ToSlash(script), "/")
+	for i := len(parts) - 2; i >= 0; i-- {
+		if parts[i] != "sh_models" || i+1 >= len(parts)-1 {
+			continue
+		}
+		// Scoped packages take two segments, e.g. @scope/name
+		if strings.HasPrefix(parts[i+1], "@") && i+2 < len(parts)-1 {
+			return parts[i+1] + "/" + parts[i+2]
+		}
+		return parts[i+1]
+	}
+	return ""
+}
+
+// matches reports whether a context is selected by the rule
+func (e *contextRuleEntry) matches(ctx *CheckContext) bool {
+	stack := ctx.CallStack
+	if len(stack) == 0 && ctx.Script != "" {
+		stack = []string{ctx.Script}
+	}
+
+	if len(e.scripts) > 0 && !anyScript(stack, func(script string) bool {
+		for _, pattern := range e.scripts {
+			if !strings.Contains(pattern, "/") {
+				if matched, _ := filepath.Match(pattern, filepath.Base(script)); matched {
+					return true
+				}
+			} else if matchPathGlob(pattern, script) {
+				return true
+			}
+		}
+		return false
+	}) {
+		return false
+	}
+
+	if len(e.rule.Modules) > 0 && !anyScript(stack, func(script string) bool {
+		module := ModuleOf(script)
+		if module == "" {
+			return false
+		}
+		for _, pattern := range e.rule.Modules {
+			if matchWildcard(pattern, module) {
+				return true
+			}
+		}
+		return false
+	}) {
+		return false
+	}
+
+	if len(e.dirs) > 0 {
+		matched := false
+		for _, pattern := range e.dirs {
+			if matchPathGlob(pattern, filepath.Clean(ctx.Dir)) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// anyScript reports whether match accepts a script of the call stack
+func anyScript(stack []string, match func(string) bool) bool {
+	for _, script := range stack {
+		if match(script) {
+			return true
+		}
+	}
+	return false
+}
+
+// describe names the context a rule selects, for violation messages
+func (e *contextRuleEntry) describe() string {
+	var parts []string
+	if len(e.rule.Scripts) > 0 {
+		parts = append(parts, "scripts "+strings.Join(e.rule.Scripts, ", "))
+	}
+	if len(e.rule.Modules) > 0 {
+		parts = append(parts, "modules "+strings.Join(e.rule.Modules, ", "))
+	}
+	if len(e.rule.Dirs) > 0 {
+		parts = append(parts, "dirs "+strings.Join(e.rule.Dirs, ", "))
+	}
+	return strings.Join(parts, " in ")
+}
+
+// checkContextRules applies the context rules to a command known by names
+func (sc *SecurityChecker) checkContextRules(names []string, ctx *CheckContext) error {
+	for i := range sc.contextRules {
+		entry := &sc.contextRules[i]
+		denied := commandListed(entry.rule.Deny, names)
+		only := commandListed(entry.rule.Only, names)
+		if denied == "" && only == "" {
+			continue
+		}
+		matches := entry.matches(ctx)
+		if denied != "" && matches {
+			return fmt.Errorf("security violation: command '%s' may not run in %s (contexts of policy %s)", denied, entry.describe(), entry.source)
+		}
+		if only != "" && !matches {
+			return fmt.Errorf("security violation: command '%s' may only run in %s (contexts of policy %s)", only, entry.describe(), entry.source)
+		}
+	}
+	return nil
+}
+
+// commandListed returns the name under which a command is in list, or ""
+func commandListed(list []string, names []string) string {
+	for _, command := range list {
+		for _, name := range names {
+			if strings.ToLower(command) == name {
+				return name
+			}
+		}
+	}
+	return ""
+}
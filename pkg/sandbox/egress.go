@@ -0,0 +1,315 @@
+package sandbox
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Timeouts of the egress proxy
+const (
+	egressResolveTimeout = 10 * time.Second
+	egressDialTimeout    = 30 * time.Second
+)
+
+// proxyVariables point HTTP clients at the egress proxy
+var proxyVariables = []string{"HTTP_PROXY", "HTTPS_PROXY", "ALL_PROXY", "http_proxy", "https_proxy", "all_proxy"}
+
+// NetworkEvent is a connection a command attempted through the egress
+// proxy, or an HTTP function of the standard library through Dialer
+type NetworkEvent struct {
+	Time    time.Time `json:"time"`
+	Command string    `json:"command"`           // command line that made the connection
+	Host    string    `json:"host"`              // destination as requested
+	Port    int       `json:"port"`              // destination port
+	Address string    `json:"address,omitempty"` // address connected to
+	Allowed bool      `json:"allowed"`           // not blocked by the network policy
+	Reason  string    `json:"reason,omitempty"`  // why the connection was blocked
+	Error   string    `json:"error,omitempty"`   // why an allowed connection failed, e.g. the host did not resolve or refused it
+}
+
+// blockedError is returned for connections the network policy blocks, as
+// opposed to connections that were allowed but failed
+type blockedError struct {
+	destination string
+	reason      string
+}
+
+func (e *blockedError) Error() string {
+	return fmt.Sprintf("connection to %s is %s", e.destination, e.reason)
+}
+
+// SetNetworkLogger sets the function that receives the connections made
+// through the egress proxy and Dialer. It is called from the proxy's
+// goroutines. The default prints blocked connections to stderr.
+func (sc *SecurityChecker) SetNetworkLogger(logger func(NetworkEvent)) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.networkLogger = logger
+}
+
+// NetworkLogger returns the function that receives egress proxy
+// connections, so callers can wrap it
+func (sc *SecurityChecker) NetworkLogger() func(NetworkEvent) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return sc.networkLogger
+}
+
+// logBlockedConnection is the default network logger. Connections that
+// fail without being blocked are reported by the command that made them.
+func logBlockedConnection(event NetworkEvent) {
+	if !event.Allowed {
+		fmt.Fprintf(os.Stderr, "shode: network: blocked connection to %s from '%s': %s\n",
+			net.JoinHostPort(event.Host, strconv.Itoa(event.Port)), event.Command, event.Reason)
+	}
+}
+
+// egressProxy is an HTTP proxy that connects a single command to the
+// destinations the network policy allows
+type egressProxy struct {
+	listener net.Listener
+	checker  *SecurityChecker
+	command  string
+}
+
+// startEgressProxy listens on a loopback port for the connections of a
+// command
+func (sc *SecurityChecker) startEgressProxy(command string) (*egressProxy, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to start egress proxy: %v", err)
+	}
+	proxy := &egressProxy{listener: listener, checker: sc, command: command}
+	go proxy.serve()
+	return proxy, nil
+}
+
+// port returns the port the proxy listens on
+func (p *egressProxy) port() int {
+	return p.listener.Addr().(*net.TCPAddr).Port
+}
+
+// url returns the proxy URL for the proxy environment variables
+func (p *egressProxy) url() string {
+	return "http://" + p.listener.Addr().String()
+}
+
+// close stops accepting connections; open connections run to completion
+func (p *egressProxy) close() {
+	p.listener.Close()
+}
+
+// serve accepts connections until the proxy is closed
+func (p *egressProxy) serve() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		go p.handle(conn)
+	}
+}
+
+// handle serves one client connection: a CONNECT tunnel or a single plain
+// HTTP request
+func (p *egressProxy) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	req, err := http.ReadRequest(reader)
+	if err != nil {
+		return
+	}
+
+	hostPort := req.Host
+	if req.Method != http.MethodConnect {
+		if req.URL.Host == "" {
+			writeProxyError(conn, http.StatusBadRequest, "shode egress proxy: request must use an absolute URL")
+			return
+		}
+		hostPort = req.URL.Host
+		if req.URL.Port() == "" {
+			hostPort = net.JoinHostPort(req.URL.Hostname(), "80")
+		}
+	}
+	host, portText, err := net.SplitHostPort(hostPort)
+	port, convErr := strconv.Atoi(portText)
+	if err != nil || convErr != nil {
+		writeProxyError(conn, http.StatusBadRequest, "shode egress proxy: invalid destination "+hostPort)
+		return
+	}
+
+	upstream, err := p.dial(host, port)
+	if err != nil {
+		status := http.StatusBadGateway
+		var blocked *blockedError
+		if errors.As(err, &blocked) {
+			status = http.StatusForbidden
+		}
+		writeProxyError(conn, status, fmt.Sprintf("shode egress proxy: %v", err))
+		return
+	}
+	defer upstream.Close()
+
+	if req.Method == http.MethodConnect {
+		if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+			return
+		}
+		go func() {
+			io.Copy(upstream, reader)
+			upstream.Close()
+		}()
+		io.Copy(conn, upstream)
+		return
+	}
+
+	// Forward one request; the connection is not reused for another host
+	req.Header.Del("Proxy-Authorization")
+	req.Header.Del("Proxy-Connection")
+	req.Close = true
+	if err := req.Write(upstream); err != nil {
+		return
+	}
+	io.Copy(conn, upstream)
+}
+
+// dial connects the proxy to a destination
+func (p *egressProxy) dial(host string, port int) (net.Conn, error) {
+	return p.checker.dialChecked(context.Background(), p.command, host, port)
+}
+
+// Dialer returns a dial function for HTTP clients of the engine that
+// connects only to destinations the network policy allows, reporting each
+// connection to the network logger as made by command
+func (sc *SecurityChecker) Dialer(command string) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, portText, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		port, err := strconv.Atoi(portText)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port in %s", address)
+		}
+		return sc.dialChecked(ctx, command, host, port)
+	}
+}
+
+// dialChecked connects to the addresses of a destination the network
+// policy allows, in turn until one accepts the connection, so an IPv4
+// address is tried when IPv6 fails. The address checked is the one
+// connected to, so a name cannot resolve differently between the check and
+// the connection. Only policy denials are logged as blocked; resolution and
+// connection failures are logged as allowed events with an error.
+func (sc *SecurityChecker) dialChecked(ctx context.Context, command, host string, port int) (net.Conn, error) {
+	event := NetworkEvent{Time: time.Now(), Command: command, Host: host, Port: port}
+	addresses, err := resolveHost(ctx, host)
+	if err != nil {
+		event.Allowed = true
+		event.Error = fmt.Sprintf("cannot resolve %s: %v", host, err)
+		sc.logNetwork(event)
+		return nil, fmt.Errorf("%s", event.Error)
+	}
+
+	reason := ""
+	var dialErr error
+	for _, ip := range addresses {
+		sc.mu.RLock()
+		allowed, why := sc.networkAccess(host, ip, port)
+		sc.mu.RUnlock()
+		if !allowed {
+			if reason == "" {
+				reason = why
+			}
+			continue
+		}
+		attempt := event
+		attempt.Time = time.Now()
+		attempt.Address = net.JoinHostPort(ip.String(), strconv.Itoa(port))
+		attempt.Allowed = true
+		dialer := net.Dialer{Timeout: egressDialTimeout}
+		conn, err := dialer.DialContext(ctx, "tcp", attempt.Address)
+		if err != nil {
+			attempt.Error = err.Error()
+			sc.logNetwork(attempt)
+			dialErr = err
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		sc.logNetwork(attempt)
+		return conn, nil
+	}
+	if dialErr != nil {
+		return nil, dialErr
+	}
+
+	event.Reason = reason
+	sc.logNetwork(event)
+	return nil, &blockedError{destination: net.JoinHostPort(host, strconv.Itoa(port)), reason: reason}
+}
+
+// logNetwork passes an event to the network logger
+func (sc *SecurityChecker) logNetwork(event NetworkEvent) {
+	if logger := sc.NetworkLogger(); logger != nil {
+		logger(event)
+	}
+}
+
+// resolveHost returns the addresses of a host name or the address itself
+func resolveHost(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, egressResolveTimeout)
+	defer cancel()
+	resolved, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	addresses := make([]net.IP, 0, len(resolved))
+	for _, address := range resolved {
+		addresses = append(addresses, address.IP)
+	}
+	return addresses, nil
+}
+
+// writeProxyError answers a client with an error status
+func writeProxyError(conn net.Conn, status int, message string) {
+	fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\nContent-Type: text/plain\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s\n",
+		status, http.StatusText(status), len(message)+1, message)
+}
+
+// guardNetwork applies the network policy to a command: with no
+// destination allowed the command runs without network, otherwise its HTTP
+// clients are pointed at an egress proxy. It returns the proxy, or nil.
+func (sc *SecurityChecker) guardNetwork(command *exec.Cmd) (*egressProxy, error) {
+	if sc.network == nil || sc.isolateNetwork() {
+		return nil, nil
+	}
+	proxy, err := sc.startEgressProxy(strings.Join(command.Args, " "))
+	if err != nil {
+		return nil, err
+	}
+
+	env := command.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	env = withoutEnv(withoutEnv(env, "NO_PROXY"), "no_proxy")
+	for _, name := range proxyVariables {
+		env = append(withoutEnv(env, name), name+"="+proxy.url())
+	}
+	command.Env = env
+	return proxy, nil
+}
@@ -0,0 +1,277 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// enforceEnv carries the sandbox configuration to the helper process that
+// confines itself and then executes the command
+const enforceEnv = "SHODE_SANDBOX_EXEC"
+
+// Namespaces an enforced command can be isolated in
+const (
+	NamespaceUser    = "user"
+	NamespaceMount   = "mount"
+	NamespaceNetwork = "network"
+	NamespacePID     = "pid"
+)
+
+// Limits on the Landlock rules derived from path rules
+const (
+	maxCarveDepth    = 8
+	maxLandlockRules = 4096
+)
+
+// A process started by Confine is the sandbox helper: it confines itself
+// and executes the command before anything else runs
+func init() {
+	if config, ok := os.LookupEnv(enforceEnv); ok {
+		runConfined(config)
+	}
+}
+
+// EnforcementPolicy configures the kernel sandbox external commands run in.
+// Static checks only see the command line; the sandbox also confines what a
+// binary does once it runs.
+type EnforcementPolicy struct {
+	Landlock   bool     `json:"landlock,omitempty"`   // restrict file access to what the path rules grant
+	Seccomp    bool     `json:"seccomp,omitempty"`    // block system administration syscalls
+	Namespaces []string `json:"namespaces,omitempty"` // "user", "mount", "network" and "pid"
+
+	// BestEffort runs commands without the Landlock or seccomp layer when
+	// the kernel does not support it, instead of refusing to run them
+	BestEffort bool `json:"bestEffort,omitempty"`
+}
+
+// enabled reports whether any enforcement is configured
+func (e *EnforcementPolicy) enabled() bool {
+	return e != nil && (e.Landlock || e.Seccomp || len(e.Namespaces) > 0)
+}
+
+// hasNamespace reports whether a namespace is requested
+func (e EnforcementPolicy) hasNamespace(name string) bool {
+	for _, namespace := range e.Namespaces {
+		if namespace == name {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks the namespace names
+func (e *EnforcementPolicy) validate() error {
+	for _, namespace := range e.Namespaces {
+		switch namespace {
+		case NamespaceUser, NamespaceMount, NamespaceNetwork, NamespacePID:
+		default:
+			return fmt.Errorf("enforcement: unknown namespace %q (expected user, mount, network or pid)", namespace)
+		}
+	}
+	return nil
+}
+
+// Enforcement returns the kernel sandbox settings, or nil if external
+// commands run unconfined
+func (sc *SecurityChecker) Enforcement() *EnforcementPolicy {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	if !sc.enforcement.enabled() {
+		return nil
+	}
+	enforcement := *sc.enforcement
+	return &enforcement
+}
+
+// SetEnforcement sets the kernel sandbox settings; nil disables it
+func (sc *SecurityChecker) SetEnforcement(enforcement *EnforcementPolicy) error {
+	if enforcement != nil {
+		if err := enforcement.validate(); err != nil {
+			return err
+		}
+		copied := *enforcement
+		enforcement = &copied
+	}
+	sc.override(func(sc *SecurityChecker) {
+		sc.enforcement = enforcement
+	})
+	return nil
+}
+
+// Confine prepares a command to run in the kernel sandbox and under the
+// network policy. The command is started through a helper that sets
+// no_new_privs, applies Landlock rules derived from the path rules and a
+// seccomp filter, and then executes it, optionally inside new namespaces.
+// The returned function releases the resources of the network policy once
+// the command has finished. Confine does nothing when neither enforcement
+// nor a network policy nor an overlay transaction is configured or the
+// command cannot run anyway.
+func (sc *SecurityChecker) Confine(command *exec.Cmd) (func(), error) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	release := func() {}
+	if command.Err != nil || (!sc.enforcement.enabled() && sc.network == nil && sc.overlay() == nil) {
+		return release, nil
+	}
+
+	proxy, err := sc.guardNetwork(command)
+	if err != nil {
+		return release, err
+	}
+	if proxy != nil {
+		release = proxy.close
+	}
+	if err := sc.confine(command, proxy); err != nil {
+		release()
+		return func() {}, err
+	}
+	return release, nil
+}
+
+// withoutEnv removes a variable from an environment
+func withoutEnv(env []string, name string) []string {
+	kept := make([]string, 0, len(env)+1)
+	for _, entry := range env {
+		if !strings.HasPrefix(entry, name+"=") {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// helperConfig is what the helper process needs to confine and execute
+// the command
+type helperConfig struct {
+	Path     string         `json:"path"`
+	Args     []string       `json:"args"`
+	Landlock bool           `json:"landlock"`
+	Rules    []landlockRule `json:"rules,omitempty"`
+	Seccomp  bool           `json:"seccomp"`
+
+	// RestrictConnect limits TCP connections to ConnectPorts
+	RestrictConnect bool  `json:"restrictConnect,omitempty"`
+	ConnectPorts    []int `json:"connectPorts,omitempty"`
+
+	// Overlay is mounted over the tree of a transaction, after which the
+	// command runs in Dir. A probe exits once the overlay is mounted.
+	Overlay *overlayMount `json:"overlay,omitempty"`
+	Dir     string        `json:"dir,omitempty"`
+	Probe   bool          `json:"probe,omitempty"`
+}
+
+// landlockRule grants access to a file or directory tree
+type landlockRule struct {
+	Path     string     `json:"path"`
+	Perm     Permission `json:"perm"`
+	ListOnly bool       `json:"listOnly,omitempty"` // only listing the directory is granted
+}
+
+// runtimeProtected reports whether a built-in sensitive path is enforced by
+// Landlock. /etc/passwd, /dev, /proc, /sys and /var/log are read by too many
+// ordinary programs and stay protected by static checks only.
+func runtimeProtected(entry string) bool {
+	switch filepath.Clean(entry) {
+	case "/etc/passwd", "/dev", "/proc", "/sys", "/var/log":
+		return false
+	}
+	return true
+}
+
+// landlockRules derives the Landlock rules for a command run in dir. Landlock
+// only grants access, so directories that contain differently protected
+// paths are split: the directory may be listed and each entry is granted
+// what the path rules allow it.
+func (sc *SecurityChecker) landlockRules(dir string) []landlockRule {
+	dirs := resolvePathForms(".", dir)
+	var rules []landlockRule
+	sc.carve("/", dirs, 0, &rules)
+	for file := range specialFiles {
+		rules = append(rules, landlockRule{Path: file, Perm: PermRead | PermWrite})
+	}
+	return rules
+}
+
+// carve adds the rules granting access to a path and what lies beneath it
+func (sc *SecurityChecker) carve(path string, dirs []string, depth int, rules *[]landlockRule) {
+	perm, _, _ := sc.decideAccess(path, dirs, runtimeProtected)
+	grant := func() {
+		if perm != PermNone {
+			*rules = append(*rules, landlockRule{Path: path, Perm: perm})
+		}
+	}
+	if depth >= maxCarveDepth || len(*rules) >= maxLandlockRules || !sc.splitsBelow(path, dirs) {
+		grant()
+		return
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		grant()
+		return
+	}
+
+	if perm&PermRead != 0 {
+		*rules = append(*rules, landlockRule{Path: path, Perm: PermRead, ListOnly: true})
+	}
+	for _, entry := range entries {
+		// Symlinks are governed by the rules of their targets
+		if entry.Type()&os.ModeSymlink != 0 {
+			continue
+		}
+		sc.carve(filepath.Join(path, entry.Name()), dirs, depth+1, rules)
+	}
+}
+
+// splitsBelow reports whether paths beneath a directory may be granted
+// different access than the directory itself
+func (sc *SecurityChecker) splitsBelow(path string, dirs []string) bool {
+	perm, decided, _ := sc.decideAccess(path, dirs, runtimeProtected)
+	for i := range sc.pathRules {
+		rule := &sc.pathRules[i]
+		reaches, wholeTree := reachesBelow(rule.pattern, path)
+		// A rule for the whole tree that also decides the directory
+		// grants the same access everywhere beneath it
+		if reaches && !(wholeTree && rule == decided) {
+			return true
+		}
+	}
+	for entry := range sc.fileBlacklist {
+		entry = filepath.Clean(entry)
+		if runtimeProtected(entry) && entry != path && underPath(entry, path) {
+			return true
+		}
+	}
+	for _, dir := range dirs {
+		if dir != path && underPath(dir, path) {
+			if granted, _, _ := sc.decideAccess(dir, dirs, runtimeProtected); granted != perm {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// reachesBelow reports whether a path glob can match a path strictly
+// beneath dir, and whether it matches the whole tree beneath it
+func reachesBelow(pattern, dir string) (reaches, wholeTree bool) {
+	patternSegments := strings.Split(strings.Trim(pattern, "/"), "/")
+	var dirSegments []string
+	if dir != "/" {
+		dirSegments = strings.Split(strings.Trim(dir, "/"), "/")
+	}
+	for i, segment := range dirSegments {
+		if i >= len(patternSegments) {
+			return false, false
+		}
+		if patternSegments[i] == "**" {
+			return true, i == len(patternSegments)-1
+		}
+		if matched, err := filepath.Match(patternSegments[i], segment); err != nil || !matched {
+			return false, false
+		}
+	}
+	rest := patternSegments[len(dirSegments):]
+	return len(rest) > 0, len(rest) == 1 && rest[0] == "**"
+}
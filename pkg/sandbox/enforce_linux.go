@@ -0,0 +1,344 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// Kernel interfaces not covered by the syscall package
+const (
+	sysLandlockCreateRuleset = 444
+	sysLandlockAddRule       = 445
+	sysLandlockRestrictSelf  = 446
+
+	landlockCreateRulesetVersion = 1
+	landlockRulePathBeneath      = 1
+	landlockRuleNetPort          = 2
+	landlockConnectTCP           = 1 << 1 // ABI 4
+
+	prSetNoNewPrivs = 38
+	oPath           = 0x200000
+
+	// maxHelperConfig keeps the configuration below the kernel's limit on
+	// the size of one environment variable
+	maxHelperConfig = 120 * 1024
+)
+
+// Landlock filesystem access rights
+const (
+	landlockExecute    = 1 << 0
+	landlockWriteFile  = 1 << 1
+	landlockReadFile   = 1 << 2
+	landlockReadDir    = 1 << 3
+	landlockRemoveDir  = 1 << 4
+	landlockRemoveFile = 1 << 5
+	landlockMakeChar   = 1 << 6
+	landlockMakeDir    = 1 << 7
+	landlockMakeReg    = 1 << 8
+	landlockMakeSock   = 1 << 9
+	landlockMakeFifo   = 1 << 10
+	landlockMakeBlock  = 1 << 11
+	landlockMakeSym    = 1 << 12
+	landlockRefer      = 1 << 13 // ABI 2
+	landlockTruncate   = 1 << 14 // ABI 3
+
+	landlockFileAccess = landlockExecute | landlockWriteFile | landlockReadFile | landlockTruncate
+)
+
+var (
+	landlockOnce    sync.Once
+	landlockVersion int
+)
+
+// landlockABI returns the Landlock ABI version of the kernel, or 0 if
+// Landlock is unavailable
+func landlockABI() int {
+	landlockOnce.Do(func() {
+		version, _, errno := syscall.Syscall(sysLandlockCreateRuleset, 0, 0, landlockCreateRulesetVersion)
+		if errno == 0 {
+			landlockVersion = int(version)
+		}
+	})
+	return landlockVersion
+}
+
+// landlockHandled returns the access rights an ABI version can restrict
+func landlockHandled(abi int) uint64 {
+	handled := uint64(landlockExecute | landlockWriteFile | landlockReadFile | landlockReadDir |
+		landlockRemoveDir | landlockRemoveFile | landlockMakeChar | landlockMakeDir | landlockMakeReg |
+		landlockMakeSock | landlockMakeFifo | landlockMakeBlock | landlockMakeSym)
+	if abi >= 2 {
+		handled |= landlockRefer
+	}
+	if abi >= 3 {
+		handled |= landlockTruncate
+	}
+	return handled
+}
+
+// landlockAccess converts a permission to Landlock access rights
+func landlockAccess(perm Permission) uint64 {
+	var access uint64
+	if perm&PermRead != 0 {
+		access |= landlockReadFile | landlockReadDir
+	}
+	if perm&PermWrite != 0 {
+		access |= landlockWriteFile | landlockRemoveDir | landlockRemoveFile | landlockMakeChar |
+			landlockMakeDir | landlockMakeReg | landlockMakeSock | landlockMakeFifo | landlockMakeBlock |
+			landlockMakeSym | landlockRefer | landlockTruncate
+	}
+	if perm&PermExecute != 0 {
+		access |= landlockExecute
+	}
+	return access
+}
+
+// confine starts the command through the sandbox helper and in the
+// namespaces the enforcement and network policy call for
+func (sc *SecurityChecker) confine(command *exec.Cmd, proxy *egressProxy) error {
+	enforcement := EnforcementPolicy{}
+	if sc.enforcement != nil {
+		enforcement = *sc.enforcement
+	}
+	config := helperConfig{Path: command.Path, Args: command.Args}
+
+	if enforcement.Landlock {
+		if landlockABI() > 0 {
+			config.Landlock = true
+			config.Rules = sc.landlockRules(command.Dir)
+		} else if !enforcement.BestEffort {
+			return fmt.Errorf("landlock is not supported by this kernel")
+		}
+	}
+	if enforcement.Seccomp {
+		if auditArch != 0 {
+			config.Seccomp = true
+		} else if !enforcement.BestEffort {
+			return fmt.Errorf("seccomp filtering is not supported on %s", runtime.GOARCH)
+		}
+	}
+
+	// With the network denied by default, TCP connections may only go
+	// through the egress proxy
+	if proxy != nil && sc.network.defaultDeny && landlockABI() >= 4 {
+		config.RestrictConnect = true
+		config.ConnectPorts = []int{proxy.port()}
+	}
+
+	// The helper mounts the overlay of a transaction over its tree and then
+	// changes to the command's directory, which may only exist in the overlay
+	if overlay := sc.overlay(); overlay != nil {
+		config.Overlay = overlay
+		config.Dir = command.Dir
+		if config.Dir == "" {
+			config.Dir, _ = os.Getwd()
+		}
+		command.Dir = overlay.Lower
+	}
+
+	if config.Landlock || config.Seccomp || config.RestrictConnect || config.Overlay != nil {
+		if err := useHelper(command, config); err != nil {
+			return err
+		}
+	}
+
+	if command.SysProcAttr == nil {
+		command.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	setNamespaces(command.SysProcAttr, enforcement, sc.isolateNetwork(), config.Overlay != nil)
+	return nil
+}
+
+// useHelper replaces a command by the sandbox helper, which executes it
+// once confined
+func useHelper(command *exec.Cmd, config helperConfig) error {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("failed to encode sandbox configuration: %v", err)
+	}
+	if len(data) > maxHelperConfig {
+		return fmt.Errorf("too many Landlock rules (%d); use fewer or broader path rules", len(config.Rules))
+	}
+	helper, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("cannot locate sandbox helper: %v", err)
+	}
+
+	env := command.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	command.Path = helper
+	command.Args = []string{helper}
+	command.Env = append(withoutEnv(env, enforceEnv), enforceEnv+"="+string(data))
+	return nil
+}
+
+// setNamespaces requests the configured namespaces, an empty network
+// namespace when isolateNetwork is set, and a user and mount namespace to
+// mount an overlay in. Without root the other namespaces need a user
+// namespace, which maps the current user to itself.
+func setNamespaces(attr *syscall.SysProcAttr, enforcement EnforcementPolicy, isolateNetwork, overlay bool) {
+	var flags uintptr
+	if enforcement.hasNamespace(NamespaceMount) || overlay {
+		flags |= syscall.CLONE_NEWNS
+	}
+	if enforcement.hasNamespace(NamespaceNetwork) || isolateNetwork {
+		flags |= syscall.CLONE_NEWNET
+	}
+	if enforcement.hasNamespace(NamespacePID) {
+		flags |= syscall.CLONE_NEWPID
+	}
+	if enforcement.hasNamespace(NamespaceUser) || overlay || (flags != 0 && os.Geteuid() != 0) {
+		flags |= syscall.CLONE_NEWUSER
+		attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1}}
+		attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1}}
+		attr.GidMappingsEnableSetgroups = false
+	}
+	attr.Cloneflags |= flags
+}
+
+// runConfined is the sandbox helper: it confines its own thread and
+// executes the command in its place. It never returns.
+func runConfined(data string) {
+	// no_new_privs, Landlock and seccomp apply to the calling thread, which
+	// is the one that executes the command
+	runtime.LockOSThread()
+
+	var config helperConfig
+	if err := json.Unmarshal([]byte(data), &config); err != nil {
+		failConfined(fmt.Errorf("invalid configuration: %v", err))
+	}
+	env := withoutEnv(os.Environ(), enforceEnv)
+
+	if config.Overlay != nil {
+		if err := mountOverlay(config.Overlay); err != nil {
+			failConfined(err)
+		}
+		if config.Probe {
+			os.Exit(0)
+		}
+		if err := dropCapabilities(); err != nil {
+			failConfined(err)
+		}
+	}
+	if config.Dir != "" {
+		if err := os.Chdir(config.Dir); err != nil {
+			failConfined(fmt.Errorf("cannot change to %s: %v", config.Dir, err))
+		}
+	}
+
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0); errno != 0 {
+		failConfined(fmt.Errorf("failed to set no_new_privs: %v", errno))
+	}
+	if config.Landlock || config.RestrictConnect {
+		if err := restrictLandlock(&config); err != nil {
+			failConfined(err)
+		}
+	}
+	if config.Seccomp {
+		if err := installSeccomp(); err != nil {
+			failConfined(err)
+		}
+	}
+
+	err := syscall.Exec(config.Path, config.Args, env)
+	failConfined(fmt.Errorf("cannot execute %s: %v", config.Path, err))
+}
+
+// failConfined reports a sandbox setup error and exits like a shell does for
+// a command that cannot be executed
+func failConfined(err error) {
+	fmt.Fprintf(os.Stderr, "shode: sandbox: %v\n", err)
+	os.Exit(126)
+}
+
+// restrictLandlock confines the calling thread to the file rules and the
+// TCP ports of a configuration
+func restrictLandlock(config *helperConfig) error {
+	abi := landlockABI()
+	var attr struct{ handledAccessFS, handledAccessNet uint64 }
+	size := unsafe.Sizeof(attr.handledAccessFS)
+	if config.Landlock {
+		attr.handledAccessFS = landlockHandled(abi)
+	}
+	if config.RestrictConnect {
+		attr.handledAccessNet = landlockConnectTCP
+		size = unsafe.Sizeof(attr)
+	}
+	rulesetFd, _, errno := syscall.Syscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&attr)), size, 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to create Landlock ruleset: %v", errno)
+	}
+	defer syscall.Close(int(rulesetFd))
+
+	if config.Landlock {
+		for _, rule := range config.Rules {
+			if err := addLandlockRule(int(rulesetFd), rule, attr.handledAccessFS); err != nil {
+				return err
+			}
+		}
+	}
+	for _, port := range config.ConnectPorts {
+		rule := struct{ allowedAccess, port uint64 }{landlockConnectTCP, uint64(port)}
+		if _, _, errno := syscall.Syscall6(sysLandlockAddRule, rulesetFd, landlockRuleNetPort,
+			uintptr(unsafe.Pointer(&rule)), 0, 0, 0); errno != 0 {
+			return fmt.Errorf("failed to allow connections to port %d: %v", port, errno)
+		}
+	}
+
+	if _, _, errno := syscall.Syscall(sysLandlockRestrictSelf, rulesetFd, 0, 0); errno != 0 {
+		return fmt.Errorf("failed to enforce Landlock ruleset: %v", errno)
+	}
+	return nil
+}
+
+// addLandlockRule grants a rule's access beneath its path. Paths that no
+// longer exist are skipped.
+func addLandlockRule(rulesetFd int, rule landlockRule, handled uint64) error {
+	fd, err := syscall.Open(rule.Path, oPath|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil
+	}
+	defer syscall.Close(fd)
+
+	access := landlockAccess(rule.Perm)
+	if rule.ListOnly {
+		access = landlockReadDir
+	}
+	var stat syscall.Stat_t
+	if err := syscall.Fstat(fd, &stat); err != nil {
+		return nil
+	}
+	switch stat.Mode & syscall.S_IFMT {
+	case syscall.S_IFDIR:
+	case syscall.S_IFREG, syscall.S_IFCHR, syscall.S_IFBLK:
+		access &= landlockFileAccess
+	default:
+		// Pipes and sockets, as /dev/stdout may be, are not files Landlock
+		// restricts
+		return nil
+	}
+	access &= handled
+	if access == 0 {
+		return nil
+	}
+
+	// struct landlock_path_beneath_attr is packed: a u64 followed by an s32
+	var beneath [12]byte
+	*(*uint64)(unsafe.Pointer(&beneath[0])) = access
+	*(*int32)(unsafe.Pointer(&beneath[8])) = int32(fd)
+	if _, _, errno := syscall.Syscall6(sysLandlockAddRule, uintptr(rulesetFd), landlockRulePathBeneath,
+		uintptr(unsafe.Pointer(&beneath[0])), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("failed to add Landlock rule for %s: %v", rule.Path, errno)
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// confine fails unless enforcement is best effort: the kernel sandbox needs
+// Linux. Namespaces, including the one a network policy without allowed
+// destinations uses, cannot be skipped. The egress proxy works everywhere.
+func (sc *SecurityChecker) confine(command *exec.Cmd, proxy *egressProxy) error {
+	if sc.isolateNetwork() {
+		return fmt.Errorf("network isolation is only supported on Linux")
+	}
+	if !sc.enforcement.enabled() || sc.enforcement.BestEffort && len(sc.enforcement.Namespaces) == 0 {
+		return nil
+	}
+	return fmt.Errorf("kernel enforcement is only supported on Linux")
+}
+
+// runConfined refuses to run the command
+func runConfined(data string) {
+	fmt.Fprintln(os.Stderr, "shode: sandbox: kernel enforcement is only supported on Linux")
+	os.Exit(126)
+}
@@ -0,0 +1,263 @@
+package sandbox
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Network policy defaults
+const (
+	NetworkAllow = "allow" // destinations not denied may be reached (the default)
+	NetworkDeny  = "deny"  // only destinations in network.allow may be reached
+)
+
+// NetworkPolicy restricts the destinations commands may connect to. Entries
+// are host names ("github.com"), subdomain wildcards ("*.npmjs.org"), IP
+// addresses or CIDR ranges ("10.0.0.0/8"), each optionally followed by a
+// port ("api.example.com:443", "[::1]:8080"). "*" matches any host.
+type NetworkPolicy struct {
+	Default string   `json:"default,omitempty"` // "allow" (default) or "deny"
+	Allow   []string `json:"allow,omitempty"`   // destinations that may be reached
+	Deny    []string `json:"deny,omitempty"`    // destinations that may not be reached; takes precedence over allow
+}
+
+// networkEntry is a parsed destination of a network policy
+type networkEntry struct {
+	raw     string
+	host    string     // lower-case host name, "*.domain" or "*"; empty for addresses
+	network *net.IPNet // address or range
+	port    int        // 0 matches any port
+	source  string     // policy the entry came from
+}
+
+// networkRules are the network policies applied to a checker
+type networkRules struct {
+	defaultDeny   bool
+	defaultSource string
+	allow         []networkEntry
+	deny          []networkEntry
+}
+
+// validate checks the default and the entries of a network policy
+func (n *NetworkPolicy) validate() error {
+	if n.Default != "" && n.Default != NetworkAllow && n.Default != NetworkDeny {
+		return fmt.Errorf("network.default must be %q or %q, got %q", NetworkAllow, NetworkDeny, n.Default)
+	}
+	for _, raw := range append(append([]string(nil), n.Allow...), n.Deny...) {
+		if _, err := parseNetworkEntry(raw, ""); err != nil {
+			return fmt.Errorf("network entry %q: %v", raw, err)
+		}
+	}
+	return nil
+}
+
+// parseNetworkEntry parses a host, address or range with an optional port
+func parseNetworkEntry(raw, source string) (networkEntry, error) {
+	entry := networkEntry{raw: raw, source: source}
+	hostPart := raw
+	portPart := ""
+	switch {
+	case strings.HasPrefix(raw, "["):
+		end := strings.Index(raw, "]")
+		if end < 0 {
+			return entry, fmt.Errorf("missing ]")
+		}
+		hostPart = raw[1:end]
+		if rest := raw[end+1:]; rest != "" {
+			if !strings.HasPrefix(rest, ":") {
+				return entry, fmt.Errorf("unexpected %q after ]", rest)
+			}
+			portPart = rest[1:]
+		}
+	case strings.Count(raw, ":") == 1:
+		hostPart, portPart, _ = strings.Cut(raw, ":")
+	}
+
+	if portPart != "" {
+		port, err := strconv.Atoi(portPart)
+		if err != nil || port < 1 || port > 65535 {
+			return entry, fmt.Errorf("invalid port %q", portPart)
+		}
+		entry.port = port
+	}
+
+	switch {
+	case hostPart == "":
+		return entry, fmt.Errorf("host is empty")
+	case strings.Contains(hostPart, "/"):
+		_, network, err := net.ParseCIDR(hostPart)
+		if err != nil {
+			return entry, fmt.Errorf("invalid CIDR %q", hostPart)
+		}
+		entry.network = network
+	case net.ParseIP(hostPart) != nil:
+		ip := net.ParseIP(hostPart)
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		entry.network = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+	default:
+		entry.host = strings.ToLower(strings.TrimSuffix(hostPart, "."))
+	}
+	return entry, nil
+}
+
+// matches reports whether the entry covers a destination. ip is the
+// address being connected to, or nil if only the name is known.
+func (e networkEntry) matches(host string, ip net.IP, port int) bool {
+	if e.port != 0 && e.port != port {
+		return false
+	}
+	if e.network != nil {
+		return ip != nil && e.network.Contains(ip)
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	switch {
+	case e.host == "*":
+		return true
+	case strings.HasPrefix(e.host, "*."):
+		return strings.HasSuffix(host, e.host[1:])
+	}
+	return host == e.host
+}
+
+// applyNetwork merges the network section of a policy
+func (sc *SecurityChecker) applyNetwork(policy *Policy) {
+	if policy.Network == nil {
+		return
+	}
+	if sc.network == nil {
+		sc.network = &networkRules{}
+	}
+	if policy.Network.Default != "" {
+		sc.network.defaultDeny = policy.Network.Default == NetworkDeny
+		sc.network.defaultSource = policy.name()
+	}
+	for _, raw := range policy.Network.Allow {
+		if entry, err := parseNetworkEntry(raw, policy.name()); err == nil {
+			sc.network.allow = append(sc.network.allow, entry)
+		}
+	}
+	for _, raw := range policy.Network.Deny {
+		if entry, err := parseNetworkEntry(raw, policy.name()); err == nil {
+			sc.network.deny = append(sc.network.deny, entry)
+		}
+	}
+}
+
+// networkAccess decides whether a destination may be reached. ip is nil
+// when the host name has not been resolved. The reason explains a denial.
+func (sc *SecurityChecker) networkAccess(host string, ip net.IP, port int) (bool, string) {
+	rules := sc.network
+	if rules == nil {
+		return true, ""
+	}
+	if ip == nil {
+		ip = net.ParseIP(host)
+	}
+	if entry := rules.denyEntry(host, ip, port); entry != nil {
+		return false, fmt.Sprintf("denied by network.deny entry '%s' of policy %s", entry.raw, entry.source)
+	}
+	for _, entry := range rules.allow {
+		if entry.matches(host, ip, port) {
+			return true, ""
+		}
+	}
+	if rules.defaultDeny {
+		return false, fmt.Sprintf("not in network.allow of policy %s", rules.defaultSource)
+	}
+	return true, ""
+}
+
+// denyEntry returns the deny entry matching a destination, or nil
+func (rules *networkRules) denyEntry(host string, ip net.IP, port int) *networkEntry {
+	for i := range rules.deny {
+		if rules.deny[i].matches(host, ip, port) {
+			return &rules.deny[i]
+		}
+	}
+	return nil
+}
+
+// isolateNetwork reports whether commands may not reach any destination,
+// so they can run without network access at all
+func (sc *SecurityChecker) isolateNetwork() bool {
+	return sc.network != nil && sc.network.defaultDeny && len(sc.network.allow) == 0
+}
+
+// hasAddressRules reports whether allow entries match addresses, which
+// host names only reveal once resolved
+func (rules *networkRules) hasAddressRules() bool {
+	for _, entry := range rules.allow {
+		if entry.network != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// checkNetworkArguments checks the URLs in a command's arguments against
+// the network policy. Host names that address rules could allow once
+// resolved are left to the runtime check.
+func (sc *SecurityChecker) checkNetworkArguments(args []string) error {
+	if sc.network == nil {
+		return nil
+	}
+	for _, arg := range args {
+		host, port, ok := urlDestination(arg)
+		if !ok {
+			continue
+		}
+		allowed, reason := sc.networkAccess(host, nil, port)
+		if allowed {
+			continue
+		}
+		// A host name may resolve into an allowed range
+		ip := net.ParseIP(host)
+		if ip == nil && sc.network.hasAddressRules() && sc.network.denyEntry(host, ip, port) == nil {
+			continue
+		}
+		return fmt.Errorf("security violation: network access to '%s' is %s", net.JoinHostPort(host, strconv.Itoa(port)), reason)
+	}
+	return nil
+}
+
+// defaultPorts are the ports of URL schemes commands commonly fetch
+var defaultPorts = map[string]int{
+	"http": 80, "https": 443, "ftp": 21, "ssh": 22, "git": 9418, "ws": 80, "wss": 443,
+	"rsync": 873, "sftp": 22, "scp": 22,
+}
+
+// urlDestination returns the host and port of a URL argument, also when it
+// is the value of an option such as --url=https://host
+func urlDestination(arg string) (string, int, bool) {
+	start := strings.Index(arg, "://")
+	if start < 0 {
+		return "", 0, false
+	}
+	for start > 0 && isSchemeChar(arg[start-1]) {
+		start--
+	}
+	parsed, err := url.Parse(arg[start:])
+	if err != nil || parsed.Hostname() == "" {
+		return "", 0, false
+	}
+	port, ok := defaultPorts[strings.ToLower(parsed.Scheme)]
+	if p := parsed.Port(); p != "" {
+		port, err = strconv.Atoi(p)
+		ok = err == nil
+	}
+	if !ok {
+		return "", 0, false
+	}
+	return parsed.Hostname(), port, true
+}
+
+// isSchemeChar reports whether c may appear in a URL scheme
+func isSchemeChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '+' || c == '-' || c == '.'
+}
@@ -0,0 +1,510 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"gitee.com/com_818cloud/shode/pkg/types"
+)
+
+// Permission is a set of access rights to a path
+type Permission uint8
+
+// Access rights checked by path rules
+const (
+	PermRead Permission = 1 << iota
+	PermWrite
+	PermExecute
+
+	PermNone Permission = 0
+	PermAll             = PermRead | PermWrite | PermExecute
+)
+
+// String formats a permission set as in path rules, e.g. "rw"
+func (p Permission) String() string {
+	if p == PermNone {
+		return "none"
+	}
+	var b strings.Builder
+	if p&PermRead != 0 {
+		b.WriteString("r")
+	}
+	if p&PermWrite != 0 {
+		b.WriteString("w")
+	}
+	if p&PermExecute != 0 {
+		b.WriteString("x")
+	}
+	return b.String()
+}
+
+// describe names the access rights in violation messages
+func (p Permission) describe() string {
+	var names []string
+	if p&PermRead != 0 {
+		names = append(names, "read")
+	}
+	if p&PermWrite != 0 {
+		names = append(names, "write")
+	}
+	if p&PermExecute != 0 {
+		names = append(names, "execute")
+	}
+	return strings.Join(names, "/")
+}
+
+// ParseAccess parses the access of a path rule: "allow" (rwx), "deny" or
+// "none", or a combination of r, w and x
+func ParseAccess(access string) (Permission, error) {
+	switch access {
+	case "allow":
+		return PermAll, nil
+	case "deny", "none":
+		return PermNone, nil
+	case "":
+		return PermNone, fmt.Errorf("access is empty")
+	}
+	perm := PermNone
+	for _, c := range access {
+		switch c {
+		case 'r':
+			perm |= PermRead
+		case 'w':
+			perm |= PermWrite
+		case 'x':
+			perm |= PermExecute
+		default:
+			return PermNone, fmt.Errorf("access must be \"allow\", \"deny\" or a combination of r, w and x, got %q", access)
+		}
+	}
+	return perm, nil
+}
+
+// pathRuleEntry is a path rule as applied to a checker
+type pathRuleEntry struct {
+	pattern string // rule path with ~ expanded and cleaned
+	rule    PathRule
+	perm    Permission
+	source  string // policy the rule came from
+}
+
+// newPathRuleEntry prepares a validated rule for matching
+func newPathRuleEntry(rule PathRule, source string) pathRuleEntry {
+	perm, _ := ParseAccess(rule.Access)
+	pattern := expandTilde(rule.Path)
+	if !strings.HasSuffix(pattern, "/**") {
+		pattern = filepath.Clean(pattern)
+	}
+	return pathRuleEntry{pattern: pattern, rule: rule, perm: perm, source: source}
+}
+
+// specialFiles are device files every script may use, even though /dev is
+// protected
+var specialFiles = map[string]bool{
+	"/dev/null": true, "/dev/zero": true, "/dev/random": true, "/dev/urandom": true,
+	"/dev/stdin": true, "/dev/stdout": true, "/dev/stderr": true, "/dev/tty": true,
+}
+
+// expandTilde expands ~ and ~user at the start of a path
+func expandTilde(path string) string {
+	if !strings.HasPrefix(path, "~") {
+		return path
+	}
+	name, rest, _ := strings.Cut(path[1:], "/")
+	var home string
+	if name == "" {
+		dir, err := os.UserHomeDir()
+		if err != nil {
+			return path
+		}
+		home = dir
+	} else {
+		account, err := user.Lookup(name)
+		if err != nil {
+			return path
+		}
+		home = account.HomeDir
+	}
+	return filepath.Join(home, rest)
+}
+
+// resolvePathForms returns the absolute, cleaned form of a path relative to
+// dir and, if different, the form with symlinks resolved. Symlinks are
+// resolved in the longest existing prefix, so files about to be created
+// resolve too.
+func resolvePathForms(path, dir string) []string {
+	expanded := expandTilde(path)
+	if !filepath.IsAbs(expanded) {
+		if dir == "" {
+			dir, _ = os.Getwd()
+		}
+		expanded = filepath.Join(dir, expanded)
+	}
+	lexical := filepath.Clean(expanded)
+	forms := []string{lexical}
+	if resolved := resolveExisting(lexical); resolved != lexical {
+		forms = append(forms, resolved)
+	}
+	return forms
+}
+
+// resolveExisting resolves the symlinks in the longest existing prefix of an
+// absolute path
+func resolveExisting(path string) string {
+	rest := ""
+	for current := path; ; {
+		if resolved, err := filepath.EvalSymlinks(current); err == nil {
+			return filepath.Join(resolved, rest)
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			return path
+		}
+		rest = filepath.Join(filepath.Base(current), rest)
+		current = parent
+	}
+}
+
+// grantedAccess returns the access allowed to an absolute path and the rule
+// that decided it. Policy path rules take precedence over the built-in
+// sensitive file list; the last matching rule wins. A built-in entry that
+// contains the working directory, as /root does for a script run in
+// /root/project, does not protect the working directory itself.
+func (sc *SecurityChecker) grantedAccess(path string, dirs []string) (Permission, *pathRuleEntry, bool) {
+	return sc.decideAccess(path, dirs, func(string) bool { return true })
+}
+
+// decideAccess is grantedAccess with only the built-in entries for which
+// builtin returns true
+func (sc *SecurityChecker) decideAccess(path string, dirs []string, builtin func(string) bool) (Permission, *pathRuleEntry, bool) {
+	for i := len(sc.pathRules) - 1; i >= 0; i-- {
+		if matchPathGlob(sc.pathRules[i].pattern, path) {
+			return sc.pathRules[i].perm, &sc.pathRules[i], false
+		}
+	}
+	for blacklisted := range sc.fileBlacklist {
+		if !underPath(path, blacklisted) || !builtin(blacklisted) {
+			continue
+		}
+		inWorkingDir := false
+		for _, dir := range dirs {
+			if underPath(dir, blacklisted) && underPath(path, dir) {
+				inWorkingDir = true
+			}
+		}
+		if !inWorkingDir {
+			// The built-in entries protect data; programs installed
+			// beneath them, e.g. in /root/go/bin, may still be run
+			return PermExecute, nil, true
+		}
+	}
+	return PermAll, nil, false
+}
+
+// underPath reports whether path is base or inside it. /root matches
+// /root/.ssh but not /rootless.
+func underPath(path, base string) bool {
+	base = filepath.Clean(base)
+	return path == base || base == "/" || strings.HasPrefix(path, base+"/")
+}
+
+// checkPath checks that a path, relative to dir, may be accessed with the
+// needed rights
+func (sc *SecurityChecker) checkPath(path, dir string, need Permission) error {
+	forms := resolvePathForms(path, dir)
+	if specialFiles[forms[0]] || strings.HasPrefix(forms[0], "/dev/fd/") {
+		return nil
+	}
+	dirs := resolvePathForms(".", dir)
+
+	for _, form := range forms {
+		granted, rule, builtin := sc.grantedAccess(form, dirs)
+		missing := need &^ granted
+		if missing == 0 {
+			continue
+		}
+		shown := fmt.Sprintf("'%s'", path)
+		if form != path {
+			shown = fmt.Sprintf("'%s' (%s)", path, form)
+		}
+		if builtin || rule == nil {
+			return fmt.Errorf("security violation: access to sensitive file %s is not allowed", shown)
+		}
+		return fmt.Errorf("security violation: %s access to %s is denied by path rule '%s' (%s) of policy %s",
+			missing.describe(), shown, rule.rule.Path, rule.rule.Access, rule.source)
+	}
+	return nil
+}
+
+// pathOperand is an argument used as a path and the access it needs
+type pathOperand struct {
+	path string
+	need Permission
+}
+
+// writingCommands modify their path operands; chmod, chown and chgrp take
+// a mode or owner first
+var writingCommands = map[string]bool{
+	"rm": true, "rmdir": true, "unlink": true, "touch": true, "mkdir": true, "truncate": true,
+	"shred": true, "tee": true, "mv": true, "chmod": true, "chown": true, "chgrp": true,
+}
+
+// copyingCommands read their operands and write the last one
+var copyingCommands = map[string]bool{"cp": true, "ln": true, "install": true, "rsync": true, "scp": true}
+
+// fileFunctionAccess is the access the file functions of the standard
+// library need to their arguments, by position; the last entry applies to
+// the remaining ones. Options are not paths but keep their position, so a
+// Chmod mode such as -w cannot shift a path out of place.
+var fileFunctionAccess = map[string][]Permission{
+	"ReadFile":    {PermRead},
+	"WriteFile":   {PermWrite, PermNone},
+	"AppendFile":  {PermWrite, PermNone},
+	"AtomicWrite": {PermWrite, PermNone},
+	"ListFiles":   {PermRead},
+	"FileExists":  {PermRead},
+	"CopyFile":    {PermRead, PermWrite},
+	"CopyDir":     {PermRead, PermWrite},
+	"Move":        {PermWrite, PermWrite},
+	"Remove":      {PermWrite},
+	"MkdirAll":    {PermWrite},
+	"Chmod":       {PermNone, PermWrite},
+	"Stat":        {PermRead},
+	"Glob":        {PermRead},
+	"Walk":        {PermRead, PermNone},
+	"Symlink":     {PermNone, PermWrite},
+	"ReadLink":    {PermRead},
+	"TempFile":    {PermNone},
+	"TempDir":     {PermNone},
+}
+
+// functionOperands returns the arguments of a file function that are paths
+// and the access each needs
+func functionOperands(access []Permission, args []string) []pathOperand {
+	var operands []pathOperand
+	for i, arg := range args {
+		need := access[len(access)-1]
+		if i < len(access) {
+			need = access[i]
+		}
+		if need != PermNone && arg != "" && !strings.HasPrefix(arg, "-") {
+			operands = append(operands, pathOperand{path: arg, need: need})
+		}
+	}
+	return operands
+}
+
+// operandAccess returns the access a command needs to its i-th positional
+// operand, given the index of the last one
+func operandAccess(names []string, args []string) func(i, last int) Permission {
+	for _, name := range names {
+		switch {
+		case writingCommands[name]:
+			skipFirst := name == "chmod" || name == "chown" || name == "chgrp"
+			return func(i, last int) Permission {
+				if skipFirst && i == 0 {
+					return PermNone
+				}
+				return PermWrite
+			}
+		case copyingCommands[name]:
+			return func(i, last int) Permission {
+				if i == last {
+					return PermWrite
+				}
+				return PermRead
+			}
+		case name == "sed":
+			if flags, _ := splitOptions(args); hasFlag(flags, "i", "--in-place") {
+				return func(i, last int) Permission { return PermRead | PermWrite }
+			}
+		}
+	}
+	return func(i, last int) Permission { return PermRead }
+}
+
+// pathOperands returns the arguments of a command that may be paths and the
+// access each needs. Option values (--file=x) and assignments (of=x) are
+// included.
+func pathOperands(names []string, args []string) []pathOperand {
+	need := operandAccess(names, args)
+
+	// Index positional operands so the last one can be told apart
+	var operands []pathOperand
+	positional := []int{}
+	for i, arg := range args {
+		if !strings.HasPrefix(arg, "-") && !isAssignmentWord(arg) {
+			positional = append(positional, i)
+		}
+	}
+	position := map[int]int{}
+	for n, i := range positional {
+		position[i] = n
+	}
+
+	for i, arg := range args {
+		if arg == "" || arg == "-" || strings.Contains(arg, "://") {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(arg, "-"):
+			// --file=/etc/shadow
+			if _, value, ok := strings.Cut(arg, "="); ok && value != "" {
+				operands = append(operands, pathOperand{path: value, need: PermRead})
+			}
+		case isAssignmentWord(arg):
+			// dd if=/dev/sda of=disk.img
+			key, value, _ := strings.Cut(arg, "=")
+			access := PermRead
+			if key == "of" {
+				access = PermWrite
+			}
+			if value != "" {
+				operands = append(operands, pathOperand{path: value, need: access})
+			}
+		default:
+			if access := need(position[i], len(positional)-1); access != PermNone {
+				operands = append(operands, pathOperand{path: arg, need: access})
+			}
+		}
+	}
+	return operands
+}
+
+// isExplicitPath reports whether an argument is clearly meant as a path,
+// as opposed to a bare word that may be a file in the working directory
+func isExplicitPath(arg string) bool {
+	return strings.Contains(arg, "/") || strings.HasPrefix(arg, "~") || arg == "." || arg == ".."
+}
+
+// checkPathArguments checks the paths a command reads, writes and runs
+func (sc *SecurityChecker) checkPathArguments(cmd *types.CommandNode, names []string, ctx *CheckContext) error {
+	dir := ctx.Dir
+	// The command itself needs execute access
+	if executable := commandPath(cmd.Name, ctx); executable != "" {
+		if err := sc.checkPath(executable, dir, PermExecute); err != nil {
+			return err
+		}
+	}
+
+	for _, operand := range commandPaths(cmd, names, dir) {
+		if err := sc.checkPath(operand.path, dir, operand.need); err != nil {
+			return err
+		}
+	}
+
+	if cmd.Redirect != nil {
+		return sc.checkRedirect(cmd.Redirect, dir)
+	}
+	return nil
+}
+
+// commandPaths returns the path operands of a command that refer to files.
+// Bare words are only paths if the file exists or is written.
+func commandPaths(cmd *types.CommandNode, names []string, dir string) []pathOperand {
+	operands := pathOperands(names, cmd.Args)
+	if access, ok := fileFunctionAccess[cmd.Name]; ok {
+		operands = functionOperands(access, cmd.Args)
+	}
+	var paths []pathOperand
+	for _, operand := range operands {
+		if !isExplicitPath(operand.path) && operand.need&PermWrite == 0 {
+			if _, err := os.Lstat(resolvePathForms(operand.path, dir)[0]); err != nil {
+				continue
+			}
+		}
+		paths = append(paths, operand)
+	}
+	return paths
+}
+
+// FileAccess is a file a command refers to and the access it needs
+type FileAccess struct {
+	Path   string
+	Access Permission
+}
+
+// CommandFiles returns the files the arguments of a command read and write,
+// with relative paths resolved against dir
+func (sc *SecurityChecker) CommandFiles(cmd *types.CommandNode, dir string) []FileAccess {
+	var files []FileAccess
+	for _, operand := range commandPaths(cmd, identities(cmd.Name, &CheckContext{Dir: dir}), dir) {
+		files = append(files, FileAccess{Path: resolvePathForms(operand.path, dir)[0], Access: operand.need})
+	}
+	return files
+}
+
+// commandPath returns the file a command name runs in ctx, or "" if it is
+// not found. Names are looked up in the PATH of the script's variables, or
+// of the process without them.
+func commandPath(name string, ctx *CheckContext) string {
+	if strings.Contains(name, "/") {
+		return name
+	}
+	var path string
+	var err error
+	if search, ok := ctx.Env["PATH"]; ok {
+		path, err = LookPath(name, search, ctx.Dir)
+	} else {
+		path, err = exec.LookPath(name)
+	}
+	if err != nil {
+		return ""
+	}
+	return path
+}
+
+// LookPath finds the executable a command name runs when searched in the
+// directories of a PATH value. Relative directories are resolved against
+// dir, or the process working directory if dir is empty.
+func LookPath(name, search, dir string) (string, error) {
+	if strings.Contains(name, "/") {
+		return name, nil
+	}
+	for _, entry := range filepath.SplitList(search) {
+		if entry == "" {
+			entry = "."
+		}
+		if !filepath.IsAbs(entry) && dir != "" {
+			entry = filepath.Join(dir, entry)
+		}
+		path := filepath.Join(entry, name)
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() && info.Mode()&0111 != 0 {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("%s: executable file not found in $PATH", name)
+}
+
+// CheckPath checks that a path, relative to dir, may be accessed with the
+// needed rights. It lets the file functions of the standard library check
+// each file they reach, e.g. while copying a directory tree.
+func (sc *SecurityChecker) CheckPath(path, dir string, need Permission) error {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return sc.checkPath(path, dir, need)
+}
+
+// CheckRedirect checks the file of a redirection: < needs read access,
+// >, >> and &> need write access. dir is the working directory relative
+// paths are resolved against; empty means the process working directory.
+func (sc *SecurityChecker) CheckRedirect(redirect *types.RedirectNode, dir string) error {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return sc.checkRedirect(redirect, dir)
+}
+
+// checkRedirect is CheckRedirect for callers holding the lock
+func (sc *SecurityChecker) checkRedirect(redirect *types.RedirectNode, dir string) error {
+	switch redirect.Op {
+	case "<":
+		return sc.checkPath(redirect.File, dir, PermRead)
+	case ">", ">>", "&>":
+		return sc.checkPath(redirect.File, dir, PermWrite)
+	}
+	return nil
+}
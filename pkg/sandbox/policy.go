@@ -0,0 +1,529 @@
+package sandbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PolicyFileName is the policy file discovered in the working directory
+const PolicyFileName = ".shode-policy.json"
+
+// maxPolicyDepth bounds the extends chain of a policy
+const maxPolicyDepth = 16
+
+// Policy is a declarative rule set for the SecurityChecker. Policies are
+// applied on top of the built-in default rules, after the policy or profile
+// named by Extends.
+type Policy struct {
+	Extends string `json:"extends,omitempty"` // profile name or policy file to build on
+	Mode    string `json:"mode,omitempty"`    // "denylist" (default) or "allowlist"
+
+	// RiskThreshold is the risk score from which commands are blocked;
+	// 0 keeps the inherited threshold
+	RiskThreshold int           `json:"riskThreshold,omitempty"`
+	Commands      CommandPolicy `json:"commands,omitempty"`
+	Paths         []PathRule    `json:"paths,omitempty"`
+
+	// Network restricts the destinations commands may connect to
+	Network *NetworkPolicy `json:"network,omitempty"`
+
+	// Enforcement runs external commands in a kernel sandbox; the last
+	// policy that sets it wins
+	Enforcement *EnforcementPolicy `json:"enforcement,omitempty"`
+
+	// Approved are command lines, with their expanded arguments joined by
+	// spaces, that run despite violations, e.g. approved in --confirm mode
+	Approved []string `json:"approved,omitempty"`
+
+	// Secrets are names or patterns of variables whose values are masked
+	// in output, errors and audit logs, in addition to the defaults
+	Secrets []string `json:"secrets,omitempty"`
+
+	// Contexts restrict commands by the scripts, modules and directories
+	// they run in
+	Contexts []ContextRule `json:"contexts,omitempty"`
+
+	source string // file or profile the policy was loaded from
+}
+
+// CommandPolicy holds the command allow and deny lists
+type CommandPolicy struct {
+	Allow []string      `json:"allow,omitempty"` // commands removed from the deny lists; in allowlist mode the only commands that may run
+	Deny  []string      `json:"deny,omitempty"`  // commands that may not run
+	Rules []CommandRule `json:"rules,omitempty"` // per-command argument rules
+
+	// Pins maps a command name or binary path to the sha256 its binary
+	// must have
+	Pins map[string]string `json:"pins,omitempty"`
+}
+
+// CommandRule restricts the arguments of a command. Patterns are matched
+// against the arguments joined by spaces; '*' matches any text and '?' any
+// single character.
+type CommandRule struct {
+	Command   string   `json:"command"`
+	DenyArgs  []string `json:"denyArgs,omitempty"`  // matching arguments are rejected
+	AllowArgs []string `json:"allowArgs,omitempty"` // if set, only matching arguments are accepted, even for denied commands
+}
+
+// PathRule grants access to paths matching a glob. "**" matches any number
+// of directories and a leading "~/" or "~user/" is a home directory.
+// Paths are matched after they are made absolute and cleaned, and again
+// with symlinks resolved. The last matching rule wins.
+type PathRule struct {
+	Path   string `json:"path"`
+	Access string `json:"access"` // "allow", "deny", or a combination of r, w and x
+}
+
+// Source returns the file or profile the policy was loaded from
+func (p *Policy) Source() string {
+	return p.source
+}
+
+// name identifies the policy in violation messages
+func (p *Policy) name() string {
+	if p.source == "" {
+		return "inline"
+	}
+	return p.source
+}
+
+// LoadPolicy loads a policy file. For shode.json the "security" section is
+// used. Unknown keys are errors, so a misspelled rule is not silently
+// dropped.
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file %s: %v", path, err)
+	}
+
+	if filepath.Base(path) == "shode.json" {
+		var config struct {
+			Security json.RawMessage `json:"security"`
+		}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+		}
+		if config.Security == nil || string(config.Security) == "null" {
+			return nil, fmt.Errorf("%s has no security section", path)
+		}
+		data = config.Security
+	}
+
+	policy := &Policy{}
+	if err := decodePolicy(data, policy); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file %s: %v", path, err)
+	}
+
+	policy.source = path
+	if err := policy.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %v", path, err)
+	}
+	return policy, nil
+}
+
+// decodePolicy decodes a single JSON policy, rejecting unknown keys and
+// trailing data
+func decodePolicy(data []byte, policy *Policy) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(policy); err != nil {
+		return err
+	}
+	if decoder.More() {
+		return fmt.Errorf("unexpected data after the policy")
+	}
+	return nil
+}
+
+// FindPolicy looks for .shode-policy.json, then a security section in
+// shode.json, in dir. It returns nil if neither exists.
+func FindPolicy(dir string) (*Policy, error) {
+	path := filepath.Join(dir, PolicyFileName)
+	if _, err := os.Stat(path); err == nil {
+		return LoadPolicy(path)
+	}
+
+	path = filepath.Join(dir, "shode.json")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil
+	}
+	var config struct {
+		Security json.RawMessage `json:"security"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil || config.Security == nil {
+		return nil, nil
+	}
+	return LoadPolicy(path)
+}
+
+// ResolvePolicy resolves a --policy flag value: a profile name, a policy
+// file, or empty to discover a policy file in dir
+func ResolvePolicy(spec, dir string) (*Policy, error) {
+	if spec == "" {
+		return FindPolicy(dir)
+	}
+	if profile, ok := Profile(spec); ok {
+		return profile, nil
+	}
+	if _, err := os.Stat(spec); err != nil && !strings.ContainsAny(spec, "/.") {
+		return nil, fmt.Errorf("unknown policy profile %q (available: %s)", spec, strings.Join(ProfileNames(), ", "))
+	}
+	return LoadPolicy(spec)
+}
+
+// Validate checks a policy for malformed rules
+func (p *Policy) Validate() error {
+	if err := p.validateAllowlist(); err != nil {
+		return err
+	}
+	if p.RiskThreshold < 0 || p.RiskThreshold > 100 {
+		return fmt.Errorf("riskThreshold must be between 1 and 100, got %d", p.RiskThreshold)
+	}
+	for _, rule := range p.Commands.Rules {
+		if rule.Command == "" {
+			return fmt.Errorf("command rule without a command")
+		}
+	}
+	if p.Network != nil {
+		if err := p.Network.validate(); err != nil {
+			return err
+		}
+	}
+	if p.Enforcement != nil {
+		if err := p.Enforcement.validate(); err != nil {
+			return err
+		}
+	}
+	for _, rule := range p.Paths {
+		if rule.Path == "" {
+			return fmt.Errorf("path rule without a path")
+		}
+		if _, err := ParseAccess(rule.Access); err != nil {
+			return fmt.Errorf("path rule %s: %v", rule.Path, err)
+		}
+	}
+	for i := range p.Contexts {
+		if err := p.Contexts[i].validate(); err != nil {
+			return err
+		}
+	}
+	for _, secret := range p.Secrets {
+		if secret == "" {
+			return fmt.Errorf("empty secret variable name")
+		}
+	}
+	return nil
+}
+
+// NewSecurityCheckerWithPolicy creates a security checker with the default
+// rules and the given policy applied on top
+func NewSecurityCheckerWithPolicy(policy *Policy) (*SecurityChecker, error) {
+	sc := NewSecurityChecker()
+	if policy == nil {
+		return sc, nil
+	}
+	if err := sc.ApplyPolicy(policy); err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+// ApplyPolicy applies a policy, after the policies it extends
+func (sc *SecurityChecker) ApplyPolicy(policy *Policy) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.applyPolicy(policy, 0)
+}
+
+// applyPolicy applies a policy and its extends chain
+func (sc *SecurityChecker) applyPolicy(policy *Policy, depth int) error {
+	if depth > maxPolicyDepth {
+		return fmt.Errorf("policy %s: extends chain is too deep (possible cycle)", policy.name())
+	}
+
+	if policy.Extends != "" {
+		parent, err := policy.loadParent()
+		if err != nil {
+			return err
+		}
+		if err := sc.applyPolicy(parent, depth+1); err != nil {
+			return err
+		}
+	}
+
+	for _, command := range policy.Commands.Deny {
+		command = strings.ToLower(command)
+		sc.dangerousCommands[command] = true
+		sc.denySources[command] = policy.name()
+	}
+	for _, command := range policy.Commands.Allow {
+		command = strings.ToLower(command)
+		delete(sc.dangerousCommands, command)
+		delete(sc.networkBlacklist, command)
+		delete(sc.denySources, command)
+	}
+	for _, rule := range policy.Commands.Rules {
+		command := strings.ToLower(rule.Command)
+		sc.commandRules[command] = append(sc.commandRules[command], rule)
+	}
+	for _, rule := range policy.Paths {
+		sc.pathRules = append(sc.pathRules, newPathRuleEntry(rule, policy.name()))
+	}
+	sc.applyAllowlist(policy)
+	if policy.RiskThreshold > 0 {
+		sc.riskThreshold = policy.RiskThreshold
+	}
+	sc.applyNetwork(policy)
+	sc.applyApprovals(policy)
+	sc.applySecrets(policy)
+	sc.applyContextRules(policy)
+	if policy.Enforcement != nil {
+		enforcement := *policy.Enforcement
+		sc.enforcement = &enforcement
+	}
+
+	sc.policies = append(sc.policies, policy.name())
+	return nil
+}
+
+// loadParent loads the profile or policy file named by Extends. Files are
+// relative to the extending policy file.
+func (p *Policy) loadParent() (*Policy, error) {
+	if profile, ok := Profile(p.Extends); ok {
+		return profile, nil
+	}
+	path := p.Extends
+	if !filepath.IsAbs(path) && p.source != "" {
+		path = filepath.Join(filepath.Dir(p.source), path)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("policy %s: extends unknown profile or file %q", p.name(), p.Extends)
+	}
+	return LoadPolicy(path)
+}
+
+// Policies returns the sources of the applied policies, in order
+func (sc *SecurityChecker) Policies() []string {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return append([]string(nil), sc.policies...)
+}
+
+// profiles are the built-in policies that policy files can extend
+var profiles = map[string]Policy{
+	// default is the built-in rule set every checker starts with
+	"default": {},
+
+	// strict also denies interpreters, remote access, privilege escalation
+	// and process control, keeps scripts out of /etc and SSH keys, and
+	// blocks medium-risk arguments
+	"strict": {
+		RiskThreshold: 50,
+		Commands: CommandPolicy{
+			Deny: []string{
+				"sudo", "su", "doas",
+				"curl", "wget", "ssh", "scp", "sftp", "rsync", "ftp", "telnet",
+				"sh", "bash", "zsh", "python", "python3", "perl", "ruby", "node", "php",
+				"kill", "killall", "pkill", "mount", "umount", "systemctl", "crontab",
+			},
+		},
+		Paths: []PathRule{
+			{Path: "/etc/**", Access: "deny"},
+			{Path: "~/.ssh/**", Access: "deny"},
+			{Path: "/home/*/.ssh/**", Access: "deny"},
+		},
+	},
+
+	// ci allows build tooling such as chmod but no privilege escalation,
+	// service control or force pushes
+	"ci": {
+		Commands: CommandPolicy{
+			Allow: []string{"chmod"},
+			Deny:  []string{"sudo", "su", "doas", "systemctl", "crontab", "kill", "killall", "pkill"},
+			Rules: []CommandRule{
+				{Command: "git", DenyArgs: []string{"push *--force*", "push *-f", "push *-f *"}},
+			},
+		},
+		Paths: []PathRule{
+			{Path: "~/.ssh/**", Access: "deny"},
+		},
+	},
+
+	// dev allows everyday file management on a developer machine
+	"dev": {
+		Commands: CommandPolicy{
+			Allow: []string{"rm", "chmod", "chown"},
+			Rules: []CommandRule{
+				{Command: "rm", DenyArgs: []string{"*--no-preserve-root*"}},
+			},
+		},
+	},
+}
+
+// Profile returns a copy of a built-in profile
+func Profile(name string) (*Policy, bool) {
+	profile, ok := profiles[name]
+	if !ok {
+		return nil, false
+	}
+	policy := profile.clone()
+	policy.source = "profile:" + name
+	return policy, true
+}
+
+// clone returns a copy of the policy that shares no slices or maps with it,
+// so changes to the copy cannot reach a built-in profile
+func (p *Policy) clone() *Policy {
+	c := *p
+	c.Commands.Allow = append([]string(nil), p.Commands.Allow...)
+	c.Commands.Deny = append([]string(nil), p.Commands.Deny...)
+	c.Commands.Rules = nil
+	for _, rule := range p.Commands.Rules {
+		rule.DenyArgs = append([]string(nil), rule.DenyArgs...)
+		rule.AllowArgs = append([]string(nil), rule.AllowArgs...)
+		c.Commands.Rules = append(c.Commands.Rules, rule)
+	}
+	if p.Commands.Pins != nil {
+		c.Commands.Pins = make(map[string]string, len(p.Commands.Pins))
+		for name, sum := range p.Commands.Pins {
+			c.Commands.Pins[name] = sum
+		}
+	}
+	c.Paths = append([]PathRule(nil), p.Paths...)
+	if p.Network != nil {
+		network := *p.Network
+		network.Allow = append([]string(nil), p.Network.Allow...)
+		network.Deny = append([]string(nil), p.Network.Deny...)
+		c.Network = &network
+	}
+	if p.Enforcement != nil {
+		enforcement := *p.Enforcement
+		enforcement.Namespaces = append([]string(nil), p.Enforcement.Namespaces...)
+		c.Enforcement = &enforcement
+	}
+	c.Approved = append([]string(nil), p.Approved...)
+	c.Secrets = append([]string(nil), p.Secrets...)
+	c.Contexts = nil
+	for _, context := range p.Contexts {
+		context.Scripts = append([]string(nil), context.Scripts...)
+		context.Modules = append([]string(nil), context.Modules...)
+		context.Dirs = append([]string(nil), context.Dirs...)
+		context.Deny = append([]string(nil), context.Deny...)
+		context.Only = append([]string(nil), context.Only...)
+		c.Contexts = append(c.Contexts, context)
+	}
+	return &c
+}
+
+// ProfileNames lists the built-in profiles
+func ProfileNames() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkCommandRules applies the argument rules of a command. It reports
+// whether an allowArgs pattern explicitly permitted the arguments.
+func (sc *SecurityChecker) checkCommandRules(name string, args []string) (bool, error) {
+	rules := sc.commandRules[name]
+	if len(rules) == 0 {
+		return false, nil
+	}
+
+	// Rules for git match its subcommand, e.g. "push *-f", whatever global
+	// options such as -C dir come first
+	if name == "git" {
+		args = gitSubcommandArgs(args)
+	}
+	joined := strings.Join(args, " ")
+	allowed := false
+	for _, rule := range rules {
+		for _, pattern := range rule.DenyArgs {
+			if matchWildcard(pattern, joined) {
+				return false, fmt.Errorf("security violation: arguments '%s' of '%s' are denied by policy rule '%s'", joined, name, pattern)
+			}
+		}
+		if len(rule.AllowArgs) == 0 {
+			continue
+		}
+		matched := false
+		for _, pattern := range rule.AllowArgs {
+			if matchWildcard(pattern, joined) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false, fmt.Errorf("security violation: arguments '%s' of '%s' are not allowed by policy", joined, name)
+		}
+		allowed = true
+	}
+	return allowed, nil
+}
+
+// matchPathGlob matches a slash-separated path against a glob where "**"
+// spans any number of segments
+func matchPathGlob(pattern, path string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(path, "/"))
+}
+
+// matchSegments matches path segments against pattern segments
+func matchSegments(pattern, path []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(path); i++ {
+				if matchSegments(pattern[1:], path[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(path) == 0 {
+			return false
+		}
+		if matched, err := filepath.Match(pattern[0], path[0]); err != nil || !matched {
+			return false
+		}
+		pattern, path = pattern[1:], path[1:]
+	}
+	return len(path) == 0
+}
+
+// matchWildcard matches s against a pattern where '*' matches any text,
+// including spaces and slashes, and '?' matches one character. On a
+// mismatch it resumes after the last '*', which then matches one more
+// character, so matching takes linear time per '*' rather than
+// backtracking through every combination.
+func matchWildcard(pattern, s string) bool {
+	p, i := 0, 0
+	star, resume := -1, 0
+	for i < len(s) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			star, resume = p, i
+			p++
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == s[i]):
+			p++
+			i++
+		case star >= 0:
+			resume++
+			p, i = star+1, resume
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
@@ -0,0 +1,159 @@
+package sandbox
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultWatchInterval is how often WatchPolicy looks for changes
+const DefaultWatchInterval = 2 * time.Second
+
+// Reload replaces the rules of the checker with the defaults and policy,
+// atomically: a command is checked against either the old or the new rules.
+// Rules changed at runtime with Add*, Remove*, SetRiskThreshold and
+// SetEnforcement are applied again, and command lines approved for the
+// session are kept. If the policy is invalid
+// the checker keeps its rules and the error is returned.
+func (sc *SecurityChecker) Reload(policy *Policy) error {
+	if policy != nil {
+		if err := policy.Validate(); err != nil {
+			return fmt.Errorf("invalid policy %s: %v", policy.name(), err)
+		}
+	}
+	fresh, err := NewSecurityCheckerWithPolicy(policy)
+	if err != nil {
+		return err
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for _, change := range sc.overrides {
+		change(fresh)
+	}
+	for line, source := range sc.approved {
+		if source == "session" {
+			fresh.approved[line] = source
+		}
+	}
+	sc.ruleSet = fresh.ruleSet
+	return nil
+}
+
+// PolicyWatcher reloads the policy of a checker when its files change
+type PolicyWatcher struct {
+	checker *SecurityChecker
+	path    string
+	report  func(error)
+	digests map[string][sha256.Size]byte // watched files and their content; zero if missing
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+// WatchPolicy reloads the checker from the policy file at path whenever it,
+// or a policy file it extends, changes. Files are compared by content every
+// interval. report is called after each reload with its error, nil on
+// success; a policy that fails to load or validate leaves the previous
+// rules in place until the files change again.
+func (sc *SecurityChecker) WatchPolicy(path string, interval time.Duration, report func(error)) (*PolicyWatcher, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch policy file %s: %v", path, err)
+	}
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	w := &PolicyWatcher{
+		checker: sc,
+		path:    abs,
+		report:  report,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	w.digests = w.snapshot(sc.Policies())
+	go w.run(interval)
+	return w, nil
+}
+
+// Stop ends watching; it returns once no reload is in progress
+func (w *PolicyWatcher) Stop() {
+	w.once.Do(func() { close(w.stop) })
+	<-w.done
+}
+
+// run polls the watched files until stopped
+func (w *PolicyWatcher) run(interval time.Duration) {
+	defer close(w.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			if w.changed() {
+				w.reload()
+			}
+		}
+	}
+}
+
+// changed reports whether a watched file differs from its last snapshot
+func (w *PolicyWatcher) changed() bool {
+	for path, digest := range w.digests {
+		if fileDigest(path) != digest {
+			return true
+		}
+	}
+	return false
+}
+
+// reload loads the policy file again and swaps it into the checker
+func (w *PolicyWatcher) reload() {
+	policy, err := LoadPolicy(w.path)
+	if err == nil {
+		err = w.checker.Reload(policy)
+	}
+	if err == nil {
+		w.digests = w.snapshot(w.checker.Policies())
+	} else {
+		// Keep watching the same files, with their broken content, so the
+		// error is reported once per change
+		for path := range w.digests {
+			w.digests[path] = fileDigest(path)
+		}
+	}
+	if w.report != nil {
+		w.report(err)
+	}
+}
+
+// snapshot returns the digests of the policy file and of the files among
+// the sources of the applied policies
+func (w *PolicyWatcher) snapshot(sources []string) map[string][sha256.Size]byte {
+	digests := map[string][sha256.Size]byte{w.path: fileDigest(w.path)}
+	for _, source := range sources {
+		if source == "inline" || strings.HasPrefix(source, "profile:") {
+			continue
+		}
+		if abs, err := filepath.Abs(source); err == nil {
+			digests[abs] = fileDigest(abs)
+		}
+	}
+	return digests
+}
+
+// fileDigest returns the sha256 of a file's content, or zero if it cannot
+// be read
+func fileDigest(path string) [sha256.Size]byte {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return [sha256.Size]byte{}
+	}
+	return sha256.Sum256(data)
+}
@@ -0,0 +1,751 @@
+package sandbox
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gitee.com/com_818cloud/shode/pkg/types"
+)
+
+// DefaultRiskThreshold is the risk score from which commands are blocked
+const DefaultRiskThreshold = 70
+
+// Risk levels of an assessment
+const (
+	RiskNone     = "none"
+	RiskLow      = "low"
+	RiskMedium   = "medium"
+	RiskHigh     = "high"
+	RiskCritical = "critical"
+)
+
+// Finding is one risky use of a command found in its arguments
+type Finding struct {
+	Rule    string `json:"rule"`          // identifier of the check, e.g. "find-delete"
+	Arg     string `json:"arg,omitempty"` // argument the finding is about
+	Message string `json:"message"`
+	Score   int    `json:"score"` // 0-100
+}
+
+// RiskAssessment is the result of analyzing a command's arguments
+type RiskAssessment struct {
+	Command  string    `json:"command"`
+	Score    int       `json:"score"` // 0-100
+	Level    string    `json:"level"`
+	Findings []Finding `json:"findings,omitempty"`
+}
+
+// riskLevel maps a score to a level
+func riskLevel(score int) string {
+	switch {
+	case score == 0:
+		return RiskNone
+	case score < 40:
+		return RiskLow
+	case score < 70:
+		return RiskMedium
+	case score < 90:
+		return RiskHigh
+	default:
+		return RiskCritical
+	}
+}
+
+// SetRiskThreshold sets the risk score from which commands are blocked
+func (sc *SecurityChecker) SetRiskThreshold(threshold int) {
+	sc.override(func(sc *SecurityChecker) {
+		sc.riskThreshold = threshold
+	})
+}
+
+// RiskThreshold returns the risk score from which commands are blocked
+func (sc *SecurityChecker) RiskThreshold() int {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return sc.riskThreshold
+}
+
+// AssessCommand scores the risk of a command's arguments, including the
+// commands it runs through wrappers and shells. Unlike CheckCommand it
+// blocks nothing.
+func (sc *SecurityChecker) AssessCommand(cmd *types.CommandNode) *RiskAssessment {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return sc.assessCommand(cmd)
+}
+
+// assessCommand is AssessCommand for callers holding the lock
+func (sc *SecurityChecker) assessCommand(cmd *types.CommandNode) *RiskAssessment {
+	return newRiskAssessment(cmd.Name, sc.assess(cmd, 0))
+}
+
+// newRiskAssessment combines findings into a score: the highest finding
+// plus 10 for every further finding, capped at 100
+func newRiskAssessment(command string, findings []Finding) *RiskAssessment {
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Score > findings[j].Score
+	})
+	score := 0
+	if len(findings) > 0 {
+		score = findings[0].Score + 10*(len(findings)-1)
+		if score > 100 {
+			score = 100
+		}
+	}
+	return &RiskAssessment{Command: command, Score: score, Level: riskLevel(score), Findings: findings}
+}
+
+// assess collects the findings of a command and the commands it wraps
+func (sc *SecurityChecker) assess(cmd *types.CommandNode, depth int) []Finding {
+	findings := commandFindings(cmd, identities(cmd.Name, &CheckContext{}))
+	if depth >= maxWrapperDepth {
+		return findings
+	}
+	wrapped, _, err := wrappedCommands(identities(cmd.Name, &CheckContext{}), cmd.Args)
+	if err != nil {
+		return append(findings, Finding{Rule: "unparsable-script", Message: err.Error(), Score: 50})
+	}
+	for _, inner := range wrapped {
+		for _, finding := range sc.assess(inner, depth+1) {
+			finding.Message += fmt.Sprintf(" (run by '%s')", cmd.Name)
+			findings = append(findings, finding)
+		}
+	}
+	return findings
+}
+
+// checkRisk blocks a command whose own arguments score at or above the
+// risk threshold. Wrapped commands are checked on their own.
+func (sc *SecurityChecker) checkRisk(cmd *types.CommandNode, names []string) error {
+	assessment := newRiskAssessment(cmd.Name, commandFindings(cmd, names))
+	if assessment.Score < sc.riskThreshold || len(assessment.Findings) == 0 {
+		return nil
+	}
+	top := assessment.Findings[0]
+	return fmt.Errorf("security violation: %s (risk %d, rule %s)", top.Message, assessment.Score, top.Rule)
+}
+
+// commandFindings analyzes the arguments of a single command
+func commandFindings(cmd *types.CommandNode, names []string) []Finding {
+	var findings []Finding
+
+	// Shell-active constructs in the words as written. Once expanded, the
+	// arguments are passed to the command as literal data.
+	for _, word := range rawWords(cmd) {
+		for _, construct := range shellActiveConstructs(word) {
+			findings = append(findings, Finding{
+				Rule:    "command-substitution",
+				Arg:     word,
+				Message: fmt.Sprintf("unquoted %s in '%s' is passed as literal text; shode does not run command substitution", construct, word),
+				Score:   30,
+			})
+		}
+	}
+
+	findings = append(findings, secretFindings(names, cmd.Args)...)
+
+	for _, name := range names {
+		if schema, ok := argumentSchemas[name]; ok {
+			findings = append(findings, schema(cmd.Args)...)
+			break
+		}
+	}
+	return findings
+}
+
+// rawWords returns the arguments of a command as written in the source, if
+// known
+func rawWords(cmd *types.CommandNode) []string {
+	if cmd.Origin != nil {
+		cmd = cmd.Origin
+	}
+	return cmd.RawArgs
+}
+
+// shellActiveConstructs returns the constructs in a raw word that a shell
+// would run rather than pass on: command substitution, backquotes and
+// process substitution outside single quotes. Quoted '|', ';', '&' and '$'
+// are literal and not reported.
+func shellActiveConstructs(word string) []string {
+	var found []string
+	inSingle, inDouble := false, false
+	for i := 0; i < len(word); i++ {
+		c := word[i]
+		next := byte(0)
+		if i+1 < len(word) {
+			next = word[i+1]
+		}
+		switch {
+		case inSingle:
+			if c == '\'' {
+				inSingle = false
+			}
+		case c == '\\':
+			i++
+		case c == '\'' && !inDouble:
+			inSingle = true
+		case c == '"':
+			inDouble = !inDouble
+		case c == '`':
+			found = append(found, "`...`")
+			if end := strings.IndexByte(word[i+1:], '`'); end >= 0 {
+				i += end + 1
+			}
+		case c == '$' && next == '(' && !strings.HasPrefix(word[i:], "$(("):
+			found = append(found, "$(...)")
+		case (c == '<' || c == '>') && next == '(' && !inDouble:
+			found = append(found, string(c)+"(...)")
+		}
+	}
+	return found
+}
+
+// secretOptions are long option names that carry credentials
+var secretOptions = []string{"password", "passwd", "pass", "token", "secret"}
+
+// mysqlClients take the password attached to -p
+var mysqlClients = map[string]bool{
+	"mysql": true, "mysqldump": true, "mysqladmin": true, "mysqlimport": true, "mysqlshow": true, "mariadb": true,
+}
+
+// secretFindings reports credentials passed as arguments, where other users
+// can read them from the process list
+func secretFindings(names []string, args []string) []Finding {
+	var findings []Finding
+	for i, arg := range args {
+		if option, value, ok := secretOption(arg, args, i); ok && value != "" {
+			kind := "password"
+			if strings.HasSuffix(option, "token") || strings.HasSuffix(option, "secret") {
+				kind = "secret"
+			}
+			findings = append(findings, Finding{
+				Rule:    "password-argument",
+				Arg:     option,
+				Message: fmt.Sprintf("%s in command line detected ('%s')", kind, option),
+				Score:   80,
+			})
+		}
+		if strings.Contains(arg, "://") {
+			if u, err := url.Parse(arg); err == nil && u.User != nil {
+				if _, hasPassword := u.User.Password(); hasPassword {
+					findings = append(findings, Finding{
+						Rule:    "url-credentials",
+						Arg:     u.Redacted(),
+						Message: fmt.Sprintf("credentials in URL '%s'", u.Redacted()),
+						Score:   60,
+					})
+				}
+			}
+		}
+	}
+
+	for _, name := range names {
+		switch {
+		case mysqlClients[name]:
+			for _, arg := range args {
+				if strings.HasPrefix(arg, "-p") && len(arg) > 2 && !strings.HasPrefix(arg, "--") {
+					findings = append(findings, Finding{Rule: "password-argument", Arg: "-p", Message: "password in command line detected ('-p')", Score: 80})
+				}
+			}
+		case name == "sshpass":
+			for i, arg := range args {
+				if arg == "-p" && i+1 < len(args) || strings.HasPrefix(arg, "-p") && len(arg) > 2 {
+					findings = append(findings, Finding{Rule: "password-argument", Arg: "-p", Message: "password in command line detected ('-p')", Score: 80})
+				}
+			}
+		}
+	}
+	return findings
+}
+
+// secretOption reports whether arg is a credential option such as
+// --password=x or --api-token x, and returns its value
+func secretOption(arg string, args []string, i int) (string, string, bool) {
+	if !strings.HasPrefix(arg, "--") {
+		return "", "", false
+	}
+	option, value, attached := strings.Cut(arg, "=")
+	name := strings.ToLower(strings.TrimPrefix(option, "--"))
+	for _, secret := range secretOptions {
+		if name == secret || strings.HasSuffix(name, "-"+secret) || strings.HasSuffix(name, "_"+secret) {
+			if !attached && i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+				value = args[i+1]
+			}
+			return option, value, true
+		}
+	}
+	return "", "", false
+}
+
+// argumentSchemas analyze the arguments of specific commands
+var argumentSchemas = map[string]func(args []string) []Finding{
+	"rm":     rmFindings,
+	"remove": rmFindings, // Remove of the standard library
+	"chmod":  chmodFindings,
+	"chown":  recursiveSystemFindings("chown"),
+	"dd":     ddFindings,
+	"find":   findFindings,
+	"git":    gitFindings,
+	"curl":   curlFindings,
+	"wget":   wgetFindings,
+	"tar":    tarFindings,
+	"ssh":    sshFindings,
+	"rsync":  rsyncFindings,
+}
+
+// systemDirs are directories whose recursive removal or permission change
+// breaks the system
+var systemDirs = map[string]bool{
+	"/": true, "/bin": true, "/boot": true, "/dev": true, "/etc": true, "/home": true, "/lib": true,
+	"/lib64": true, "/opt": true, "/proc": true, "/root": true, "/sbin": true, "/sys": true, "/usr": true, "/var": true,
+}
+
+// splitOptions separates flags from operands; "--" ends the flags
+func splitOptions(args []string) (flags, operands []string) {
+	for i, arg := range args {
+		if arg == "--" {
+			return flags, append(operands, args[i+1:]...)
+		}
+		if strings.HasPrefix(arg, "-") && len(arg) > 1 {
+			flags = append(flags, arg)
+		} else {
+			operands = append(operands, arg)
+		}
+	}
+	return flags, operands
+}
+
+// hasFlag reports whether a long flag or a short flag, alone or in a
+// cluster such as -rf, is present
+func hasFlag(flags []string, short string, long ...string) bool {
+	for _, flag := range flags {
+		if strings.HasPrefix(flag, "--") {
+			for _, l := range long {
+				if flag == l || strings.HasPrefix(flag, l+"=") {
+					return true
+				}
+			}
+			continue
+		}
+		if short != "" && strings.ContainsAny(flag[1:], short) {
+			return true
+		}
+	}
+	return false
+}
+
+// targetFinding classifies the target of a recursive operation
+func targetFinding(rule, action, target string) (Finding, bool) {
+	cleaned := filepath.Clean(target)
+	home, _ := os.UserHomeDir()
+	switch {
+	case cleaned == "/" || target == "/*":
+		return Finding{Rule: rule, Arg: target, Message: action + " of root directory detected", Score: 100}, true
+	case systemDirs[cleaned]:
+		return Finding{Rule: rule, Arg: target, Message: fmt.Sprintf("%s of system directory '%s' detected", action, cleaned), Score: 90}, true
+	case cleaned == "~" || cleaned == "$HOME" || home != "" && cleaned == filepath.Clean(home):
+		return Finding{Rule: rule, Arg: target, Message: action + " of home directory detected", Score: 90}, true
+	}
+	return Finding{}, false
+}
+
+// rmFindings flags recursive deletion of important directories
+func rmFindings(args []string) []Finding {
+	flags, operands := splitOptions(args)
+	if hasFlag(flags, "", "--no-preserve-root") {
+		return []Finding{{Rule: "recursive-delete", Arg: "--no-preserve-root", Message: "recursive deletion of root directory detected", Score: 100}}
+	}
+	if !hasFlag(flags, "rR", "--recursive") {
+		return nil
+	}
+
+	var findings []Finding
+	for _, target := range operands {
+		if finding, ok := targetFinding("recursive-delete", "recursive deletion", target); ok {
+			findings = append(findings, finding)
+		} else if target == "*" || target == "." || target == ".." {
+			findings = append(findings, Finding{Rule: "recursive-delete", Arg: target, Message: fmt.Sprintf("recursive deletion of '%s'", target), Score: 40})
+		}
+	}
+	if len(findings) == 0 {
+		findings = append(findings, Finding{Rule: "recursive-delete", Message: "recursive deletion", Score: 10})
+	}
+	return findings
+}
+
+// recursiveSystemFindings flags recursive changes to system directories
+func recursiveSystemFindings(command string) func(args []string) []Finding {
+	return func(args []string) []Finding {
+		flags, operands := splitOptions(args)
+		if !hasFlag(flags, "R", "--recursive") {
+			return nil
+		}
+		var findings []Finding
+		for _, target := range operands {
+			if finding, ok := targetFinding("recursive-"+command, "recursive "+command, target); ok {
+				findings = append(findings, finding)
+			}
+		}
+		return findings
+	}
+}
+
+// chmodFindings flags world-writable and setuid modes
+func chmodFindings(args []string) []Finding {
+	findings := recursiveSystemFindings("chmod")(args)
+	_, operands := splitOptions(args)
+	if len(operands) == 0 {
+		return findings
+	}
+
+	mode := operands[0]
+	switch {
+	case isSetIDMode(mode):
+		findings = append(findings, Finding{Rule: "chmod-setuid", Arg: mode, Message: fmt.Sprintf("setuid/setgid mode '%s'", mode), Score: 60})
+	case isWorldWritableMode(mode):
+		findings = append(findings, Finding{Rule: "chmod-world-writable", Arg: mode, Message: fmt.Sprintf("world-writable mode '%s'", mode), Score: 40})
+	}
+	return findings
+}
+
+// isSetIDMode reports whether a chmod mode sets the setuid or setgid bit
+func isSetIDMode(mode string) bool {
+	if isOctal(mode) {
+		return len(mode) == 4 && strings.ContainsAny(mode[:1], "2467")
+	}
+	for _, clause := range strings.Split(mode, ",") {
+		if i := strings.IndexAny(clause, "+="); i >= 0 && strings.Contains(clause[i:], "s") {
+			return true
+		}
+	}
+	return false
+}
+
+// isWorldWritableMode reports whether a chmod mode lets everyone write
+func isWorldWritableMode(mode string) bool {
+	if isOctal(mode) {
+		last := mode[len(mode)-1] - '0'
+		return last&2 != 0
+	}
+	for _, clause := range strings.Split(mode, ",") {
+		i := strings.IndexAny(clause, "+=")
+		if i < 0 {
+			continue
+		}
+		who := clause[:i]
+		if (who == "" || strings.ContainsAny(who, "oa")) && strings.Contains(clause[i:], "w") {
+			return true
+		}
+	}
+	return false
+}
+
+// isOctal reports whether s is an octal mode
+func isOctal(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '7' {
+			return false
+		}
+	}
+	return true
+}
+
+// ddFindings flags writes to devices
+func ddFindings(args []string) []Finding {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "of=/dev/") && arg != "of=/dev/null" {
+			return []Finding{{Rule: "dd-device-write", Arg: arg, Message: fmt.Sprintf("write to device '%s'", strings.TrimPrefix(arg, "of=")), Score: 100}}
+		}
+	}
+	return nil
+}
+
+// findFindings flags deleting and command-running expressions; the
+// commands run by -exec are checked as wrapped commands
+func findFindings(args []string) []Finding {
+	var findings []Finding
+	for _, arg := range args {
+		switch arg {
+		case "-delete":
+			findings = append(findings, Finding{Rule: "find-delete", Arg: arg, Message: "find deletes the matching files", Score: 50})
+		case "-exec", "-execdir", "-ok", "-okdir":
+			findings = append(findings, Finding{Rule: "find-exec", Arg: arg, Message: fmt.Sprintf("find runs a command for each file (%s)", arg), Score: 20})
+		case "-fprint", "-fprint0", "-fprintf", "-fls":
+			findings = append(findings, Finding{Rule: "find-write", Arg: arg, Message: fmt.Sprintf("find writes a file (%s)", arg), Score: 10})
+		}
+	}
+	return findings
+}
+
+// findCommands returns the commands run by the -exec expressions of find.
+// -delete counts as running rm on each file, so the rules for rm apply.
+func findCommands(args []string) []*types.CommandNode {
+	var commands []*types.CommandNode
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-delete":
+			commands = append(commands, &types.CommandNode{Name: "rm", Args: []string{"{}"}})
+		case "-exec", "-execdir", "-ok", "-okdir":
+			end := i + 1
+			for end < len(args) && args[end] != ";" && args[end] != "+" {
+				end++
+			}
+			if end > i+1 {
+				commands = append(commands, &types.CommandNode{Name: args[i+1], Args: args[i+2 : end]})
+			}
+			i = end
+		}
+	}
+	return commands
+}
+
+// gitExecConfig are git settings whose value is a program git runs
+var gitExecConfig = []string{
+	"core.sshcommand", "core.pager", "core.editor", "core.fsmonitor", "core.hookspath",
+	"credential.helper", "diff.external", "sequence.editor", "protocol.ext.allow",
+}
+
+// gitConfigFinding flags a git setting that makes git run a program
+func gitConfigFinding(key, value string) (Finding, bool) {
+	key = strings.ToLower(key)
+	execs := strings.HasPrefix(key, "alias.") && strings.HasPrefix(value, "!")
+	for _, setting := range gitExecConfig {
+		if key == setting {
+			execs = true
+		}
+	}
+	if !execs {
+		return Finding{}, false
+	}
+	return Finding{Rule: "git-exec-config", Arg: key, Message: fmt.Sprintf("git setting '%s' runs a program", key), Score: 70}, true
+}
+
+// gitValueOptions are the global options of git that take the next
+// argument as their value
+var gitValueOptions = map[string]bool{
+	"-C": true, "-c": true, "--git-dir": true, "--work-tree": true, "--namespace": true,
+	"--config-env": true, "--exec-path": true, "--super-prefix": true,
+}
+
+// gitSubcommandArgs returns the arguments of git from its subcommand on,
+// without the global options before it
+func gitSubcommandArgs(args []string) []string {
+	i := 0
+	for ; i < len(args) && strings.HasPrefix(args[i], "-"); i++ {
+		if gitValueOptions[args[i]] {
+			i++
+		}
+	}
+	if i >= len(args) {
+		return nil
+	}
+	return args[i:]
+}
+
+// gitFindings flags history-destroying subcommands and settings that run
+// programs
+func gitFindings(args []string) []Finding {
+	var findings []Finding
+
+	// Global options before the subcommand
+	i := 0
+	for ; i < len(args) && strings.HasPrefix(args[i], "-"); i++ {
+		switch args[i] {
+		case "-c":
+			if i+1 < len(args) {
+				i++
+				key, value, _ := strings.Cut(args[i], "=")
+				if finding, ok := gitConfigFinding(key, value); ok {
+					findings = append(findings, finding)
+				}
+			}
+		default:
+			if gitValueOptions[args[i]] {
+				i++
+			}
+		}
+	}
+	if i >= len(args) {
+		return findings
+	}
+
+	subcommand, rest := args[i], args[i+1:]
+	flags, operands := splitOptions(rest)
+	switch subcommand {
+	case "push":
+		if hasFlag(flags, "f", "--force", "--mirror") {
+			findings = append(findings, Finding{Rule: "git-force-push", Arg: "--force", Message: "git push overwrites remote history", Score: 50})
+		} else if hasFlag(flags, "", "--force-with-lease") {
+			findings = append(findings, Finding{Rule: "git-force-push", Arg: "--force-with-lease", Message: "git push may overwrite remote history", Score: 30})
+		}
+		deletes := hasFlag(flags, "d", "--delete")
+		for _, operand := range operands {
+			// A refspec without a source, as in :branch, deletes the branch
+			deletes = deletes || strings.HasPrefix(operand, ":")
+		}
+		if deletes {
+			findings = append(findings, Finding{Rule: "git-delete-branch", Message: "git push deletes a remote branch", Score: 40})
+		}
+	case "reset":
+		if hasFlag(flags, "", "--hard") {
+			findings = append(findings, Finding{Rule: "git-discard", Arg: "--hard", Message: "git reset --hard discards local changes", Score: 40})
+		}
+	case "clean":
+		if hasFlag(flags, "f", "--force") {
+			score := 40
+			if hasFlag(flags, "x", "") {
+				score = 50
+			}
+			findings = append(findings, Finding{Rule: "git-clean", Message: "git clean deletes untracked files", Score: score})
+		}
+	case "branch":
+		if hasFlag(flags, "D", "") || hasFlag(flags, "d", "--delete") && hasFlag(flags, "f", "--force") {
+			findings = append(findings, Finding{Rule: "git-delete-branch", Message: "git branch force-deletes a branch", Score: 30})
+		}
+	case "filter-branch", "filter-repo":
+		findings = append(findings, Finding{Rule: "git-rewrite-history", Message: "git " + subcommand + " rewrites history", Score: 50})
+	case "config":
+		if len(operands) >= 2 {
+			if finding, ok := gitConfigFinding(operands[0], operands[1]); ok {
+				findings = append(findings, finding)
+			}
+		}
+	}
+	return findings
+}
+
+// optionValues returns the values of an option given as "-o value",
+// "-ovalue", "--output value" or "--output=value"
+func optionValues(args []string, short string, long ...string) []string {
+	var values []string
+	for i, arg := range args {
+		matched := ""
+		switch {
+		case short != "" && arg == short, containsString(long, arg):
+			if i+1 < len(args) {
+				values = append(values, args[i+1])
+			}
+			continue
+		case short != "" && strings.HasPrefix(arg, short) && !strings.HasPrefix(arg, "--"):
+			matched = strings.TrimPrefix(arg, short)
+		default:
+			for _, l := range long {
+				if strings.HasPrefix(arg, l+"=") {
+					matched = strings.TrimPrefix(arg, l+"=")
+				}
+			}
+		}
+		if matched != "" {
+			values = append(values, matched)
+		}
+	}
+	return values
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// curlFindings flags uploads of local files, file writes and disabled TLS
+// verification
+func curlFindings(args []string) []Finding {
+	var findings []Finding
+	for _, path := range optionValues(args, "-o", "--output") {
+		findings = append(findings, Finding{Rule: "download-write", Arg: path, Message: fmt.Sprintf("curl writes the download to '%s'", path), Score: 20})
+	}
+	for _, path := range optionValues(args, "-T", "--upload-file") {
+		findings = append(findings, Finding{Rule: "upload-file", Arg: path, Message: fmt.Sprintf("curl uploads local file '%s'", path), Score: 50})
+	}
+	data := optionValues(args, "-d", "--data", "--data-binary", "--data-urlencode", "--json")
+	for _, value := range data {
+		if strings.HasPrefix(value, "@") && value != "@-" {
+			findings = append(findings, Finding{Rule: "upload-file", Arg: value, Message: fmt.Sprintf("curl uploads local file '%s'", value[1:]), Score: 50})
+		}
+	}
+	for _, value := range optionValues(args, "-F", "--form") {
+		if _, file, ok := strings.Cut(value, "=@"); ok {
+			findings = append(findings, Finding{Rule: "upload-file", Arg: value, Message: fmt.Sprintf("curl uploads local file '%s'", file), Score: 50})
+		}
+	}
+	flags, _ := splitOptions(args)
+	if hasFlag(flags, "k", "--insecure") {
+		findings = append(findings, Finding{Rule: "tls-disabled", Arg: "--insecure", Message: "curl disables TLS certificate verification", Score: 30})
+	}
+	return findings
+}
+
+// wgetFindings flags uploads, file writes, wgetrc commands and disabled TLS
+// verification
+func wgetFindings(args []string) []Finding {
+	var findings []Finding
+	for _, path := range optionValues(args, "-O", "--output-document") {
+		if path != "-" {
+			findings = append(findings, Finding{Rule: "download-write", Arg: path, Message: fmt.Sprintf("wget writes the download to '%s'", path), Score: 20})
+		}
+	}
+	for _, path := range optionValues(args, "", "--post-file", "--body-file") {
+		findings = append(findings, Finding{Rule: "upload-file", Arg: path, Message: fmt.Sprintf("wget uploads local file '%s'", path), Score: 50})
+	}
+	for _, command := range optionValues(args, "-e", "--execute") {
+		findings = append(findings, Finding{Rule: "wget-execute", Arg: command, Message: fmt.Sprintf("wget runs wgetrc command '%s'", command), Score: 40})
+	}
+	if containsString(args, "--no-check-certificate") {
+		findings = append(findings, Finding{Rule: "tls-disabled", Arg: "--no-check-certificate", Message: "wget disables TLS certificate verification", Score: 30})
+	}
+	return findings
+}
+
+// tarFindings flags options that make tar run programs
+func tarFindings(args []string) []Finding {
+	var findings []Finding
+	for _, arg := range args {
+		option, _, _ := strings.Cut(arg, "=")
+		switch {
+		case option == "--to-command", option == "--use-compress-program", option == "--rsh-command", option == "--info-script", option == "--new-volume-script",
+			strings.HasPrefix(arg, "--checkpoint-action=exec"):
+			findings = append(findings, Finding{Rule: "tar-exec", Arg: option, Message: fmt.Sprintf("tar runs a program (%s)", option), Score: 70})
+		}
+	}
+	return findings
+}
+
+// sshFindings flags options that run local commands
+func sshFindings(args []string) []Finding {
+	var findings []Finding
+	for _, option := range optionValues(args, "-o") {
+		key, _, _ := strings.Cut(option, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		if key == "proxycommand" || key == "localcommand" || key == "permitlocalcommand" {
+			findings = append(findings, Finding{Rule: "ssh-local-command", Arg: option, Message: fmt.Sprintf("ssh option '%s' runs a local command", key), Score: 70})
+		}
+	}
+	return findings
+}
+
+// rsyncFindings flags remote shells and deleting transfers
+func rsyncFindings(args []string) []Finding {
+	var findings []Finding
+	for _, shell := range optionValues(args, "-e", "--rsh", "--rsync-path") {
+		findings = append(findings, Finding{Rule: "rsync-command", Arg: shell, Message: fmt.Sprintf("rsync runs '%s'", shell), Score: 40})
+	}
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--delete") || arg == "--remove-source-files" {
+			findings = append(findings, Finding{Rule: "rsync-delete", Arg: arg, Message: fmt.Sprintf("rsync deletes files (%s)", arg), Score: 40})
+			break
+		}
+	}
+	return findings
+}
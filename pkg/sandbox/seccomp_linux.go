@@ -0,0 +1,92 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// Classic BPF and seccomp constants
+const (
+	bpfLdWAbs = 0x20 // BPF_LD | BPF_W | BPF_ABS
+	bpfJeqK   = 0x15 // BPF_JMP | BPF_JEQ | BPF_K
+	bpfJgeK   = 0x35 // BPF_JMP | BPF_JGE | BPF_K
+	bpfJsetK  = 0x45 // BPF_JMP | BPF_JSET | BPF_K
+	bpfRetK   = 0x06 // BPF_RET | BPF_K
+
+	seccompModeFilter     = 2
+	seccompRetKillProcess = 0x80000000
+	seccompRetErrno       = 0x00050000
+	seccompRetAllow       = 0x7fff0000
+
+	seccompDataNr   = 0  // offsetof(struct seccomp_data, nr)
+	seccompDataArch = 4  // offsetof(struct seccomp_data, arch)
+	seccompDataArg0 = 16 // offsetof(struct seccomp_data, args[0]), low word on little-endian
+
+	// cloneNamespaceFlags are the CLONE_NEW* flags clone accepts;
+	// CLONE_NEWTIME shares its bit with the exit signal and is clone3 only
+	cloneNamespaceFlags = syscall.CLONE_NEWNS | syscall.CLONE_NEWCGROUP | syscall.CLONE_NEWUTS |
+		syscall.CLONE_NEWIPC | syscall.CLONE_NEWUSER | syscall.CLONE_NEWPID | syscall.CLONE_NEWNET
+)
+
+// sockFilter is struct sock_filter
+type sockFilter struct {
+	code uint16
+	jt   uint8
+	jf   uint8
+	k    uint32
+}
+
+// sockFprog is struct sock_fprog
+type sockFprog struct {
+	len    uint16
+	filter *sockFilter
+}
+
+// seccompFilter returns a filter that fails the denied syscalls with EPERM
+// and kills processes using another syscall ABI, whose numbers differ.
+// clone fails with EPERM when it would create namespaces, as unshare does;
+// clone3 passes its flags in memory the filter cannot read, so it fails
+// with ENOSYS and the C library falls back to clone.
+func seccompFilter() []sockFilter {
+	kill := sockFilter{code: bpfRetK, k: seccompRetKillProcess}
+	filter := []sockFilter{
+		{code: bpfLdWAbs, k: seccompDataArch},
+		{code: bpfJeqK, jt: 1, k: auditArch},
+		kill,
+		{code: bpfLdWAbs, k: seccompDataNr},
+	}
+	if syscallABIBit != 0 {
+		// x32 syscalls on amd64 share the architecture
+		filter = append(filter, sockFilter{code: bpfJgeK, jf: 1, k: syscallABIBit}, kill)
+	}
+	for _, nr := range deniedSyscalls {
+		filter = append(filter,
+			sockFilter{code: bpfJeqK, jf: 1, k: nr},
+			sockFilter{code: bpfRetK, k: seccompRetErrno | uint32(syscall.EPERM)})
+	}
+	filter = append(filter,
+		sockFilter{code: bpfJeqK, jf: 3, k: sysClone},
+		sockFilter{code: bpfLdWAbs, k: seccompDataArg0},
+		sockFilter{code: bpfJsetK, jf: 1, k: cloneNamespaceFlags},
+		sockFilter{code: bpfRetK, k: seccompRetErrno | uint32(syscall.EPERM)},
+		// Only clone comes here with the flags loaded, and it is not clone3
+		sockFilter{code: bpfJeqK, jf: 1, k: sysClone3},
+		sockFilter{code: bpfRetK, k: seccompRetErrno | uint32(syscall.ENOSYS)})
+	return append(filter, sockFilter{code: bpfRetK, k: seccompRetAllow})
+}
+
+// installSeccomp applies the seccomp filter to the calling thread
+func installSeccomp() error {
+	filter := seccompFilter()
+	prog := sockFprog{len: uint16(len(filter)), filter: &filter[0]}
+	_, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, syscall.PR_SET_SECCOMP, seccompModeFilter, uintptr(unsafe.Pointer(&prog)))
+	runtime.KeepAlive(filter)
+	if errno != 0 {
+		return fmt.Errorf("failed to install seccomp filter: %v", errno)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package sandbox
+
+// auditArch is AUDIT_ARCH_X86_64
+const auditArch = 0xc000003e
+
+// syscallABIBit marks x32 syscall numbers
+const syscallABIBit = 0x40000000
+
+// clone and clone3 are filtered on the namespaces they create
+const (
+	sysClone  = 56
+	sysClone3 = 435
+)
+
+// deniedSyscalls administer the system, load kernel code, inspect other
+// processes or change namespaces
+var deniedSyscalls = []uint32{
+	165, // mount
+	166, // umount2
+	155, // pivot_root
+	161, // chroot
+	167, // swapon
+	168, // swapoff
+	169, // reboot
+	175, // init_module
+	313, // finit_module
+	176, // delete_module
+	246, // kexec_load
+	320, // kexec_file_load
+	101, // ptrace
+	310, // process_vm_readv
+	311, // process_vm_writev
+	321, // bpf
+	298, // perf_event_open
+	248, // add_key
+	249, // request_key
+	250, // keyctl
+	303, // name_to_handle_at
+	304, // open_by_handle_at
+	323, // userfaultfd
+	163, // acct
+	164, // settimeofday
+	227, // clock_settime
+	159, // adjtimex
+	305, // clock_adjtime
+	103, // syslog
+	172, // iopl
+	173, // ioperm
+	179, // quotactl
+	272, // unshare
+	308, // setns
+	428, // open_tree
+	429, // move_mount
+	430, // fsopen
+	431, // fsconfig
+	432, // fsmount
+	442, // mount_setattr
+}
@@ -0,0 +1,56 @@
+package sandbox
+
+// auditArch is AUDIT_ARCH_AARCH64
+const auditArch = 0xc00000b7
+
+// syscallABIBit is unused on arm64
+const syscallABIBit = 0
+
+// clone and clone3 are filtered on the namespaces they create
+const (
+	sysClone  = 220
+	sysClone3 = 435
+)
+
+// deniedSyscalls administer the system, load kernel code, inspect other
+// processes or change namespaces
+var deniedSyscalls = []uint32{
+	40,  // mount
+	39,  // umount2
+	41,  // pivot_root
+	51,  // chroot
+	224, // swapon
+	225, // swapoff
+	142, // reboot
+	105, // init_module
+	273, // finit_module
+	106, // delete_module
+	104, // kexec_load
+	294, // kexec_file_load
+	117, // ptrace
+	270, // process_vm_readv
+	271, // process_vm_writev
+	280, // bpf
+	241, // perf_event_open
+	217, // add_key
+	218, // request_key
+	219, // keyctl
+	264, // name_to_handle_at
+	265, // open_by_handle_at
+	282, // userfaultfd
+	89,  // acct
+	170, // settimeofday
+	112, // clock_settime
+	171, // adjtimex
+	266, // clock_adjtime
+	116, // syslog
+	60,  // quotactl
+	97,  // unshare
+	268, // setns
+	428, // open_tree
+	429, // move_mount
+	430, // fsopen
+	431, // fsconfig
+	432, // fsmount
+	442, // mount_setattr
+}
@@ -0,0 +1,13 @@
+//go:build linux && !amd64 && !arm64
+
+package sandbox
+
+// Seccomp filtering is only implemented for amd64 and arm64
+const (
+	auditArch     = 0
+	syscallABIBit = 0
+	sysClone      = 0
+	sysClone3     = 0
+)
+
+var deniedSyscalls []uint32
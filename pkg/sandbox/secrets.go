@@ -0,0 +1,86 @@
+package sandbox
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// SecretMask replaces the values of secret variables in output
+const SecretMask = "***"
+
+// minSecretLength is the length from which values of secret variables are
+// masked; shorter values would mask unrelated text
+const minSecretLength = 4
+
+// defaultSecrets are the name patterns of variables that are secret
+// without any policy, matched case-insensitively
+var defaultSecrets = []string{
+	"*_TOKEN", "*_SECRET", "*_PASSWORD", "*_PASSWD", "*_API_KEY",
+	"*_ACCESS_KEY", "*_PRIVATE_KEY", "TOKEN", "SECRET", "PASSWORD", "API_KEY",
+}
+
+// authorizationHeader matches the credentials of Authorization and
+// Proxy-Authorization headers, after an optional scheme such as Bearer
+var authorizationHeader = regexp.MustCompile(`(?i)(\b(?:proxy-)?authorization:\s*(?:(?:basic|bearer|digest|token)\s+)?)[^\s'"]+`)
+
+// MaskAuthorization replaces the credentials of Authorization headers in s
+// with SecretMask, keeping the scheme
+func MaskAuthorization(s string) string {
+	return authorizationHeader.ReplaceAllString(s, "${1}"+SecretMask)
+}
+
+// IsSecret reports whether a variable holds a secret: its name matches a
+// default pattern or a pattern from the secrets of a policy. Variables
+// marked by declare -S are secret in the engine that ran it.
+func (sc *SecurityChecker) IsSecret(name string) bool {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return sc.isSecret(name)
+}
+
+// isSecret is IsSecret for callers holding the lock
+func (sc *SecurityChecker) isSecret(name string) bool {
+	name = strings.ToUpper(name)
+	for _, patterns := range [][]string{defaultSecrets, sc.secrets} {
+		for _, pattern := range patterns {
+			if matchWildcard(pattern, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// applySecrets records the secret variable patterns of a policy
+func (sc *SecurityChecker) applySecrets(policy *Policy) {
+	for _, pattern := range policy.Secrets {
+		sc.secrets = append(sc.secrets, strings.ToUpper(pattern))
+	}
+}
+
+// SecretMasker returns a function that replaces the values of the secret
+// variables in env with SecretMask, longest values first, and the
+// credentials of Authorization headers. marked holds further secret
+// variables by their upper-case names.
+func (sc *SecurityChecker) SecretMasker(env map[string]string, marked map[string]bool) func(string) string {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	var values []string
+	for name, value := range env {
+		if len(value) >= minSecretLength && (marked[strings.ToUpper(name)] || sc.isSecret(name)) {
+			values = append(values, value)
+		}
+	}
+	if len(values) == 0 {
+		return MaskAuthorization
+	}
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+
+	pairs := make([]string, 0, 2*len(values))
+	for _, value := range values {
+		pairs = append(pairs, value, SecretMask)
+	}
+	replacer := strings.NewReplacer(pairs...)
+	return func(s string) string { return MaskAuthorization(replacer.Replace(s)) }
+}
@@ -2,25 +2,62 @@ package sandbox
 
 import (
 	"fmt"
-	"regexp"
 	"strings"
+	"sync"
 
 	"gitee.com/com_818cloud/shode/pkg/types"
 )
 
-// SecurityChecker provides security validation for shell commands
+// SecurityChecker provides security validation for shell commands. It is
+// safe for concurrent use, and Reload replaces its rules atomically.
 type SecurityChecker struct {
+	mu sync.RWMutex
+	ruleSet
+
+	hashMu        sync.Mutex
+	hashes        map[string]hashEntry        // cached binary hashes
+	networkLogger func(NetworkEvent)          // receives egress proxy connections
+	transaction   *Transaction                // transaction whose overlay external commands see, nil if none
+	overrides     []func(sc *SecurityChecker) // rule changes made at runtime, replayed after a reload
+}
+
+// ruleSet holds the rules of a checker: the defaults and those of the
+// applied policies
+type ruleSet struct {
 	dangerousCommands map[string]bool
 	fileBlacklist     map[string]bool
 	networkBlacklist  map[string]bool
+	commandRules      map[string][]CommandRule // per-command argument rules from policies
+	pathRules         []pathRuleEntry          // glob path rules from policies, last match wins
+	policies          []string                 // sources of the applied policies
+	denySources       map[string]string        // policy that denied each command
+	mode              string                   // ModeDenylist or ModeAllowlist
+	modeSource        string                   // policy that set the mode
+	allowed           []string                 // commands allowed in allowlist mode, as written
+	pins              []pinEntry               // expected binary hashes
+	riskThreshold     int                      // risk score from which commands are blocked
+	enforcement       *EnforcementPolicy       // kernel sandbox for external commands, nil if disabled
+	network           *networkRules            // network policy, nil if unrestricted
+	approved          map[string]string        // approved command lines and the policy or session that approved them
+	secrets           []string                 // name patterns of secret variables from policies, upper case
+	contextRules      []contextRuleEntry       // context rules from policies
 }
 
 // NewSecurityChecker creates a new security checker with default rules
 func NewSecurityChecker() *SecurityChecker {
 	sc := &SecurityChecker{
-		dangerousCommands: make(map[string]bool),
-		fileBlacklist:     make(map[string]bool),
-		networkBlacklist:  make(map[string]bool),
+		ruleSet: ruleSet{
+			dangerousCommands: make(map[string]bool),
+			fileBlacklist:     make(map[string]bool),
+			networkBlacklist:  make(map[string]bool),
+			commandRules:      make(map[string][]CommandRule),
+			denySources:       make(map[string]string),
+			mode:              ModeDenylist,
+			riskThreshold:     DefaultRiskThreshold,
+			approved:          make(map[string]string),
+		},
+		hashes:        make(map[string]hashEntry),
+		networkLogger: logBlockedConnection,
 	}
 
 	// Initialize default dangerous commands
@@ -93,117 +130,180 @@ func (sc *SecurityChecker) initializeNetworkBlacklist() {
 	}
 }
 
-// CheckCommand validates a command for security risks
+// CheckCommand validates a command for security risks. The command is
+// identified by its executable, so /bin/rm and a symlink to rm count as rm,
+// and commands run through wrappers such as env, sudo, xargs or sh -c are
+// checked as well.
 func (sc *SecurityChecker) CheckCommand(cmd *types.CommandNode) error {
-	commandName := strings.ToLower(cmd.Name)
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return sc.checkCommand(cmd, &CheckContext{}, 0)
+}
+
+// CheckCommandInDir validates a command run in dir. Relative paths in its
+// arguments and redirection are resolved against dir.
+func (sc *SecurityChecker) CheckCommandInDir(cmd *types.CommandNode, dir string) error {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return sc.checkCommand(cmd, &CheckContext{Dir: dir}, 0)
+}
 
-	// Check for dangerous commands
-	if sc.dangerousCommands[commandName] {
-		return fmt.Errorf("security violation: dangerous command '%s' is not allowed", commandName)
+// CheckCommandWithContext validates a command run in ctx: relative paths
+// are resolved against its directory, and the context rules of policies
+// are applied to its script, module and directory
+func (sc *SecurityChecker) CheckCommandWithContext(cmd *types.CommandNode, ctx *CheckContext) error {
+	if ctx == nil {
+		ctx = &CheckContext{}
 	}
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return sc.checkCommand(cmd, ctx, 0)
+}
 
-	// Check for network-related dangerous commands
-	if sc.networkBlacklist[commandName] {
-		return fmt.Errorf("security violation: network command '%s' is not allowed", commandName)
+// checkCommand checks a command run in ctx and, at the given wrapper
+// depth, the commands it wraps
+func (sc *SecurityChecker) checkCommand(cmd *types.CommandNode, ctx *CheckContext, depth int) error {
+	// An approved command line runs as a whole, with what it wraps
+	if depth == 0 && sc.isApproved(cmd) {
+		return nil
 	}
+	names := identities(cmd.Name, ctx)
+
+	for _, commandName := range names {
+		// Check policy argument rules; allowArgs can permit a denied command
+		allowedByRule, err := sc.checkCommandRules(commandName, cmd.Args)
+		if err != nil {
+			return err
+		}
 
-	// Check arguments for sensitive file access
-	for _, arg := range cmd.Args {
-		if sc.isSensitiveFile(arg) {
-			return fmt.Errorf("security violation: access to sensitive file '%s' is not allowed", arg)
+		// Check for dangerous commands
+		if sc.isDenied(cmd, commandName) && !allowedByRule {
+			if source, ok := sc.denySources[commandName]; ok {
+				return fmt.Errorf("security violation: command '%s' is denied by commands.deny of policy %s", commandName, source)
+			}
+			return fmt.Errorf("security violation: dangerous command '%s' is not allowed", commandName)
+		}
+
+		// Check for network-related dangerous commands
+		if sc.networkBlacklist[commandName] && !allowedByRule {
+			return fmt.Errorf("security violation: network command '%s' is not allowed", commandName)
 		}
 	}
 
-	// Additional pattern-based checks
-	if err := sc.checkPatterns(cmd); err != nil {
+	// Check where the command runs
+	if err := sc.checkContextRules(names, ctx); err != nil {
 		return err
 	}
 
-	return nil
-}
+	// Check the paths the command reads, writes and runs
+	if err := sc.checkPathArguments(cmd, names, ctx); err != nil {
+		return err
+	}
 
-// isSensitiveFile checks if a path matches sensitive file patterns
-func (sc *SecurityChecker) isSensitiveFile(path string) bool {
-	// Check exact matches
-	if sc.fileBlacklist[path] {
-		return true
+	// Check the destinations of URL arguments
+	if err := sc.checkNetworkArguments(cmd.Args); err != nil {
+		return err
 	}
 
-	// Check prefix matches (e.g., /root/anything)
-	for blacklistedPath := range sc.fileBlacklist {
-		if strings.HasPrefix(path, blacklistedPath) {
-			return true
-		}
+	// Analyze the arguments and block risky uses
+	if err := sc.checkRisk(cmd, names); err != nil {
+		return err
 	}
 
-	return false
+	// Check the commands run by wrappers and shells
+	return sc.checkWrapped(cmd, names, ctx, depth)
 }
 
-// checkPatterns performs regex-based security checks
-func (sc *SecurityChecker) checkPatterns(cmd *types.CommandNode) error {
-	// Combine command and arguments for pattern matching
-	fullCommand := cmd.Name + " " + strings.Join(cmd.Args, " ")
-
-	// Check for recursive deletion patterns (rm -rf /)
-	recursiveDelete := regexp.MustCompile(`rm\s+.*-r.*\s+/(\s|$)`)
-	if recursiveDelete.MatchString(fullCommand) {
-		return fmt.Errorf("security violation: recursive deletion of root directory detected")
+// isDenied reports whether a command known by name is dangerous. The file
+// functions of the standard library are checked by the paths they use
+// instead, so Chmod is not refused as chmod is unless a policy denies it.
+func (sc *SecurityChecker) isDenied(cmd *types.CommandNode, name string) bool {
+	if !sc.dangerousCommands[name] {
+		return false
 	}
-
-	// Check for password in command line
-	passwordPattern := regexp.MustCompile(`(-p|--password|passwd)\s+(\S+)`)
-	if passwordPattern.MatchString(fullCommand) {
-		return fmt.Errorf("security violation: password in command line detected")
+	if _, ok := fileFunctionAccess[cmd.Name]; ok {
+		_, byPolicy := sc.denySources[name]
+		return byPolicy
 	}
-
-	// Check for shell injection patterns
-	shellInjection := regexp.MustCompile(`[;&|` + "`" + `$()]`)
-	if shellInjection.MatchString(fullCommand) && cmd.Name != "shode" {
-		return fmt.Errorf("security violation: potential shell injection detected")
-	}
-
-	return nil
+	return true
 }
 
 // AddDangerousCommand adds a custom dangerous command to the blacklist
 func (sc *SecurityChecker) AddDangerousCommand(command string) {
-	sc.dangerousCommands[strings.ToLower(command)] = true
+	sc.override(func(sc *SecurityChecker) {
+		sc.dangerousCommands[strings.ToLower(command)] = true
+	})
 }
 
 // RemoveDangerousCommand removes a command from the dangerous commands blacklist
 func (sc *SecurityChecker) RemoveDangerousCommand(command string) {
-	delete(sc.dangerousCommands, strings.ToLower(command))
+	sc.override(func(sc *SecurityChecker) {
+		delete(sc.dangerousCommands, strings.ToLower(command))
+	})
 }
 
 // AddSensitiveFile adds a custom sensitive file path to the blacklist
 func (sc *SecurityChecker) AddSensitiveFile(filepath string) {
-	sc.fileBlacklist[filepath] = true
+	sc.override(func(sc *SecurityChecker) {
+		sc.fileBlacklist[filepath] = true
+	})
 }
 
 // RemoveSensitiveFile removes a file path from the sensitive files blacklist
 func (sc *SecurityChecker) RemoveSensitiveFile(filepath string) {
-	delete(sc.fileBlacklist, filepath)
+	sc.override(func(sc *SecurityChecker) {
+		delete(sc.fileBlacklist, filepath)
+	})
+}
+
+// override applies a rule change made at runtime and records it, so it
+// survives reloads of the policy
+func (sc *SecurityChecker) override(change func(sc *SecurityChecker)) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	change(sc)
+	sc.overrides = append(sc.overrides, change)
 }
 
 // GetSecurityReport generates a security report for a command
 func (sc *SecurityChecker) GetSecurityReport(cmd *types.CommandNode) map[string]interface{} {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
 	report := make(map[string]interface{})
 	report["command"] = cmd.Name
 	report["arguments"] = cmd.Args
 	report["line_number"] = cmd.Pos.Line
 
 	// Check various security aspects
-	report["is_dangerous_command"] = sc.dangerousCommands[strings.ToLower(cmd.Name)]
-	report["is_network_command"] = sc.networkBlacklist[strings.ToLower(cmd.Name)]
+	report["is_dangerous_command"] = false
+	report["is_network_command"] = false
+	for _, name := range identities(cmd.Name, &CheckContext{}) {
+		if sc.isDenied(cmd, name) {
+			report["is_dangerous_command"] = true
+		}
+		if sc.networkBlacklist[name] {
+			report["is_network_command"] = true
+		}
+	}
 
 	// Check for sensitive file access
 	sensitiveFiles := []string{}
-	for _, arg := range cmd.Args {
-		if sc.isSensitiveFile(arg) {
-			sensitiveFiles = append(sensitiveFiles, arg)
+	for _, operand := range commandPaths(cmd, identities(cmd.Name, &CheckContext{}), "") {
+		if sc.checkPath(operand.path, "", operand.need) != nil {
+			sensitiveFiles = append(sensitiveFiles, operand.path)
 		}
 	}
+	if cmd.Redirect != nil && sc.checkRedirect(cmd.Redirect, "") != nil {
+		sensitiveFiles = append(sensitiveFiles, cmd.Redirect.File)
+	}
 	report["sensitive_files"] = sensitiveFiles
 
+	// Score the arguments
+	assessment := sc.assessCommand(cmd)
+	report["risk_score"] = assessment.Score
+	report["risk_level"] = assessment.Level
+	report["findings"] = assessment.Findings
+
 	return report
 }
@@ -0,0 +1,1028 @@
+package sandbox
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Backends of a transaction
+const (
+	TransactionOverlay = "overlay" // overlayfs mounted over the directory for each command
+	TransactionShadow  = "shadow"  // a copy of the directory that commands run in
+)
+
+// maxSymlinks is the number of symlinks followed before a path is taken
+// for a loop, as on Linux
+const maxSymlinks = 40
+
+// Limits of a shadow copy; larger trees need the overlay backend
+const (
+	maxShadowBytes   = 1 << 30 // total size of the files
+	maxShadowEntries = 100000  // files, directories and symlinks
+)
+
+// Kinds of changes a transaction makes
+const (
+	ChangeCreated  = "created"
+	ChangeModified = "modified"
+	ChangeDeleted  = "deleted"
+)
+
+// Change is a file or directory a transaction creates, modifies or deletes
+type Change struct {
+	Path string `json:"path"` // in the real directory tree
+	Kind string `json:"kind"`
+	Dir  bool   `json:"dir,omitempty"`
+
+	source string // file holding the new content
+}
+
+// Transaction runs commands against a copy-on-write view of a directory
+// tree, so their changes can be reviewed and then applied or thrown away.
+// With the overlay backend, external commands see an overlayfs mounted over
+// the tree in their own user and mount namespace, and their writes land in
+// an upper directory. Where overlayfs is unavailable, the tree is copied and
+// commands run in the copy. Files shode itself reads and writes, e.g. for
+// redirections, go through OpenFile.
+type Transaction struct {
+	root  string // the directory tree the transaction covers
+	mode  string // TransactionOverlay or TransactionShadow
+	temp  string // holds the directories below
+	upper string // overlay: changed files; shadow: the copy of root
+	work  string // overlay work directory
+
+	// Shadow transactions record both trees when the copy is taken, so
+	// only what changed in the copy is applied, and only to a tree nothing
+	// else changed
+	rootManifest   manifest
+	shadowManifest manifest
+}
+
+// manifestEntry is what a manifest records about a file or directory
+type manifestEntry struct {
+	mode    os.FileMode
+	size    int64
+	modTime time.Time
+	link    string // target of a symlink
+}
+
+// manifest records the entries of a directory tree by their path relative
+// to its root
+type manifest map[string]manifestEntry
+
+// takeManifest records the entries of the tree at root, leaving out the
+// tree at skip. With limit set, a tree larger than a shadow copy may be is
+// refused.
+func takeManifest(root, skip string, limit bool) (manifest, error) {
+	m := make(manifest)
+	var total int64
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == skip {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		entry := manifestEntry{mode: info.Mode()}
+		if !info.IsDir() {
+			entry.size = info.Size()
+			entry.modTime = info.ModTime()
+			total += entry.size
+		}
+		if limit && (total > maxShadowBytes || len(m) >= maxShadowEntries) {
+			return fmt.Errorf("%s is too large for a shadow copy (more than %d MiB or %d entries); use the overlay backend or a smaller directory",
+				root, maxShadowBytes>>20, maxShadowEntries)
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if entry.link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		m[rel] = entry
+		return nil
+	})
+	return m, err
+}
+
+// differences returns the paths whose entries differ between two manifests,
+// sorted. Directories differ only in their type and permissions, since
+// their modification times change with their content.
+func (m manifest) differences(other manifest) []string {
+	var paths []string
+	for rel, entry := range m {
+		if otherEntry, ok := other[rel]; !ok || otherEntry != entry {
+			paths = append(paths, rel)
+		}
+	}
+	for rel := range other {
+		if _, ok := m[rel]; !ok {
+			paths = append(paths, rel)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// NewTransaction starts a transaction on the directory tree at root. mode
+// is TransactionOverlay, TransactionShadow or "" for overlay where the
+// kernel allows it and a shadow copy elsewhere.
+func NewTransaction(root, mode string) (*Transaction, error) {
+	if mode != "" && mode != TransactionOverlay && mode != TransactionShadow {
+		return nil, fmt.Errorf("unknown transaction mode %q (expected overlay or shadow)", mode)
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolved
+	}
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("transaction root %s is not a directory", root)
+	}
+
+	temp, err := os.MkdirTemp("", "shode-tx-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create transaction directory: %v", err)
+	}
+	// The temporary directory may be inside the tree, e.g. when it is
+	// started at / or $TMPDIR is below the working directory; the shadow
+	// copy and manifests leave it out
+	if resolved, err := filepath.EvalSymlinks(temp); err == nil {
+		temp = resolved
+	}
+	tx := &Transaction{root: root, temp: temp}
+
+	if mode != TransactionShadow {
+		tx.upper = filepath.Join(temp, "upper")
+		tx.work = filepath.Join(temp, "work")
+		err := os.Mkdir(tx.upper, 0755)
+		if err == nil {
+			err = os.Mkdir(tx.work, 0700)
+		}
+		if err == nil {
+			err = probeOverlay(tx)
+		}
+		if err == nil {
+			tx.mode = TransactionOverlay
+			return tx, nil
+		}
+		if mode == TransactionOverlay {
+			tx.Discard()
+			return nil, fmt.Errorf("overlay transactions are not available: %v", err)
+		}
+		os.RemoveAll(tx.upper)
+		os.RemoveAll(tx.work)
+	}
+
+	tx.mode = TransactionShadow
+	tx.upper = filepath.Join(temp, "shadow")
+	tx.work = ""
+	if tx.rootManifest, err = takeManifest(root, temp, true); err != nil {
+		tx.Discard()
+		return nil, err
+	}
+	if err = copyTree(root, tx.upper, temp); err == nil {
+		tx.shadowManifest, err = takeManifest(tx.upper, "", false)
+	}
+	if err != nil {
+		tx.Discard()
+		return nil, fmt.Errorf("failed to copy %s: %v", root, err)
+	}
+	return tx, nil
+}
+
+// overlayMount is the overlay the sandbox helper mounts over the tree of a
+// transaction
+type overlayMount struct {
+	Lower string `json:"lower"` // the tree, which is also where the overlay is mounted
+	Upper string `json:"upper"`
+	Work  string `json:"work"`
+}
+
+// overlayMount returns the overlay of the transaction
+func (tx *Transaction) overlayMount() *overlayMount {
+	return &overlayMount{Lower: tx.root, Upper: tx.upper, Work: tx.work}
+}
+
+// SetTransaction makes external commands see the overlay of tx; nil
+// removes it. Shadow transactions need no help from the sandbox.
+func (sc *SecurityChecker) SetTransaction(tx *Transaction) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.transaction = tx
+}
+
+// overlay returns the overlay external commands run under, or nil
+func (sc *SecurityChecker) overlay() *overlayMount {
+	if sc.transaction == nil || sc.transaction.mode != TransactionOverlay {
+		return nil
+	}
+	return sc.transaction.overlayMount()
+}
+
+// Root returns the directory tree the transaction covers
+func (tx *Transaction) Root() string {
+	return tx.root
+}
+
+// Mode returns the backend of the transaction
+func (tx *Transaction) Mode() string {
+	return tx.mode
+}
+
+// relative returns the path of an absolute path inside the tree, relative
+// to its root. The transaction's own directory is not part of the tree.
+func (tx *Transaction) relative(path string) (string, bool) {
+	path = filepath.Clean(path)
+	rel, err := filepath.Rel(tx.root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || underPath(path, tx.temp) {
+		return "", false
+	}
+	return rel, true
+}
+
+// Dir returns the directory a process working in dir starts in. Shadow
+// transactions run commands in the copy; overlay transactions mount the
+// view where the tree is.
+func (tx *Transaction) Dir(dir string) string {
+	if tx.mode != TransactionShadow {
+		return dir
+	}
+	if rel, ok := tx.relative(dir); ok {
+		return filepath.Join(tx.upper, rel)
+	}
+	return dir
+}
+
+// Resolve returns the file that holds a path as commands in the
+// transaction see it, for reading. Paths outside the tree are their own.
+func (tx *Transaction) Resolve(path string) (string, error) {
+	rel, ok := tx.relative(path)
+	if !ok {
+		return path, nil
+	}
+	if tx.mode == TransactionShadow {
+		return filepath.Join(tx.upper, rel), nil
+	}
+	visible, err := tx.lookup(rel)
+	if err != nil {
+		return "", &os.PathError{Op: "lstat", Path: path, Err: err}
+	}
+	return visible, nil
+}
+
+// OpenFile opens a file as commands in the transaction see it. Files
+// outside the tree are opened as they are.
+func (tx *Transaction) OpenFile(path string, flag int, perm os.FileMode) (*os.File, error) {
+	rel, ok := tx.relative(path)
+	if !ok || tx.mode == TransactionShadow || flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		if resolved, err := tx.Resolve(path); err == nil {
+			path = resolved
+		} else if flag&os.O_CREATE == 0 {
+			return nil, err
+		}
+		return os.OpenFile(path, flag, perm)
+	}
+	target, err := tx.copyUp(rel, flag&os.O_TRUNC == 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return os.OpenFile(target, flag, perm)
+}
+
+// Stat returns the file info of a path as commands in the transaction see it
+func (tx *Transaction) Stat(path string) (os.FileInfo, error) {
+	resolved, err := tx.Resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(resolved)
+}
+
+// Lstat is Stat without following a final symlink
+func (tx *Transaction) Lstat(path string) (os.FileInfo, error) {
+	resolved, err := tx.Resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	return os.Lstat(resolved)
+}
+
+// ReadDir lists a directory as commands in the transaction see it, sorted
+// by name
+func (tx *Transaction) ReadDir(dir string) ([]os.DirEntry, error) {
+	resolved, err := tx.Resolve(dir)
+	if err != nil {
+		return nil, err
+	}
+	rel, ok := tx.relative(dir)
+	if !ok || tx.mode == TransactionShadow {
+		return os.ReadDir(resolved)
+	}
+
+	// Merge the upper directory into the lower one
+	upper := filepath.Join(tx.upper, rel)
+	lower := filepath.Join(tx.root, rel)
+	entries := make(map[string]os.DirEntry)
+	if tx.lowerVisible(rel) {
+		if lowerEntries, err := os.ReadDir(lower); err == nil {
+			for _, entry := range lowerEntries {
+				entries[entry.Name()] = entry
+			}
+		}
+	}
+	if upperEntries, err := os.ReadDir(upper); err == nil {
+		for _, entry := range upperEntries {
+			if info, err := entry.Info(); err == nil && isWhiteout(info) {
+				delete(entries, entry.Name())
+				continue
+			}
+			entries[entry.Name()] = entry
+		}
+	}
+	if len(entries) == 0 {
+		if _, err := os.Stat(resolved); err != nil {
+			return nil, err
+		}
+	}
+
+	merged := make([]os.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		merged = append(merged, entry)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Name() < merged[j].Name() })
+	return merged, nil
+}
+
+// changePath returns the file a change to path is made on directly, or ""
+// and the path relative to the tree for paths of the overlay view, whose
+// changes need copy-ups and whiteouts
+func (tx *Transaction) changePath(path string) (string, string) {
+	rel, ok := tx.relative(path)
+	switch {
+	case !ok:
+		return path, ""
+	case tx.mode == TransactionShadow:
+		return filepath.Join(tx.upper, rel), ""
+	}
+	return "", rel
+}
+
+// Mkdir creates a directory as commands in the transaction see it
+func (tx *Transaction) Mkdir(path string, perm os.FileMode) error {
+	file, rel := tx.changePath(path)
+	if file != "" {
+		return os.Mkdir(file, perm)
+	}
+	if _, err := tx.lookup(rel); err == nil {
+		return &os.PathError{Op: "mkdir", Path: path, Err: os.ErrExist}
+	}
+	upper, err := tx.copyUp(rel, false)
+	if err != nil {
+		return &os.PathError{Op: "mkdir", Path: path, Err: err}
+	}
+	if err := os.Mkdir(upper, perm); err != nil {
+		return err
+	}
+	// A directory where a deleted one was must hide what it held
+	if _, err := os.Lstat(filepath.Join(tx.root, rel)); err == nil {
+		return setOpaque(upper)
+	}
+	return nil
+}
+
+// RemoveAll removes a path and everything below it as commands in the
+// transaction see it. A missing path is not an error.
+func (tx *Transaction) RemoveAll(path string) error {
+	file, rel := tx.changePath(path)
+	if file != "" {
+		return os.RemoveAll(file)
+	}
+	if rel == "." {
+		return &os.PathError{Op: "remove", Path: path, Err: fmt.Errorf("the root of the transaction cannot be removed")}
+	}
+	if _, err := tx.lookup(rel); err != nil {
+		return nil
+	}
+	if err := os.RemoveAll(filepath.Join(tx.upper, rel)); err != nil {
+		return err
+	}
+	if _, err := tx.lookup(rel); err != nil {
+		return nil
+	}
+	// The file below still shows through: hide it
+	upper, err := tx.copyUp(rel, false)
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: path, Err: err}
+	}
+	return makeWhiteout(upper)
+}
+
+// Rename moves a path as commands in the transaction see it. The overlay
+// view has no rename, so its entries are copied and then removed.
+func (tx *Transaction) Rename(oldpath, newpath string) error {
+	oldFile, oldRel := tx.changePath(oldpath)
+	newFile, _ := tx.changePath(newpath)
+	if oldFile != "" && newFile != "" {
+		return os.Rename(oldFile, newFile)
+	}
+
+	fail := func(err error) error {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+	if oldRel == "." {
+		return fail(fmt.Errorf("the root of the transaction cannot be moved"))
+	}
+	info, err := tx.Lstat(oldpath)
+	if err != nil {
+		return fail(os.ErrNotExist)
+	}
+	if info.IsDir() && underPath(filepath.Clean(newpath), filepath.Clean(oldpath)) {
+		return fail(syscall.EINVAL)
+	}
+	if existing, err := tx.Lstat(newpath); err == nil {
+		if existing.IsDir() != info.IsDir() {
+			return fail(syscall.EEXIST)
+		}
+		if entries, err := tx.ReadDir(newpath); err == nil && len(entries) > 0 {
+			return fail(syscall.ENOTEMPTY)
+		}
+		if err := tx.RemoveAll(newpath); err != nil {
+			return fail(err)
+		}
+	}
+	if err := tx.copyView(oldpath, newpath); err != nil {
+		tx.RemoveAll(newpath)
+		return fail(err)
+	}
+	return tx.RemoveAll(oldpath)
+}
+
+// copyView copies a file, symlink or directory tree as commands in the
+// transaction see it
+func (tx *Transaction) copyView(source, target string) error {
+	info, err := tx.Lstat(source)
+	if err != nil {
+		return err
+	}
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		link, err := tx.Readlink(source)
+		if err != nil {
+			return err
+		}
+		return tx.Symlink(link, target)
+	case info.IsDir():
+		if err := tx.Mkdir(target, info.Mode().Perm()|0700); err != nil {
+			return err
+		}
+		entries, err := tx.ReadDir(source)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := tx.copyView(filepath.Join(source, entry.Name()), filepath.Join(target, entry.Name())); err != nil {
+				return err
+			}
+		}
+		return tx.Chmod(target, info.Mode().Perm())
+	case info.Mode().IsRegular():
+		in, err := tx.OpenFile(source, os.O_RDONLY, 0)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := tx.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		if err := out.Close(); err != nil {
+			return err
+		}
+		return tx.Chmod(target, info.Mode().Perm())
+	}
+	return nil
+}
+
+// Symlink creates a symlink as commands in the transaction see it
+func (tx *Transaction) Symlink(target, path string) error {
+	file, rel := tx.changePath(path)
+	if file != "" {
+		return os.Symlink(target, file)
+	}
+	if _, err := tx.lookup(rel); err == nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: path, Err: os.ErrExist}
+	}
+	upper, err := tx.copyUp(rel, false)
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: path, Err: err}
+	}
+	return os.Symlink(target, upper)
+}
+
+// Readlink returns the target of a symlink as commands in the transaction
+// see it
+func (tx *Transaction) Readlink(path string) (string, error) {
+	resolved, err := tx.Resolve(path)
+	if err != nil {
+		return "", err
+	}
+	return os.Readlink(resolved)
+}
+
+// Chmod changes the permissions of a path as commands in the transaction
+// see it, following symlinks
+func (tx *Transaction) Chmod(path string, mode os.FileMode) error {
+	for links := 0; links < maxSymlinks; links++ {
+		file, rel := tx.changePath(path)
+		if file != "" {
+			return os.Chmod(file, mode)
+		}
+		visible, err := tx.lookup(rel)
+		if err != nil {
+			return &os.PathError{Op: "chmod", Path: path, Err: err}
+		}
+		info, err := os.Lstat(visible)
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(visible)
+			if err != nil {
+				return err
+			}
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(path), target)
+			}
+			path = target
+			continue
+		}
+
+		upper := filepath.Join(tx.upper, rel)
+		if info.IsDir() && visible != upper {
+			// Copy the directory up without its content, which still
+			// shows through
+			if upper, err = tx.copyUp(rel, false); err == nil {
+				err = os.Mkdir(upper, info.Mode().Perm())
+			}
+		} else if !info.IsDir() {
+			upper, err = tx.copyUp(rel, true)
+		}
+		if err != nil {
+			return &os.PathError{Op: "chmod", Path: path, Err: err}
+		}
+		return os.Chmod(upper, mode)
+	}
+	return &os.PathError{Op: "chmod", Path: path, Err: syscall.ELOOP}
+}
+
+// lookup returns the file that holds a path of the overlay view: the upper
+// file if there is one, the lower file unless the path or a directory above
+// it was deleted or replaced
+func (tx *Transaction) lookup(rel string) (string, error) {
+	lowerVisible := true
+	parts := strings.Split(rel, string(filepath.Separator))
+	for i := range parts {
+		upper := filepath.Join(append([]string{tx.upper}, parts[:i+1]...)...)
+		info, err := os.Lstat(upper)
+		if err != nil {
+			continue
+		}
+		last := i == len(parts)-1
+		switch {
+		case isWhiteout(info):
+			return "", os.ErrNotExist
+		case !info.IsDir() && !last:
+			// A file where a directory would be
+			return "", os.ErrNotExist
+		case last:
+			return upper, nil
+		case isOpaque(upper):
+			// The directory replaced the one below
+			lowerVisible = false
+		}
+	}
+	if !lowerVisible {
+		return "", os.ErrNotExist
+	}
+	lower := filepath.Join(tx.root, rel)
+	if _, err := os.Lstat(lower); err != nil {
+		return "", os.ErrNotExist
+	}
+	return lower, nil
+}
+
+// lowerVisible reports whether the lower directory at rel shows through the
+// overlay view: no directory at or above it was deleted or replaced
+func (tx *Transaction) lowerVisible(rel string) bool {
+	parts := strings.Split(rel, string(filepath.Separator))
+	for i := range parts {
+		upper := filepath.Join(append([]string{tx.upper}, parts[:i+1]...)...)
+		info, err := os.Lstat(upper)
+		if err != nil {
+			continue
+		}
+		if !info.IsDir() || isOpaque(upper) {
+			return false
+		}
+	}
+	return true
+}
+
+// copyUp prepares the upper file for writing a path of the overlay view,
+// creating the directories above it and, if keep is set, copying the
+// current content
+func (tx *Transaction) copyUp(rel string, keep bool) (string, error) {
+	parts := strings.Split(rel, string(filepath.Separator))
+	for i := 0; i < len(parts)-1; i++ {
+		dirRel := filepath.Join(parts[:i+1]...)
+		upper := filepath.Join(tx.upper, dirRel)
+		info, err := os.Lstat(upper)
+		switch {
+		case err == nil && info.IsDir():
+			continue
+		case err == nil && isWhiteout(info):
+			return "", os.ErrNotExist
+		case err == nil:
+			return "", fmt.Errorf("%s is not a directory", dirRel)
+		}
+		visible, err := tx.lookup(dirRel)
+		if err != nil {
+			return "", err
+		}
+		mode := os.FileMode(0755)
+		if info, err := os.Stat(visible); err == nil {
+			if !info.IsDir() {
+				return "", fmt.Errorf("%s is not a directory", dirRel)
+			}
+			mode = info.Mode().Perm()
+		}
+		if err := os.Mkdir(upper, mode); err != nil {
+			return "", err
+		}
+		if err := os.Chmod(upper, mode); err != nil {
+			return "", err
+		}
+	}
+
+	upper := filepath.Join(tx.upper, rel)
+	info, err := os.Lstat(upper)
+	if err == nil {
+		if isWhiteout(info) {
+			return upper, os.Remove(upper)
+		}
+		return upper, nil
+	}
+	if !keep {
+		return upper, nil
+	}
+	if lower, err := tx.lookup(rel); err == nil {
+		if err := copyFile(lower, upper); err != nil {
+			return "", err
+		}
+	}
+	return upper, nil
+}
+
+// Changes returns what committing the transaction would change, sorted by
+// path
+func (tx *Transaction) Changes() ([]Change, error) {
+	var changes []Change
+	var err error
+	if tx.mode == TransactionShadow {
+		err = tx.diffShadow(&changes)
+	} else {
+		err = tx.diffUpper("", &changes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// diffUpper collects the changes recorded in the upper directory below rel
+func (tx *Transaction) diffUpper(rel string, changes *[]Change) error {
+	entries, err := os.ReadDir(filepath.Join(tx.upper, rel))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		entryRel := filepath.Join(rel, entry.Name())
+		upper := filepath.Join(tx.upper, entryRel)
+		real := filepath.Join(tx.root, entryRel)
+		info, err := os.Lstat(upper)
+		if err != nil {
+			return err
+		}
+		lowerInfo, lowerErr := os.Lstat(real)
+		inLower := lowerErr == nil
+
+		switch {
+		case isWhiteout(info):
+			if inLower {
+				*changes = append(*changes, Change{Path: real, Kind: ChangeDeleted, Dir: lowerInfo.IsDir()})
+			}
+		case info.IsDir():
+			if inLower && lowerInfo.IsDir() && !isOpaque(upper) {
+				if info.Mode() != lowerInfo.Mode() {
+					*changes = append(*changes, Change{Path: real, Kind: ChangeModified, Dir: true, source: upper})
+				}
+				if err := tx.diffUpper(entryRel, changes); err != nil {
+					return err
+				}
+				continue
+			}
+			if inLower {
+				*changes = append(*changes, Change{Path: real, Kind: ChangeDeleted, Dir: lowerInfo.IsDir()})
+			}
+			if err := addCreated(upper, real, changes); err != nil {
+				return err
+			}
+		default:
+			if !inLower {
+				*changes = append(*changes, Change{Path: real, Kind: ChangeCreated, source: upper})
+				continue
+			}
+			if lowerInfo.IsDir() {
+				*changes = append(*changes, Change{Path: real, Kind: ChangeDeleted, Dir: true})
+				*changes = append(*changes, Change{Path: real, Kind: ChangeCreated, source: upper})
+				continue
+			}
+			if same, err := sameFile(real, upper); err != nil {
+				return err
+			} else if !same {
+				*changes = append(*changes, Change{Path: real, Kind: ChangeModified, source: upper})
+			}
+		}
+	}
+	return nil
+}
+
+// diffShadow collects the changes commands made in the copy of a shadow
+// transaction since it was taken. Files that appear in the real tree in
+// the meantime are not changes of the transaction.
+func (tx *Transaction) diffShadow(changes *[]Change) error {
+	current, err := takeManifest(tx.upper, "", false)
+	if err != nil {
+		return err
+	}
+	for _, rel := range tx.shadowManifest.differences(current) {
+		if rel == "." {
+			continue
+		}
+		source := filepath.Join(tx.upper, rel)
+		target := filepath.Join(tx.root, rel)
+		before, existed := tx.shadowManifest[rel]
+		after, exists := current[rel]
+
+		switch {
+		case !exists:
+			// Only the top of a deleted directory tree is deleted
+			if _, parentExists := current[filepath.Dir(rel)]; parentExists {
+				*changes = append(*changes, Change{Path: target, Kind: ChangeDeleted, Dir: before.mode.IsDir()})
+			}
+		case !existed:
+			*changes = append(*changes, Change{Path: target, Kind: ChangeCreated, Dir: after.mode.IsDir(), source: source})
+		case before.mode.IsDir() != after.mode.IsDir():
+			*changes = append(*changes, Change{Path: target, Kind: ChangeDeleted, Dir: before.mode.IsDir()})
+			*changes = append(*changes, Change{Path: target, Kind: ChangeCreated, Dir: after.mode.IsDir(), source: source})
+		case after.mode.IsDir():
+			*changes = append(*changes, Change{Path: target, Kind: ChangeModified, Dir: true, source: source})
+		default:
+			// A file written with the same content is not a change
+			if same, err := sameFile(target, source); err != nil || !same {
+				*changes = append(*changes, Change{Path: target, Kind: ChangeModified, source: source})
+			}
+		}
+	}
+	return nil
+}
+
+// realChanges returns the paths of the real tree of a shadow transaction
+// that changed since the copy was taken, relative to its root
+func (tx *Transaction) realChanges() ([]string, error) {
+	current, err := takeManifest(tx.root, tx.temp, false)
+	if err != nil {
+		return nil, err
+	}
+	return tx.rootManifest.differences(current), nil
+}
+
+// addCreated records a new file, or a new directory and everything in it
+func addCreated(source, target string, changes *[]Change) error {
+	info, err := os.Lstat(source)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		*changes = append(*changes, Change{Path: target, Kind: ChangeCreated, source: source})
+		return nil
+	}
+	*changes = append(*changes, Change{Path: target, Kind: ChangeCreated, Dir: true, source: source})
+	entries, err := os.ReadDir(source)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		child := filepath.Join(source, entry.Name())
+		if childInfo, err := os.Lstat(child); err == nil && isWhiteout(childInfo) {
+			continue
+		}
+		if err := addCreated(child, filepath.Join(target, entry.Name()), changes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sameFile reports whether two files have the same type, permissions and
+// content
+func sameFile(a, b string) (bool, error) {
+	infoA, err := os.Lstat(a)
+	if err != nil {
+		return false, err
+	}
+	infoB, err := os.Lstat(b)
+	if err != nil {
+		return false, err
+	}
+	if infoA.Mode() != infoB.Mode() {
+		return false, nil
+	}
+	if infoA.Mode()&os.ModeSymlink != 0 {
+		targetA, errA := os.Readlink(a)
+		targetB, errB := os.Readlink(b)
+		return errA == nil && errB == nil && targetA == targetB, nil
+	}
+	if !infoA.Mode().IsRegular() {
+		return true, nil
+	}
+	if infoA.Size() != infoB.Size() {
+		return false, nil
+	}
+	contentA, err := os.ReadFile(a)
+	if err != nil {
+		return false, err
+	}
+	contentB, err := os.ReadFile(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(contentA, contentB), nil
+}
+
+// Commit applies the changes of the transaction to the real tree and
+// returns them. Deletions are applied first, then new and modified files
+// from the top of the tree down. A shadow transaction is not committed if
+// the real tree changed while it ran, e.g. through absolute paths.
+func (tx *Transaction) Commit() ([]Change, error) {
+	if tx.mode == TransactionShadow {
+		changed, err := tx.realChanges()
+		if err != nil {
+			return nil, err
+		}
+		if len(changed) > 0 {
+			return nil, fmt.Errorf("%s changed outside the transaction (%s)", tx.root, strings.Join(changed, ", "))
+		}
+	}
+	changes, err := tx.Changes()
+	if err != nil {
+		return nil, err
+	}
+	for _, change := range changes {
+		if change.Kind == ChangeDeleted {
+			if err := os.RemoveAll(change.Path); err != nil {
+				return changes, fmt.Errorf("failed to delete %s: %v", change.Path, err)
+			}
+		}
+	}
+	for _, change := range changes {
+		if change.Kind == ChangeDeleted {
+			continue
+		}
+		if err := applyChange(change); err != nil {
+			return changes, fmt.Errorf("failed to apply %s: %v", change.Path, err)
+		}
+	}
+	return changes, nil
+}
+
+// applyChange writes a new or modified file or directory
+func applyChange(change Change) error {
+	info, err := os.Lstat(change.source)
+	if err != nil {
+		return err
+	}
+	if change.Dir {
+		if err := os.MkdirAll(change.Path, info.Mode().Perm()); err != nil {
+			return err
+		}
+		return os.Chmod(change.Path, info.Mode().Perm())
+	}
+	if err := os.MkdirAll(filepath.Dir(change.Path), 0755); err != nil {
+		return err
+	}
+
+	// Replace the file in one step so it is never seen half written
+	staging := filepath.Join(filepath.Dir(change.Path), ".shode-tx-"+filepath.Base(change.Path))
+	os.Remove(staging)
+	if err := copyEntry(change.source, staging, info); err != nil {
+		os.Remove(staging)
+		return err
+	}
+	if err := os.Rename(staging, change.Path); err != nil {
+		os.Remove(staging)
+		return err
+	}
+	return nil
+}
+
+// Discard throws the transaction's changes away
+func (tx *Transaction) Discard() error {
+	return os.RemoveAll(tx.temp)
+}
+
+// copyTree copies a directory tree with its files, directories and
+// symlinks, leaving out the tree at skip
+func copyTree(source, target, skip string) error {
+	if source == skip {
+		return nil
+	}
+	info, err := os.Lstat(source)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return copyEntry(source, target, info)
+	}
+	if err := os.Mkdir(target, info.Mode().Perm()|0700); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(source)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := copyTree(filepath.Join(source, entry.Name()), filepath.Join(target, entry.Name()), skip); err != nil {
+			return err
+		}
+	}
+	return os.Chmod(target, info.Mode().Perm())
+}
+
+// copyEntry copies a regular file or symlink; other file types are skipped
+func copyEntry(source, target string, info os.FileInfo) error {
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		link, err := os.Readlink(source)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(link, target)
+	case info.Mode().IsRegular():
+		return copyFile(source, target)
+	}
+	return nil
+}
+
+// copyFile copies the content and permissions of a regular file
+func copyFile(source, target string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(target, info.Mode().Perm())
+}
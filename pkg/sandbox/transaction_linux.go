@@ -0,0 +1,138 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// Capability and securebits interfaces not covered by the syscall package
+const (
+	prCapBSetDrop    = 24
+	prSetSecureBits  = 28
+	prCapAmbient     = 47
+	capAmbientClear  = 4
+	secBitNoRoot     = 1 << 0
+	secBitNoRootLock = 1 << 1
+
+	// opaqueXattr marks an upper directory that replaces the one below
+	opaqueXattr = "user.overlay.opaque"
+)
+
+// probeOverlay mounts the overlay of a transaction in a helper process to
+// check that the kernel allows it without privileges
+func probeOverlay(tx *Transaction) error {
+	helper, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("cannot locate sandbox helper: %v", err)
+	}
+	data, err := json.Marshal(helperConfig{Overlay: tx.overlayMount(), Probe: true})
+	if err != nil {
+		return err
+	}
+
+	command := exec.Command(helper)
+	command.Env = append(withoutEnv(os.Environ(), enforceEnv), enforceEnv+"="+string(data))
+	command.SysProcAttr = &syscall.SysProcAttr{}
+	setNamespaces(command.SysProcAttr, EnforcementPolicy{}, false, true)
+	var stderr bytes.Buffer
+	command.Stderr = &stderr
+	if err := command.Run(); err != nil {
+		if message := strings.TrimSpace(strings.TrimPrefix(stderr.String(), "shode: sandbox: ")); message != "" {
+			return fmt.Errorf("%s", message)
+		}
+		return err
+	}
+	return nil
+}
+
+// mountOverlay mounts a transaction's overlay over its tree. The helper
+// runs in its own user and mount namespace, so only the command sees it.
+func mountOverlay(overlay *overlayMount) error {
+	// Keep the mount from propagating to other namespaces
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("failed to make mounts private: %v", err)
+	}
+	options := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s,userxattr",
+		overlayPath(overlay.Lower), overlayPath(overlay.Upper), overlayPath(overlay.Work))
+	if err := syscall.Mount("overlay", overlay.Lower, "overlay", 0, options); err != nil {
+		return fmt.Errorf("failed to mount overlay on %s: %v", overlay.Lower, err)
+	}
+	return nil
+}
+
+// overlayPath escapes the characters that separate overlay mount options
+func overlayPath(path string) string {
+	return strings.NewReplacer(`\`, `\\`, `,`, `\,`, `:`, `\:`).Replace(path)
+}
+
+// dropCapabilities keeps the command from gaining capabilities in the user
+// namespace, which would let it unmount the overlay and write to the tree
+// below
+func dropCapabilities() error {
+	for capability := 0; capability <= lastCapability(); capability++ {
+		if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prCapBSetDrop, uintptr(capability), 0, 0, 0, 0); errno != 0 && errno != syscall.EINVAL {
+			return fmt.Errorf("failed to drop capability %d: %v", capability, errno)
+		}
+	}
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prCapAmbient, capAmbientClear, 0, 0, 0, 0); errno != 0 && errno != syscall.EINVAL {
+		return fmt.Errorf("failed to clear ambient capabilities: %v", errno)
+	}
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetSecureBits, secBitNoRoot|secBitNoRootLock, 0, 0, 0, 0); errno != 0 {
+		return fmt.Errorf("failed to set securebits: %v", errno)
+	}
+	return nil
+}
+
+// lastCapability returns the highest capability the kernel knows
+func lastCapability() int {
+	data, err := os.ReadFile("/proc/sys/kernel/cap_last_cap")
+	if err != nil {
+		return 40
+	}
+	var last int
+	if _, err := fmt.Sscanf(string(data), "%d", &last); err != nil {
+		return 40
+	}
+	return last
+}
+
+// isWhiteout reports whether an upper file marks a deleted file: a
+// character device with device number 0
+func isWhiteout(info os.FileInfo) bool {
+	if info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && stat.Rdev == 0
+}
+
+// isOpaque reports whether an upper directory replaces the one below
+func isOpaque(path string) bool {
+	value := make([]byte, 1)
+	n, err := syscall.Getxattr(path, opaqueXattr, value)
+	return err == nil && n == 1 && value[0] == 'y'
+}
+
+// makeWhiteout creates an upper file that marks a deleted file. Since
+// Linux 5.8 this needs no privileges.
+func makeWhiteout(path string) error {
+	if err := syscall.Mknod(path, syscall.S_IFCHR, 0); err != nil {
+		return &os.PathError{Op: "mknod", Path: path, Err: err}
+	}
+	return nil
+}
+
+// setOpaque marks an upper directory as replacing the one below
+func setOpaque(path string) error {
+	if err := syscall.Setxattr(path, opaqueXattr, []byte("y"), 0); err != nil {
+		return &os.PathError{Op: "setxattr", Path: path, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"fmt"
+	"os"
+)
+
+// probeOverlay fails: overlay transactions need Linux
+func probeOverlay(tx *Transaction) error {
+	return fmt.Errorf("overlayfs is only supported on Linux")
+}
+
+// isWhiteout reports whether an upper file marks a deleted file; there are
+// none without overlayfs
+func isWhiteout(info os.FileInfo) bool {
+	return false
+}
+
+// isOpaque reports whether an upper directory replaces the one below;
+// there are none without overlayfs
+func isOpaque(path string) bool {
+	return false
+}
+
+// makeWhiteout fails: there are no overlay transactions without overlayfs
+func makeWhiteout(path string) error {
+	return fmt.Errorf("overlayfs is only supported on Linux")
+}
+
+// setOpaque fails: there are no overlay transactions without overlayfs
+func setOpaque(path string) error {
+	return fmt.Errorf("overlayfs is only supported on Linux")
+}
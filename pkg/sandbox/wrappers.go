@@ -0,0 +1,316 @@
+package sandbox
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"gitee.com/com_818cloud/shode/pkg/parser"
+	"gitee.com/com_818cloud/shode/pkg/types"
+)
+
+// maxWrapperDepth bounds how deeply wrapped commands are unwrapped
+const maxWrapperDepth = 16
+
+// wrapperSpec describes how a wrapper command finds the command it runs
+type wrapperSpec struct {
+	valueOptions []string // short and long options that take a value
+	assignments  bool     // NAME=VALUE words may precede the command
+	operands     int      // operands before the command, e.g. the duration of timeout
+	defaultCmd   string   // command run when none is given, e.g. echo for xargs
+	queryOptions []string // options with which the command is not run
+}
+
+// wrappers are commands that run another command given as their arguments
+var wrappers = map[string]wrapperSpec{
+	"env":     {valueOptions: []string{"-u", "--unset", "-C", "--chdir", "-S", "--split-string"}, assignments: true},
+	"sudo":    {valueOptions: []string{"-u", "--user", "-g", "--group", "-C", "--close-from", "-D", "--chdir", "-h", "--host", "-p", "--prompt", "-r", "--role", "-t", "--type", "-U", "--other-user", "-T", "--command-timeout"}, assignments: true},
+	"doas":    {valueOptions: []string{"-u", "-C"}},
+	"nice":    {valueOptions: []string{"-n", "--adjustment"}},
+	"ionice":  {valueOptions: []string{"-c", "--class", "-n", "--classdata", "-p", "--pid", "-P", "--pgid", "-u", "--uid"}},
+	"nohup":   {},
+	"setsid":  {},
+	"time":    {valueOptions: []string{"-f", "--format", "-o", "--output"}},
+	"stdbuf":  {valueOptions: []string{"-i", "--input", "-o", "--output", "-e", "--error"}},
+	"timeout": {valueOptions: []string{"-s", "--signal", "-k", "--kill-after"}, operands: 1},
+	"xargs":   {valueOptions: []string{"-a", "--arg-file", "-d", "--delimiter", "-E", "-I", "-L", "--max-lines", "-n", "--max-args", "-P", "--max-procs", "-s", "--max-chars", "--process-slot-var"}, defaultCmd: "echo"},
+	"command": {queryOptions: []string{"-v", "-V"}},
+	"exec":    {valueOptions: []string{"-a"}},
+	"busybox": {},
+}
+
+// shells are interpreters whose -c argument is a script
+var shells = map[string]bool{
+	"sh": true, "bash": true, "dash": true, "zsh": true, "ksh": true, "ash": true, "mksh": true,
+}
+
+// shellBuiltins run inside a shell without executing a binary
+var shellBuiltins = map[string]bool{
+	"echo": true, "printf": true, "read": true, "cd": true, "pwd": true, "test": true, "[": true,
+	"true": true, "false": true, "export": true, "set": true, "unset": true, "shift": true,
+	"exit": true, "return": true, "local": true, "declare": true, ":": true,
+}
+
+// identities returns the names a command is known by: the base name as
+// written and, when it resolves to an executable, the base name of the
+// binary with symlinks followed. /bin/rm, ./rm and a symlink to rm are all
+// identified as rm. Names are looked up as commandPath does in ctx.
+func identities(name string, ctx *CheckContext) []string {
+	base := strings.ToLower(filepath.Base(name))
+	names := []string{base}
+
+	path := commandPath(name, ctx)
+	if path == "" {
+		return names
+	}
+	canonical, err := canonicalExecutable(path)
+	if err != nil {
+		return names
+	}
+	if target := strings.ToLower(filepath.Base(canonical)); target != base {
+		names = append(names, target)
+	}
+	return names
+}
+
+// wrappedCommands returns the commands a wrapper or shell runs, or nil if
+// the command runs nothing else. inShell reports whether the commands run
+// inside a shell script rather than being executed directly.
+func wrappedCommands(names []string, args []string) (commands []*types.CommandNode, inShell bool, err error) {
+	for _, name := range names {
+		if shells[name] || name == "eval" {
+			return shellCommands(name, args)
+		}
+		if name == "find" {
+			return findCommands(args), false, nil
+		}
+		if name == "unlink" || name == "shred" {
+			return []*types.CommandNode{deletion(name, args)}, false, nil
+		}
+		if spec, ok := wrappers[name]; ok {
+			return spec.unwrap(name, args), false, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// shredValueOptions are the options of shred that take a value
+var shredValueOptions = map[string]bool{"-n": true, "-s": true, "--iterations": true, "--size": true, "--random-source": true}
+
+// deletion returns the rm command that unlink or shred amounts to, with
+// the files they delete, so the rules for rm apply to them
+func deletion(name string, args []string) *types.CommandNode {
+	var files []string
+	options := true
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case options && arg == "--":
+			options = false
+		case options && name == "shred" && shredValueOptions[arg]:
+			i++
+		case options && strings.HasPrefix(arg, "-") && len(arg) > 1:
+		default:
+			files = append(files, arg)
+		}
+	}
+	return &types.CommandNode{Name: "rm", Args: files}
+}
+
+// unwrap skips a wrapper's options, assignments and operands and returns
+// the command it runs
+func (spec wrapperSpec) unwrap(name string, args []string) []*types.CommandNode {
+	operands := spec.operands
+	options := true
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if options && arg == "--" {
+			options = false
+			continue
+		}
+		if options && strings.HasPrefix(arg, "-") && len(arg) > 1 {
+			if spec.runsNothing(arg) {
+				return nil
+			}
+			if name == "env" && (arg == "-S" || strings.HasPrefix(arg, "--split-string")) {
+				// env -S splits one argument into the command line
+				split := strings.TrimPrefix(arg, "--split-string=")
+				if split == arg {
+					if i+1 >= len(args) {
+						return nil
+					}
+					i++
+					split = args[i]
+				}
+				return spec.unwrap(name, append(strings.Fields(split), args[i+1:]...))
+			}
+			if spec.takesValue(arg) {
+				i++
+			}
+			continue
+		}
+		if spec.assignments && isAssignmentWord(arg) {
+			continue
+		}
+		if operands > 0 {
+			operands--
+			continue
+		}
+		return []*types.CommandNode{{Name: arg, Args: args[i+1:]}}
+	}
+	if spec.defaultCmd != "" {
+		return []*types.CommandNode{{Name: spec.defaultCmd}}
+	}
+	return nil
+}
+
+// runsNothing reports whether an option makes the wrapper only report on
+// the command, as command -v does
+func (spec wrapperSpec) runsNothing(arg string) bool {
+	for _, option := range spec.queryOptions {
+		if arg == option {
+			return true
+		}
+	}
+	return false
+}
+
+// takesValue reports whether an option consumes the following argument
+func (spec wrapperSpec) takesValue(arg string) bool {
+	if strings.Contains(arg, "=") {
+		return false
+	}
+	for _, option := range spec.valueOptions {
+		if arg == option {
+			return true
+		}
+	}
+	return false
+}
+
+// isAssignmentWord reports whether arg is a NAME=VALUE environment
+// assignment
+func isAssignmentWord(arg string) bool {
+	eq := strings.Index(arg, "=")
+	if eq <= 0 {
+		return false
+	}
+	for i, r := range arg[:eq] {
+		if r != '_' && !(r >= 'a' && r <= 'z') && !(r >= 'A' && r <= 'Z') && !(i > 0 && r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// shellCommands parses the script of sh -c, bash -c or eval and returns
+// the commands it runs
+func shellCommands(name string, args []string) ([]*types.CommandNode, bool, error) {
+	script, ok := shellScript(name, args)
+	if !ok {
+		// A script file or stdin; its contents are not known here
+		return nil, true, nil
+	}
+
+	parsed, err := parser.NewSimpleParser().ParseString(script)
+	if err != nil {
+		return nil, true, fmt.Errorf("security violation: cannot analyze script of '%s': %v", name, err)
+	}
+	var commands []*types.CommandNode
+	collectCommands(parsed, &commands)
+	return commands, true, nil
+}
+
+// shellScript returns the script a shell runs with -c, or the code eval
+// runs. It reports false if the shell reads a script file or stdin.
+func shellScript(name string, args []string) (string, bool) {
+	if name == "eval" {
+		return strings.Join(args, " "), true
+	}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || !strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "+") {
+			break
+		}
+		if arg == "-o" || arg == "+o" || arg == "-O" || arg == "+O" {
+			// Named options such as -o pipefail take the next argument
+			i++
+			continue
+		}
+		// -c may be combined with other flags, as in -ec
+		if !strings.HasPrefix(arg, "--") && strings.Contains(arg, "c") {
+			if i+1 >= len(args) {
+				return "", false
+			}
+			return args[i+1], true
+		}
+	}
+	return "", false
+}
+
+// collectCommands gathers the commands of a parsed script
+func collectCommands(node types.Node, commands *[]*types.CommandNode) {
+	switch n := node.(type) {
+	case *types.CommandNode:
+		*commands = append(*commands, n)
+	case *types.PipeNode:
+		collectCommands(n.Left, commands)
+		collectCommands(n.Right, commands)
+	case *types.ScriptNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			collectCommands(child, commands)
+		}
+	case *types.IfNode:
+		collectCommands(n.Condition, commands)
+		collectCommands(n.Then, commands)
+		if n.Else != nil {
+			collectCommands(n.Else, commands)
+		}
+	case *types.ForNode:
+		collectCommands(n.Body, commands)
+	case *types.WhileNode:
+		collectCommands(n.Condition, commands)
+		collectCommands(n.Body, commands)
+	case *types.FunctionNode:
+		collectCommands(n.Body, commands)
+	}
+}
+
+// checkWrapped checks the commands run by a wrapper or shell as if they
+// were run directly
+func (sc *SecurityChecker) checkWrapped(cmd *types.CommandNode, names []string, ctx *CheckContext, depth int) error {
+	wrapped, inShell, err := wrappedCommands(names, cmd.Args)
+	if err != nil {
+		return err
+	}
+	if len(wrapped) == 0 {
+		return nil
+	}
+	if depth >= maxWrapperDepth {
+		return fmt.Errorf("security violation: commands wrapped by '%s' are nested too deeply", cmd.Name)
+	}
+
+	for _, inner := range wrapped {
+		inner.Pos = cmd.Pos
+		if err := sc.checkCommand(inner, ctx, depth+1); err != nil {
+			return fmt.Errorf("%v (run by '%s')", err, cmd.Name)
+		}
+
+		// The allowlist applies to wrapped binaries too; shell builtins run
+		// no binary
+		if sc.mode != ModeAllowlist || (inShell && shellBuiltins[inner.Name]) {
+			continue
+		}
+		path := commandPath(inner.Name, ctx)
+		if path == "" {
+			continue
+		}
+		if err := sc.checkExecutable(inner.Name, path); err != nil {
+			return fmt.Errorf("%v (run by '%s')", err, cmd.Name)
+		}
+	}
+	return nil
+}
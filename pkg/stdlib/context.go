@@ -0,0 +1,102 @@
+package stdlib
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// Environment holds the variables and working directory functions see,
+// e.g. an environment.EnvironmentManager
+type Environment interface {
+	GetEnv(key string) string
+	SetEnv(key, value string)
+	GetWorkingDir() string
+	// ChangeDir makes dir, an absolute path to an existing directory, the
+	// working directory
+	ChangeDir(dir string) error
+}
+
+// Context is what the functions of a standard library run in. Fields left
+// nil are the process's: its environment and working directory, its file
+// system and its standard streams. An engine binds each call to its own
+// context, so several engines can run in one process.
+type Context struct {
+	Env    Environment
+	Files  FileSystem // relative paths resolve against Env when nil
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Dial makes the connections of the HTTP functions unless their
+	// options set one, e.g. to apply a network policy
+	Dial func(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// WithContext returns a standard library whose functions run in ctx
+func (sl *StdLib) WithContext(ctx Context) *StdLib {
+	copied := *sl
+	copied.ctx = ctx
+	return &copied
+}
+
+// env returns the environment of the functions
+func (sl *StdLib) env() Environment {
+	if sl.ctx.Env == nil {
+		return processEnvironment{}
+	}
+	return sl.ctx.Env
+}
+
+// files returns the file system of the functions
+func (sl *StdLib) files() FileSystem {
+	if sl.ctx.Files == nil {
+		return osFileSystem{env: sl.ctx.Env}
+	}
+	return sl.ctx.Files
+}
+
+// stdin returns the standard input of the functions
+func (sl *StdLib) stdin() io.Reader {
+	if sl.ctx.Stdin == nil {
+		return os.Stdin
+	}
+	return sl.ctx.Stdin
+}
+
+// stdout returns the standard output of the functions
+func (sl *StdLib) stdout() io.Writer {
+	if sl.ctx.Stdout == nil {
+		return os.Stdout
+	}
+	return sl.ctx.Stdout
+}
+
+// stderr returns the standard error of the functions
+func (sl *StdLib) stderr() io.Writer {
+	if sl.ctx.Stderr == nil {
+		return os.Stderr
+	}
+	return sl.ctx.Stderr
+}
+
+// path resolves a path against the working directory of the functions
+func (sl *StdLib) path(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(sl.env().GetWorkingDir(), name)
+}
+
+// processEnvironment is the environment of the process
+type processEnvironment struct{}
+
+func (processEnvironment) GetEnv(key string) string   { return os.Getenv(key) }
+func (processEnvironment) SetEnv(key, value string)   { os.Setenv(key, value) }
+func (processEnvironment) ChangeDir(dir string) error { return os.Chdir(dir) }
+func (processEnvironment) GetWorkingDir() string {
+	dir, _ := os.Getwd()
+	return dir
+}
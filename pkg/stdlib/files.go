@@ -0,0 +1,677 @@
+package stdlib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// FileSystem is what the file functions work on. Relative paths are
+// resolved by the file system, so a script's working directory or a
+// copy-on-write view can stand in for the process's.
+type FileSystem interface {
+	OpenFile(name string, flag int, perm os.FileMode) (*os.File, error)
+	Stat(name string) (os.FileInfo, error)
+	Lstat(name string) (os.FileInfo, error)
+	ReadDir(name string) ([]os.DirEntry, error)
+	Mkdir(name string, perm os.FileMode) error
+	RemoveAll(name string) error
+	Rename(oldpath, newpath string) error
+	Symlink(target, name string) error
+	Readlink(name string) (string, error)
+	Chmod(name string, mode os.FileMode) error
+}
+
+// osFileSystem is the file system of the process, with relative paths
+// resolved against the working directory of env if set
+type osFileSystem struct {
+	env Environment
+}
+
+// path resolves a relative path against the working directory
+func (fs osFileSystem) path(name string) string {
+	if fs.env == nil || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(fs.env.GetWorkingDir(), name)
+}
+
+func (fs osFileSystem) OpenFile(name string, flag int, perm os.FileMode) (*os.File, error) {
+	return os.OpenFile(fs.path(name), flag, perm)
+}
+
+func (fs osFileSystem) Stat(name string) (os.FileInfo, error)  { return os.Stat(fs.path(name)) }
+func (fs osFileSystem) Lstat(name string) (os.FileInfo, error) { return os.Lstat(fs.path(name)) }
+func (fs osFileSystem) ReadDir(name string) ([]os.DirEntry, error) {
+	return os.ReadDir(fs.path(name))
+}
+func (fs osFileSystem) Mkdir(name string, perm os.FileMode) error {
+	return os.Mkdir(fs.path(name), perm)
+}
+func (fs osFileSystem) RemoveAll(name string) error { return os.RemoveAll(fs.path(name)) }
+func (fs osFileSystem) Rename(oldpath, newpath string) error {
+	return os.Rename(fs.path(oldpath), fs.path(newpath))
+}
+func (fs osFileSystem) Symlink(target, name string) error    { return os.Symlink(target, fs.path(name)) }
+func (fs osFileSystem) Readlink(name string) (string, error) { return os.Readlink(fs.path(name)) }
+func (fs osFileSystem) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(fs.path(name), mode)
+}
+
+// FileInfo describes a file, as printed by Stat
+type FileInfo struct {
+	Name    string    `json:"name"`
+	Type    string    `json:"type"` // "file", "dir", "symlink" or "other"
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"` // permissions in octal, e.g. "0644"
+	ModTime time.Time `json:"modTime"`
+	Target  string    `json:"target,omitempty"` // of a symlink
+}
+
+// String formats the file info as JSON
+func (fi *FileInfo) String() string {
+	data, _ := json.Marshal(fi)
+	return string(data)
+}
+
+// WalkOptions filters the paths Walk returns
+type WalkOptions struct {
+	Name     string // glob the base name must match, e.g. "*.go"
+	Type     string // "f" for files, "d" for directories, "l" for symlinks; any if empty
+	MaxDepth int    // levels below the root to descend; no limit if 0
+}
+
+// AppendFile appends content to a file, creating it (replaces echo >> file)
+func (sl *StdLib) AppendFile(filename, content string) error {
+	file, err := sl.files().OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %v", filename, err)
+	}
+	_, err = file.WriteString(content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write file %s: %v", filename, err)
+	}
+	return nil
+}
+
+// AtomicWrite replaces the content of a file in one step by writing a
+// temporary file next to it and renaming it, so readers never see it half
+// written. An existing file keeps its permissions; a new one gets 0644.
+func (sl *StdLib) AtomicWrite(filename, content string) error {
+	fs := sl.files()
+	perm := os.FileMode(0644)
+	if info, err := fs.Stat(filename); err == nil {
+		if info.IsDir() {
+			return fmt.Errorf("failed to write file %s: is a directory", filename)
+		}
+		perm = info.Mode().Perm()
+	}
+
+	temp, file, err := createTemp(fs, filepath.Dir(filename), "."+filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write file %s: %v", filename, err)
+	}
+	_, err = file.WriteString(content)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = fs.Chmod(temp, perm)
+	}
+	if err == nil {
+		err = fs.Rename(temp, filename)
+	}
+	if err != nil {
+		fs.RemoveAll(temp)
+		return fmt.Errorf("failed to write file %s: %v", filename, err)
+	}
+	return nil
+}
+
+// CopyFile copies a file with its permissions (replaces cp). A directory
+// as the destination receives the file under its own name.
+func (sl *StdLib) CopyFile(src, dst string) error {
+	fs := sl.files()
+	info, err := fs.Stat(src)
+	if err != nil {
+		return fmt.Errorf("failed to copy %s: %v", src, err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("failed to copy %s: is a directory (use CopyDir)", src)
+	}
+	dst = intoDir(fs, src, dst)
+	if sameFile(fs, src, dst) {
+		return fmt.Errorf("failed to copy %s: %s is the same file", src, dst)
+	}
+	if err := copyRegular(fs, src, dst, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %v", src, dst, err)
+	}
+	return nil
+}
+
+// CopyDir copies a directory tree with its files, symlinks and permissions
+// (replaces cp -r). An existing destination directory receives the tree
+// under its own name.
+func (sl *StdLib) CopyDir(src, dst string) error {
+	fs := sl.files()
+	info, err := fs.Stat(src)
+	if err != nil {
+		return fmt.Errorf("failed to copy %s: %v", src, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("failed to copy %s: not a directory (use CopyFile)", src)
+	}
+	dst = intoDir(fs, src, dst)
+	if within(dst, src) {
+		return fmt.Errorf("failed to copy %s: cannot copy a directory into itself", src)
+	}
+	if err := copyTree(fs, src, dst); err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %v", src, dst, err)
+	}
+	return nil
+}
+
+// Move moves or renames a file or directory (replaces mv). A directory as
+// the destination receives it under its own name. Between file systems the
+// source is copied, then removed.
+func (sl *StdLib) Move(src, dst string) error {
+	fs := sl.files()
+	info, err := fs.Lstat(src)
+	if err != nil {
+		return fmt.Errorf("failed to move %s: %v", src, err)
+	}
+	dst = intoDir(fs, src, dst)
+	if info.IsDir() && within(dst, src) {
+		return fmt.Errorf("failed to move %s: cannot move a directory into itself", src)
+	}
+	err = fs.Rename(src, dst)
+	if errors.Is(err, syscall.EXDEV) {
+		if err = copyTree(fs, src, dst); err == nil {
+			err = fs.RemoveAll(src)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to move %s to %s: %v", src, dst, err)
+	}
+	return nil
+}
+
+// Remove removes a file, or with recursive a directory and everything in
+// it (replaces rm and rm -r). The root directory, . and .. are refused.
+func (sl *StdLib) Remove(path string, recursive bool) error {
+	fs := sl.files()
+	if base := filepath.Base(path); filepath.Clean(path) == "/" || base == "." || base == ".." {
+		return fmt.Errorf("refusing to remove %s", path)
+	}
+	info, err := fs.Lstat(path)
+	if err != nil {
+		return fmt.Errorf("failed to remove %s: %v", path, err)
+	}
+	if info.IsDir() && !recursive {
+		return fmt.Errorf("failed to remove %s: is a directory (remove it recursively)", path)
+	}
+	if err := fs.RemoveAll(path); err != nil {
+		return fmt.Errorf("failed to remove %s: %v", path, err)
+	}
+	return nil
+}
+
+// MkdirAll creates a directory and the missing ones above it (replaces
+// mkdir -p)
+func (sl *StdLib) MkdirAll(path string, perm os.FileMode) error {
+	if err := mkdirAll(sl.files(), filepath.Clean(path), perm); err != nil {
+		return fmt.Errorf("failed to create directory %s: %v", path, err)
+	}
+	return nil
+}
+
+// Chmod changes the permissions of a file (replaces chmod). The mode is
+// octal, e.g. 755, or symbolic, e.g. u+x,go-w.
+func (sl *StdLib) Chmod(path, mode string) error {
+	fs := sl.files()
+	info, err := fs.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to change mode of %s: %v", path, err)
+	}
+	perm, err := ParseMode(mode, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if err := fs.Chmod(path, perm); err != nil {
+		return fmt.Errorf("failed to change mode of %s: %v", path, err)
+	}
+	return nil
+}
+
+// Stat describes a file without following a final symlink (replaces stat)
+func (sl *StdLib) Stat(path string) (*FileInfo, error) {
+	fs := sl.files()
+	info, err := fs.Lstat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %v", path, err)
+	}
+	fileInfo := &FileInfo{
+		Name:    info.Name(),
+		Type:    "other",
+		Size:    info.Size(),
+		Mode:    fmt.Sprintf("%04o", info.Mode().Perm()),
+		ModTime: info.ModTime(),
+	}
+	switch {
+	case info.Mode().IsRegular():
+		fileInfo.Type = "file"
+	case info.IsDir():
+		fileInfo.Type = "dir"
+	case info.Mode()&os.ModeSymlink != 0:
+		fileInfo.Type = "symlink"
+		fileInfo.Target, _ = fs.Readlink(path)
+	}
+	return fileInfo, nil
+}
+
+// Glob returns the paths matching a pattern, sorted (replaces ls with
+// wildcards). ** matches any number of directories. As in a shell, names
+// starting with a dot only match a pattern that starts with one.
+func (sl *StdLib) Glob(pattern string) ([]string, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %s: %v", pattern, err)
+	}
+	base, prefix := ".", ""
+	if filepath.IsAbs(pattern) {
+		base, prefix = "/", "/"
+		pattern = strings.TrimLeft(pattern, "/")
+	}
+	var matches []string
+	globSegments(sl.files(), base, prefix, strings.Split(pattern, "/"), &matches)
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// Walk returns the root and the paths below it that match the options,
+// in lexical order (replaces find). Symlinks are not followed.
+func (sl *StdLib) Walk(root string, options WalkOptions) ([]string, error) {
+	if options.Name != "" {
+		if _, err := filepath.Match(options.Name, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %v", options.Name, err)
+		}
+	}
+	switch options.Type {
+	case "", "f", "d", "l":
+	default:
+		return nil, fmt.Errorf("invalid type %q, expected f, d or l", options.Type)
+	}
+
+	fs := sl.files()
+	info, err := fs.Lstat(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk %s: %v", root, err)
+	}
+	var paths []string
+	if err := walk(fs, root, info.Mode(), 0, options, &paths); err != nil {
+		return paths, fmt.Errorf("failed to walk %s: %v", root, err)
+	}
+	return paths, nil
+}
+
+// TempFile creates an empty file in the temporary directory and returns
+// its path (replaces mktemp). A * in the pattern is replaced by a random
+// string.
+func (sl *StdLib) TempFile(pattern string) (string, error) {
+	name, file, err := createTemp(sl.files(), sl.tempDir(), pattern)
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	file.Close()
+	return name, nil
+}
+
+// TempDir creates a directory in the temporary directory and returns its
+// path (replaces mktemp -d)
+func (sl *StdLib) TempDir(pattern string) (string, error) {
+	fs, dir := sl.files(), sl.tempDir()
+	for try := 0; ; try++ {
+		name := tempName(dir, pattern)
+		err := fs.Mkdir(name, 0700)
+		if err == nil {
+			return name, nil
+		}
+		if !errors.Is(err, os.ErrExist) || try == 100 {
+			return "", fmt.Errorf("failed to create temporary directory: %v", err)
+		}
+	}
+}
+
+// tempDir returns the directory of temporary files: TMPDIR of the
+// environment, or the system's
+func (sl *StdLib) tempDir() string {
+	if dir := sl.env().GetEnv("TMPDIR"); dir != "" {
+		return dir
+	}
+	return os.TempDir()
+}
+
+// Symlink creates a symlink at link pointing to target (replaces ln -s)
+func (sl *StdLib) Symlink(target, link string) error {
+	if err := sl.files().Symlink(target, link); err != nil {
+		return fmt.Errorf("failed to create symlink %s: %v", link, err)
+	}
+	return nil
+}
+
+// ReadLink returns the target of a symlink (replaces readlink)
+func (sl *StdLib) ReadLink(path string) (string, error) {
+	target, err := sl.files().Readlink(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read symlink %s: %v", path, err)
+	}
+	return target, nil
+}
+
+// ParseMode applies an octal or symbolic chmod mode to the current
+// permissions. Symbolic clauses are [ugoa]*[+-=][rwx]*, separated by commas.
+func ParseMode(mode string, current os.FileMode) (os.FileMode, error) {
+	if mode == "" {
+		return 0, fmt.Errorf("invalid mode %q", mode)
+	}
+	if value, err := strconv.ParseUint(mode, 8, 32); err == nil {
+		if value > 0777 {
+			return 0, fmt.Errorf("invalid mode %q, only permission bits are supported", mode)
+		}
+		return os.FileMode(value), nil
+	}
+
+	perm := current.Perm()
+	for _, clause := range strings.Split(mode, ",") {
+		i := strings.IndexAny(clause, "+-=")
+		if i < 0 {
+			return 0, fmt.Errorf("invalid mode %q", mode)
+		}
+		var who os.FileMode
+		for _, c := range clause[:i] {
+			switch c {
+			case 'u':
+				who |= 0700
+			case 'g':
+				who |= 0070
+			case 'o':
+				who |= 0007
+			case 'a':
+				who |= 0777
+			default:
+				return 0, fmt.Errorf("invalid mode %q", mode)
+			}
+		}
+		if who == 0 {
+			who = 0777
+		}
+		var bits os.FileMode
+		for _, c := range clause[i+1:] {
+			switch c {
+			case 'r':
+				bits |= 0444
+			case 'w':
+				bits |= 0222
+			case 'x':
+				bits |= 0111
+			default:
+				return 0, fmt.Errorf("invalid mode %q, only r, w and x are supported", mode)
+			}
+		}
+		switch clause[i] {
+		case '+':
+			perm |= bits & who
+		case '-':
+			perm &^= bits & who
+		case '=':
+			perm = perm&^who | bits&who
+		}
+	}
+	return perm, nil
+}
+
+// createTemp creates a file with a random name in dir through fs and
+// returns its name and the open file. The * in pattern is replaced by the
+// random part.
+func createTemp(fs FileSystem, dir, pattern string) (string, *os.File, error) {
+	for try := 0; ; try++ {
+		name := tempName(dir, pattern)
+		file, err := fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil || !errors.Is(err, os.ErrExist) || try == 100 {
+			return name, file, err
+		}
+	}
+}
+
+// tempName returns a path in dir named after pattern, with its last *
+// replaced by a random string, or the string appended if it has none
+func tempName(dir, pattern string) string {
+	prefix, suffix := pattern, ""
+	if i := strings.LastIndex(pattern, "*"); i >= 0 {
+		prefix, suffix = pattern[:i], pattern[i+1:]
+	}
+	return filepath.Join(dir, prefix+strconv.FormatUint(uint64(rand.Uint32()), 10)+suffix)
+}
+
+// intoDir returns where src goes when copied or moved to dst: into dst if
+// it is a directory
+func intoDir(fs FileSystem, src, dst string) string {
+	if info, err := fs.Stat(dst); err == nil && info.IsDir() {
+		return filepath.Join(dst, filepath.Base(filepath.Clean(src)))
+	}
+	return dst
+}
+
+// within reports whether path is dir or inside it
+func within(path, dir string) bool {
+	path, dir = filepath.Clean(path), filepath.Clean(dir)
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// sameFile reports whether two paths are the same existing file
+func sameFile(fs FileSystem, a, b string) bool {
+	infoA, errA := fs.Stat(a)
+	infoB, errB := fs.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(infoA, infoB)
+}
+
+// copyRegular copies the content of a file and sets the permissions of the
+// copy
+func copyRegular(fs FileSystem, src, dst string, perm os.FileMode) error {
+	in, err := fs.OpenFile(src, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := fs.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return fs.Chmod(dst, perm)
+}
+
+// copyTree copies a file, symlink or directory tree; other file types are
+// skipped
+func copyTree(fs FileSystem, src, dst string) error {
+	info, err := fs.Lstat(src)
+	if err != nil {
+		return err
+	}
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := fs.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return fs.Symlink(target, dst)
+	case info.Mode().IsRegular():
+		return copyRegular(fs, src, dst, info.Mode().Perm())
+	case !info.IsDir():
+		return nil
+	}
+
+	if err := fs.Mkdir(dst, info.Mode().Perm()|0700); err != nil {
+		return err
+	}
+	entries, err := fs.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := copyTree(fs, filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return fs.Chmod(dst, info.Mode().Perm())
+}
+
+// mkdirAll creates a directory and the missing ones above it
+func mkdirAll(fs FileSystem, path string, perm os.FileMode) error {
+	if info, err := fs.Stat(path); err == nil {
+		if info.IsDir() {
+			return nil
+		}
+		return fmt.Errorf("%s exists and is not a directory", path)
+	}
+	if parent := filepath.Dir(path); parent != path {
+		if err := mkdirAll(fs, parent, perm); err != nil {
+			return err
+		}
+	}
+	if err := fs.Mkdir(path, perm); err != nil && !errors.Is(err, os.ErrExist) {
+		return err
+	}
+	return nil
+}
+
+// globSegments matches the remaining pattern segments below dir, appending
+// matches as paths relative to the original pattern
+func globSegments(fs FileSystem, dir, prefix string, segments []string, matches *[]string) {
+	segment, rest := segments[0], segments[1:]
+	join := func(name string) string {
+		if prefix == "" || strings.HasSuffix(prefix, "/") {
+			return prefix + name
+		}
+		return prefix + "/" + name
+	}
+
+	switch {
+	case segment == "":
+		// Trailing or repeated slash
+		if len(rest) == 0 {
+			*matches = append(*matches, join(""))
+			return
+		}
+		globSegments(fs, dir, prefix, rest, matches)
+
+	case segment == "**":
+		// Zero or more directories
+		if len(rest) > 0 {
+			globSegments(fs, dir, prefix, rest, matches)
+		}
+		entries, _ := fs.ReadDir(dir)
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			if len(rest) == 0 {
+				*matches = append(*matches, join(entry.Name()))
+			}
+			if entry.IsDir() {
+				globSegments(fs, filepath.Join(dir, entry.Name()), join(entry.Name()), segments, matches)
+			}
+		}
+
+	case !strings.ContainsAny(segment, `*?[\`):
+		full := filepath.Join(dir, segment)
+		if len(rest) == 0 {
+			if _, err := fs.Lstat(full); err == nil {
+				*matches = append(*matches, join(segment))
+			}
+		} else if info, err := fs.Stat(full); err == nil && info.IsDir() {
+			globSegments(fs, full, join(segment), rest, matches)
+		}
+
+	default:
+		entries, _ := fs.ReadDir(dir)
+		for _, entry := range entries {
+			name := entry.Name()
+			if strings.HasPrefix(name, ".") && !strings.HasPrefix(segment, ".") {
+				continue
+			}
+			if matched, _ := filepath.Match(segment, name); !matched {
+				continue
+			}
+			full := filepath.Join(dir, name)
+			if len(rest) == 0 {
+				*matches = append(*matches, join(name))
+			} else if info, err := fs.Stat(full); err == nil && info.IsDir() {
+				globSegments(fs, full, join(name), rest, matches)
+			}
+		}
+	}
+}
+
+// walk collects path and the paths below it that match the options
+func walk(fs FileSystem, path string, mode os.FileMode, depth int, options WalkOptions, paths *[]string) error {
+	if walkMatches(path, mode, options) {
+		*paths = append(*paths, path)
+	}
+	if !mode.IsDir() || (options.MaxDepth > 0 && depth >= options.MaxDepth) {
+		return nil
+	}
+	entries, err := fs.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := walk(fs, filepath.Join(path, entry.Name()), entry.Type(), depth+1, options, paths); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkMatches reports whether a path passes the filters of Walk
+func walkMatches(path string, mode os.FileMode, options WalkOptions) bool {
+	switch options.Type {
+	case "f":
+		if !mode.IsRegular() {
+			return false
+		}
+	case "d":
+		if !mode.IsDir() {
+			return false
+		}
+	case "l":
+		if mode&os.ModeSymlink == 0 {
+			return false
+		}
+	}
+	if options.Name == "" {
+		return true
+	}
+	matched, _ := filepath.Match(options.Name, filepath.Base(path))
+	return matched
+}
@@ -0,0 +1,230 @@
+package stdlib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// HTTP defaults
+const (
+	DefaultHttpBackoff = time.Second      // wait before the first retry, doubled for each next one
+	maxRetryAfter      = 60 * time.Second // longest Retry-After wait honored
+	httpConnectTimeout = 30 * time.Second
+	httpHeaderTimeout  = 60 * time.Second
+)
+
+// HttpOptions configures an HTTP request
+type HttpOptions struct {
+	Headers http.Header
+	Body    string
+	Timeout time.Duration // limit of each attempt, body included; 0 for none
+	Retries int           // attempts after a connection failure or a 429 or 5xx status
+	Backoff time.Duration // wait before the first retry; DefaultHttpBackoff if 0
+	Output  io.Writer     // receives the body of a successful response instead of HttpResponse.Body
+
+	// Dial makes the connections if set, e.g. to apply a network policy;
+	// Context.Dial is used otherwise. Proxies from the environment are then
+	// not used, so the destination dialed is the server itself.
+	Dial func(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// HttpResponse is the outcome of an HTTP request
+type HttpResponse struct {
+	Status   int         // status code, e.g. 200
+	Reason   string      // status line, e.g. "200 OK"
+	Headers  http.Header // response headers
+	Body     string      // body, unless it was written to HttpOptions.Output
+	Size     int64       // length of the body
+	Attempts int         // requests made, retries included
+}
+
+// HttpGet fetches a URL (replaces curl URL)
+func (sl *StdLib) HttpGet(url string, options HttpOptions) (*HttpResponse, error) {
+	return sl.HttpRequest(http.MethodGet, url, options)
+}
+
+// HttpPost sends a body to a URL (replaces curl -d body URL). Without a
+// Content-Type header, JSON bodies are sent as application/json and others
+// as text/plain.
+func (sl *StdLib) HttpPost(url, body string, options HttpOptions) (*HttpResponse, error) {
+	options.Body = body
+	return sl.HttpRequest(http.MethodPost, url, options)
+}
+
+// HttpRequest sends a request with any method (replaces curl -X). An error
+// is returned only if no response was received; error statuses are
+// responses too.
+func (sl *StdLib) HttpRequest(method, url string, options HttpOptions) (*HttpResponse, error) {
+	if options.Dial == nil {
+		options.Dial = sl.ctx.Dial
+	}
+	client := &http.Client{Timeout: options.Timeout, Transport: httpTransport(options.Dial)}
+	defer client.CloseIdleConnections()
+	backoff := options.Backoff
+	if backoff <= 0 {
+		backoff = DefaultHttpBackoff
+	}
+
+	for attempt := 1; ; attempt++ {
+		response, wait, err := sendRequest(client, method, url, options)
+		if response != nil {
+			response.Attempts = attempt
+		}
+		if wait < 0 || attempt > options.Retries {
+			return response, err
+		}
+		if wait == 0 {
+			wait = backoff
+		}
+		time.Sleep(wait)
+		backoff *= 2
+	}
+}
+
+// Download writes the body of a URL to a file, which is removed if the
+// download fails (replaces curl -o file URL, wget)
+func (sl *StdLib) Download(url, filename string, options HttpOptions) (*HttpResponse, error) {
+	file, err := sl.files().OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file %s: %v", filename, err)
+	}
+	options.Output = file
+	response, err := sl.HttpGet(url, options)
+	if closeErr := file.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write file %s: %v", filename, closeErr)
+	}
+	if err != nil || response.Status >= 400 {
+		sl.files().RemoveAll(filename)
+	}
+	return response, err
+}
+
+// httpTransport returns the transport of a client, dialing with dial if set
+func httpTransport(dial func(ctx context.Context, network, address string) (net.Conn, error)) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = httpHeaderTimeout
+	if dial != nil {
+		transport.Proxy = nil
+		transport.DialContext = dial
+	} else {
+		transport.DialContext = (&net.Dialer{Timeout: httpConnectTimeout}).DialContext
+	}
+	return transport
+}
+
+// sendRequest makes one attempt. wait is how long to wait before retrying,
+// 0 for the default backoff, or negative if the attempt must not be retried.
+func sendRequest(client *http.Client, method, url string, options HttpOptions) (*HttpResponse, time.Duration, error) {
+	var body io.Reader
+	if options.Body != "" {
+		body = strings.NewReader(options.Body)
+	}
+	request, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, -1, fmt.Errorf("invalid request %s %s: %v", method, url, err)
+	}
+	for name, values := range options.Headers {
+		for _, value := range values {
+			request.Header.Add(name, value)
+		}
+	}
+	if body != nil && request.Header.Get("Content-Type") == "" {
+		if json.Valid([]byte(options.Body)) {
+			request.Header.Set("Content-Type", "application/json")
+		} else {
+			request.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		}
+	}
+
+	resp, err := client.Do(request)
+	if err != nil {
+		// The URL error repeats the method and URL
+		var urlErr *neturl.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, retryWait(err), fmt.Errorf("%s %s: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+
+	response := &HttpResponse{Status: resp.StatusCode, Reason: resp.Status, Headers: resp.Header}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	if options.Output != nil && resp.StatusCode < 400 {
+		response.Size, err = io.Copy(options.Output, resp.Body)
+		if err != nil {
+			// Only a body that can be rewritten from the start is retried
+			wait := time.Duration(-1)
+			if rewind(options.Output) {
+				wait = retryWait(err)
+			}
+			return response, wait, fmt.Errorf("%s %s: failed to read body: %v", method, url, err)
+		}
+		return response, -1, nil
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	response.Body, response.Size = string(data), int64(len(data))
+	if err != nil {
+		return response, retryWait(err), fmt.Errorf("%s %s: failed to read body: %v", method, url, err)
+	}
+	if !retry {
+		return response, -1, nil
+	}
+	return response, retryAfter(resp.Header.Get("Retry-After")), nil
+}
+
+// retryWait tells whether a failed attempt is worth retrying: timeouts and
+// refused, reset or cut connections are; refusals by a dialer are not
+func retryWait(err error) time.Duration {
+	var netErr net.Error
+	switch {
+	case errors.As(err, &netErr) && netErr.Timeout(),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, io.EOF):
+		return 0
+	}
+	return -1
+}
+
+// retryAfter returns the wait a Retry-After header asks for in seconds, up
+// to maxRetryAfter, or 0 for the default backoff
+func retryAfter(header string) time.Duration {
+	seconds, err := strconv.Atoi(strings.TrimSpace(header))
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	wait := time.Duration(seconds) * time.Second
+	if wait > maxRetryAfter {
+		wait = maxRetryAfter
+	}
+	return wait
+}
+
+// rewind empties a file the body is written to, so it can be written again
+func rewind(w io.Writer) bool {
+	file, ok := w.(interface {
+		io.Seeker
+		Truncate(size int64) error
+	})
+	if !ok {
+		return false
+	}
+	if err := file.Truncate(0); err != nil {
+		return false
+	}
+	_, err := file.Seek(0, io.SeekStart)
+	return err == nil
+}
@@ -0,0 +1,1187 @@
+package stdlib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// JSON functions (replace 'jq')
+//
+// Documents are passed as text. Text may hold several values, one after
+// another, as JSONQuery prints them; functions then apply to each value.
+// Objects keep the order of their keys.
+
+// JSONParse checks that text is JSON and returns it compacted
+func (sl *StdLib) JSONParse(text string) (string, error) {
+	return sl.JSONCompact(text)
+}
+
+// JSONLoad reads a JSON file and returns it compacted
+func (sl *StdLib) JSONLoad(filename string) (string, error) {
+	content, err := sl.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	values, err := parseJSON(content)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", filename, err)
+	}
+	return formatJSON(values, ""), nil
+}
+
+// JSONCompact prints JSON on a single line per value (replaces jq -c .)
+func (sl *StdLib) JSONCompact(text string) (string, error) {
+	values, err := parseJSON(text)
+	if err != nil {
+		return "", err
+	}
+	return formatJSON(values, ""), nil
+}
+
+// JSONPretty prints JSON indented by two spaces (replaces jq .)
+func (sl *StdLib) JSONPretty(text string) (string, error) {
+	values, err := parseJSON(text)
+	if err != nil {
+		return "", err
+	}
+	return formatJSON(values, "  "), nil
+}
+
+// JSONQuery applies a jq-like filter and returns each result as compact
+// JSON (replaces jq -c filter). Filters support paths (.a.b, .[0], .["k"],
+// .[]), the pipe |, the comma, the alternative //, comparisons, literals,
+// parentheses, '?' after a path to ignore errors, and keys, length, type,
+// not, empty and select(f).
+func (sl *StdLib) JSONQuery(text, filter string) ([]string, error) {
+	results, err := queryJSON(text, filter)
+	if err != nil {
+		return nil, err
+	}
+	lines := make([]string, len(results))
+	for i, result := range results {
+		lines[i] = encodeJSON(result, "")
+	}
+	return lines, nil
+}
+
+// JSONGet is JSONQuery returning strings without quotes (replaces jq -r filter)
+func (sl *StdLib) JSONGet(text, filter string) ([]string, error) {
+	results, err := queryJSON(text, filter)
+	if err != nil {
+		return nil, err
+	}
+	lines := make([]string, len(results))
+	for i, result := range results {
+		lines[i] = rawJSON(result)
+	}
+	return lines, nil
+}
+
+// JSONSet sets the value at a path, given as JSON, creating missing
+// objects and arrays on the way (replaces jq '.path = value')
+func (sl *StdLib) JSONSet(text, path, value string) (string, error) {
+	values, err := parseJSON(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid value: %v", err)
+	}
+	if len(values) != 1 {
+		return "", fmt.Errorf("invalid value: %d JSON values instead of one", len(values))
+	}
+	return updateJSON(text, path, func(doc interface{}, steps []pathStep) (interface{}, error) {
+		// Each document gets its own copy of the value
+		copied, _ := parseJSON(value)
+		return setPath(doc, steps, copied[0])
+	})
+}
+
+// JSONSetString sets the value at a path to a string (replaces
+// jq --arg v value '.path = $v')
+func (sl *StdLib) JSONSetString(text, path, value string) (string, error) {
+	return updateJSON(text, path, func(doc interface{}, steps []pathStep) (interface{}, error) {
+		return setPath(doc, steps, value)
+	})
+}
+
+// JSONDelete removes the key or array element at a path (replaces jq 'del(.path)')
+func (sl *StdLib) JSONDelete(text, path string) (string, error) {
+	return updateJSON(text, path, deletePath)
+}
+
+// JSONMerge merges documents into the first one: objects are merged key by
+// key, recursively, and any other value replaces the previous one
+// (replaces jq -s '.[0] * .[1]')
+func (sl *StdLib) JSONMerge(texts ...string) (string, error) {
+	var merged interface{}
+	found := false
+	for _, text := range texts {
+		values, err := parseJSON(text)
+		if err != nil {
+			return "", err
+		}
+		for _, value := range values {
+			if !found {
+				merged, found = value, true
+				continue
+			}
+			merged = mergeJSON(merged, value)
+		}
+	}
+	if !found {
+		return "", fmt.Errorf("no JSON documents to merge")
+	}
+	return encodeJSON(merged, ""), nil
+}
+
+// JSONToArray returns the elements of a JSON array, or the values of a
+// stream such as the output of JSONQuery, as shell words: strings without
+// quotes, anything else as compact JSON
+func (sl *StdLib) JSONToArray(text string) ([]string, error) {
+	values, err := parseJSON(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 1 {
+		if array, ok := values[0].([]interface{}); ok {
+			values = array
+		}
+	}
+	words := make([]string, len(values))
+	for i, value := range values {
+		words[i] = rawJSON(value)
+	}
+	return words, nil
+}
+
+// JSONToMap returns the keys of a JSON object and their values as shell
+// words, as JSONToArray does
+func (sl *StdLib) JSONToMap(text string) (map[string]string, error) {
+	values, err := parseJSON(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) != 1 {
+		return nil, fmt.Errorf("expected one JSON object, got %d values", len(values))
+	}
+	object, ok := values[0].(*jsonObject)
+	if !ok {
+		return nil, fmt.Errorf("expected a JSON object, got %s", typeName(values[0]))
+	}
+	words := make(map[string]string, len(object.keys))
+	for _, key := range object.keys {
+		words[key] = rawJSON(object.values[key])
+	}
+	return words, nil
+}
+
+// JSONFromArray returns a JSON array of strings
+func (sl *StdLib) JSONFromArray(words []string) string {
+	array := make([]interface{}, len(words))
+	for i, word := range words {
+		array[i] = word
+	}
+	return encodeJSON(array, "")
+}
+
+// JSONFromMap returns a JSON object of strings, with its keys sorted
+func (sl *StdLib) JSONFromMap(words map[string]string) string {
+	object := newJSONObject()
+	keys := make([]string, 0, len(words))
+	for key := range words {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		object.set(key, words[key])
+	}
+	return encodeJSON(object, "")
+}
+
+// jsonObject is a JSON object that keeps the order of its keys
+type jsonObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newJSONObject() *jsonObject {
+	return &jsonObject{values: make(map[string]interface{})}
+}
+
+// set adds or replaces a key; new keys go last
+func (o *jsonObject) set(key string, value interface{}) {
+	if _, exists := o.values[key]; !exists {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+// remove deletes a key
+func (o *jsonObject) remove(key string) {
+	if _, exists := o.values[key]; !exists {
+		return
+	}
+	delete(o.values, key)
+	for i, k := range o.keys {
+		if k == key {
+			o.keys = append(o.keys[:i], o.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// parseJSON decodes the JSON values in text into nil, bool, json.Number,
+// string, []interface{} and *jsonObject values
+func parseJSON(text string) ([]interface{}, error) {
+	decoder := json.NewDecoder(strings.NewReader(text))
+	decoder.UseNumber()
+	var values []interface{}
+	for {
+		value, err := decodeJSON(decoder)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON at offset %d: %v", decoder.InputOffset(), err)
+		}
+		values = append(values, value)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("invalid JSON: no value in input")
+	}
+	return values, nil
+}
+
+// decodeJSON decodes the next value; io.EOF means there is none
+func decodeJSON(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	delim, ok := token.(json.Delim)
+	if !ok {
+		return token, nil
+	}
+
+	switch delim {
+	case '{':
+		object := newJSONObject()
+		for decoder.More() {
+			token, err := decoder.Token()
+			if err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			value, err := decodeJSON(decoder)
+			if err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			object.set(token.(string), value)
+		}
+		if _, err := decoder.Token(); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		return object, nil
+	case '[':
+		array := []interface{}{}
+		for decoder.More() {
+			value, err := decodeJSON(decoder)
+			if err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			array = append(array, value)
+		}
+		if _, err := decoder.Token(); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		return array, nil
+	}
+	return nil, fmt.Errorf("unexpected %q", delim)
+}
+
+// unexpectedEOF reports the end of input inside a value as an error
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// formatJSON encodes values one per line
+func formatJSON(values []interface{}, indent string) string {
+	lines := make([]string, len(values))
+	for i, value := range values {
+		lines[i] = encodeJSON(value, indent)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// encodeJSON encodes a value, on one line if indent is empty
+func encodeJSON(value interface{}, indent string) string {
+	var b strings.Builder
+	writeJSON(&b, value, indent, 0)
+	return b.String()
+}
+
+func writeJSON(b *strings.Builder, value interface{}, indent string, depth int) {
+	newline := func(depth int) {
+		if indent != "" {
+			b.WriteByte('\n')
+			b.WriteString(strings.Repeat(indent, depth))
+		}
+	}
+
+	switch v := value.(type) {
+	case nil:
+		b.WriteString("null")
+	case bool:
+		b.WriteString(strconv.FormatBool(v))
+	case json.Number:
+		b.WriteString(string(v))
+	case string:
+		writeJSONString(b, v)
+	case []interface{}:
+		if len(v) == 0 {
+			b.WriteString("[]")
+			return
+		}
+		b.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			newline(depth + 1)
+			writeJSON(b, item, indent, depth+1)
+		}
+		newline(depth)
+		b.WriteByte(']')
+	case *jsonObject:
+		if len(v.keys) == 0 {
+			b.WriteString("{}")
+			return
+		}
+		b.WriteByte('{')
+		for i, key := range v.keys {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			newline(depth + 1)
+			writeJSONString(b, key)
+			b.WriteByte(':')
+			if indent != "" {
+				b.WriteByte(' ')
+			}
+			writeJSON(b, v.values[key], indent, depth+1)
+		}
+		newline(depth)
+		b.WriteByte('}')
+	}
+}
+
+// writeJSONString writes a quoted string, leaving <, > and & as they are
+func writeJSONString(b *strings.Builder, s string) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(s)
+	b.Write(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+}
+
+// rawJSON returns strings as they are and other values as compact JSON
+func rawJSON(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	return encodeJSON(value, "")
+}
+
+// typeName returns the jq name of the type of a value
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	}
+	return "object"
+}
+
+// truthy reports whether a value counts as true: anything but false and null
+func truthy(value interface{}) bool {
+	return value != nil && value != false
+}
+
+// queryJSON applies a filter to each value in text
+func queryJSON(text, filter string) ([]interface{}, error) {
+	compiled, err := compileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	values, err := parseJSON(text)
+	if err != nil {
+		return nil, err
+	}
+	var results []interface{}
+	for _, value := range values {
+		outputs, err := compiled(value)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, outputs...)
+	}
+	return results, nil
+}
+
+// updateJSON applies a change at a path to each value in text
+func updateJSON(text, path string, change func(doc interface{}, steps []pathStep) (interface{}, error)) (string, error) {
+	steps, err := parsePath(path)
+	if err != nil {
+		return "", err
+	}
+	values, err := parseJSON(text)
+	if err != nil {
+		return "", err
+	}
+	for i, value := range values {
+		if values[i], err = change(value, steps); err != nil {
+			return "", fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return formatJSON(values, ""), nil
+}
+
+// pathStep is a step of a path: an object key, an array index or, in
+// filters only, every element
+type pathStep struct {
+	key     string
+	index   int
+	isIndex bool
+	iterate bool
+}
+
+// apply returns the values a step selects in a value
+func (s pathStep) apply(value interface{}) ([]interface{}, error) {
+	switch {
+	case s.iterate:
+		switch v := value.(type) {
+		case []interface{}:
+			return v, nil
+		case *jsonObject:
+			values := make([]interface{}, len(v.keys))
+			for i, key := range v.keys {
+				values[i] = v.values[key]
+			}
+			return values, nil
+		}
+		return nil, fmt.Errorf("cannot iterate over %s", typeName(value))
+	case s.isIndex:
+		switch v := value.(type) {
+		case nil:
+			return []interface{}{nil}, nil
+		case []interface{}:
+			i := s.index
+			if i < 0 {
+				i += len(v)
+			}
+			if i < 0 || i >= len(v) {
+				return []interface{}{nil}, nil
+			}
+			return []interface{}{v[i]}, nil
+		}
+		return nil, fmt.Errorf("cannot index %s with number", typeName(value))
+	}
+	switch v := value.(type) {
+	case nil:
+		return []interface{}{nil}, nil
+	case *jsonObject:
+		return []interface{}{v.values[s.key]}, nil
+	}
+	return nil, fmt.Errorf("cannot index %s with %q", typeName(value), s.key)
+}
+
+// setPath returns root with the value at steps replaced
+func setPath(root interface{}, steps []pathStep, value interface{}) (interface{}, error) {
+	if len(steps) == 0 {
+		return value, nil
+	}
+	step := steps[0]
+
+	if step.isIndex {
+		var array []interface{}
+		switch v := root.(type) {
+		case nil:
+		case []interface{}:
+			array = v
+		default:
+			return nil, fmt.Errorf("cannot index %s with number", typeName(root))
+		}
+		i := step.index
+		if i < 0 {
+			i += len(array)
+			if i < 0 {
+				return nil, fmt.Errorf("index %d out of range", step.index)
+			}
+		}
+		for len(array) <= i {
+			array = append(array, nil)
+		}
+		child, err := setPath(array[i], steps[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		array[i] = child
+		return array, nil
+	}
+
+	var object *jsonObject
+	switch v := root.(type) {
+	case nil:
+		object = newJSONObject()
+	case *jsonObject:
+		object = v
+	default:
+		return nil, fmt.Errorf("cannot index %s with %q", typeName(root), step.key)
+	}
+	child, err := setPath(object.values[step.key], steps[1:], value)
+	if err != nil {
+		return nil, err
+	}
+	object.set(step.key, child)
+	return object, nil
+}
+
+// deletePath returns root without the value at steps; missing values are
+// ignored
+func deletePath(root interface{}, steps []pathStep) (interface{}, error) {
+	if len(steps) == 0 {
+		return nil, nil
+	}
+	step, last := steps[0], len(steps) == 1
+
+	switch v := root.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		if !step.isIndex {
+			return nil, fmt.Errorf("cannot delete %q from array", step.key)
+		}
+		i := step.index
+		if i < 0 {
+			i += len(v)
+		}
+		if i < 0 || i >= len(v) {
+			return v, nil
+		}
+		if last {
+			return append(v[:i:i], v[i+1:]...), nil
+		}
+		child, err := deletePath(v[i], steps[1:])
+		if err != nil {
+			return nil, err
+		}
+		v[i] = child
+		return v, nil
+	case *jsonObject:
+		if step.isIndex {
+			return nil, fmt.Errorf("cannot delete index %d from object", step.index)
+		}
+		child, exists := v.values[step.key]
+		if !exists {
+			return v, nil
+		}
+		if last {
+			v.remove(step.key)
+			return v, nil
+		}
+		child, err := deletePath(child, steps[1:])
+		if err != nil {
+			return nil, err
+		}
+		v.set(step.key, child)
+		return v, nil
+	}
+	return nil, fmt.Errorf("cannot delete from %s", typeName(root))
+}
+
+// mergeJSON merges overlay into base, recursively for objects
+func mergeJSON(base, overlay interface{}) interface{} {
+	baseObject, ok := base.(*jsonObject)
+	overlayObject, overlayOk := overlay.(*jsonObject)
+	if !ok || !overlayOk {
+		return overlay
+	}
+	for _, key := range overlayObject.keys {
+		value := overlayObject.values[key]
+		if existing, exists := baseObject.values[key]; exists {
+			value = mergeJSON(existing, value)
+		}
+		baseObject.set(key, value)
+	}
+	return baseObject
+}
+
+// jsonFilter maps an input value to its outputs
+type jsonFilter func(input interface{}) ([]interface{}, error)
+
+// filterParser compiles filters by recursive descent. From the loosest
+// binding: pipe |, comma, alternative //, comparison, then terms with
+// their path steps.
+type filterParser struct {
+	src string
+	pos int
+}
+
+// compileFilter compiles a jq-like filter
+func compileFilter(src string) (jsonFilter, error) {
+	p := &filterParser{src: src}
+	filter, err := p.pipe()
+	if err == nil {
+		p.space()
+		if p.pos < len(p.src) {
+			err = fmt.Errorf("unexpected %q at %d", p.src[p.pos:], p.pos)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter %q: %v", src, err)
+	}
+	return filter, nil
+}
+
+// parsePath compiles a path as used by JSONSet and JSONDelete, e.g. .a.b[0]
+func parsePath(src string) ([]pathStep, error) {
+	p := &filterParser{src: strings.TrimSpace(src)}
+	if !p.peek('.') {
+		return nil, fmt.Errorf("invalid path %q: paths start with '.'", src)
+	}
+	p.pos++
+	steps, err := p.steps(true)
+	if err == nil && p.pos < len(p.src) {
+		err = fmt.Errorf("unexpected %q at %d", p.src[p.pos:], p.pos)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid path %q: %v", src, err)
+	}
+	for _, step := range steps {
+		if step.iterate {
+			return nil, fmt.Errorf("invalid path %q: [] selects several values", src)
+		}
+	}
+	return steps, nil
+}
+
+func (p *filterParser) pipe() (jsonFilter, error) {
+	left, err := p.comma()
+	if err != nil {
+		return nil, err
+	}
+	for p.consume("|") {
+		right, err := p.comma()
+		if err != nil {
+			return nil, err
+		}
+		left = composeFilters(left, right)
+	}
+	return left, nil
+}
+
+func (p *filterParser) comma() (jsonFilter, error) {
+	left, err := p.alternative()
+	if err != nil {
+		return nil, err
+	}
+	for p.consume(",") {
+		right, err := p.alternative()
+		if err != nil {
+			return nil, err
+		}
+		first, second := left, right
+		left = func(input interface{}) ([]interface{}, error) {
+			outputs, err := first(input)
+			if err != nil {
+				return nil, err
+			}
+			more, err := second(input)
+			if err != nil {
+				return nil, err
+			}
+			return append(outputs, more...), nil
+		}
+	}
+	return left, nil
+}
+
+func (p *filterParser) alternative() (jsonFilter, error) {
+	left, err := p.comparison()
+	if err != nil {
+		return nil, err
+	}
+	for p.consume("//") {
+		right, err := p.comparison()
+		if err != nil {
+			return nil, err
+		}
+		first, fallback := left, right
+		left = func(input interface{}) ([]interface{}, error) {
+			// Errors of the first filter select the fallback too
+			outputs, err := first(input)
+			var kept []interface{}
+			if err == nil {
+				for _, output := range outputs {
+					if truthy(output) {
+						kept = append(kept, output)
+					}
+				}
+			}
+			if len(kept) > 0 {
+				return kept, nil
+			}
+			return fallback(input)
+		}
+	}
+	return left, nil
+}
+
+func (p *filterParser) comparison() (jsonFilter, error) {
+	left, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if !p.consume(op) {
+			continue
+		}
+		right, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		return func(input interface{}) ([]interface{}, error) {
+			lefts, err := left(input)
+			if err != nil {
+				return nil, err
+			}
+			rights, err := right(input)
+			if err != nil {
+				return nil, err
+			}
+			var outputs []interface{}
+			for _, r := range rights {
+				for _, l := range lefts {
+					result, err := compareJSON(op, l, r)
+					if err != nil {
+						return nil, err
+					}
+					outputs = append(outputs, result)
+				}
+			}
+			return outputs, nil
+		}, nil
+	}
+	return left, nil
+}
+
+// term parses a path, literal, builtin or parenthesized filter, followed
+// by path steps and '?'
+func (p *filterParser) term() (jsonFilter, error) {
+	p.space()
+	var filter jsonFilter
+	var steps []pathStep
+	var err error
+	if p.peek('.') {
+		p.pos++
+		filter = func(input interface{}) ([]interface{}, error) { return []interface{}{input}, nil }
+		steps, err = p.steps(true)
+	} else {
+		if filter, err = p.primary(); err == nil {
+			steps, err = p.steps(false)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, step := range steps {
+		filter = composeFilters(filter, step.apply)
+	}
+
+	for p.peek('?') {
+		p.pos++
+		tried := filter
+		filter = func(input interface{}) ([]interface{}, error) {
+			outputs, err := tried(input)
+			if err != nil {
+				return nil, nil
+			}
+			return outputs, nil
+		}
+	}
+	return filter, nil
+}
+
+// steps parses path steps; afterDot means a '.' was just read, so a key
+// may follow without another one
+func (p *filterParser) steps(afterDot bool) ([]pathStep, error) {
+	var steps []pathStep
+	for {
+		switch {
+		case afterDot && p.pos < len(p.src) && isIdentStart(p.src[p.pos]):
+			steps = append(steps, pathStep{key: p.ident()})
+		case afterDot && p.peek('"'):
+			key, err := p.str()
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, pathStep{key: key})
+		case p.peek('['):
+			step, err := p.bracket()
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, step)
+		case !afterDot && p.peek('.') && p.pos+1 < len(p.src) &&
+			(isIdentStart(p.src[p.pos+1]) || p.src[p.pos+1] == '"' || p.src[p.pos+1] == '['):
+			p.pos++
+			afterDot = true
+			continue
+		default:
+			return steps, nil
+		}
+		afterDot = false
+	}
+}
+
+// bracket parses [], [index] or ["key"]
+func (p *filterParser) bracket() (pathStep, error) {
+	p.pos++
+	p.space()
+	var step pathStep
+	switch {
+	case p.peek(']'):
+		step.iterate = true
+	case p.peek('"'):
+		key, err := p.str()
+		if err != nil {
+			return step, err
+		}
+		step.key = key
+	default:
+		start := p.pos
+		if p.peek('-') {
+			p.pos++
+		}
+		for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+			p.pos++
+		}
+		index, err := strconv.Atoi(p.src[start:p.pos])
+		if err != nil {
+			return step, fmt.Errorf("expected an index, a key or ] at %d", start)
+		}
+		step.index, step.isIndex = index, true
+	}
+	p.space()
+	if !p.peek(']') {
+		return step, fmt.Errorf("missing ] at %d", p.pos)
+	}
+	p.pos++
+	return step, nil
+}
+
+// primary parses a literal, a builtin or a parenthesized filter
+func (p *filterParser) primary() (jsonFilter, error) {
+	constant := func(value interface{}) jsonFilter {
+		return func(interface{}) ([]interface{}, error) { return []interface{}{value}, nil }
+	}
+
+	switch {
+	case p.pos >= len(p.src):
+		return nil, fmt.Errorf("unexpected end of filter")
+	case p.peek('('):
+		p.pos++
+		filter, err := p.pipe()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume(")") {
+			return nil, fmt.Errorf("missing ) at %d", p.pos)
+		}
+		return filter, nil
+	case p.peek('"'):
+		s, err := p.str()
+		if err != nil {
+			return nil, err
+		}
+		return constant(s), nil
+	case p.peek('-') || p.src[p.pos] >= '0' && p.src[p.pos] <= '9':
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.src) && strings.IndexByte("0123456789.eE+-", p.src[p.pos]) >= 0 {
+			p.pos++
+		}
+		number := p.src[start:p.pos]
+		if _, err := strconv.ParseFloat(number, 64); err != nil {
+			return nil, fmt.Errorf("invalid number %q at %d", number, start)
+		}
+		return constant(json.Number(number)), nil
+	case !isIdentStart(p.src[p.pos]):
+		return nil, fmt.Errorf("unexpected %q at %d", p.src[p.pos:], p.pos)
+	}
+
+	start := p.pos
+	name := p.ident()
+	switch name {
+	case "null":
+		return constant(nil), nil
+	case "true", "false":
+		return constant(name == "true"), nil
+	case "empty":
+		return func(interface{}) ([]interface{}, error) { return nil, nil }, nil
+	case "not":
+		return func(input interface{}) ([]interface{}, error) {
+			return []interface{}{!truthy(input)}, nil
+		}, nil
+	case "type":
+		return func(input interface{}) ([]interface{}, error) {
+			return []interface{}{typeName(input)}, nil
+		}, nil
+	case "length":
+		return func(input interface{}) ([]interface{}, error) {
+			length, err := jsonLength(input)
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{length}, nil
+		}, nil
+	case "keys":
+		return func(input interface{}) ([]interface{}, error) {
+			keys, err := jsonKeys(input)
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{keys}, nil
+		}, nil
+	case "select":
+		if !p.consume("(") {
+			return nil, fmt.Errorf("select needs a condition in parentheses at %d", p.pos)
+		}
+		condition, err := p.pipe()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume(")") {
+			return nil, fmt.Errorf("missing ) at %d", p.pos)
+		}
+		return func(input interface{}) ([]interface{}, error) {
+			results, err := condition(input)
+			if err != nil {
+				return nil, err
+			}
+			var outputs []interface{}
+			for _, result := range results {
+				if truthy(result) {
+					outputs = append(outputs, input)
+				}
+			}
+			return outputs, nil
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown function %q at %d", name, start)
+}
+
+// composeFilters feeds each output of first to then
+func composeFilters(first, then jsonFilter) jsonFilter {
+	return func(input interface{}) ([]interface{}, error) {
+		values, err := first(input)
+		if err != nil {
+			return nil, err
+		}
+		var outputs []interface{}
+		for _, value := range values {
+			results, err := then(value)
+			if err != nil {
+				return nil, err
+			}
+			outputs = append(outputs, results...)
+		}
+		return outputs, nil
+	}
+}
+
+func (p *filterParser) space() {
+	for p.pos < len(p.src) && strings.IndexByte(" \t\r\n", p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+// peek reports whether the next character is c
+func (p *filterParser) peek(c byte) bool {
+	return p.pos < len(p.src) && p.src[p.pos] == c
+}
+
+// consume skips spaces and token if it comes next
+func (p *filterParser) consume(token string) bool {
+	p.space()
+	if strings.HasPrefix(p.src[p.pos:], token) {
+		p.pos += len(token)
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) ident() string {
+	start := p.pos
+	for p.pos < len(p.src) && (isIdentStart(p.src[p.pos]) || p.src[p.pos] >= '0' && p.src[p.pos] <= '9') {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+// str parses a JSON string literal
+func (p *filterParser) str() (string, error) {
+	start := p.pos
+	for i := p.pos + 1; i < len(p.src); i++ {
+		switch p.src[i] {
+		case '\\':
+			i++
+		case '"':
+			var s string
+			if err := json.Unmarshal([]byte(p.src[start:i+1]), &s); err != nil {
+				return "", fmt.Errorf("invalid string at %d: %v", start, err)
+			}
+			p.pos = i + 1
+			return s, nil
+		}
+	}
+	return "", fmt.Errorf("unterminated string at %d", start)
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// jsonLength returns the jq length of a value
+func jsonLength(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case nil:
+		return json.Number("0"), nil
+	case json.Number:
+		if strings.HasPrefix(string(v), "-") {
+			return json.Number(strings.TrimPrefix(string(v), "-")), nil
+		}
+		return v, nil
+	case string:
+		return json.Number(strconv.Itoa(utf8.RuneCountInString(v))), nil
+	case []interface{}:
+		return json.Number(strconv.Itoa(len(v))), nil
+	case *jsonObject:
+		return json.Number(strconv.Itoa(len(v.keys))), nil
+	}
+	return nil, fmt.Errorf("%s has no length", typeName(value))
+}
+
+// jsonKeys returns the sorted keys of an object or the indices of an array
+func jsonKeys(value interface{}) ([]interface{}, error) {
+	switch v := value.(type) {
+	case []interface{}:
+		keys := make([]interface{}, len(v))
+		for i := range v {
+			keys[i] = json.Number(strconv.Itoa(i))
+		}
+		return keys, nil
+	case *jsonObject:
+		sorted := append([]string(nil), v.keys...)
+		sort.Strings(sorted)
+		keys := make([]interface{}, len(sorted))
+		for i, key := range sorted {
+			keys[i] = key
+		}
+		return keys, nil
+	}
+	return nil, fmt.Errorf("%s has no keys", typeName(value))
+}
+
+// compareJSON applies a comparison operator to two values
+func compareJSON(op string, a, b interface{}) (bool, error) {
+	switch op {
+	case "==":
+		return equalJSON(a, b), nil
+	case "!=":
+		return !equalJSON(a, b), nil
+	}
+
+	var order int
+	switch {
+	case typeName(a) == "number" && typeName(b) == "number":
+		x, y := numberValue(a), numberValue(b)
+		switch {
+		case x < y:
+			order = -1
+		case x > y:
+			order = 1
+		}
+	case typeName(a) == "string" && typeName(b) == "string":
+		order = strings.Compare(a.(string), b.(string))
+	default:
+		return false, fmt.Errorf("cannot compare %s with %s", typeName(a), typeName(b))
+	}
+	switch op {
+	case "<":
+		return order < 0, nil
+	case "<=":
+		return order <= 0, nil
+	case ">":
+		return order > 0, nil
+	}
+	return order >= 0, nil
+}
+
+// equalJSON reports whether two values are equal; numbers by value
+func equalJSON(a, b interface{}) bool {
+	if typeName(a) != typeName(b) {
+		return false
+	}
+	switch v := a.(type) {
+	case json.Number:
+		return numberValue(v) == numberValue(b)
+	case []interface{}:
+		w := b.([]interface{})
+		if len(v) != len(w) {
+			return false
+		}
+		for i := range v {
+			if !equalJSON(v[i], w[i]) {
+				return false
+			}
+		}
+		return true
+	case *jsonObject:
+		w := b.(*jsonObject)
+		if len(v.keys) != len(w.keys) {
+			return false
+		}
+		for _, key := range v.keys {
+			other, exists := w.values[key]
+			if !exists || !equalJSON(v.values[key], other) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+// numberValue returns a JSON number as a float64
+func numberValue(value interface{}) float64 {
+	f, err := value.(json.Number).Float64()
+	if err != nil {
+		return math.NaN()
+	}
+	return f
+}
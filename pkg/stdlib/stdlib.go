@@ -2,15 +2,19 @@ package stdlib
 
 import (
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
 	"strings"
 )
 
 // StdLib provides built-in functions to replace external commands
-type StdLib struct{}
+type StdLib struct {
+	ctx Context // environment, file system and streams the functions use
+}
 
-// New creates a new standard library instance
+// New creates a new standard library instance that works on the process:
+// its environment, working directory, files and standard streams. Use
+// WithContext to bind it to a script instead.
 func New() *StdLib {
 	return &StdLib{}
 }
@@ -19,7 +23,12 @@ func New() *StdLib {
 
 // ReadFile reads the contents of a file (replaces 'cat')
 func (sl *StdLib) ReadFile(filename string) (string, error) {
-	content, err := ioutil.ReadFile(filename)
+	file, err := sl.files().OpenFile(filename, os.O_RDONLY, 0)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file %s: %v", filename, err)
+	}
+	defer file.Close()
+	content, err := io.ReadAll(file)
 	if err != nil {
 		return "", fmt.Errorf("failed to read file %s: %v", filename, err)
 	}
@@ -28,12 +37,20 @@ func (sl *StdLib) ReadFile(filename string) (string, error) {
 
 // WriteFile writes content to a file (replaces echo > file)
 func (sl *StdLib) WriteFile(filename, content string) error {
-	return ioutil.WriteFile(filename, []byte(content), 0644)
+	file, err := sl.files().OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString(content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
 }
 
 // ListFiles lists files in a directory (replaces 'ls')
 func (sl *StdLib) ListFiles(dirpath string) ([]string, error) {
-	files, err := ioutil.ReadDir(dirpath)
+	files, err := sl.files().ReadDir(dirpath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list directory %s: %v", dirpath, err)
 	}
@@ -47,7 +64,7 @@ func (sl *StdLib) ListFiles(dirpath string) ([]string, error) {
 
 // FileExists checks if a file exists (replaces test -f)
 func (sl *StdLib) FileExists(filename string) bool {
-	_, err := os.Stat(filename)
+	_, err := sl.files().Stat(filename)
 	return !os.IsNotExist(err)
 }
 
@@ -82,42 +99,63 @@ func (sl *StdLib) Trim(s string) string {
 
 // GetEnv gets an environment variable (replaces $VAR)
 func (sl *StdLib) GetEnv(key string) string {
-	return os.Getenv(key)
+	return sl.env().GetEnv(key)
 }
 
 // SetEnv sets an environment variable (replaces export)
 func (sl *StdLib) SetEnv(key, value string) error {
-	return os.Setenv(key, value)
+	if key == "" || strings.ContainsAny(key, "=\x00") {
+		return fmt.Errorf("invalid environment variable name %q", key)
+	}
+	sl.env().SetEnv(key, value)
+	return nil
 }
 
 // WorkingDir gets the current working directory (replaces pwd)
 func (sl *StdLib) WorkingDir() (string, error) {
-	return os.Getwd()
+	dir := sl.env().GetWorkingDir()
+	if dir == "" {
+		return "", fmt.Errorf("failed to get working directory")
+	}
+	return dir, nil
 }
 
 // ChangeDir changes the current directory (replaces cd)
 func (sl *StdLib) ChangeDir(dirpath string) error {
-	return os.Chdir(dirpath)
+	dir := sl.path(dirpath)
+	if info, err := sl.files().Stat(dir); err != nil || !info.IsDir() {
+		return fmt.Errorf("directory does not exist: %s", dir)
+	}
+	return sl.env().ChangeDir(dir)
 }
 
 // Utility functions
 
 // Print outputs text to stdout (replaces echo)
 func (sl *StdLib) Print(text string) {
-	fmt.Print(text)
+	fmt.Fprint(sl.stdout(), text)
 }
 
 // Println outputs text with newline to stdout (replaces echo)
 func (sl *StdLib) Println(text string) {
-	fmt.Println(text)
+	fmt.Fprintln(sl.stdout(), text)
 }
 
 // Error outputs text to stderr (replaces echo >&2)
 func (sl *StdLib) Error(text string) {
-	fmt.Fprint(os.Stderr, text)
+	fmt.Fprint(sl.stderr(), text)
 }
 
 // Errorln outputs text with newline to stderr (replaces echo >&2)
 func (sl *StdLib) Errorln(text string) {
-	fmt.Fprintln(os.Stderr, text)
+	fmt.Fprintln(sl.stderr(), text)
+}
+
+// ReadInput reads the whole standard input (replaces cat without a file)
+func (sl *StdLib) ReadInput() (string, error) {
+	data, err := io.ReadAll(sl.stdin())
+	if err != nil {
+		return "", fmt.Errorf("failed to read input: %v", err)
+	}
+	return string(data), nil
 }
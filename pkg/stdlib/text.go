@@ -0,0 +1,443 @@
+package stdlib
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Text functions (replace grep, sed, cut, sort, uniq, wc, head, tail, tr)
+//
+// Patterns are Go regular expressions (RE2), the same on every platform.
+// Functions working on lines split text at newlines; a final newline does
+// not start another line.
+
+// RegexMatch returns the first match of pattern in text followed by its
+// capture groups, or nil if there is none (replaces [[ =~ ]] and BASH_REMATCH)
+func (sl *StdLib) RegexMatch(text, pattern string) ([]string, error) {
+	re, err := compilePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return re.FindStringSubmatch(text), nil
+}
+
+// RegexFindAll returns every match of pattern in text (replaces grep -o)
+func (sl *StdLib) RegexFindAll(text, pattern string) ([]string, error) {
+	re, err := compilePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return re.FindAllString(text, -1), nil
+}
+
+// RegexReplace replaces every match of pattern; $1, ${name} and \1 in the
+// replacement stand for capture groups (replaces sed 's/pattern/replacement/g')
+func (sl *StdLib) RegexReplace(text, pattern, replacement string) (string, error) {
+	re, err := compilePattern(pattern)
+	if err != nil {
+		return "", err
+	}
+	return re.ReplaceAllString(text, sedReplacement(replacement)), nil
+}
+
+// FilterLines returns the lines matching pattern (replaces grep -E)
+func (sl *StdLib) FilterLines(text, pattern string) ([]string, error) {
+	return filterLines(text, pattern, true)
+}
+
+// RejectLines returns the lines not matching pattern (replaces grep -v -E)
+func (sl *StdLib) RejectLines(text, pattern string) ([]string, error) {
+	return filterLines(text, pattern, false)
+}
+
+// Fields returns the selected fields of each line, joined by the delimiter
+// (replaces cut -d -f). list holds field numbers and ranges from 1, e.g.
+// "1,3" or "2-". An empty delimiter splits at runs of whitespace and joins
+// with a space (replaces awk '{print $1}').
+func (sl *StdLib) Fields(text, delimiter, list string) ([]string, error) {
+	ranges, err := parseFieldList(list)
+	if err != nil {
+		return nil, err
+	}
+	separator := delimiter
+	if separator == "" {
+		separator = " "
+	}
+
+	var result []string
+	for _, line := range splitLines(text) {
+		fields := splitFields(line, delimiter)
+		var selected []string
+		for i, field := range fields {
+			for _, r := range ranges {
+				if i+1 >= r[0] && (r[1] == 0 || i+1 <= r[1]) {
+					selected = append(selected, field)
+					break
+				}
+			}
+		}
+		result = append(result, strings.Join(selected, separator))
+	}
+	return result, nil
+}
+
+// SortOptions controls SortLines
+type SortOptions struct {
+	Key       int    // field to sort by, from 1; 0 sorts by the whole line
+	Delimiter string // field delimiter; empty splits at whitespace
+	Numeric   bool   // compare keys as numbers; keys that are not sort first
+	Reverse   bool   // sort in descending order
+	Unique    bool   // keep only the first of lines with equal keys
+}
+
+// SortLines sorts lines, stably (replaces sort -k -t -n -r -u)
+func (sl *StdLib) SortLines(text string, options SortOptions) []string {
+	lines := splitLines(text)
+	key := func(line string) string {
+		if options.Key <= 0 {
+			return line
+		}
+		fields := splitFields(line, options.Delimiter)
+		if options.Key > len(fields) {
+			return ""
+		}
+		return fields[options.Key-1]
+	}
+	compare := func(a, b string) int {
+		if options.Numeric {
+			x, y := leadingNumber(a), leadingNumber(b)
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+		return strings.Compare(a, b)
+	}
+
+	sort.SliceStable(lines, func(i, j int) bool {
+		order := compare(key(lines[i]), key(lines[j]))
+		if options.Reverse {
+			return order > 0
+		}
+		return order < 0
+	})
+	if !options.Unique {
+		return lines
+	}
+	var unique []string
+	for i, line := range lines {
+		if i == 0 || compare(key(lines[i-1]), key(line)) != 0 {
+			unique = append(unique, line)
+		}
+	}
+	return unique
+}
+
+// UniqueLines removes adjacent duplicate lines; with count, each line is
+// prefixed by the number of its repetitions and a space (replaces uniq, uniq -c)
+func (sl *StdLib) UniqueLines(text string, count bool) []string {
+	var result []string
+	var counts []int
+	for _, line := range splitLines(text) {
+		if len(result) > 0 && result[len(result)-1] == line {
+			counts[len(counts)-1]++
+			continue
+		}
+		result = append(result, line)
+		counts = append(counts, 1)
+	}
+	if count {
+		for i := range result {
+			result[i] = fmt.Sprintf("%d %s", counts[i], result[i])
+		}
+	}
+	return result
+}
+
+// CountLines returns the number of lines (replaces wc -l)
+func (sl *StdLib) CountLines(text string) int {
+	return len(splitLines(text))
+}
+
+// CountWords returns the number of words separated by whitespace (replaces wc -w)
+func (sl *StdLib) CountWords(text string) int {
+	return len(strings.Fields(text))
+}
+
+// CountChars returns the number of characters (replaces wc -m)
+func (sl *StdLib) CountChars(text string) int {
+	return utf8.RuneCountInString(text)
+}
+
+// HeadLines returns the first n lines (replaces head -n)
+func (sl *StdLib) HeadLines(text string, n int) []string {
+	lines := splitLines(text)
+	if n < 0 {
+		n = 0
+	}
+	if n < len(lines) {
+		lines = lines[:n]
+	}
+	return lines
+}
+
+// TailLines returns the last n lines (replaces tail -n)
+func (sl *StdLib) TailLines(text string, n int) []string {
+	lines := splitLines(text)
+	if n < 0 {
+		n = 0
+	}
+	if n < len(lines) {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// Translate replaces each character of from by the character at the same
+// position in to, the last one of to if it is shorter (replaces tr). Sets
+// may hold ranges such as a-z, classes such as [:upper:] and the escapes
+// \n, \t and \\.
+func (sl *StdLib) Translate(text, from, to string) (string, error) {
+	source, err := expandCharSet(from)
+	if err != nil {
+		return "", err
+	}
+	target, err := expandCharSet(to)
+	if err != nil {
+		return "", err
+	}
+	if len(target) == 0 {
+		return "", fmt.Errorf("empty replacement set")
+	}
+	mapping := make(map[rune]rune, len(source))
+	for i, r := range source {
+		if i >= len(target) {
+			i = len(target) - 1
+		}
+		if _, exists := mapping[r]; !exists {
+			mapping[r] = target[i]
+		}
+	}
+	return strings.Map(func(r rune) rune {
+		if mapped, ok := mapping[r]; ok {
+			return mapped
+		}
+		return r
+	}, text), nil
+}
+
+// DeleteChars removes the characters of a set, as Translate reads it (replaces tr -d)
+func (sl *StdLib) DeleteChars(text, set string) (string, error) {
+	chars, err := expandCharSet(set)
+	if err != nil {
+		return "", err
+	}
+	deleted := make(map[rune]bool, len(chars))
+	for _, r := range chars {
+		deleted[r] = true
+	}
+	return strings.Map(func(r rune) rune {
+		if deleted[r] {
+			return -1
+		}
+		return r
+	}, text), nil
+}
+
+// compilePattern compiles a regular expression
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+	}
+	return re, nil
+}
+
+// sedReplacement turns the \1 group references of sed into ${1}
+func sedReplacement(replacement string) string {
+	var b strings.Builder
+	for i := 0; i < len(replacement); i++ {
+		c := replacement[i]
+		if c == '\\' && i+1 < len(replacement) {
+			next := replacement[i+1]
+			switch {
+			case next >= '0' && next <= '9':
+				b.WriteString("${" + string(next) + "}")
+				i++
+				continue
+			case next == '\\':
+				b.WriteByte('\\')
+				i++
+				continue
+			}
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// filterLines returns the lines that match pattern, or that do not
+func filterLines(text, pattern string, keep bool) ([]string, error) {
+	re, err := compilePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for _, line := range splitLines(text) {
+		if re.MatchString(line) == keep {
+			result = append(result, line)
+		}
+	}
+	return result, nil
+}
+
+// splitLines splits text into lines, without their line endings
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
+}
+
+// splitFields splits a line at a delimiter, or at whitespace if it is empty
+func splitFields(line, delimiter string) []string {
+	if delimiter == "" {
+		return strings.Fields(line)
+	}
+	return strings.Split(line, delimiter)
+}
+
+// parseFieldList parses a list of fields such as "1,3-5,7-" into ranges;
+// an end of 0 means the last field
+func parseFieldList(list string) ([][2]int, error) {
+	var ranges [][2]int
+	for _, part := range strings.Split(list, ",") {
+		part = strings.TrimSpace(part)
+		from, to, isRange := strings.Cut(part, "-")
+		var r [2]int
+		var err error
+		if r[0], err = fieldNumber(from, 1); err == nil {
+			if !isRange {
+				r[1] = r[0]
+			} else {
+				r[1], err = fieldNumber(to, 0)
+			}
+		}
+		if err != nil || r[1] != 0 && r[1] < r[0] {
+			return nil, fmt.Errorf("invalid field list %q", list)
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// fieldNumber parses a field number from 1, or returns fallback if s is empty
+func fieldNumber(s string, fallback int) (int, error) {
+	if s == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid field %q", s)
+	}
+	return n, nil
+}
+
+// leadingNumber returns the number at the start of s, ignoring leading
+// blanks, or -Inf if there is none
+func leadingNumber(s string) float64 {
+	s = strings.TrimLeftFunc(s, unicode.IsSpace)
+	end := 0
+	for end < len(s) && strings.IndexByte("0123456789.-+eE", s[end]) >= 0 {
+		end++
+	}
+	// Shorten until it parses, e.g. "12-3" or "1e"
+	for ; end > 0; end-- {
+		if f, err := strconv.ParseFloat(s[:end], 64); err == nil {
+			return f
+		}
+	}
+	return math.Inf(-1)
+}
+
+// expandCharSet expands the ranges, classes and escapes of a tr set
+func expandCharSet(set string) ([]rune, error) {
+	classes := map[string]func(rune) bool{
+		"[:lower:]": unicode.IsLower,
+		"[:upper:]": unicode.IsUpper,
+		"[:digit:]": unicode.IsDigit,
+		"[:space:]": unicode.IsSpace,
+		"[:alpha:]": unicode.IsLetter,
+		"[:alnum:]": func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) },
+		"[:punct:]": unicode.IsPunct,
+	}
+
+	var runes []rune
+	for len(set) > 0 {
+		matched := false
+		for name, is := range classes {
+			if !strings.HasPrefix(set, name) {
+				continue
+			}
+			// Classes stand for their ASCII members, in order
+			for r := rune(0); r < utf8.RuneSelf; r++ {
+				if is(r) {
+					runes = append(runes, r)
+				}
+			}
+			set = set[len(name):]
+			matched = true
+			break
+		}
+		if matched {
+			continue
+		}
+
+		r, rest := charSetRune(set)
+		set = rest
+		if strings.HasPrefix(set, "-") && len(set) > 1 {
+			end, after := charSetRune(set[1:])
+			if end < r {
+				return nil, fmt.Errorf("invalid range %c-%c", r, end)
+			}
+			for c := r; c <= end; c++ {
+				runes = append(runes, c)
+			}
+			set = after
+			continue
+		}
+		runes = append(runes, r)
+	}
+	return runes, nil
+}
+
+// charSetRune reads a character or escape from the start of a tr set
+func charSetRune(set string) (rune, string) {
+	if strings.HasPrefix(set, "\\") && len(set) > 1 {
+		switch set[1] {
+		case 'n':
+			return '\n', set[2:]
+		case 't':
+			return '\t', set[2:]
+		case 'r':
+			return '\r', set[2:]
+		case '\\':
+			return '\\', set[2:]
+		}
+	}
+	r, size := utf8.DecodeRuneInString(set)
+	return r, set[size:]
+}
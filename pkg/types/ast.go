@@ -18,7 +18,9 @@ type CommandNode struct {
 	Pos      Position
 	Name     string
 	Args     []string
+	RawArgs  []string // arguments as written in the source, before quote removal and expansion
 	Redirect *RedirectNode
+	Origin   *CommandNode // for an expanded command, the command as parsed
 }
 
 func (n *CommandNode) Position() Position { return n.Pos }
@@ -71,6 +73,7 @@ type ForNode struct {
 	Variable string
 	List     []string
 	Body     *ScriptNode
+	Redirect *RedirectNode // optional, applies to the whole loop
 }
 
 func (n *ForNode) Position() Position { return n.Pos }
@@ -81,6 +84,7 @@ type WhileNode struct {
 	Pos       Position
 	Condition Node
 	Body      *ScriptNode
+	Redirect  *RedirectNode // optional, applies to the whole loop
 }
 
 func (n *WhileNode) Position() Position { return n.Pos }
@@ -98,9 +102,13 @@ func (n *FunctionNode) String() string     { return "function" }
 
 // AssignmentNode represents a variable assignment
 type AssignmentNode struct {
-	Pos   Position
-	Name  string
-	Value string
+	Pos      Position
+	Name     string
+	Index    string   // subscript for name[index]=value, empty otherwise
+	Value    string
+	Append   bool     // += assignment
+	IsArray  bool     // name=(...) compound assignment
+	Elements []string // raw elements of a compound assignment
 }
 
 func (n *AssignmentNode) Position() Position { return n.Pos }